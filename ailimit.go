@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// errAIBusy means a call waited too long for a free slot in the AI limiter
+var errAIBusy = errors.New("too many AI requests in progress; try again shortly")
+
+// aiLimiter caps how many LLM and embedding calls run at once across all users,
+// so several people organizing at the same time don't trip provider rate limits.
+// Calls beyond the limit wait for a slot, up to a timeout. A nil limiter allows everything
+type aiLimiter struct {
+	slots   chan struct{}
+	timeout time.Duration
+}
+
+// newAILimiter creates a limiter allowing maxConcurrent calls at once
+// Returns nil (no limit) when maxConcurrent is 0
+func newAILimiter(maxConcurrent, timeoutSecs int) *aiLimiter {
+	if maxConcurrent <= 0 {
+		return nil
+	}
+	return &aiLimiter{
+		slots:   make(chan struct{}, maxConcurrent),
+		timeout: time.Duration(timeoutSecs) * time.Second,
+	}
+}
+
+// acquire waits for a free slot and returns the function that frees it
+func (l *aiLimiter) acquire() (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	timer := time.NewTimer(l.timeout)
+	defer timer.Stop()
+
+	select {
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, nil
+	case <-timer.C:
+		return nil, errAIBusy
+	}
+}
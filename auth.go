@@ -3,8 +3,11 @@ package main
 import (
 	"crypto/subtle"
 	"fmt"
+	"log"
 	"net/http"
 	"regexp"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -14,25 +17,70 @@ import (
 var usernameRegex = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
 
 const (
-	sessionCookieName = "mnemosyne_session"
+	sessionCookieName = "mnemosyne_session" // default session cookie name
 	csrfTokenName     = "csrf_token"
 )
 
+// When the session cookie is marked Secure (config cookie_secure)
+const (
+	CookieSecureAuto   = "auto"   // only on requests that arrived over TLS
+	CookieSecureAlways = "always" // always, e.g. behind a TLS-terminating reverse proxy
+	CookieSecureNever  = "never"  // never
+)
+
+// SameSite policies for the session cookie (config cookie_same_site)
+const (
+	CookieSameSiteStrict = "strict" // never sent on cross-site requests, not even when following a link
+	CookieSameSiteLax    = "lax"    // also sent when following a link from another site
+	CookieSameSiteNone   = "none"   // sent on all cross-site requests (e.g. embedded in an iframe); requires Secure
+)
+
+// cookieNameRegex allows the characters a cookie name can safely contain
+var cookieNameRegex = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// CookieOptions controls how the session cookie is set
+type CookieOptions struct {
+	Name     string // Cookie name ("" = sessionCookieName)
+	Secure   string // CookieSecureAuto (default), CookieSecureAlways or CookieSecureNever
+	SameSite string // CookieSameSiteStrict (default), CookieSameSiteLax or CookieSameSiteNone
+}
+
 // Session represents a user session
 type Session struct {
 	Token     string
 	UserID    int64
 	Username  string
 	Role      string
+	GroupID   int64 // family area the user shares in (0 = no group)
 	CreatedAt time.Time
 	ExpiresAt time.Time
 	CSRFToken string
+
+	// Set when an admin is acting as this user; the admin's own session is kept
+	// under ImpersonatorToken so it can be restored when impersonation stops
+	ImpersonatorID    int64
+	ImpersonatorName  string
+	ImpersonatorToken string
 }
 
-// LoginAttempt tracks failed login attempts
+// LoginAttempt tracks failed login attempts from one IP
 type LoginAttempt struct {
-	Count       int
-	LockedUntil time.Time
+	Count        int
+	LockedUntil  time.Time
+	FirstAttempt time.Time
+	LastAttempt  time.Time
+	Usernames    []string // distinct usernames tried, oldest first (at most MaxAttemptUsernames)
+}
+
+// LoginAttemptInfo is a snapshot of one IP's failed logins, for the admin audit
+type LoginAttemptInfo struct {
+	IP           string     `json:"ip"`
+	Count        int        `json:"count"`
+	Usernames    []string   `json:"usernames"`
+	FirstAttempt time.Time  `json:"first_attempt"`
+	LastAttempt  time.Time  `json:"last_attempt"`
+	Locked       bool       `json:"locked"`
+	LockedUntil  *time.Time `json:"locked_until,omitempty"`
 }
 
 // SessionManager handles session management and authentication
@@ -40,16 +88,24 @@ type SessionManager struct {
 	sessions      map[string]*Session
 	loginAttempts map[string]*LoginAttempt
 	sessionExpiry time.Duration
+	cleanupEvery  time.Duration
+	cookies       CookieOptions
 	db            *Database
 	mu            sync.RWMutex
 }
 
 // NewSessionManager creates a new session manager
-func NewSessionManager(db *Database, sessionExpiryHours int) *SessionManager {
+// Expired sessions and stale login attempts are cleared every cleanupHours
+func NewSessionManager(db *Database, sessionExpiryHours, cleanupHours int, cookies CookieOptions) *SessionManager {
+	if cookies.Name == "" {
+		cookies.Name = sessionCookieName
+	}
 	sm := &SessionManager{
 		sessions:      make(map[string]*Session),
 		loginAttempts: make(map[string]*LoginAttempt),
 		sessionExpiry: time.Duration(sessionExpiryHours) * time.Hour,
+		cleanupEvery:  time.Duration(cleanupHours) * time.Hour,
+		cookies:       cookies,
 		db:            db,
 	}
 
@@ -59,7 +115,17 @@ func NewSessionManager(db *Database, sessionExpiryHours int) *SessionManager {
 	return sm
 }
 
+// LockoutError means an IP is locked out of logging in after too many failed attempts
+type LockoutError struct {
+	Remaining time.Duration
+}
+
+func (e *LockoutError) Error() string {
+	return fmt.Sprintf("too many failed attempts, try again in %v", e.Remaining.Round(time.Second))
+}
+
 // checkBruteForce checks if the IP is locked out due to too many attempts
+// Returns a *LockoutError while it is
 func (sm *SessionManager) checkBruteForce(ip string) error {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
@@ -71,30 +137,39 @@ func (sm *SessionManager) checkBruteForce(ip string) error {
 
 	// Check if still locked out
 	if time.Now().Before(attempt.LockedUntil) {
-		remaining := time.Until(attempt.LockedUntil).Round(time.Second)
-		return fmt.Errorf("too many failed attempts, try again in %v", remaining)
+		return &LockoutError{Remaining: time.Until(attempt.LockedUntil)}
 	}
 
-	// Lockout expired, reset
-	if time.Now().After(attempt.LockedUntil) {
+	// Lockout expired, reset (attempts that haven't caused a lockout yet keep counting)
+	if !attempt.LockedUntil.IsZero() {
 		delete(sm.loginAttempts, ip)
 	}
 
 	return nil
 }
 
-// recordFailedAttempt records a failed login attempt
-func (sm *SessionManager) recordFailedAttempt(ip string) {
+// recordFailedAttempt records a failed login attempt and the username it tried
+func (sm *SessionManager) recordFailedAttempt(ip, username string) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
+	now := time.Now()
 	attempt, exists := sm.loginAttempts[ip]
 	if !exists {
-		attempt = &LoginAttempt{Count: 0}
+		attempt = &LoginAttempt{Count: 0, FirstAttempt: now}
 		sm.loginAttempts[ip] = attempt
 	}
 
 	attempt.Count++
+	attempt.LastAttempt = now
+
+	// No valid username is longer than 32 characters
+	if len(username) > 32 {
+		username = username[:32]
+	}
+	if !slices.Contains(attempt.Usernames, username) && len(attempt.Usernames) < MaxAttemptUsernames {
+		attempt.Usernames = append(attempt.Usernames, username)
+	}
 
 	// Lock out after max attempts
 	if attempt.Count >= MaxLoginAttempts {
@@ -111,41 +186,75 @@ func (sm *SessionManager) resetFailedAttempts(ip string) {
 
 // Login authenticates a user and creates a session
 func (sm *SessionManager) Login(w http.ResponseWriter, r *http.Request, username, password string) error {
+	session, err := sm.authenticate(r, username, password)
+	if err != nil {
+		return err
+	}
+
+	sm.setSessionCookie(w, r, session)
+
+	return nil
+}
+
+// IssueToken authenticates a user and creates a session for a non-browser client
+// The session token is returned to the caller instead of being set as a cookie
+func (sm *SessionManager) IssueToken(r *http.Request, username, password string) (*Session, error) {
+	return sm.authenticate(r, username, password)
+}
+
+// RevokeToken ends the session presented as a bearer token
+func (sm *SessionManager) RevokeToken(session *Session) {
+	sm.mu.Lock()
+	delete(sm.sessions, session.Token)
+	sm.mu.Unlock()
+}
+
+// authenticate verifies credentials (with brute force protection) and stores a new session
+func (sm *SessionManager) authenticate(r *http.Request, username, password string) (*Session, error) {
 	ip := getClientIP(r)
 
 	// Check brute force protection
 	if err := sm.checkBruteForce(ip); err != nil {
-		return err
+		return nil, err
 	}
 
 	// Get user from database
 	user, err := sm.db.GetUserByUsername(username)
 	if err != nil {
-		return fmt.Errorf("authentication failed")
+		return nil, fmt.Errorf("authentication failed")
 	}
 	if user == nil {
-		sm.recordFailedAttempt(ip)
-		return fmt.Errorf("invalid username or password")
+		sm.recordFailedAttempt(ip, username)
+		return nil, fmt.Errorf("invalid username or password")
 	}
 
 	// Verify password
 	if !user.VerifyPassword(password) {
-		sm.recordFailedAttempt(ip)
-		return fmt.Errorf("invalid username or password")
+		sm.recordFailedAttempt(ip, username)
+		return nil, fmt.Errorf("invalid username or password")
 	}
 
 	// Reset failed attempts on successful login
 	sm.resetFailedAttempts(ip)
 
+	// Transparently upgrade hashes made with a lower bcrypt cost
+	if user.NeedsRehash(sm.db.bcryptCost) {
+		if err := sm.db.UpdatePassword(user.ID, password); err != nil {
+			log.Printf("Failed to upgrade password hash for user %s: %v", user.Username, err)
+		} else {
+			log.Printf("Upgraded password hash for user %s to bcrypt cost %d", user.Username, sm.db.bcryptCost)
+		}
+	}
+
 	// Create session
 	token, err := generateRandomToken(SessionTokenLength)
 	if err != nil {
-		return fmt.Errorf("failed to generate session token: %v", err)
+		return nil, fmt.Errorf("failed to generate session token: %v", err)
 	}
 
 	csrfToken, err := generateRandomToken(CSRFTokenLength)
 	if err != nil {
-		return fmt.Errorf("failed to generate CSRF token: %v", err)
+		return nil, fmt.Errorf("failed to generate CSRF token: %v", err)
 	}
 
 	session := &Session{
@@ -153,6 +262,7 @@ func (sm *SessionManager) Login(w http.ResponseWriter, r *http.Request, username
 		UserID:    user.ID,
 		Username:  user.Username,
 		Role:      user.Role,
+		GroupID:   user.GroupID,
 		CreatedAt: time.Now(),
 		ExpiresAt: time.Now().Add(sm.sessionExpiry),
 		CSRFToken: csrfToken,
@@ -162,70 +272,255 @@ func (sm *SessionManager) Login(w http.ResponseWriter, r *http.Request, username
 	sm.sessions[token] = session
 	sm.mu.Unlock()
 
-	// Set session cookie
+	return session, nil
+}
+
+// SetUserGroup updates the live sessions of a user who was moved to another group
+// Sessions are replaced rather than modified, since handlers may be reading them
+func (sm *SessionManager) SetUserGroup(userID, groupID int64) {
+	sm.regroupSessions(func(s *Session) bool { return s.UserID == userID }, groupID)
+}
+
+// ClearGroup moves the live sessions of a deleted group's members out of it
+func (sm *SessionManager) ClearGroup(groupID int64) {
+	sm.regroupSessions(func(s *Session) bool { return s.GroupID == groupID }, 0)
+}
+
+// regroupSessions sets the group of every session matching match
+func (sm *SessionManager) regroupSessions(match func(*Session) bool, groupID int64) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	for token, session := range sm.sessions {
+		if match(session) {
+			updated := *session
+			updated.GroupID = groupID
+			sm.sessions[token] = &updated
+		}
+	}
+}
+
+// secureCookie reports whether the session cookie should be marked Secure
+// Behind a TLS-terminating proxy the request arrives as plain HTTP, so "auto"
+// can't tell; use "always" there
+func (sm *SessionManager) secureCookie(r *http.Request) bool {
+	switch sm.cookies.Secure {
+	case CookieSecureAlways:
+		return true
+	case CookieSecureNever:
+		return false
+	default:
+		return r.TLS != nil
+	}
+}
+
+// sameSite returns the session cookie's SameSite policy
+// State-changing requests still need the CSRF token, whatever the policy
+func (sm *SessionManager) sameSite() http.SameSite {
+	switch sm.cookies.SameSite {
+	case CookieSameSiteLax:
+		return http.SameSiteLaxMode
+	case CookieSameSiteNone:
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteStrictMode
+	}
+}
+
+// setSessionCookie points the browser at a session
+func (sm *SessionManager) setSessionCookie(w http.ResponseWriter, r *http.Request, session *Session) {
 	http.SetCookie(w, &http.Cookie{
-		Name:     sessionCookieName,
-		Value:    token,
+		Name:     sm.cookies.Name,
+		Value:    session.Token,
 		Path:     "/",
-		MaxAge:   int(sm.sessionExpiry.Seconds()),
+		MaxAge:   int(time.Until(session.ExpiresAt).Seconds()),
 		HttpOnly: true,
-		Secure:   r.TLS != nil,
-		SameSite: http.SameSiteStrictMode,
+		Secure:   sm.secureCookie(r),
+		SameSite: sm.sameSite(),
 	})
+}
+
+// Impersonate switches an admin's browser to a new session acting as user
+// The admin session stays valid and is restored by StopImpersonating
+func (sm *SessionManager) Impersonate(w http.ResponseWriter, r *http.Request, admin *Session, user *User) error {
+	if !admin.IsAdmin() || admin.IsImpersonating() {
+		return fmt.Errorf("only admins can impersonate")
+	}
+
+	token, err := generateRandomToken(SessionTokenLength)
+	if err != nil {
+		return fmt.Errorf("failed to generate session token: %v", err)
+	}
+
+	csrfToken, err := generateRandomToken(CSRFTokenLength)
+	if err != nil {
+		return fmt.Errorf("failed to generate CSRF token: %v", err)
+	}
+
+	session := &Session{
+		Token:             token,
+		UserID:            user.ID,
+		Username:          user.Username,
+		Role:              user.Role,
+		GroupID:           user.GroupID,
+		CreatedAt:         time.Now(),
+		ExpiresAt:         admin.ExpiresAt, // Never outlive the admin's own session
+		CSRFToken:         csrfToken,
+		ImpersonatorID:    admin.UserID,
+		ImpersonatorName:  admin.Username,
+		ImpersonatorToken: admin.Token,
+	}
+
+	sm.mu.Lock()
+	sm.sessions[token] = session
+	sm.mu.Unlock()
+
+	sm.setSessionCookie(w, r, session)
+
+	log.Printf("IMPERSONATION START: admin %s (ID %d) is acting as user %s (ID %d) from %s",
+		admin.Username, admin.UserID, user.Username, user.ID, getClientIP(r))
+
+	return nil
+}
+
+// StopImpersonating ends an impersonation session and restores the admin's session
+func (sm *SessionManager) StopImpersonating(w http.ResponseWriter, r *http.Request, session *Session) error {
+	if !session.IsImpersonating() {
+		return fmt.Errorf("not impersonating")
+	}
+
+	sm.mu.Lock()
+	delete(sm.sessions, session.Token)
+	admin, exists := sm.sessions[session.ImpersonatorToken]
+	sm.mu.Unlock()
+
+	log.Printf("IMPERSONATION STOP: admin %s (ID %d) stopped acting as user %s (ID %d)",
+		session.ImpersonatorName, session.ImpersonatorID, session.Username, session.UserID)
 
+	if !exists || time.Now().After(admin.ExpiresAt) {
+		sm.clearSessionCookie(w)
+		return fmt.Errorf("admin session expired")
+	}
+
+	sm.setSessionCookie(w, r, admin)
 	return nil
 }
 
 // Register creates a new user account
 func (sm *SessionManager) Register(username, password string) (*User, error) {
+	username = normalizeUsername(username)
+	if err := sm.validateRegistration(username, password); err != nil {
+		return nil, err
+	}
+
+	// Create user
+	user, err := sm.db.CreateUser(username, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user: %v", err)
+	}
+
+	return user, nil
+}
+
+// SetupAdmin creates the first account, as admin, during first-run setup
+// Fails with errSetupDone once any account exists
+func (sm *SessionManager) SetupAdmin(username, password string) (*User, error) {
+	username = normalizeUsername(username)
+	if err := sm.validateRegistration(username, password); err != nil {
+		return nil, err
+	}
+
+	user, err := sm.db.CreateFirstAdmin(username, password)
+	if err == errSetupDone {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user: %v", err)
+	}
+
+	return user, nil
+}
+
+// RegisterWithInvite creates a new user account by redeeming an invite code
+func (sm *SessionManager) RegisterWithInvite(username, password, code string) (*User, error) {
+	username = normalizeUsername(username)
+	if err := sm.validateRegistration(username, password); err != nil {
+		return nil, err
+	}
+
+	// Codes are uppercase base32; accept them however they were typed
+	user, err := sm.db.CreateUserWithInvite(username, password, strings.ToUpper(strings.TrimSpace(code)))
+	if err == errInvalidInvite {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user: %v", err)
+	}
+
+	log.Printf("User %s registered with invite code", username)
+	return user, nil
+}
+
+// normalizeUsername returns the canonical (lowercase) form new usernames are stored in
+// Lookups ignore case, so accounts created before this still log in either way
+func normalizeUsername(username string) string {
+	return strings.ToLower(username)
+}
+
+// validateRegistration checks a requested username and password before an account is created
+func (sm *SessionManager) validateRegistration(username, password string) error {
 	// Validate username length
 	if len(username) < 3 || len(username) > 32 {
-		return nil, fmt.Errorf("username must be between 3 and 32 characters")
+		return fmt.Errorf("username must be between 3 and 32 characters")
 	}
 
 	// Validate username characters (alphanumeric and underscore only)
 	// SECURITY: Prevents special characters that could cause XSS, path issues, or display confusion
 	if !usernameRegex.MatchString(username) {
-		return nil, fmt.Errorf("username can only contain letters, numbers, and underscores")
+		return fmt.Errorf("username can only contain letters, numbers, and underscores")
 	}
 
 	// Validate password length
 	if len(password) < 6 {
-		return nil, fmt.Errorf("password must be at least 6 characters")
+		return fmt.Errorf("password must be at least 6 characters")
 	}
 
 	// Check if username already exists
 	existing, err := sm.db.GetUserByUsername(username)
 	if err != nil {
-		return nil, fmt.Errorf("registration failed")
+		return fmt.Errorf("registration failed")
 	}
 	if existing != nil {
-		return nil, fmt.Errorf("username already taken")
+		return fmt.Errorf("username already taken")
 	}
 
-	// Create user
-	user, err := sm.db.CreateUser(username, password)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create user: %v", err)
-	}
-
-	return user, nil
+	return nil
 }
 
 // Logout destroys a session
 func (sm *SessionManager) Logout(w http.ResponseWriter, r *http.Request) {
-	cookie, err := r.Cookie(sessionCookieName)
+	cookie, err := r.Cookie(sm.cookies.Name)
 	if err != nil {
 		return
 	}
 
 	sm.mu.Lock()
+	// Logging out while impersonating also ends the admin session behind it
+	if session, exists := sm.sessions[cookie.Value]; exists && session.ImpersonatorToken != "" {
+		log.Printf("IMPERSONATION STOP: admin %s (ID %d) logged out while acting as user %s (ID %d)",
+			session.ImpersonatorName, session.ImpersonatorID, session.Username, session.UserID)
+		delete(sm.sessions, session.ImpersonatorToken)
+	}
 	delete(sm.sessions, cookie.Value)
 	sm.mu.Unlock()
 
-	// Clear cookie
+	sm.clearSessionCookie(w)
+}
+
+// clearSessionCookie removes the session cookie from the browser
+func (sm *SessionManager) clearSessionCookie(w http.ResponseWriter) {
 	http.SetCookie(w, &http.Cookie{
-		Name:     sessionCookieName,
+		Name:     sm.cookies.Name,
 		Value:    "",
 		Path:     "/",
 		MaxAge:   -1,
@@ -234,14 +529,19 @@ func (sm *SessionManager) Logout(w http.ResponseWriter, r *http.Request) {
 }
 
 // ValidateSession checks if a session is valid
+// A session token sent as "Authorization: Bearer <token>" takes precedence over the cookie
 func (sm *SessionManager) ValidateSession(r *http.Request) (*Session, error) {
-	cookie, err := r.Cookie(sessionCookieName)
-	if err != nil {
-		return nil, fmt.Errorf("no session cookie")
+	token := bearerToken(r)
+	if token == "" {
+		cookie, err := r.Cookie(sm.cookies.Name)
+		if err != nil {
+			return nil, fmt.Errorf("no session cookie")
+		}
+		token = cookie.Value
 	}
 
 	sm.mu.RLock()
-	session, exists := sm.sessions[cookie.Value]
+	session, exists := sm.sessions[token]
 	sm.mu.RUnlock()
 
 	if !exists {
@@ -250,7 +550,7 @@ func (sm *SessionManager) ValidateSession(r *http.Request) (*Session, error) {
 
 	if time.Now().After(session.ExpiresAt) {
 		sm.mu.Lock()
-		delete(sm.sessions, cookie.Value)
+		delete(sm.sessions, token)
 		sm.mu.Unlock()
 		return nil, fmt.Errorf("session expired")
 	}
@@ -259,7 +559,13 @@ func (sm *SessionManager) ValidateSession(r *http.Request) (*Session, error) {
 }
 
 // ValidateCSRF checks if the CSRF token is valid
+// Bearer-token requests are exempt: browsers never attach the Authorization
+// header on their own, so a forged cross-site request can't carry one
 func (sm *SessionManager) ValidateCSRF(r *http.Request, session *Session) error {
+	if bearerToken(r) != "" {
+		return nil
+	}
+
 	token := r.Header.Get("X-CSRF-Token")
 	if token == "" {
 		token = r.FormValue("csrf_token")
@@ -277,36 +583,114 @@ func (sm *SessionManager) ValidateCSRF(r *http.Request, session *Session) error
 	return nil
 }
 
+// bearerToken returns the session token from an "Authorization: Bearer" header, if any
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
 // IsAdmin checks if the session user is an admin
 func (s *Session) IsAdmin() bool {
 	return s.Role == "admin"
 }
 
-// cleanupExpiredSessions periodically removes expired sessions
+// IsImpersonating reports whether an admin is acting as the session user
+func (s *Session) IsImpersonating() bool {
+	return s.ImpersonatorID != 0
+}
+
+// cleanupExpiredSessions removes expired sessions now and then every cleanupEvery
 func (sm *SessionManager) cleanupExpiredSessions() {
-	ticker := time.NewTicker(time.Duration(SessionCleanupHours) * time.Hour)
+	sm.cleanup()
+
+	ticker := time.NewTicker(sm.cleanupEvery)
 	defer ticker.Stop()
 
 	for range ticker.C {
-		now := time.Now()
+		sm.cleanup()
+	}
+}
 
-		sm.mu.Lock()
-		for token, session := range sm.sessions {
-			if now.After(session.ExpiresAt) {
-				delete(sm.sessions, token)
-			}
+// cleanup drops expired sessions, and login attempts whose lockout (or, if
+// never locked out, last attempt) was more than one cleanup interval ago
+func (sm *SessionManager) cleanup() {
+	now := time.Now()
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	for token, session := range sm.sessions {
+		if now.After(session.ExpiresAt) {
+			delete(sm.sessions, token)
 		}
+	}
 
-		// Also cleanup old login attempts
-		for ip, attempt := range sm.loginAttempts {
-			if now.After(attempt.LockedUntil.Add(time.Duration(SessionCleanupHours) * time.Hour)) {
-				delete(sm.loginAttempts, ip)
-			}
+	for ip, attempt := range sm.loginAttempts {
+		ended := attempt.LockedUntil
+		if ended.Before(attempt.LastAttempt) {
+			ended = attempt.LastAttempt
+		}
+		if now.After(ended.Add(sm.cleanupEvery)) {
+			delete(sm.loginAttempts, ip)
 		}
-		sm.mu.Unlock()
 	}
 }
 
+// Counts returns how many sessions and login-attempt records are held in memory
+// Expired entries count until the next cleanup
+func (sm *SessionManager) Counts() (sessions, loginAttempts int) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return len(sm.sessions), len(sm.loginAttempts)
+}
+
+// LoginAttempts returns the failed-login records held in memory, most recent first
+func (sm *SessionManager) LoginAttempts() []LoginAttemptInfo {
+	now := time.Now()
+
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	attempts := make([]LoginAttemptInfo, 0, len(sm.loginAttempts))
+	for ip, attempt := range sm.loginAttempts {
+		info := LoginAttemptInfo{
+			IP:           ip,
+			Count:        attempt.Count,
+			Usernames:    slices.Clone(attempt.Usernames),
+			FirstAttempt: attempt.FirstAttempt,
+			LastAttempt:  attempt.LastAttempt,
+			Locked:       now.Before(attempt.LockedUntil),
+		}
+		if info.Locked {
+			lockedUntil := attempt.LockedUntil
+			info.LockedUntil = &lockedUntil
+		}
+		attempts = append(attempts, info)
+	}
+
+	sort.Slice(attempts, func(i, j int) bool {
+		return attempts[i].LastAttempt.After(attempts[j].LastAttempt)
+	})
+
+	return attempts
+}
+
+// ClearLoginAttempts forgets an IP's failed logins, lifting any lockout
+// Returns false if nothing was recorded for the IP
+func (sm *SessionManager) ClearLoginAttempts(ip string) bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if _, exists := sm.loginAttempts[ip]; !exists {
+		return false
+	}
+	delete(sm.loginAttempts, ip)
+	return true
+}
+
 // getClientIP extracts the client IP from the request
 // SECURITY: Only use RemoteAddr to prevent IP spoofing attacks on brute force protection.
 // X-Forwarded-For and X-Real-IP headers are easily spoofable and should not be trusted
@@ -315,7 +699,7 @@ func (sm *SessionManager) cleanupExpiredSessions() {
 func getClientIP(r *http.Request) string {
 	// Extract IP from RemoteAddr (format: "IP:port" or just "IP")
 	ip := r.RemoteAddr
-	
+
 	// Handle IPv6 addresses in brackets [::1]:port
 	if len(ip) > 0 && ip[0] == '[' {
 		if idx := strings.Index(ip, "]:"); idx != -1 {
@@ -323,11 +707,11 @@ func getClientIP(r *http.Request) string {
 		}
 		return strings.Trim(ip, "[]")
 	}
-	
+
 	// Handle IPv4 addresses ip:port
 	if idx := strings.LastIndex(ip, ":"); idx != -1 {
 		return ip[:idx]
 	}
-	
+
 	return ip
 }
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// AutoArchiver periodically archives old photos for users who opted in
+type AutoArchiver struct {
+	db       *Database
+	photoMgr *PhotoManager
+	interval time.Duration
+}
+
+// NewAutoArchiver creates an auto-archiver and starts its sweep loop
+// An interval of 0 disables the background job entirely
+func NewAutoArchiver(db *Database, photoMgr *PhotoManager, intervalHours int) *AutoArchiver {
+	aa := &AutoArchiver{
+		db:       db,
+		photoMgr: photoMgr,
+		interval: time.Duration(intervalHours) * time.Hour,
+	}
+
+	if aa.interval > 0 {
+		go aa.run()
+	}
+
+	return aa
+}
+
+// run sweeps on every tick
+func (aa *AutoArchiver) run() {
+	ticker := time.NewTicker(aa.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		aa.Sweep()
+	}
+}
+
+// Sweep archives photos older than each opted-in user's threshold
+// Returns the total number of photos archived
+func (aa *AutoArchiver) Sweep() int {
+	settings, err := aa.db.GetAutoArchiveUsers()
+	if err != nil {
+		log.Printf("Auto-archive: failed to load settings: %v", err)
+		return 0
+	}
+
+	total := 0
+	for _, setting := range settings {
+		photos, err := aa.db.GetPhotosOlderThan(setting.UserID, setting.Days)
+		if err != nil {
+			log.Printf("Auto-archive: failed to list photos for user %d: %v", setting.UserID, err)
+			continue
+		}
+
+		archived := 0
+		for _, photo := range photos {
+			if err := aa.photoMgr.ArchivePhoto(photo); err != nil {
+				log.Printf("Auto-archive: failed to archive photo %d (%s) for user %d: %v", photo.ID, photo.Filename, setting.UserID, err)
+				continue
+			}
+			log.Printf("Auto-archive: archived photo %d (%s) for user %d", photo.ID, photo.Filename, setting.UserID)
+			archived++
+		}
+
+		if archived > 0 {
+			log.Printf("Auto-archive: archived %d photo(s) older than %d day(s) for user %d", archived, setting.Days, setting.UserID)
+		}
+		total += archived
+	}
+
+	return total
+}
+
+// HandleGetAutoArchive returns the current user's auto-archive preference
+func (app *App) HandleGetAutoArchive(w http.ResponseWriter, r *http.Request) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	setting, err := app.db.GetAutoArchiveSetting(session.UserID)
+	if err != nil || setting == nil {
+		http.Error(w, "Failed to load setting", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":        setting.Enabled,
+		"days":           setting.Days,
+		"sweeper_active": app.config.AutoArchiveIntervalHours > 0,
+	})
+}
+
+// HandleUpdateAutoArchive opts the current user in or out of auto-archive
+func (app *App) HandleUpdateAutoArchive(w http.ResponseWriter, r *http.Request) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := app.sessionMgr.ValidateCSRF(r, session); err != nil {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	// Limit request body size
+	r.Body = http.MaxBytesReader(w, r.Body, SmallJSONBodyBytes)
+
+	var body struct {
+		Enabled bool `json:"enabled"`
+		Days    int  `json:"days"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	if body.Enabled && (body.Days < 1 || body.Days > MaxAutoArchiveDays) {
+		http.Error(w, fmt.Sprintf("Days must be between 1 and %d", MaxAutoArchiveDays), http.StatusBadRequest)
+		return
+	}
+	if !body.Enabled {
+		body.Days = 0
+	}
+
+	if err := app.db.SetAutoArchiveSetting(session.UserID, body.Enabled, body.Days); err != nil {
+		http.Error(w, "Failed to update setting", http.StatusInternalServerError)
+		return
+	}
+
+	if body.Enabled {
+		log.Printf("User %d enabled auto-archive for photos older than %d day(s)", session.UserID, body.Days)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "success",
+		"message": "Auto-archive setting updated",
+		"enabled": body.Enabled,
+		"days":    body.Days,
+	})
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+)
+
+// AutoEmbedder generates embeddings for new uploads in the background, so the
+// similarity index stays current without a manual "generate embeddings" run.
+// Each user has their own queue worked by one goroutine at a time, so a large
+// batch upload from one user doesn't hold up anyone else's. Photos it can't
+// embed (service down, AI limiter busy) are left for the next manual run.
+// A nil AutoEmbedder ignores everything
+type AutoEmbedder struct {
+	db         *Database
+	photoMgr   *PhotoManager
+	newService func() *EmbeddingService
+
+	mu     sync.Mutex
+	queues map[int64][]int64 // user ID -> photo IDs waiting, oldest first
+}
+
+// NewAutoEmbedder creates an auto-embedder that uses newService for each batch
+func NewAutoEmbedder(db *Database, photoMgr *PhotoManager, newService func() *EmbeddingService) *AutoEmbedder {
+	return &AutoEmbedder{
+		db:         db,
+		photoMgr:   photoMgr,
+		newService: newService,
+		queues:     make(map[int64][]int64),
+	}
+}
+
+// Enqueue queues a photo for embedding and starts the user's worker if it isn't running
+// Photos past AutoEmbedQueueMax per user are dropped (they show up as missing embeddings)
+func (ae *AutoEmbedder) Enqueue(userID, photoID int64) {
+	if ae == nil {
+		return
+	}
+
+	ae.mu.Lock()
+	defer ae.mu.Unlock()
+
+	queue, running := ae.queues[userID]
+	if len(queue) >= AutoEmbedQueueMax {
+		log.Printf("Auto-embed: queue for user %d is full; photo %d left for the next manual run", userID, photoID)
+		return
+	}
+	ae.queues[userID] = append(queue, photoID)
+
+	if !running {
+		go ae.drain(userID)
+	}
+}
+
+// next pops the user's oldest queued photo; ok is false once the queue is empty,
+// at which point the worker has been unregistered
+func (ae *AutoEmbedder) next(userID int64) (photoID int64, ok bool) {
+	ae.mu.Lock()
+	defer ae.mu.Unlock()
+
+	queue := ae.queues[userID]
+	if len(queue) == 0 {
+		delete(ae.queues, userID)
+		return 0, false
+	}
+	ae.queues[userID] = queue[1:]
+	return queue[0], true
+}
+
+// drop empties the user's queue and unregisters the worker
+func (ae *AutoEmbedder) drop(userID int64) int {
+	ae.mu.Lock()
+	defer ae.mu.Unlock()
+
+	dropped := len(ae.queues[userID])
+	delete(ae.queues, userID)
+	return dropped
+}
+
+// drain embeds the user's queued photos until the queue is empty
+func (ae *AutoEmbedder) drain(userID int64) {
+	service := ae.newService()
+
+	// Check the service once per batch rather than once per photo
+	status, health, err := service.Status()
+	if status != EmbeddingHealthy {
+		dropped := ae.drop(userID)
+		if err != nil {
+			log.Printf("Auto-embed: embedding service %s: %v; %d photo(s) for user %d left for the next manual run", status, err, dropped, userID)
+		} else {
+			log.Printf("Auto-embed: embedding service %s; %d photo(s) for user %d left for the next manual run", status, dropped, userID)
+		}
+		return
+	}
+
+	// New vectors must match the user's stored ones or they can't be compared
+	stored, err := ae.db.GetEmbeddingDimensions(userID)
+	if err == nil {
+		err = service.ExpectStoredDimension(health.Dimension, stored)
+	}
+	if err != nil {
+		dropped := ae.drop(userID)
+		log.Printf("Auto-embed: %v; %d photo(s) for user %d left for the next manual run", err, dropped, userID)
+		return
+	}
+
+	for {
+		photoID, ok := ae.next(userID)
+		if !ok {
+			return
+		}
+
+		if err := ae.embed(service, photoID); err == errAIBusy {
+			dropped := ae.drop(userID) + 1
+			log.Printf("Auto-embed: AI service busy; %d photo(s) for user %d left for the next manual run", dropped, userID)
+			return
+		} else if err != nil {
+			log.Printf("Auto-embed: failed to embed photo %d: %v", photoID, err)
+		}
+	}
+}
+
+// embed generates and stores the embedding for one photo
+// Photos deleted or archived since they were queued are skipped
+func (ae *AutoEmbedder) embed(service *EmbeddingService, photoID int64) error {
+	photo, err := ae.db.GetPhotoByID(photoID)
+	if err != nil {
+		return err
+	}
+	if photo == nil || photo.IsArchived {
+		return nil
+	}
+
+	path, err := ae.photoMgr.GetOriginalPath(photo)
+	if err != nil {
+		return err
+	}
+
+	embedding, err := service.GenerateEmbedding(path, fmt.Sprintf("%d", photo.ID))
+	if err != nil {
+		return err
+	}
+
+	return ae.db.SaveEmbedding(photo.ID, EmbeddingToBytes(embedding), len(embedding))
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// backupFilePrefix and backupTimeLayout name backup files so they sort by age
+const (
+	backupFilePrefix = "mnemosyne-"
+	backupTimeLayout = "20060102-150405"
+)
+
+// errBackupExists means a backup was already written this second
+var errBackupExists = errors.New("backup already exists")
+
+// Backuper writes consistent copies of the database to a backup directory
+// Copying mnemosyne.db while the server runs can catch a half-written
+// transaction; VACUUM INTO reads through SQLite, so every backup is consistent
+type Backuper struct {
+	db       *Database
+	dir      string
+	interval time.Duration
+	keep     int // Newest backups to keep (0 = keep all)
+
+	mu sync.Mutex // one backup at a time
+}
+
+// NewBackuper creates a backuper and starts its schedule
+// An interval of 0 disables scheduled backups; on-demand ones still work
+func NewBackuper(db *Database, dir string, intervalHours, keep int) *Backuper {
+	b := &Backuper{
+		db:       db,
+		dir:      dir,
+		interval: time.Duration(intervalHours) * time.Hour,
+		keep:     keep,
+	}
+
+	if b.interval > 0 {
+		go b.run()
+	}
+
+	return b
+}
+
+// run backs up on every tick
+func (b *Backuper) run() {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if path, size, err := b.Backup(); err != nil {
+			log.Printf("Scheduled backup failed: %v", err)
+		} else {
+			log.Printf("Scheduled backup written to %s (%d bytes)", path, size)
+		}
+	}
+}
+
+// Backup writes a new backup and prunes old ones
+// Returns the backup's path and size
+func (b *Backuper) Backup() (string, int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := os.MkdirAll(b.dir, 0700); err != nil {
+		return "", 0, fmt.Errorf("failed to create backup directory: %v", err)
+	}
+
+	path := filepath.Join(b.dir, backupFilePrefix+time.Now().UTC().Format(backupTimeLayout)+".db")
+	if _, err := os.Stat(path); err == nil {
+		return "", 0, errBackupExists
+	}
+
+	if err := b.db.BackupTo(path); err != nil {
+		os.Remove(path)
+		return "", 0, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to stat backup: %v", err)
+	}
+
+	b.prune()
+	return path, info.Size(), nil
+}
+
+// prune deletes all but the newest keep backups (best effort)
+func (b *Backuper) prune() {
+	if b.keep <= 0 {
+		return
+	}
+
+	matches, err := filepath.Glob(filepath.Join(b.dir, backupFilePrefix+"*.db"))
+	if err != nil || len(matches) <= b.keep {
+		return
+	}
+
+	// Timestamped names sort oldest first
+	sort.Strings(matches)
+	for _, path := range matches[:len(matches)-b.keep] {
+		if err := os.Remove(path); err != nil {
+			log.Printf("Warning: failed to remove old backup %s: %v", path, err)
+		}
+	}
+}
+
+// HandleAPIBackup writes a database backup on demand (admin only)
+func (app *App) HandleAPIBackup(w http.ResponseWriter, r *http.Request) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !session.IsAdmin() {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if err := app.sessionMgr.ValidateCSRF(r, session); err != nil {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	path, size, err := app.backuper.Backup()
+	if err != nil {
+		log.Printf("Backup requested by %s failed: %v", session.Username, err)
+		if errors.Is(err, errBackupExists) {
+			http.Error(w, "A backup was just written; try again in a second", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to back up database", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Admin %s backed up the database to %s (%d bytes)", session.Username, path, size)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "success",
+		"message": "Database backed up",
+		"path":    path,
+		"size":    size,
+	})
+}
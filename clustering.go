@@ -33,16 +33,22 @@ func NewDBSCAN() *DBSCAN {
 	}
 }
 
-// Cluster performs DBSCAN clustering on photos using their embeddings
-func (d *DBSCAN) Cluster(embeddings map[int64][]float64) ClusteringResult {
-	// Get all photo IDs
+// sortedPhotoIDs returns the photo IDs of an embedding map in ascending order
+// Every pass over embeddings walks IDs in this order rather than ranging over
+// the map, so the same embeddings always produce the same groups, group IDs and
+// tie-breaks
+func sortedPhotoIDs(embeddings map[int64][]float64) []int64 {
 	ids := make([]int64, 0, len(embeddings))
 	for id := range embeddings {
 		ids = append(ids, id)
 	}
-
-	// Sort for deterministic results
 	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Cluster performs DBSCAN clustering on photos using their embeddings
+func (d *DBSCAN) Cluster(embeddings map[int64][]float64) ClusteringResult {
+	ids := sortedPhotoIDs(embeddings)
 
 	n := len(ids)
 	if n == 0 {
@@ -167,9 +173,13 @@ func (d *DBSCAN) buildResult(ids []int64, labels map[int64]int, embeddings map[i
 		})
 	}
 
-	// Sort groups by size (largest first)
+	// Sort groups by size (largest first); equal sizes keep the order of their
+	// lowest photo ID so renumbering is the same on every run
 	sort.Slice(result.Groups, func(i, j int) bool {
-		return len(result.Groups[i].PhotoIDs) > len(result.Groups[j].PhotoIDs)
+		if len(result.Groups[i].PhotoIDs) != len(result.Groups[j].PhotoIDs) {
+			return len(result.Groups[i].PhotoIDs) > len(result.Groups[j].PhotoIDs)
+		}
+		return result.Groups[i].PhotoIDs[0] < result.Groups[j].PhotoIDs[0]
 	})
 
 	// Renumber group IDs
@@ -213,3 +223,154 @@ func ClusterPhotos(embeddings map[int64][]float64, similarityThreshold float64)
 	return dbscan.Cluster(embeddings)
 }
 
+
+// Update incrementally updates stored cluster assignments (photo ID -> group ID, 0 = ungrouped)
+// Only photos that were added or removed since the assignments were computed are evaluated:
+// removed photos are dropped, groups left with fewer than MinPts photos are dissolved, and
+// each added photo joins the group of its most similar grouped neighbor or, if it has enough
+// ungrouped neighbors, starts a new group with them. Existing groups keep their IDs
+func (d *DBSCAN) Update(embeddings map[int64][]float64, assignments map[int64]int64, nextGroupID int64) map[int64]int64 {
+	ids := sortedPhotoIDs(embeddings)
+
+	// Keep assignments of photos that still have embeddings
+	updated := make(map[int64]int64, len(embeddings))
+	groupSizes := make(map[int64]int)
+	for _, id := range ids {
+		if groupID, ok := assignments[id]; ok {
+			updated[id] = groupID
+			if groupID > 0 {
+				groupSizes[groupID]++
+			}
+		}
+	}
+
+	// Dissolve groups that shrank below the minimum size
+	for id, groupID := range updated {
+		if groupID > 0 && groupSizes[groupID] < d.MinPts {
+			updated[id] = 0
+		}
+	}
+
+	for _, id := range ids {
+		if _, evaluated := updated[id]; evaluated {
+			continue
+		}
+
+		neighbors := d.regionQuery(id, ids, embeddings)
+
+		// Prefer joining the existing group of the most similar neighbor
+		// (on equal similarity, the neighbor with the lowest ID)
+		var bestGroup int64
+		bestSim := -1.0
+		for _, neighborID := range neighbors {
+			if updated[neighborID] <= 0 {
+				continue
+			}
+			if sim := CosineSimilarity(embeddings[id], embeddings[neighborID]); sim > bestSim {
+				bestSim = sim
+				bestGroup = updated[neighborID]
+			}
+		}
+		if bestGroup > 0 {
+			updated[id] = bestGroup
+			continue
+		}
+
+		if len(neighbors) < d.MinPts {
+			updated[id] = 0
+			continue
+		}
+
+		// Start a new group with the neighbors that are not grouped yet
+		updated[id] = nextGroupID
+		for _, neighborID := range neighbors {
+			if groupID, evaluated := updated[neighborID]; !evaluated || groupID == 0 {
+				updated[neighborID] = nextGroupID
+			}
+		}
+		nextGroupID++
+	}
+
+	return updated
+}
+
+// AssignGroupIDs converts a fresh clustering result into assignments (photo ID -> group ID),
+// reusing the previous ID of the group each new group overlaps most so references stay valid
+func AssignGroupIDs(result ClusteringResult, previous map[int64]int64, nextGroupID int64) map[int64]int64 {
+	assignments := make(map[int64]int64)
+	for _, id := range result.Ungrouped {
+		assignments[id] = 0
+	}
+
+	used := make(map[int64]bool)
+	for _, group := range result.Groups {
+		overlap := make(map[int64]int)
+		for _, id := range group.PhotoIDs {
+			if groupID := previous[id]; groupID > 0 && !used[groupID] {
+				overlap[groupID]++
+			}
+		}
+
+		// Pick the previous group with the largest overlap, lowest ID on ties
+		var groupID int64
+		best := 0
+		for candidate, count := range overlap {
+			if count > best || (count == best && candidate < groupID) {
+				groupID = candidate
+				best = count
+			}
+		}
+		if groupID == 0 {
+			groupID = nextGroupID
+			nextGroupID++
+		}
+		used[groupID] = true
+
+		for _, id := range group.PhotoIDs {
+			assignments[id] = groupID
+		}
+	}
+
+	return assignments
+}
+
+// GroupsFromAssignments builds a clustering result from stored assignments
+// Groups keep their assigned IDs and are sorted by size (largest first)
+func (d *DBSCAN) GroupsFromAssignments(embeddings map[int64][]float64, assignments map[int64]int64) ClusteringResult {
+	result := ClusteringResult{
+		Groups:    make([]PhotoGroup, 0),
+		Ungrouped: make([]int64, 0),
+	}
+
+	ids := make([]int64, 0, len(assignments))
+	for id := range assignments {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	clusters := make(map[int64][]int64)
+	for _, id := range ids {
+		if groupID := assignments[id]; groupID > 0 {
+			clusters[groupID] = append(clusters[groupID], id)
+		} else {
+			result.Ungrouped = append(result.Ungrouped, id)
+		}
+	}
+
+	for groupID, photoIDs := range clusters {
+		result.Groups = append(result.Groups, PhotoGroup{
+			GroupID:       int(groupID),
+			PhotoIDs:      photoIDs,
+			AvgSimilarity: d.calculateAvgSimilarity(photoIDs, embeddings),
+		})
+	}
+
+	sort.Slice(result.Groups, func(i, j int) bool {
+		if len(result.Groups[i].PhotoIDs) != len(result.Groups[j].PhotoIDs) {
+			return len(result.Groups[i].PhotoIDs) > len(result.Groups[j].PhotoIDs)
+		}
+		return result.Groups[i].GroupID < result.Groups[j].GroupID
+	})
+
+	return result
+}
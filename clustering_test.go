@@ -0,0 +1,69 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// testEmbeddings returns 2-D unit vectors at the given angles (degrees), keyed
+// by photo ID and inserted in a random order. Equal gaps between angles give
+// equal similarities, so tie-breaks are exercised
+func testEmbeddings(angles map[int64]float64, rng *rand.Rand) map[int64][]float64 {
+	ids := make([]int64, 0, len(angles))
+	for id := range angles {
+		ids = append(ids, id)
+	}
+	rng.Shuffle(len(ids), func(i, j int) { ids[i], ids[j] = ids[j], ids[i] })
+
+	embeddings := make(map[int64][]float64, len(ids))
+	for _, id := range ids {
+		rad := angles[id] * math.Pi / 180
+		embeddings[id] = []float64{math.Cos(rad), math.Sin(rad)}
+	}
+	return embeddings
+}
+
+var clusteringAngles = map[int64]float64{
+	// Two groups of the same size, so their order comes down to the tie-break
+	11: 0, 12: 10, 13: 20,
+	21: 90, 22: 100, 23: 110,
+	// Two pairs, and photos too far from anything to be grouped
+	31: 200, 32: 210,
+	41: 300, 42: 310,
+	40: 255, 50: 145,
+}
+
+func TestClusterIsDeterministic(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	dbscan := &DBSCAN{Eps: 0.1, MinPts: 2}
+
+	want := dbscan.Cluster(testEmbeddings(clusteringAngles, rng))
+	if len(want.Groups) == 0 {
+		t.Fatal("no groups found")
+	}
+
+	for i := 0; i < 50; i++ {
+		got := dbscan.Cluster(testEmbeddings(clusteringAngles, rng))
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("run %d: got %+v, want %+v", i, got, want)
+		}
+	}
+}
+
+func TestFindSimilarPhotosIsDeterministic(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	want := FindSimilarPhotos(testEmbeddings(clusteringAngles, rng), 0.9)
+	if len(want) == 0 {
+		t.Fatal("no similar pairs found")
+	}
+
+	for i := 0; i < 50; i++ {
+		got := FindSimilarPhotos(testEmbeddings(clusteringAngles, rng), 0.9)
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("run %d: got %+v, want %+v", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// HandleListComments returns the comments on a photo
+// Anyone who can view the photo can read its comments
+func (app *App) HandleListComments(w http.ResponseWriter, r *http.Request) {
+	_, photo := app.viewablePhotoFromPath(w, r)
+	if photo == nil {
+		return
+	}
+
+	comments, err := app.db.GetComments(photo.ID)
+	if err != nil {
+		http.Error(w, "Failed to get comments", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":   "success",
+		"comments": comments,
+	})
+}
+
+// HandleAddComment adds a comment to a photo
+// Body: {"body": "Lovely!"}; anyone who can view the photo can comment on it
+func (app *App) HandleAddComment(w http.ResponseWriter, r *http.Request) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := app.sessionMgr.ValidateCSRF(r, session); err != nil {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	photo := app.photoFromPathFor(w, r, session)
+	if photo == nil {
+		return
+	}
+
+	// Limit request body size
+	r.Body = http.MaxBytesReader(w, r.Body, MaxJSONBodyBytes)
+
+	var req struct {
+		Body string `json:"body"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	body := strings.TrimSpace(req.Body)
+	if body == "" {
+		http.Error(w, "Comment cannot be empty", http.StatusBadRequest)
+		return
+	}
+	if utf8.RuneCountInString(body) > MaxCommentLength {
+		http.Error(w, fmt.Sprintf("Comment must be at most %d characters", MaxCommentLength), http.StatusBadRequest)
+		return
+	}
+
+	comment, err := app.db.CreateComment(photo.ID, session.UserID, body)
+	if err != nil {
+		http.Error(w, "Failed to add comment", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "success",
+		"comment": comment,
+	})
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// gzipWriters reuses compressors between responses; each holds sizeable buffers
+var gzipWriters = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(nil)
+	},
+}
+
+// gzipMiddleware compresses JSON and text responses for clients that accept gzip
+// Responses under GzipMinBytes, images and other binary types, responses that
+// already have a Content-Encoding, and range and HEAD requests are sent as is
+func gzipMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead || r.Header.Get("Range") != "" || !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.Close()
+
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether the request's Accept-Encoding allows gzip
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			continue
+		}
+		// "gzip;q=0" means the client refuses it
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if weight, err := strconv.ParseFloat(q, 64); err == nil && weight == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// compressibleType reports whether a Content-Type is worth compressing:
+// text, JSON and JavaScript. Images, archives and video are already compressed
+func compressibleType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "text/"):
+		return true
+	case mediaType == "application/json", mediaType == "application/javascript", mediaType == "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter holds back the start of a response until it knows whether
+// to compress it: once GzipMinBytes are written, or when the handler finishes
+// or flushes. Until then the status code and body are buffered
+type gzipResponseWriter struct {
+	http.ResponseWriter
+
+	status  int
+	buf     []byte
+	started bool
+	gz      *gzip.Writer
+}
+
+// WriteHeader records the status; it is sent when the response starts
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if g.started {
+		g.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if g.status == 0 {
+		g.status = status
+	}
+}
+
+// Write buffers until the response is large enough to decide on compression
+func (g *gzipResponseWriter) Write(p []byte) (int, error) {
+	if !g.started {
+		g.buf = append(g.buf, p...)
+		if len(g.buf) < GzipMinBytes {
+			return len(p), nil
+		}
+		if err := g.start(true); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	if g.gz != nil {
+		return g.gz.Write(p)
+	}
+	return g.ResponseWriter.Write(p)
+}
+
+// Flush starts the response (compressed if the type allows, whatever its size
+// so far) and pushes out everything written
+func (g *gzipResponseWriter) Flush() {
+	if !g.started {
+		if err := g.start(true); err != nil {
+			return
+		}
+	}
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close sends a response still being held back, uncompressed since it is
+// under GzipMinBytes, and finishes the gzip stream
+func (g *gzipResponseWriter) Close() {
+	if !g.started {
+		g.start(false)
+	}
+	if g.gz != nil {
+		g.gz.Close()
+		gzipWriters.Put(g.gz)
+		g.gz = nil
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (g *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+// start sends the headers, compressing if allowed and the response qualifies,
+// then the buffered body
+func (g *gzipResponseWriter) start(allowCompress bool) error {
+	g.started = true
+
+	h := g.Header()
+	if h.Get("Content-Type") == "" && len(g.buf) > 0 {
+		// What net/http would otherwise sniff when the body is written
+		h.Set("Content-Type", http.DetectContentType(g.buf))
+	}
+
+	if compressibleType(h.Get("Content-Type")) {
+		h.Add("Vary", "Accept-Encoding")
+
+		bodiless := g.status == http.StatusNoContent || g.status == http.StatusNotModified || g.status == http.StatusPartialContent
+		if allowCompress && !bodiless && h.Get("Content-Encoding") == "" {
+			h.Del("Content-Length")
+			h.Set("Content-Encoding", "gzip")
+			g.gz = gzipWriters.Get().(*gzip.Writer)
+			g.gz.Reset(g.ResponseWriter)
+		}
+	}
+
+	if g.status != 0 {
+		g.ResponseWriter.WriteHeader(g.status)
+	}
+
+	buf := g.buf
+	g.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	if g.gz != nil {
+		_, err := g.gz.Write(buf)
+		return err
+	}
+	_, err := g.ResponseWriter.Write(buf)
+	return err
+}
@@ -2,9 +2,14 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 // Config holds the application configuration
@@ -12,6 +17,7 @@ type Config struct {
 	Port          int    `json:"port"`
 	StoragePath   string `json:"storage_path"`
 	BindAddress   string `json:"bind_address"`
+	UnixSocket    string `json:"unix_socket"` // Listen on this Unix domain socket instead of bind_address:port (for reverse proxies)
 	MaxUploadMB   int64  `json:"max_upload_mb"`
 	SessionExpHrs int    `json:"session_expiry_hours"`
 	EnableHTTPS   bool   `json:"enable_https"`
@@ -19,17 +25,90 @@ type Config struct {
 	KeyPath       string `json:"key_path"`
 	UseMkcert     bool   `json:"use_mkcert"` // Set to true if using mkcert certificates (suppresses warning messages)
 
+	// HTTP server limits (0 = no limit / Go default)
+	ReadHeaderTimeoutSecs int `json:"read_header_timeout_seconds"` // Time allowed to send request headers (guards against slowloris)
+	ReadTimeoutSecs       int `json:"read_timeout_seconds"`        // Time allowed to read a whole request, including upload bodies
+	WriteTimeoutSecs      int `json:"write_timeout_seconds"`       // Time allowed to write a response, including bulk downloads
+	IdleTimeoutSecs       int `json:"idle_timeout_seconds"`        // How long idle keep-alive connections stay open
+	MaxHeaderBytes        int `json:"max_header_bytes"`            // Maximum size of request headers
+
+	// Responses
+	CompressResponses bool `json:"compress_responses"` // gzip JSON and text responses for clients that accept it (photos are never compressed)
+
+	// Debugging
+	LogRequestBodies bool `json:"log_request_bodies"` // Log the JSON bodies of /api/ requests, truncated, with passwords, keys and tokens redacted
+
+	// Security
+	AllowRegistration  bool     `json:"allow_registration"`    // Let anyone who can reach the server create an account (the first account is always allowed)
+	FirstRunSetup      bool     `json:"first_run_setup"`       // With no accounts yet, send visitors to /setup to create the admin instead of making the first registration admin
+	AdminUsername      string   `json:"admin_username"`        // Create this admin account at startup; self-registered users are then never admin ("" = first user to register becomes admin)
+	AdminPassword      string   `json:"admin_password"`        // Password for admin_username, used only when the account is created
+	AllowPublicLinks   bool     `json:"allow_public_links"`    // Let users create expiring links that show one of their photos to people without an account
+	StripExifForOthers bool     `json:"strip_exif_for_others"` // Serve originals without EXIF/XMP metadata (GPS, camera details) to anyone but the owner
+	BcryptCost         int      `json:"bcrypt_cost"`           // bcrypt cost for password hashes (older hashes are upgraded on login)
+	CORSAllowedOrigins []string `json:"cors_allowed_origins"`  // Origins (e.g. https://app.example.com) allowed to call the API cross-origin (empty = same-origin only)
+	CookieName         string   `json:"cookie_name"`           // Session cookie name (change it to run several instances on one domain)
+	CookieSecure       string   `json:"cookie_secure"`         // Mark the session cookie Secure: "auto" (when served over TLS), "always" (behind a TLS-terminating proxy) or "never"
+	CookieSameSite     string   `json:"cookie_same_site"`      // Session cookie SameSite policy: "strict", "lax" (survives following links from other sites) or "none" (requires cookie_secure "always")
+
+	// Database
+	DBJournalMode   string `json:"db_journal_mode"`    // SQLite journal mode: "wal" lets uploads and browsing run concurrently; "delete" is SQLite's default
+	DBBusyTimeoutMs int    `json:"db_busy_timeout_ms"` // How long to wait for a locked database before failing
+	DBSynchronous   string `json:"db_synchronous"`     // "normal" (fast, safe with WAL) or "full" (sync on every commit)
+	DBMaxOpenConns  int    `json:"db_max_open_conns"`  // Connection pool size in WAL mode (other modes always use 1)
+
+	// Storage
+	StorageBackend   string `json:"storage_backend"`     // Where photo files live: "local" (under storage_path)
+	TempDir          string `json:"temp_dir"`            // Where uploads are staged before being moved into place (default: <storage_path>/tmp)
+	LowDiskWarningMB int64  `json:"low_disk_warning_mb"` // Flag the storage volume in admin stats and the log when less than this is free (0 = never)
+
+	// Uploads
+	DefaultVisibility        string `json:"default_visibility"`         // "user" follows each user's default_shared setting; "private" or "shared" overrides it
+	FilenameStrategy         string `json:"filename_strategy"`          // On name collisions: "suffix" appends _N, "timestamp" appends the upload time, "uuid" always stores under a random name
+	NormalizeToJPEG          bool   `json:"normalize_to_jpeg"`          // Convert PNG, WebP and still GIF uploads to JPEG (stored as .jpg; the uploaded name is kept)
+	NormalizeKeepTransparent bool   `json:"normalize_keep_transparent"` // With normalize_to_jpeg, leave images with transparency as uploaded instead of flattening them onto white
+
+	// Thumbnails
+	ThumbnailMode           string   `json:"thumbnail_mode"`            // "fit" keeps the aspect ratio, "fill" crops to a uniform square
+	ThumbnailQuality        int      `json:"thumbnail_quality"`         // JPEG quality for generated thumbnails (1-100); lower is smaller but blurrier
+	ThumbnailFilter         string   `json:"thumbnail_filter"`          // Resampling filter: "lanczos" (sharpest), "catmull_rom", "linear" or "nearest_neighbor" (fastest)
+	AnimatedGIFThumbnails   bool     `json:"animated_gif_thumbnails"`   // Show animated GIFs animated in the gallery instead of a first-frame poster
+	ThumbnailCacheMB        int64    `json:"thumbnail_cache_mb"`        // Disk budget for thumbnails and cached variants; least recently served are evicted (0 = unlimited)
+	ThumbnailSkipExtensions []string `json:"thumbnail_skip_extensions"` // Extensions (e.g. ".gif") that get no stored thumbnail; they are resized on each request, or served as is when already thumbnail-sized
+	ArchiveDropThumbnails   bool     `json:"archive_drop_thumbnails"`   // Delete thumbnails when photos are archived (originals are kept); archived thumbnails are then resized on each request
+
+	// Background jobs
+	AutoArchiveIntervalHours int `json:"auto_archive_interval_hours"` // How often to run the auto-archive sweep (0 = disabled)
+	SessionCleanupHours      int `json:"session_cleanup_hours"`       // How often expired sessions and stale login attempts are cleared
+
+	// Database backups
+	BackupDir           string `json:"backup_dir"`            // Where database backups are written (default: <storage_path>/backups)
+	BackupIntervalHours int    `json:"backup_interval_hours"` // How often to back up the database automatically (0 = only on demand)
+	BackupKeep          int    `json:"backup_keep"`           // Newest backups to keep; older ones are deleted (0 = keep all)
+
 	// Photo Selector / AI Features
-	EmbeddingServiceURL string `json:"embedding_service_url"` // CLIP embedding service URL
-	SimilarityThreshold float64 `json:"similarity_threshold"` // Threshold for grouping similar photos (0-1)
+	EmbeddingProvider   string  `json:"embedding_provider"`       // clip (bundled service) or openai (OpenAI-compatible /embeddings API)
+	EmbeddingServiceURL string  `json:"embedding_service_url"`    // CLIP embedding service URL, or the API base URL for openai
+	EmbeddingAPIKey     string  `json:"embedding_api_key"`        // Bearer token for the openai provider (optional)
+	EmbeddingModel      string  `json:"embedding_model"`          // Model name for the openai provider
+	AutoEmbedOnUpload   bool    `json:"auto_embed_on_upload"`     // Generate each upload's embedding in the background so similar-photo groups stay current
+	SimilarityThreshold float64 `json:"similarity_threshold"`     // Threshold for grouping similar photos (0-1)
+	AIMaxConcurrent     int     `json:"ai_max_concurrent"`        // LLM and embedding calls allowed at once across all users; the rest queue (0 = unlimited)
+	AIQueueTimeoutSecs  int     `json:"ai_queue_timeout_seconds"` // How long a queued call waits for a slot before failing
 
 	// LLM Configuration
-	LLMProvider        string `json:"llm_provider"`         // openai, azure, gemini, custom
-	LLMAPIKey          string `json:"llm_api_key"`          // API key for the LLM provider
-	LLMBaseURL         string `json:"llm_base_url"`         // Base URL (for Azure/custom providers)
-	LLMModel           string `json:"llm_model"`            // Model name (e.g., gpt-4o, gemini-1.5-pro)
-	LLMAzureDeployment string `json:"llm_azure_deployment"` // Azure deployment name
-	LLMAzureAPIVersion string `json:"llm_azure_api_version"` // Azure API version
+	LLMProvider             string           `json:"llm_provider"`                // openai, azure, gemini, custom
+	LLMAPIKey               string           `json:"llm_api_key"`                 // API key for the LLM provider
+	LLMBaseURL              string           `json:"llm_base_url"`                // Base URL (for Azure/custom providers)
+	LLMModel                string           `json:"llm_model"`                   // Model name (e.g., gpt-4o, gemini-1.5-pro)
+	LLMAzureDeployment      string           `json:"llm_azure_deployment"`        // Azure deployment name
+	LLMAzureAPIVersion      string           `json:"llm_azure_api_version"`       // Azure API version
+	LLMPromptTemplate       string           `json:"llm_prompt_template"`         // Custom analysis prompt ({photo_count}, {photo_list} placeholders)
+	LLMScoreWeights         ScoreWeights     `json:"llm_score_weights"`           // Weights for recomputing overall_score (all zero = trust the model)
+	LLMFallbackModel        string           `json:"llm_fallback_model"`          // Model (Azure: deployment) to retry with if the primary call fails transiently (timeout, 429, 5xx)
+	LLMImageMaxDimension    int              `json:"llm_image_max_dimension"`     // Longest side photos are shrunk to before analysis (0 = send originals)
+	LLMMaxPhotosPerAnalysis int              `json:"llm_max_photos_per_analysis"` // Most photos sent in one analysis; larger groups are narrowed to the most distinct by embedding, or rejected (0 = no limit)
+	LLMAlternatives         []LLMAlternative `json:"llm_alternatives"`            // Other providers/models an analysis request may pick with "provider"/"model" (empty = only the default)
 }
 
 // DefaultConfig returns a config with sensible defaults
@@ -44,9 +123,57 @@ func DefaultConfig() *Config {
 		CertPath:      "./certs/server.crt",
 		KeyPath:       "./certs/server.key",
 
+		// HTTP server defaults (generous read/write limits for large uploads and downloads)
+		ReadHeaderTimeoutSecs: 10,
+		ReadTimeoutSecs:       300,
+		WriteTimeoutSecs:      600,
+		IdleTimeoutSecs:       120,
+		MaxHeaderBytes:        1 << 20, // 1MB
+
+		// Response defaults
+		CompressResponses: true,
+
+		// Security defaults
+		AllowRegistration: true,
+		FirstRunSetup:     true,
+		AllowPublicLinks:  true,
+		BcryptCost:        BcryptCost,
+		CookieName:        sessionCookieName,
+		CookieSecure:      CookieSecureAuto,
+		CookieSameSite:    CookieSameSiteStrict,
+
+		// Database defaults (WAL so concurrent uploads and reads don't hit "database is locked")
+		DBJournalMode:   "wal",
+		DBBusyTimeoutMs: 5000,
+		DBSynchronous:   "normal",
+		DBMaxOpenConns:  4,
+
+		// Storage defaults
+		StorageBackend:   "local",
+		LowDiskWarningMB: 1024,
+
+		// Upload defaults
+		DefaultVisibility: VisibilityUser,
+		FilenameStrategy:  FilenameStrategySuffix,
+
+		// Thumbnail defaults
+		ThumbnailMode:    ThumbnailModeFit,
+		ThumbnailQuality: ThumbnailQuality,
+		ThumbnailFilter:  "lanczos",
+
+		// Background job defaults
+		AutoArchiveIntervalHours: 24, // Only affects users who opt in
+		SessionCleanupHours:      SessionCleanupHours,
+
+		// Backup defaults
+		BackupKeep: 7,
+
 		// Photo Selector defaults
+		EmbeddingProvider:   string(EmbeddingProviderCLIP),
 		EmbeddingServiceURL: "http://127.0.0.1:8081",
 		SimilarityThreshold: 0.75, // 75% similarity
+		AIMaxConcurrent:     4,
+		AIQueueTimeoutSecs:  60,
 
 		// LLM defaults (unconfigured)
 		LLMProvider:        "",
@@ -55,21 +182,168 @@ func DefaultConfig() *Config {
 		LLMModel:           "",
 		LLMAzureDeployment: "",
 		LLMAzureAPIVersion: "2024-02-15-preview",
+
+		// Plenty to compare sharpness and exposure, at a fraction of the tokens
+		LLMImageMaxDimension: 1024,
+
+		// Enough for a typical burst without hitting token limits or timeouts
+		LLMMaxPhotosPerAnalysis: 8,
 	}
 }
 
 // GetLLMConfig returns the LLM configuration
 func (c *Config) GetLLMConfig() LLMConfig {
 	return LLMConfig{
-		Provider:        LLMProvider(c.LLMProvider),
-		APIKey:          c.LLMAPIKey,
-		BaseURL:         c.LLMBaseURL,
-		Model:           c.LLMModel,
-		AzureDeployment: c.LLMAzureDeployment,
-		AzureAPIVersion: c.LLMAzureAPIVersion,
+		Provider:          LLMProvider(c.LLMProvider),
+		APIKey:            c.LLMAPIKey,
+		BaseURL:           c.LLMBaseURL,
+		Model:             c.LLMModel,
+		AzureDeployment:   c.LLMAzureDeployment,
+		AzureAPIVersion:   c.LLMAzureAPIVersion,
+		PromptTemplate:    c.LLMPromptTemplate,
+		ScoreWeights:      c.LLMScoreWeights,
+		FallbackModel:     c.LLMFallbackModel,
+		ImageMaxDimension: c.LLMImageMaxDimension,
+	}
+}
+
+// errLLMNotAllowed means a request asked for a provider/model that isn't in llm_alternatives
+var errLLMNotAllowed = errors.New("provider/model is not in llm_alternatives")
+
+// GetLLMConfigFor returns the LLM configuration for a request's provider/model choice
+// Empty values (or ones naming the default) give the default configuration;
+// anything else must match an entry in llm_alternatives. Alternatives don't
+// retry with llm_fallback_model, so a comparison reports the model that was asked for
+func (c *Config) GetLLMConfigFor(provider LLMProvider, model string) (LLMConfig, error) {
+	config := c.GetLLMConfig()
+	if provider == "" {
+		provider = config.Provider
+	}
+	if provider == config.Provider && (model == "" || model == c.defaultLLMModel()) {
+		return config, nil
+	}
+
+	for _, alt := range c.LLMAlternatives {
+		if alt.Provider != provider || (model != "" && alt.Model != model) {
+			continue
+		}
+
+		if alt.Provider != config.Provider {
+			config.APIKey, config.BaseURL = "", ""
+		}
+		config.Provider = alt.Provider
+		config.Model = alt.Model
+		config.FallbackModel = ""
+		if alt.APIKey != "" {
+			config.APIKey = alt.APIKey
+		}
+		if alt.BaseURL != "" {
+			config.BaseURL = alt.BaseURL
+		}
+		if alt.AzureAPIVersion != "" {
+			config.AzureAPIVersion = alt.AzureAPIVersion
+		}
+		if alt.Provider == ProviderAzure {
+			config.AzureDeployment = alt.Model
+		}
+		return config, nil
+	}
+
+	return LLMConfig{}, errLLMNotAllowed
+}
+
+// defaultLLMModel is the model (Azure: deployment) the default configuration uses
+func (c *Config) defaultLLMModel() string {
+	if LLMProvider(c.LLMProvider) == ProviderAzure {
+		return c.LLMAzureDeployment
+	}
+	return c.LLMModel
+}
+
+// GetCookieOptions returns the session cookie settings
+func (c *Config) GetCookieOptions() CookieOptions {
+	return CookieOptions{
+		Name:     c.CookieName,
+		Secure:   c.CookieSecure,
+		SameSite: c.CookieSameSite,
 	}
 }
 
+// GetTempDir returns the directory uploads are staged in
+// It should be on the same filesystem as storage_path so the final move is an atomic rename
+func (c *Config) GetTempDir() string {
+	if c.TempDir != "" {
+		return c.TempDir
+	}
+	return filepath.Join(c.StoragePath, "tmp")
+}
+
+// GetEmbeddingConfig returns the embedding provider configuration
+func (c *Config) GetEmbeddingConfig() EmbeddingConfig {
+	return EmbeddingConfig{
+		Provider: EmbeddingProvider(c.EmbeddingProvider),
+		BaseURL:  c.EmbeddingServiceURL,
+		APIKey:   c.EmbeddingAPIKey,
+		Model:    c.EmbeddingModel,
+	}
+}
+
+// GetDatabaseOptions returns the SQLite connection settings
+func (c *Config) GetDatabaseOptions() DatabaseOptions {
+	return DatabaseOptions{
+		JournalMode:   c.DBJournalMode,
+		BusyTimeoutMs: c.DBBusyTimeoutMs,
+		Synchronous:   c.DBSynchronous,
+		MaxOpenConns:  c.DBMaxOpenConns,
+	}
+}
+
+// GetBackupDir returns the directory database backups are written to
+func (c *Config) GetBackupDir() string {
+	if c.BackupDir != "" {
+		return c.BackupDir
+	}
+	return filepath.Join(c.StoragePath, "backups")
+}
+
+// GetNormalizeOptions returns the upload conversion settings
+func (c *Config) GetNormalizeOptions() NormalizeOptions {
+	return NormalizeOptions{
+		ToJPEG:          c.NormalizeToJPEG,
+		KeepTransparent: c.NormalizeKeepTransparent,
+	}
+}
+
+// GetThumbnailOptions returns the thumbnail generation settings
+func (c *Config) GetThumbnailOptions() ThumbnailOptions {
+	return ThumbnailOptions{
+		Mode:          c.ThumbnailMode,
+		Quality:       c.ThumbnailQuality,
+		Filter:        c.ThumbnailFilter,
+		AnimatedGIFs:  c.AnimatedGIFThumbnails,
+		CacheBytes:    c.ThumbnailCacheMB * 1024 * 1024,
+		DropOnArchive: c.ArchiveDropThumbnails,
+
+		SkipExtensions: normalizeExtensions(c.ThumbnailSkipExtensions),
+	}
+}
+
+// normalizeExtensions lowercases extensions and gives each a leading dot
+func normalizeExtensions(exts []string) []string {
+	normalized := make([]string, 0, len(exts))
+	for _, ext := range exts {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		normalized = append(normalized, ext)
+	}
+	return normalized
+}
+
 // IsLLMConfigured checks if LLM is configured
 func (c *Config) IsLLMConfigured() bool {
 	return c.LLMProvider != "" && c.LLMAPIKey != ""
@@ -132,10 +406,178 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("storage_path cannot be empty")
 	}
 
+	if c.BcryptCost < bcrypt.MinCost || c.BcryptCost > bcrypt.MaxCost {
+		return fmt.Errorf("bcrypt_cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+
+	if c.AdminUsername != "" {
+		if len(c.AdminUsername) < 3 || len(c.AdminUsername) > 32 || !usernameRegex.MatchString(c.AdminUsername) {
+			return fmt.Errorf("admin_username must be 3-32 letters, numbers or underscores")
+		}
+		if len(c.AdminPassword) < 6 {
+			return fmt.Errorf("admin_password must be at least 6 characters")
+		}
+	}
+
+	for _, origin := range c.CORSAllowedOrigins {
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.TrimSuffix(u.Path, "/") != "" {
+			return fmt.Errorf("invalid cors_allowed_origins entry %q: must be scheme://host[:port]", origin)
+		}
+	}
+
+	switch c.StorageBackend {
+	case "", "local":
+	default:
+		return fmt.Errorf("unsupported storage_backend: %s", c.StorageBackend)
+	}
+
+	if c.LowDiskWarningMB < 0 {
+		return fmt.Errorf("low_disk_warning_mb cannot be negative")
+	}
+
+	switch strings.ToLower(c.DBJournalMode) {
+	case "", "wal", "delete", "truncate", "persist":
+	default:
+		return fmt.Errorf("db_journal_mode must be wal, delete, truncate or persist")
+	}
+
+	switch strings.ToLower(c.DBSynchronous) {
+	case "", "off", "normal", "full", "extra":
+	default:
+		return fmt.Errorf("db_synchronous must be off, normal, full or extra")
+	}
+
+	if c.DBBusyTimeoutMs < 0 {
+		return fmt.Errorf("db_busy_timeout_ms cannot be negative")
+	}
+
+	if c.DBMaxOpenConns < 0 {
+		return fmt.Errorf("db_max_open_conns cannot be negative")
+	}
+
+	switch c.DefaultVisibility {
+	case "", VisibilityUser, VisibilityPrivate, VisibilityShared:
+	default:
+		return fmt.Errorf("default_visibility must be %q, %q or %q", VisibilityUser, VisibilityPrivate, VisibilityShared)
+	}
+
+	if c.CookieName != "" && !cookieNameRegex.MatchString(c.CookieName) {
+		return fmt.Errorf("cookie_name may only contain letters, digits, '_', '.' and '-'")
+	}
+
+	switch c.CookieSecure {
+	case "", CookieSecureAuto, CookieSecureAlways, CookieSecureNever:
+	default:
+		return fmt.Errorf("cookie_secure must be %q, %q or %q", CookieSecureAuto, CookieSecureAlways, CookieSecureNever)
+	}
+
+	switch c.CookieSameSite {
+	case "", CookieSameSiteStrict, CookieSameSiteLax:
+	case CookieSameSiteNone:
+		// Browsers reject SameSite=None cookies that aren't Secure
+		if c.CookieSecure != CookieSecureAlways {
+			return fmt.Errorf("cookie_same_site %q requires cookie_secure %q", CookieSameSiteNone, CookieSecureAlways)
+		}
+	default:
+		return fmt.Errorf("cookie_same_site must be %q, %q or %q", CookieSameSiteStrict, CookieSameSiteLax, CookieSameSiteNone)
+	}
+
+	if c.FilenameStrategy != "" && !isFilenameStrategy(c.FilenameStrategy) {
+		return fmt.Errorf("filename_strategy must be %q, %q or %q", FilenameStrategySuffix, FilenameStrategyTimestamp, FilenameStrategyUUID)
+	}
+
+	switch c.ThumbnailMode {
+	case "", ThumbnailModeFit, ThumbnailModeFill:
+	default:
+		return fmt.Errorf("thumbnail_mode must be %q or %q", ThumbnailModeFit, ThumbnailModeFill)
+	}
+
+	if _, ok := thumbnailFilters[c.ThumbnailFilter]; !ok && c.ThumbnailFilter != "" {
+		return fmt.Errorf("thumbnail_filter must be %q, %q, %q or %q", "lanczos", "catmull_rom", "linear", "nearest_neighbor")
+	}
+
+	if c.ThumbnailQuality < 1 || c.ThumbnailQuality > 100 {
+		return fmt.Errorf("thumbnail_quality must be between 1 and 100")
+	}
+
+	if c.ThumbnailCacheMB < 0 {
+		return fmt.Errorf("thumbnail_cache_mb cannot be negative")
+	}
+
+	if c.ReadHeaderTimeoutSecs < 0 || c.ReadTimeoutSecs < 0 || c.WriteTimeoutSecs < 0 || c.IdleTimeoutSecs < 0 {
+		return fmt.Errorf("server timeouts cannot be negative")
+	}
+
+	if c.MaxHeaderBytes < 0 {
+		return fmt.Errorf("max_header_bytes cannot be negative")
+	}
+
 	if c.MaxUploadMB < 1 {
 		return fmt.Errorf("max_upload_mb must be at least 1")
 	}
 
+	if c.LLMPromptTemplate != "" {
+		if err := validatePromptTemplate(c.LLMPromptTemplate); err != nil {
+			return fmt.Errorf("invalid llm_prompt_template: %v", err)
+		}
+	}
+
+	if err := c.LLMScoreWeights.Validate(); err != nil {
+		return fmt.Errorf("invalid llm_score_weights: %v", err)
+	}
+
+	switch EmbeddingProvider(c.EmbeddingProvider) {
+	case "", EmbeddingProviderCLIP:
+	case EmbeddingProviderOpenAI:
+		if c.EmbeddingServiceURL == "" || c.EmbeddingModel == "" {
+			return fmt.Errorf("embedding_provider openai needs embedding_service_url and embedding_model")
+		}
+	default:
+		return fmt.Errorf("embedding_provider must be %q or %q", EmbeddingProviderCLIP, EmbeddingProviderOpenAI)
+	}
+
+	for i, alt := range c.LLMAlternatives {
+		switch alt.Provider {
+		case ProviderOpenAI, ProviderAzure, ProviderGemini, ProviderCustom:
+		default:
+			return fmt.Errorf("llm_alternatives[%d]: unsupported provider %q", i, alt.Provider)
+		}
+		if alt.Model == "" {
+			return fmt.Errorf("llm_alternatives[%d]: model is required", i)
+		}
+		if alt.APIKey == "" && alt.Provider != LLMProvider(c.LLMProvider) {
+			return fmt.Errorf("llm_alternatives[%d]: api_key is required for a provider other than llm_provider", i)
+		}
+	}
+
+	if c.LLMMaxPhotosPerAnalysis != 0 && c.LLMMaxPhotosPerAnalysis < 2 {
+		return fmt.Errorf("llm_max_photos_per_analysis must be 0 (no limit) or at least 2")
+	}
+
+	if c.LLMImageMaxDimension < 0 {
+		return fmt.Errorf("llm_image_max_dimension cannot be negative")
+	}
+
+	if c.AIMaxConcurrent < 0 {
+		return fmt.Errorf("ai_max_concurrent cannot be negative")
+	}
+	if c.AIMaxConcurrent > 0 && c.AIQueueTimeoutSecs < 1 {
+		return fmt.Errorf("ai_queue_timeout_seconds must be at least 1")
+	}
+
+	if c.AutoArchiveIntervalHours < 0 {
+		return fmt.Errorf("auto_archive_interval_hours cannot be negative")
+	}
+
+	if c.SessionCleanupHours < 1 {
+		return fmt.Errorf("session_cleanup_hours must be at least 1")
+	}
+
+	if c.BackupIntervalHours < 0 || c.BackupKeep < 0 {
+		return fmt.Errorf("backup_interval_hours and backup_keep cannot be negative")
+	}
+
 	return nil
 }
 
@@ -144,6 +586,7 @@ func (c *Config) EnsureDirectories() error {
 	dirs := []string{
 		c.StoragePath,
 		filepath.Join(c.StoragePath, "users"),
+		c.GetTempDir(),
 	}
 
 	if c.EnableHTTPS {
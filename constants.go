@@ -5,22 +5,70 @@ package main
 
 const (
 	// Security
-	BcryptCost          = 12        // bcrypt hashing cost (12 is recommended)
+	BcryptCost          = 12        // default bcrypt hashing cost (12 is recommended)
 	SessionTokenLength  = 32        // bytes for session token
 	CSRFTokenLength     = 32        // bytes for CSRF token
 	MaxLoginAttempts    = 5         // failed attempts before lockout
 	LockoutMinutes      = 15        // lockout duration in minutes
+	MaxAttemptUsernames = 10        // distinct usernames remembered per IP for the failed-login audit
+	InviteCodeLength    = 10        // bytes for invite codes (16 base32 characters)
+	PublicTokenLength   = 32        // bytes for public link tokens
+	PublicLinkHours     = 72        // how long a public link lasts when no expiry is given
+	MaxPublicLinkHours  = 30 * 24   // upper bound for a public link's expiry (30 days)
 
 	// File handling
 	ThumbnailSize       = 300       // pixels (width/height for thumbnail)
+	ThumbnailQuality    = 95        // default JPEG quality for thumbnails (the encoder's own default)
 	MaxFilenameLength   = 200       // characters
+	MaxCaptionLength    = 500       // characters
+	MaxCommentLength    = 2000      // characters
+	MaxGroupNameLength  = 100       // characters
 	MaxFilenameCounter  = 10000     // max attempts to find unique filename
+	VariantQuality      = 80        // default encoder quality for transcoded originals (1-100)
+	NormalizeQuality    = 90        // JPEG quality for uploads converted by normalize_to_jpeg
+	PlaceholderMaxAge   = 300       // seconds browsers may cache the placeholder served for a broken thumbnail
+	ThumbnailMaxAge     = 86400     // seconds browsers may cache a thumbnail without revalidating
+	ExifScanBytes       = 1 << 18   // 256KB at the start of an upload kept in memory for EXIF parsing
+	LLMImageQuality     = 85        // JPEG quality for photos shrunk before LLM analysis
+	LLMImageWorkers     = 4         // photos read and encoded at once for an LLM request
+	MinFreeSpaceMB      = 16        // disk space an upload must leave free (for thumbnails and the database)
+	MaxReportedFailures = 50        // per-photo failures listed in an embedding run's response
+	AutoEmbedQueueMax   = 1000      // uploads per user waiting for a background embedding
+
+	// Recent uploads
+	DefaultRecentDays   = 7         // window for /api/photos/recent when ?days= is omitted
+	MaxRecentDays       = 366       // upper bound for ?days=
+
+	// Sync
+	TombstoneDays       = 90        // how long deleted photos are reported by /api/photos/changed-since
+
+	// Pagination
+	DefaultPageSize     = 50        // photos per page when only an offset or filter is given
+	MaxPageSize         = 500       // upper bound for ?limit=
 
 	// Request limits
 	MaxJSONBodyBytes    = 64 * 1024 // 64KB for JSON request bodies
 	SmallJSONBodyBytes  = 1024      // 1KB for simple JSON (role updates, thresholds)
+	LogBodyBytes        = 2048      // characters of a request body written to the log by log_request_bodies
+	ZipBufferBytes      = 64 * 1024 // write buffer between a bulk download's zip and the connection
+	GzipMinBytes        = 1024      // smallest JSON or text response compressed by compress_responses
+	MaxFormBodyBytes    = 16 * 1024 // 16KB for login/registration forms
+	UploadOverheadBytes = 1 << 20   // multipart headers and fields allowed on top of max_upload_mb
+	UploadMemoryBytes   = 32 << 20  // multipart form kept in memory when the CSRF token is a form field; the rest spills to temp files
 
 	// Session cleanup
-	SessionCleanupHours = 1         // how often to clean expired sessions
+	SessionCleanupHours = 1         // default for how often to clean expired sessions
+
+	// Access counts
+	AccessFlushSecs     = 30        // how often buffered view/download counts are written to the database
+
+	// Server
+	ShutdownTimeoutSecs = 30        // how long in-flight requests get to finish on Ctrl+C / SIGTERM
+
+	// CORS
+	CORSMaxAgeSeconds   = 600       // how long browsers may cache a preflight response
+
+	// Auto-archive
+	MaxAutoArchiveDays  = 36500     // upper bound for the per-user age threshold (~100 years)
 )
 
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// AccessCounts is a photo's views and downloads not yet written to the database
+type AccessCounts struct {
+	Views     int64
+	Downloads int64
+}
+
+// AccessCounter buffers photo view and download counts in memory and writes
+// them in one transaction every AccessFlushSecs, so counting never adds a
+// database write to serving a photo
+type AccessCounter struct {
+	db *Database
+
+	mu      sync.Mutex
+	pending map[int64]AccessCounts
+}
+
+// NewAccessCounter creates a counter and starts its flush schedule
+func NewAccessCounter(db *Database) *AccessCounter {
+	c := &AccessCounter{
+		db:      db,
+		pending: make(map[int64]AccessCounts),
+	}
+
+	go c.run()
+
+	return c
+}
+
+// run flushes on every tick
+func (c *AccessCounter) run() {
+	ticker := time.NewTicker(AccessFlushSecs * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := c.Flush(); err != nil {
+			log.Printf("Failed to save access counts: %v", err)
+		}
+	}
+}
+
+// RecordView counts one view of a photo
+func (c *AccessCounter) RecordView(photoID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	counts := c.pending[photoID]
+	counts.Views++
+	c.pending[photoID] = counts
+}
+
+// RecordDownload counts one download of a photo
+func (c *AccessCounter) RecordDownload(photoID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	counts := c.pending[photoID]
+	counts.Downloads++
+	c.pending[photoID] = counts
+}
+
+// Flush writes buffered counts to the database
+// If the write fails the counts are kept for the next flush
+func (c *AccessCounter) Flush() error {
+	c.mu.Lock()
+	batch := c.pending
+	c.pending = make(map[int64]AccessCounts)
+	c.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	if err := c.db.AddAccessCounts(batch); err != nil {
+		c.mu.Lock()
+		for photoID, counts := range batch {
+			merged := c.pending[photoID]
+			merged.Views += counts.Views
+			merged.Downloads += counts.Downloads
+			c.pending[photoID] = merged
+		}
+		c.mu.Unlock()
+		return err
+	}
+
+	return nil
+}
+
+// HandleRecordView counts a view of a photo the user can see
+// The gallery calls this when a photo is opened in the viewer
+func (app *App) HandleRecordView(w http.ResponseWriter, r *http.Request) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Before the lookup, so a forged request can't probe which photos exist
+	if err := app.sessionMgr.ValidateCSRF(r, session); err != nil {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	photo := app.photoFromPathFor(w, r, session)
+	if photo == nil {
+		return
+	}
+
+	app.counter.RecordView(photo.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "success",
+	})
+}
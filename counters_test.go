@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandleRecordViewChecksCSRFBeforeLookup(t *testing.T) {
+	pm := newTestPhotoManager(t, nil)
+	user := newTestUser(t, pm.db, "alice")
+
+	sm := NewSessionManager(pm.db, 1, 1, CookieOptions{})
+	session := &Session{Token: "session", UserID: user.ID, Username: user.Username, ExpiresAt: time.Now().Add(time.Hour), CSRFToken: "csrf"}
+	sm.mu.Lock()
+	sm.sessions[session.Token] = session
+	sm.mu.Unlock()
+	app := &App{config: DefaultConfig(), db: pm.db, photoMgr: pm, sessionMgr: sm}
+
+	// Without a token, a missing photo must look the same as an existing one
+	req := httptest.NewRequest(http.MethodPost, "/api/photos/999/view", nil)
+	req.SetPathValue("photoID", "999")
+	req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: session.Token})
+
+	rec := httptest.NewRecorder()
+	app.HandleRecordView(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
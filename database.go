@@ -2,7 +2,14 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -11,7 +18,9 @@ import (
 
 // Database wraps the SQLite connection
 type Database struct {
-	db *sql.DB
+	db             *sql.DB
+	bcryptCost     int  // cost for new password hashes
+	firstUserAdmin bool // make the first user created an admin
 }
 
 // User represents a user in the system
@@ -19,23 +28,66 @@ type User struct {
 	ID           int64     `json:"id"`
 	Username     string    `json:"username"`
 	PasswordHash string    `json:"-"`
-	Role         string    `json:"role"` // "admin" or "user"
+	Role         string    `json:"role"`               // "admin" or "user"
+	GroupID      int64     `json:"group_id,omitempty"` // 0 = not in a group
 	CreatedAt    time.Time `json:"created_at"`
 }
 
 // Photo represents photo metadata in the database
 type Photo struct {
-	ID           int64      `json:"id"`
-	Filename     string     `json:"filename"`
-	UserID       int64      `json:"user_id"`
-	Username     string     `json:"username,omitempty"`
-	IsShared     bool       `json:"is_shared"`
-	IsArchived   bool       `json:"is_archived"`
-	ArchivedAt   *time.Time `json:"archived_at,omitempty"`
-	Size         int64      `json:"size"`
-	UploadedAt   time.Time  `json:"uploaded_at"`
-	ThumbnailURL string     `json:"thumbnail_url"`
-	OriginalURL  string     `json:"original_url"`
+	ID               int64      `json:"id"`
+	Filename         string     `json:"filename"`
+	UserID           int64      `json:"user_id"`
+	Username         string     `json:"username,omitempty"`
+	IsShared         bool       `json:"is_shared"`
+	IsArchived       bool       `json:"is_archived"`
+	ArchivedAt       *time.Time `json:"archived_at,omitempty"`
+	Size             int64      `json:"size"`
+	UploadedAt       time.Time  `json:"uploaded_at"`
+	Latitude         *float64   `json:"latitude,omitempty"`
+	Longitude        *float64   `json:"longitude,omitempty"`
+	Width            int        `json:"width,omitempty"`  // pixels, 0 if unknown
+	Height           int        `json:"height,omitempty"` // pixels, 0 if unknown
+	Blurhash         string     `json:"blurhash,omitempty"`
+	TakenAt          *time.Time `json:"taken_at,omitempty"`          // capture time from EXIF
+	TakenAtOverride  *time.Time `json:"taken_at_override,omitempty"` // manual correction, preferred over taken_at
+	Caption          string     `json:"caption,omitempty"`
+	PHash            string     `json:"-"`                       // perceptual hash (hex dHash), "" if unknown
+	ContentHash      string     `json:"content_hash,omitempty"`  // SHA-256 of the original file (hex)
+	OriginalName     string     `json:"original_name,omitempty"` // name as uploaded, when it differs from Filename
+	GroupID          int64      `json:"-"`                       // group a shared photo is visible to (0 = users in no group)
+	ViewCount        int64      `json:"view_count"`
+	DownloadCount    int64      `json:"download_count"`
+	UpdatedAt        time.Time  `json:"updated_at"` // last change to sharing, archiving, date, caption or location
+	ThumbnailDropped bool       `json:"-"`          // archived with archive_drop_thumbnails: no stored thumbnail
+	ThumbnailURL     string     `json:"thumbnail_url"`
+	OriginalURL      string     `json:"original_url"`
+}
+
+// CapturedAt returns the best known capture date: the manual override,
+// then the EXIF date, then the upload time
+func (p *Photo) CapturedAt() time.Time {
+	if p.TakenAtOverride != nil {
+		return *p.TakenAtOverride
+	}
+	if p.TakenAt != nil {
+		return *p.TakenAt
+	}
+	return p.UploadedAt
+}
+
+// DisplayName returns the name the photo was uploaded under, for downloads
+// The extension follows the stored file, which was typed by its content
+func (p *Photo) DisplayName() string {
+	if p.OriginalName == "" {
+		return p.Filename
+	}
+	ext := filepath.Ext(p.Filename)
+	originalExt := filepath.Ext(p.OriginalName)
+	if strings.EqualFold(originalExt, ext) {
+		return p.OriginalName
+	}
+	return strings.TrimSuffix(p.OriginalName, originalExt) + ext
 }
 
 // PhotoEmbedding represents a CLIP embedding for a photo
@@ -45,19 +97,59 @@ type PhotoEmbedding struct {
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// DatabaseOptions tunes how SQLite handles concurrent access
+type DatabaseOptions struct {
+	JournalMode   string // e.g. "wal" (readers never block the writer) or "delete" (SQLite's default)
+	BusyTimeoutMs int    // How long a connection waits for a lock before failing with "database is locked"
+	Synchronous   string // "full" syncs every commit; "normal" is safe with WAL and much faster
+	MaxOpenConns  int    // Connection pool size; forced to 1 outside WAL mode, where readers block the writer
+}
+
+// dsn builds a go-sqlite3 connection string that applies the options to every connection
+// PRAGMAs run with Exec only reach whichever pooled connection executed them
+func (o DatabaseOptions) dsn(dbPath string) string {
+	params := url.Values{}
+	params.Set("_foreign_keys", "on")
+	// Transactions take the write lock at BEGIN; a deferred one that reads first
+	// fails immediately if another writer committed in between, busy timeout or not
+	params.Set("_txlock", "immediate")
+	if o.JournalMode != "" {
+		params.Set("_journal_mode", strings.ToUpper(o.JournalMode))
+	}
+	if o.BusyTimeoutMs > 0 {
+		params.Set("_busy_timeout", strconv.Itoa(o.BusyTimeoutMs))
+	}
+	if o.Synchronous != "" {
+		params.Set("_synchronous", strings.ToUpper(o.Synchronous))
+	}
+	return dbPath + "?" + params.Encode()
+}
+
 // NewDatabase creates and initializes the database
-func NewDatabase(dbPath string) (*Database, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+func NewDatabase(dbPath string, opts DatabaseOptions) (*Database, error) {
+	db, err := sql.Open("sqlite3", opts.dsn(dbPath))
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %v", err)
 	}
 
-	// Enable foreign keys
-	if _, err := db.Exec("PRAGMA foreign_keys = ON"); err != nil {
-		return nil, fmt.Errorf("failed to enable foreign keys: %v", err)
+	// Connect now so a bad path or journal mode fails at startup
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open database: %v", err)
+	}
+
+	// SQLite allows one writer at a time whatever the pool size. With WAL, extra
+	// connections let reads (gallery listings, downloads) proceed alongside it and
+	// writers queue on busy_timeout; without WAL a reader blocks the writer, so
+	// a single connection that serializes everything avoids lock errors instead
+	conns := opts.MaxOpenConns
+	if conns < 1 || !strings.EqualFold(opts.JournalMode, "wal") {
+		conns = 1
 	}
+	db.SetMaxOpenConns(conns)
+	db.SetMaxIdleConns(conns)
 
-	database := &Database{db: db}
+	database := &Database{db: db, bcryptCost: BcryptCost, firstUserAdmin: true}
 
 	// Create tables
 	if err := database.createTables(); err != nil {
@@ -83,6 +175,36 @@ func (d *Database) createTables() error {
 		return fmt.Errorf("failed to create users table: %v", err)
 	}
 
+	// Add auto-archive preference columns (migration)
+	d.db.Exec(`ALTER TABLE users ADD COLUMN auto_archive_enabled BOOLEAN DEFAULT FALSE`)
+	d.db.Exec(`ALTER TABLE users ADD COLUMN auto_archive_days INTEGER DEFAULT 0`)
+
+	// Add invite code column (migration): the code a user registered with, if any
+	d.db.Exec(`ALTER TABLE users ADD COLUMN invite_code TEXT`)
+
+	// Groups split the family area between households sharing one instance
+	// Users in no group (the default) share with each other as before
+	_, err = d.db.Exec(`
+		CREATE TABLE IF NOT EXISTS user_groups (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT UNIQUE NOT NULL COLLATE NOCASE,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create user_groups table: %v", err)
+	}
+
+	// Add group membership column (migration, NULL = no group)
+	d.db.Exec(`ALTER TABLE users ADD COLUMN group_id INTEGER REFERENCES user_groups(id) ON DELETE SET NULL`)
+
+	// Usernames are unique regardless of case ("Alice" and "alice" are the same account)
+	// Older databases may already hold case variants; those have to be renamed by hand
+	_, err = d.db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username_nocase ON users(username COLLATE NOCASE)`)
+	if err != nil {
+		log.Printf("Warning: usernames differing only in case exist, case-insensitive uniqueness not enforced: %v", err)
+	}
+
 	// Photos table
 	_, err = d.db.Exec(`
 		CREATE TABLE IF NOT EXISTS photos (
@@ -120,6 +242,81 @@ func (d *Database) createTables() error {
 		return fmt.Errorf("failed to create archived index: %v", err)
 	}
 
+	// Add GPS location columns (migration)
+	d.db.Exec(`ALTER TABLE photos ADD COLUMN latitude REAL`)
+	d.db.Exec(`ALTER TABLE photos ADD COLUMN longitude REAL`)
+
+	// Add image dimension columns (migration, NULL = not yet measured)
+	d.db.Exec(`ALTER TABLE photos ADD COLUMN width INTEGER`)
+	d.db.Exec(`ALTER TABLE photos ADD COLUMN height INTEGER`)
+
+	// Add blurhash placeholder column (migration)
+	d.db.Exec(`ALTER TABLE photos ADD COLUMN blurhash TEXT`)
+
+	// Add capture date columns (migration): EXIF value and manual override
+	d.db.Exec(`ALTER TABLE photos ADD COLUMN taken_at DATETIME`)
+	d.db.Exec(`ALTER TABLE photos ADD COLUMN taken_at_override DATETIME`)
+
+	// Add caption column (migration)
+	d.db.Exec(`ALTER TABLE photos ADD COLUMN caption TEXT`)
+
+	// Add perceptual hash column (migration, NULL = not yet computed, '' = undecodable)
+	d.db.Exec(`ALTER TABLE photos ADD COLUMN phash TEXT`)
+
+	// Add content hash column (migration, NULL for photos uploaded before it existed)
+	d.db.Exec(`ALTER TABLE photos ADD COLUMN content_hash TEXT`)
+
+	// Add uploaded filename column (migration, NULL when the stored name is the uploaded one)
+	d.db.Exec(`ALTER TABLE photos ADD COLUMN original_name TEXT`)
+
+	// Add sharing group column (migration): the owner's group when the photo was
+	// shared, NULL for the ungrouped family area. Deleting a group moves its
+	// photos there along with its members
+	d.db.Exec(`ALTER TABLE photos ADD COLUMN group_id INTEGER REFERENCES user_groups(id) ON DELETE SET NULL`)
+
+	// Add access count columns (migration)
+	d.db.Exec(`ALTER TABLE photos ADD COLUMN view_count INTEGER DEFAULT 0`)
+	d.db.Exec(`ALTER TABLE photos ADD COLUMN download_count INTEGER DEFAULT 0`)
+
+	// Add last-modified column (migration) for incremental sync; older rows
+	// start from their archive or upload time. Values must all carry
+	// milliseconds, as sync cursors compare them as text: earlier backfills
+	// without them are padded
+	d.db.Exec(`ALTER TABLE photos ADD COLUMN updated_at DATETIME`)
+	if _, err := d.db.Exec(`UPDATE photos SET updated_at = strftime('%Y-%m-%d %H:%M:%f', COALESCE(archived_at, uploaded_at)) WHERE updated_at IS NULL`); err != nil {
+		return fmt.Errorf("failed to backfill updated_at: %v", err)
+	}
+	if _, err := d.db.Exec(`UPDATE photos SET updated_at = strftime('%Y-%m-%d %H:%M:%f', updated_at) WHERE length(updated_at) = 19`); err != nil {
+		return fmt.Errorf("failed to add milliseconds to updated_at: %v", err)
+	}
+
+	_, err = d.db.Exec(`CREATE INDEX IF NOT EXISTS idx_photos_user_updated ON photos(user_id, updated_at)`)
+	if err != nil {
+		return fmt.Errorf("failed to create updated_at index: %v", err)
+	}
+
+	// Add dropped-thumbnail flag (migration): set when archiving deleted the thumbnail
+	d.db.Exec(`ALTER TABLE photos ADD COLUMN thumbnail_dropped BOOLEAN DEFAULT FALSE`)
+
+	_, err = d.db.Exec(`CREATE INDEX IF NOT EXISTS idx_photos_content_hash ON photos(content_hash)`)
+	if err != nil {
+		return fmt.Errorf("failed to create content hash index: %v", err)
+	}
+
+	// Per-user preferences
+	_, err = d.db.Exec(`
+		CREATE TABLE IF NOT EXISTS user_settings (
+			user_id INTEGER PRIMARY KEY,
+			default_shared BOOLEAN DEFAULT FALSE,
+			ui_preferences TEXT DEFAULT '{}',
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create user_settings table: %v", err)
+	}
+
 	// Photo embeddings table for CLIP vectors
 	_, err = d.db.Exec(`
 		CREATE TABLE IF NOT EXISTS photo_embeddings (
@@ -133,6 +330,124 @@ func (d *Database) createTables() error {
 		return fmt.Errorf("failed to create photo_embeddings table: %v", err)
 	}
 
+	// Add embedding dimension column (migration), backfilled from the stored JSON arrays
+	d.db.Exec(`ALTER TABLE photo_embeddings ADD COLUMN dimension INTEGER`)
+	d.db.Exec(`UPDATE photo_embeddings SET dimension = json_array_length(CAST(embedding AS TEXT)) WHERE dimension IS NULL`)
+
+	// Persisted cluster assignments so group IDs stay stable between runs
+	// group_id 0 marks a photo that was evaluated but not grouped
+	_, err = d.db.Exec(`
+		CREATE TABLE IF NOT EXISTS photo_groups (
+			photo_id INTEGER PRIMARY KEY,
+			user_id INTEGER NOT NULL,
+			group_id INTEGER NOT NULL,
+			threshold REAL NOT NULL,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (photo_id) REFERENCES photos(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create photo_groups table: %v", err)
+	}
+
+	_, err = d.db.Exec(`CREATE INDEX IF NOT EXISTS idx_photo_groups_user ON photo_groups(user_id)`)
+	if err != nil {
+		return fmt.Errorf("failed to create photo_groups index: %v", err)
+	}
+
+	// Invite codes let people register while open registration is off
+	// max_uses 0 means unlimited; expires_at NULL means the code never expires
+	_, err = d.db.Exec(`
+		CREATE TABLE IF NOT EXISTS invite_codes (
+			code TEXT PRIMARY KEY,
+			created_by INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			expires_at DATETIME,
+			max_uses INTEGER NOT NULL DEFAULT 1,
+			uses INTEGER NOT NULL DEFAULT 0,
+			last_used_at DATETIME
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create invite_codes table: %v", err)
+	}
+
+	// Comments left on photos by anyone who can see them
+	_, err = d.db.Exec(`
+		CREATE TABLE IF NOT EXISTS photo_comments (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			photo_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			body TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (photo_id) REFERENCES photos(id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create photo_comments table: %v", err)
+	}
+
+	_, err = d.db.Exec(`CREATE INDEX IF NOT EXISTS idx_photo_comments_photo ON photo_comments(photo_id)`)
+	if err != nil {
+		return fmt.Errorf("failed to create photo_comments index: %v", err)
+	}
+
+	// Photos whose thumbnail couldn't be generated, usually because the original is corrupt
+	// A row is removed as soon as a thumbnail is generated successfully
+	_, err = d.db.Exec(`
+		CREATE TABLE IF NOT EXISTS thumbnail_failures (
+			photo_id INTEGER PRIMARY KEY,
+			attempts INTEGER NOT NULL DEFAULT 1,
+			last_error TEXT NOT NULL,
+			first_failed_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			last_failed_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (photo_id) REFERENCES photos(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create thumbnail_failures table: %v", err)
+	}
+
+	// Public links give people without an account access to one photo until
+	// they expire. Only a hash of each token is stored
+	_, err = d.db.Exec(`
+		CREATE TABLE IF NOT EXISTS public_links (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			token_hash TEXT NOT NULL UNIQUE,
+			photo_id INTEGER NOT NULL,
+			created_by INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			expires_at DATETIME NOT NULL,
+			uses INTEGER NOT NULL DEFAULT 0,
+			last_used_at DATETIME,
+			FOREIGN KEY (photo_id) REFERENCES photos(id) ON DELETE CASCADE,
+			FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create public_links table: %v", err)
+	}
+
+	// Tombstones tell syncing clients which photos were deleted; they are
+	// kept for TombstoneDays
+	_, err = d.db.Exec(`
+		CREATE TABLE IF NOT EXISTS photo_tombstones (
+			photo_id INTEGER PRIMARY KEY,
+			user_id INTEGER NOT NULL,
+			deleted_at DATETIME NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create photo_tombstones table: %v", err)
+	}
+
+	_, err = d.db.Exec(`CREATE INDEX IF NOT EXISTS idx_photo_tombstones_user ON photo_tombstones(user_id, deleted_at)`)
+	if err != nil {
+		return fmt.Errorf("failed to create tombstone index: %v", err)
+	}
+
 	return nil
 }
 
@@ -141,26 +456,47 @@ func (d *Database) Close() error {
 	return d.db.Close()
 }
 
+// BackupTo writes a consistent copy of the database to path, which must not exist
+// VACUUM INTO reads through SQLite like any other query, so it is safe while the server runs
+func (d *Database) BackupTo(path string) error {
+	if _, err := d.db.Exec("VACUUM INTO ?", path); err != nil {
+		return fmt.Errorf("failed to back up database: %v", err)
+	}
+	return nil
+}
+
+// SetBcryptCost sets the bcrypt cost used for new password hashes
+func (d *Database) SetBcryptCost(cost int) {
+	d.bcryptCost = cost
+}
+
+// SetFirstUserAdmin sets whether CreateUser makes the first user an admin
+// Turned off when the admin account is provisioned from config instead
+func (d *Database) SetFirstUserAdmin(enabled bool) {
+	d.firstUserAdmin = enabled
+}
+
 // User methods
 
 // CreateUser creates a new user
 func (d *Database) CreateUser(username, password string) (*User, error) {
 	// Hash password
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), BcryptCost)
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), d.bcryptCost)
 	if err != nil {
 		return nil, fmt.Errorf("failed to hash password: %v", err)
 	}
 
 	// Check if this is the first user (make them admin)
-	var count int
-	err = d.db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count)
-	if err != nil {
-		return nil, fmt.Errorf("failed to count users: %v", err)
-	}
-
 	role := "user"
-	if count == 0 {
-		role = "admin"
+	if d.firstUserAdmin {
+		var count int
+		err = d.db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count users: %v", err)
+		}
+		if count == 0 {
+			role = "admin"
+		}
 	}
 
 	// Insert user
@@ -181,13 +517,77 @@ func (d *Database) CreateUser(username, password string) (*User, error) {
 	}, nil
 }
 
-// GetUserByUsername retrieves a user by username
+// EnsureAdmin creates an admin account with the given password, or makes an
+// existing account with that name an admin. An existing password is left alone.
+// Returns whether the account was created
+func (d *Database) EnsureAdmin(username, password string) (bool, error) {
+	existing, err := d.GetUserByUsername(username)
+	if err != nil {
+		return false, err
+	}
+	if existing != nil {
+		if existing.Role != "admin" {
+			if err := d.UpdateUserRole(existing.ID, "admin"); err != nil {
+				return false, fmt.Errorf("failed to make %s an admin: %v", username, err)
+			}
+		}
+		return false, nil
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), d.bcryptCost)
+	if err != nil {
+		return false, fmt.Errorf("failed to hash password: %v", err)
+	}
+
+	if _, err := d.db.Exec(
+		"INSERT INTO users (username, password_hash, role) VALUES (?, ?, 'admin')",
+		username, string(hash),
+	); err != nil {
+		return false, fmt.Errorf("failed to create admin: %v", err)
+	}
+	return true, nil
+}
+
+// errSetupDone means first-run setup was attempted after an account already exists
+var errSetupDone = errors.New("setup has already been completed")
+
+// CreateFirstAdmin creates the admin account during first-run setup
+// The insert only happens while there are no users, so concurrent setups
+// can't both succeed; the loser gets errSetupDone
+func (d *Database) CreateFirstAdmin(username, password string) (*User, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), d.bcryptCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %v", err)
+	}
+
+	result, err := d.db.Exec(
+		"INSERT INTO users (username, password_hash, role) SELECT ?, ?, 'admin' WHERE NOT EXISTS (SELECT 1 FROM users)",
+		username, string(hash),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create admin: %v", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, errSetupDone
+	}
+
+	id, _ := result.LastInsertId()
+
+	return &User{
+		ID:       id,
+		Username: username,
+		Role:     "admin",
+	}, nil
+}
+
+// GetUserByUsername retrieves a user by username, ignoring case
+// An exact match wins if a legacy database still holds case variants
 func (d *Database) GetUserByUsername(username string) (*User, error) {
 	user := &User{}
 	err := d.db.QueryRow(
-		"SELECT id, username, password_hash, role, created_at FROM users WHERE username = ?",
-		username,
-	).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Role, &user.CreatedAt)
+		"SELECT id, username, password_hash, role, COALESCE(group_id, 0), created_at FROM users WHERE username = ? COLLATE NOCASE ORDER BY username = ? DESC LIMIT 1",
+		username, username,
+	).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Role, &user.GroupID, &user.CreatedAt)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -203,9 +603,9 @@ func (d *Database) GetUserByUsername(username string) (*User, error) {
 func (d *Database) GetUserByID(id int64) (*User, error) {
 	user := &User{}
 	err := d.db.QueryRow(
-		"SELECT id, username, password_hash, role, created_at FROM users WHERE id = ?",
+		"SELECT id, username, password_hash, role, COALESCE(group_id, 0), created_at FROM users WHERE id = ?",
 		id,
-	).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Role, &user.CreatedAt)
+	).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Role, &user.GroupID, &user.CreatedAt)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -217,10 +617,70 @@ func (d *Database) GetUserByID(id int64) (*User, error) {
 	return user, nil
 }
 
+// errInvalidInvite means an invite code doesn't exist, has expired or is used up
+var errInvalidInvite = errors.New("invalid or expired invite code")
+
+// CreateUserWithInvite creates a regular user and consumes one use of an invite code
+// Both happen in one transaction, so a failed registration never burns the code
+func (d *Database) CreateUserWithInvite(username, password, code string) (*User, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), d.bcryptCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %v", err)
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE invite_codes SET uses = uses + 1, last_used_at = CURRENT_TIMESTAMP
+		WHERE code = ?
+			AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)
+			AND (max_uses = 0 OR uses < max_uses)
+	`, code)
+	if err != nil {
+		return nil, fmt.Errorf("failed to use invite code: %v", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, errInvalidInvite
+	}
+
+	result, err = tx.Exec(
+		"INSERT INTO users (username, password_hash, role, invite_code) VALUES (?, ?, 'user', ?)",
+		username, string(hash), code,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to create user: %v", err)
+	}
+
+	id, _ := result.LastInsertId()
+
+	return &User{
+		ID:       id,
+		Username: username,
+		Role:     "user",
+	}, nil
+}
+
+// CountUsers returns the number of registered users
+func (d *Database) CountUsers() (int, error) {
+	var count int
+	if err := d.db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count users: %v", err)
+	}
+	return count, nil
+}
+
 // GetAllUsers retrieves all users (for admin)
 func (d *Database) GetAllUsers() ([]*User, error) {
 	rows, err := d.db.Query(
-		"SELECT id, username, role, created_at FROM users ORDER BY created_at DESC",
+		"SELECT id, username, role, COALESCE(group_id, 0), created_at FROM users ORDER BY created_at DESC",
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get users: %v", err)
@@ -230,7 +690,7 @@ func (d *Database) GetAllUsers() ([]*User, error) {
 	users := make([]*User, 0)
 	for rows.Next() {
 		user := &User{}
-		if err := rows.Scan(&user.ID, &user.Username, &user.Role, &user.CreatedAt); err != nil {
+		if err := rows.Scan(&user.ID, &user.Username, &user.Role, &user.GroupID, &user.CreatedAt); err != nil {
 			return nil, fmt.Errorf("failed to scan user: %v", err)
 		}
 		users = append(users, user)
@@ -251,112 +711,450 @@ func (d *Database) UpdateUserRole(id int64, role string) error {
 	return err
 }
 
-// VerifyPassword checks if the password matches the user's hash
-func (u *User) VerifyPassword(password string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password))
-	return err == nil
-}
-
-// IsAdmin returns true if user is an admin
-func (u *User) IsAdmin() bool {
-	return u.Role == "admin"
+// AutoArchiveSetting holds a user's auto-archive preference
+type AutoArchiveSetting struct {
+	UserID  int64 `json:"user_id"`
+	Enabled bool  `json:"enabled"`
+	Days    int   `json:"days"`
 }
 
-// Photo methods
+// GetAutoArchiveSetting returns a user's auto-archive preference
+func (d *Database) GetAutoArchiveSetting(userID int64) (*AutoArchiveSetting, error) {
+	setting := &AutoArchiveSetting{UserID: userID}
+	err := d.db.QueryRow(
+		"SELECT COALESCE(auto_archive_enabled, FALSE), COALESCE(auto_archive_days, 0) FROM users WHERE id = ?",
+		userID,
+	).Scan(&setting.Enabled, &setting.Days)
 
-// CreatePhoto adds a photo record to the database
-func (d *Database) CreatePhoto(filename string, userID int64, size int64) (*Photo, error) {
-	result, err := d.db.Exec(
-		"INSERT INTO photos (filename, user_id, size) VALUES (?, ?, ?)",
-		filename, userID, size,
-	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to create photo record: %v", err)
+		return nil, fmt.Errorf("failed to get auto-archive setting: %v", err)
 	}
 
-	id, _ := result.LastInsertId()
+	return setting, nil
+}
 
-	return &Photo{
-		ID:       id,
-		Filename: filename,
-		UserID:   userID,
-		Size:     size,
-	}, nil
+// SetAutoArchiveSetting updates a user's auto-archive preference
+func (d *Database) SetAutoArchiveSetting(userID int64, enabled bool, days int) error {
+	_, err := d.db.Exec(
+		"UPDATE users SET auto_archive_enabled = ?, auto_archive_days = ? WHERE id = ?",
+		enabled, days, userID,
+	)
+	return err
 }
 
-// GetPhotosByUser retrieves all photos for a user
-func (d *Database) GetPhotosByUser(userID int64) ([]*Photo, error) {
+// GetAutoArchiveUsers returns the settings of all users who opted in to auto-archive
+func (d *Database) GetAutoArchiveUsers() ([]*AutoArchiveSetting, error) {
 	rows, err := d.db.Query(
-		"SELECT id, filename, user_id, is_shared, size, uploaded_at FROM photos WHERE user_id = ? AND (is_archived = FALSE OR is_archived IS NULL) ORDER BY uploaded_at DESC",
-		userID,
+		"SELECT id, auto_archive_enabled, auto_archive_days FROM users WHERE auto_archive_enabled = TRUE AND auto_archive_days > 0",
 	)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get photos: %v", err)
+		return nil, fmt.Errorf("failed to get auto-archive users: %v", err)
 	}
 	defer rows.Close()
 
-	return d.scanPhotos(rows)
+	settings := make([]*AutoArchiveSetting, 0)
+	for rows.Next() {
+		setting := &AutoArchiveSetting{}
+		if err := rows.Scan(&setting.UserID, &setting.Enabled, &setting.Days); err != nil {
+			return nil, fmt.Errorf("failed to scan auto-archive setting: %v", err)
+		}
+		settings = append(settings, setting)
+	}
+
+	return settings, nil
 }
 
-// GetSharedPhotos retrieves all shared photos (family area)
-func (d *Database) GetSharedPhotos() ([]*Photo, error) {
-	rows, err := d.db.Query(`
-		SELECT p.id, p.filename, p.user_id, p.is_shared, p.size, p.uploaded_at, u.username
-		FROM photos p
-		JOIN users u ON p.user_id = u.id
-		WHERE p.is_shared = TRUE AND (p.is_archived = FALSE OR p.is_archived IS NULL)
-		ORDER BY p.uploaded_at DESC
-	`)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get shared photos: %v", err)
-	}
-	defer rows.Close()
+// UserSettings holds a user's preferences
+type UserSettings struct {
+	UserID        int64           `json:"-"`
+	DefaultShared bool            `json:"default_shared"` // share new uploads to the family area
+	UIPreferences json.RawMessage `json:"ui_preferences"` // opaque JSON object owned by the frontend
+}
 
-	photos := make([]*Photo, 0)
-	for rows.Next() {
-		photo := &Photo{}
-		if err := rows.Scan(&photo.ID, &photo.Filename, &photo.UserID, &photo.IsShared, &photo.Size, &photo.UploadedAt, &photo.Username); err != nil {
-			return nil, fmt.Errorf("failed to scan photo: %v", err)
-		}
-		photos = append(photos, photo)
+// GetUserSettings returns a user's preferences, or defaults if none were saved
+func (d *Database) GetUserSettings(userID int64) (*UserSettings, error) {
+	settings := &UserSettings{UserID: userID, UIPreferences: json.RawMessage("{}")}
+
+	var uiPreferences string
+	err := d.db.QueryRow(
+		"SELECT COALESCE(default_shared, FALSE), COALESCE(ui_preferences, '{}') FROM user_settings WHERE user_id = ?",
+		userID,
+	).Scan(&settings.DefaultShared, &uiPreferences)
+
+	if err == sql.ErrNoRows {
+		return settings, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user settings: %v", err)
 	}
 
-	return photos, nil
+	settings.UIPreferences = json.RawMessage(uiPreferences)
+	return settings, nil
 }
 
-// GetAllPhotos retrieves all photos (for admin)
-func (d *Database) GetAllPhotos() ([]*Photo, error) {
+// UpdateUserSettings saves a user's preferences
+func (d *Database) UpdateUserSettings(settings *UserSettings) error {
+	uiPreferences := string(settings.UIPreferences)
+	if uiPreferences == "" {
+		uiPreferences = "{}"
+	}
+
+	_, err := d.db.Exec(`
+		INSERT INTO user_settings (user_id, default_shared, ui_preferences, updated_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(user_id) DO UPDATE SET
+			default_shared = excluded.default_shared,
+			ui_preferences = excluded.ui_preferences,
+			updated_at = CURRENT_TIMESTAMP
+	`, settings.UserID, settings.DefaultShared, uiPreferences)
+	if err != nil {
+		return fmt.Errorf("failed to update user settings: %v", err)
+	}
+
+	return nil
+}
+
+// UpdatePassword hashes a new password for a user and stores it
+func (d *Database) UpdatePassword(userID int64, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), d.bcryptCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %v", err)
+	}
+
+	result, err := d.db.Exec("UPDATE users SET password_hash = ? WHERE id = ?", string(hash), userID)
+	if err != nil {
+		return fmt.Errorf("failed to update password: %v", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("user not found")
+	}
+
+	return nil
+}
+
+// NeedsRehash reports whether the user's password hash uses a lower cost than cost
+func (u *User) NeedsRehash(cost int) bool {
+	hashCost, err := bcrypt.Cost([]byte(u.PasswordHash))
+	return err == nil && hashCost < cost
+}
+
+// VerifyPassword checks if the password matches the user's hash
+func (u *User) VerifyPassword(password string) bool {
+	err := bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password))
+	return err == nil
+}
+
+// IsAdmin returns true if user is an admin
+func (u *User) IsAdmin() bool {
+	return u.Role == "admin"
+}
+
+// Photo methods
+
+// photoColumns is the column list shared by photo queries
+// Queries must alias photos as p and join users as u; scan with scanPhoto
+const photoColumns = `p.id, p.filename, p.user_id, u.username, p.is_shared,
+	COALESCE(p.is_archived, FALSE), p.archived_at, p.size, p.uploaded_at,
+	p.latitude, p.longitude, COALESCE(p.width, 0), COALESCE(p.height, 0),
+	COALESCE(p.blurhash, ''), p.taken_at, p.taken_at_override,
+	COALESCE(p.caption, ''), COALESCE(p.phash, ''), COALESCE(p.content_hash, ''),
+	COALESCE(p.original_name, ''), COALESCE(p.group_id, 0),
+	COALESCE(p.view_count, 0), COALESCE(p.download_count, 0),
+	p.updated_at, COALESCE(p.thumbnail_dropped, FALSE)`
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanPhoto scans a single row selected with photoColumns
+func scanPhoto(row rowScanner) (*Photo, error) {
+	photo := &Photo{}
+	var archivedAt, takenAt, takenAtOverride, updatedAt sql.NullTime
+	if err := row.Scan(
+		&photo.ID, &photo.Filename, &photo.UserID, &photo.Username, &photo.IsShared,
+		&photo.IsArchived, &archivedAt, &photo.Size, &photo.UploadedAt,
+		&photo.Latitude, &photo.Longitude, &photo.Width, &photo.Height,
+		&photo.Blurhash, &takenAt, &takenAtOverride,
+		&photo.Caption, &photo.PHash, &photo.ContentHash,
+		&photo.OriginalName, &photo.GroupID,
+		&photo.ViewCount, &photo.DownloadCount,
+		&updatedAt, &photo.ThumbnailDropped,
+	); err != nil {
+		return nil, err
+	}
+	if archivedAt.Valid {
+		photo.ArchivedAt = &archivedAt.Time
+	}
+	if takenAt.Valid {
+		photo.TakenAt = &takenAt.Time
+	}
+	if takenAtOverride.Valid {
+		photo.TakenAtOverride = &takenAtOverride.Time
+	}
+	photo.UpdatedAt = photo.UploadedAt
+	if updatedAt.Valid {
+		photo.UpdatedAt = updatedAt.Time
+	}
+	return photo, nil
+}
+
+// CreatePhoto adds a photo record to the database
+// originalName is the uploaded name if it differs from filename ("" otherwise)
+func (d *Database) CreatePhoto(filename, originalName string, userID int64, size int64, width, height int, contentHash string) (*Photo, error) {
+	var id int64
+	var uploadedAt, updatedAt time.Time
+	err := d.db.QueryRow(
+		"INSERT INTO photos (filename, original_name, user_id, size, width, height, content_hash, updated_at) VALUES (?, NULLIF(?, ''), ?, ?, ?, ?, NULLIF(?, ''), "+sqliteNowMillis+") RETURNING id, uploaded_at, updated_at",
+		filename, originalName, userID, size, width, height, contentHash,
+	).Scan(&id, &uploadedAt, &updatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create photo record: %v", err)
+	}
+
+	return &Photo{
+		ID:           id,
+		Filename:     filename,
+		UserID:       userID,
+		Size:         size,
+		Width:        width,
+		Height:       height,
+		ContentHash:  contentHash,
+		OriginalName: originalName,
+		UploadedAt:   uploadedAt,
+		UpdatedAt:    updatedAt,
+	}, nil
+}
+
+// GetPhotosByUser retrieves all photos for a user
+func (d *Database) GetPhotosByUser(userID int64) ([]*Photo, error) {
 	rows, err := d.db.Query(`
-		SELECT p.id, p.filename, p.user_id, p.is_shared, p.size, p.uploaded_at, u.username
+		SELECT `+photoColumns+`
 		FROM photos p
 		JOIN users u ON p.user_id = u.id
-		WHERE (p.is_archived = FALSE OR p.is_archived IS NULL)
+		WHERE p.user_id = ? AND (p.is_archived = FALSE OR p.is_archived IS NULL)
 		ORDER BY p.uploaded_at DESC
-	`)
+	`, userID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get all photos: %v", err)
+		return nil, fmt.Errorf("failed to get photos: %v", err)
 	}
 	defer rows.Close()
 
-	photos := make([]*Photo, 0)
+	return d.scanPhotos(rows)
+}
+
+// GetSharedPhotos retrieves all shared photos in a group's family area (0 = users in no group)
+func (d *Database) GetSharedPhotos(groupID int64) ([]*Photo, error) {
+	rows, err := d.db.Query(`
+		SELECT `+photoColumns+`
+		FROM photos p
+		JOIN users u ON p.user_id = u.id
+		WHERE p.is_shared = TRUE AND (p.is_archived = FALSE OR p.is_archived IS NULL)
+		AND COALESCE(p.group_id, 0) = ?
+		ORDER BY p.uploaded_at DESC
+	`, groupID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get shared photos: %v", err)
+	}
+	defer rows.Close()
+
+	return d.scanPhotos(rows)
+}
+
+// GetSharedPhotosPaged retrieves one page of the shared feed plus the total number of matching photos
+// Only the given group's family area is included (0 = users in no group).
+// uploader ("" = everyone) filters by username, case-insensitively. byTaken orders
+// by capture date like sortPhotos does; otherwise newest uploads come first
+func (d *Database) GetSharedPhotosPaged(groupID int64, uploader string, byTaken bool, limit, offset int) ([]*Photo, int, error) {
+	where := `WHERE p.is_shared = TRUE AND (p.is_archived = FALSE OR p.is_archived IS NULL)
+		AND COALESCE(p.group_id, 0) = ?
+		AND (? = '' OR u.username = ? COLLATE NOCASE)`
+
+	var total int
+	if err := d.db.QueryRow(`
+		SELECT COUNT(*)
+		FROM photos p
+		JOIN users u ON p.user_id = u.id
+		`+where, groupID, uploader, uploader).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count shared photos: %v", err)
+	}
+
+	// Tie-break on id so pages never overlap or skip photos with equal dates
+	order := "p.uploaded_at DESC, p.id DESC"
+	if byTaken {
+		order = "COALESCE(p.taken_at_override, p.taken_at, p.uploaded_at) DESC, p.id DESC"
+	}
+
+	rows, err := d.db.Query(`
+		SELECT `+photoColumns+`
+		FROM photos p
+		JOIN users u ON p.user_id = u.id
+		`+where+`
+		ORDER BY `+order+`
+		LIMIT ? OFFSET ?
+	`, groupID, uploader, uploader, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get shared photos: %v", err)
+	}
+	defer rows.Close()
+
+	photos, err := d.scanPhotos(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	return photos, total, nil
+}
+
+// GetUserPhotosPaged returns one page of a user's photos, newest first, plus
+// the total across all pages. Archived photos are included only if asked for
+func (d *Database) GetUserPhotosPaged(userID int64, includeArchived, byTaken bool, limit, offset int) ([]*Photo, int, error) {
+	where := `WHERE p.user_id = ? AND (? OR p.is_archived = FALSE OR p.is_archived IS NULL)`
+
+	var total int
+	if err := d.db.QueryRow(`
+		SELECT COUNT(*)
+		FROM photos p
+		JOIN users u ON p.user_id = u.id
+		`+where, userID, includeArchived).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count photos: %v", err)
+	}
+
+	// Tie-break on id so pages never overlap or skip photos with equal dates
+	order := "p.uploaded_at DESC, p.id DESC"
+	if byTaken {
+		order = "COALESCE(p.taken_at_override, p.taken_at, p.uploaded_at) DESC, p.id DESC"
+	}
+
+	rows, err := d.db.Query(`
+		SELECT `+photoColumns+`
+		FROM photos p
+		JOIN users u ON p.user_id = u.id
+		`+where+`
+		ORDER BY `+order+`
+		LIMIT ? OFFSET ?
+	`, userID, includeArchived, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get photos: %v", err)
+	}
+	defer rows.Close()
+
+	photos, err := d.scanPhotos(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	return photos, total, nil
+}
+
+// Tombstone is a deleted photo reported to syncing clients
+type Tombstone struct {
+	PhotoID   int64     `json:"photo_id"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// SyncCursor is the position after the last photo of a page of changed photos
+type SyncCursor struct {
+	UpdatedAt time.Time
+	PhotoID   int64
+}
+
+// GetPhotosChangedSince returns one page of a user's photos (archived included)
+// changed at or after since, oldest change first, plus the total across all pages
+// Pages continue after a cursor (nil for the first page) rather than at an
+// offset, so a photo edited between pages moves to the end instead of
+// shifting a later one onto a page that was already read
+func (d *Database) GetPhotosChangedSince(userID int64, since time.Time, after *SyncCursor, limit int) ([]*Photo, int, error) {
+	where := `WHERE p.user_id = ? AND p.updated_at >= ?`
+	sinceStr := since.UTC().Format(sqliteMillisLayout)
+
+	var total int
+	if err := d.db.QueryRow(`
+		SELECT COUNT(*)
+		FROM photos p
+		JOIN users u ON p.user_id = u.id
+		`+where, userID, sinceStr).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count changed photos: %v", err)
+	}
+
+	args := []interface{}{userID, sinceStr}
+	if after != nil {
+		where += ` AND (p.updated_at, p.id) > (?, ?)`
+		args = append(args, after.UpdatedAt.UTC().Format(sqliteMillisLayout), after.PhotoID)
+	}
+	args = append(args, limit)
+
+	rows, err := d.db.Query(`
+		SELECT `+photoColumns+`
+		FROM photos p
+		JOIN users u ON p.user_id = u.id
+		`+where+`
+		ORDER BY p.updated_at ASC, p.id ASC
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get changed photos: %v", err)
+	}
+	defer rows.Close()
+
+	photos, err := d.scanPhotos(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	return photos, total, nil
+}
+
+// GetTombstonesSince returns a user's photos deleted at or after since, oldest first
+func (d *Database) GetTombstonesSince(userID int64, since time.Time) ([]Tombstone, error) {
+	rows, err := d.db.Query(`
+		SELECT photo_id, deleted_at FROM photo_tombstones
+		WHERE user_id = ? AND deleted_at >= ?
+		ORDER BY deleted_at ASC, photo_id ASC
+	`, userID, since.UTC().Format(sqliteMillisLayout))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tombstones: %v", err)
+	}
+	defer rows.Close()
+
+	tombstones := make([]Tombstone, 0)
 	for rows.Next() {
-		photo := &Photo{}
-		if err := rows.Scan(&photo.ID, &photo.Filename, &photo.UserID, &photo.IsShared, &photo.Size, &photo.UploadedAt, &photo.Username); err != nil {
-			return nil, fmt.Errorf("failed to scan photo: %v", err)
+		var t Tombstone
+		if err := rows.Scan(&t.PhotoID, &t.DeletedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan tombstone: %v", err)
 		}
-		photos = append(photos, photo)
+		tombstones = append(tombstones, t)
 	}
+	return tombstones, rows.Err()
+}
 
-	return photos, nil
+// GetAllPhotos retrieves all photos (for admin)
+func (d *Database) GetAllPhotos() ([]*Photo, error) {
+	rows, err := d.db.Query(`
+		SELECT ` + photoColumns + `
+		FROM photos p
+		JOIN users u ON p.user_id = u.id
+		WHERE (p.is_archived = FALSE OR p.is_archived IS NULL)
+		ORDER BY p.uploaded_at DESC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get all photos: %v", err)
+	}
+	defer rows.Close()
+
+	return d.scanPhotos(rows)
 }
 
 // GetPhotoByID retrieves a photo by ID
 func (d *Database) GetPhotoByID(id int64) (*Photo, error) {
-	photo := &Photo{}
-	err := d.db.QueryRow(
-		"SELECT id, filename, user_id, is_shared, size, uploaded_at FROM photos WHERE id = ?",
-		id,
-	).Scan(&photo.ID, &photo.Filename, &photo.UserID, &photo.IsShared, &photo.Size, &photo.UploadedAt)
+	photo, err := scanPhoto(d.db.QueryRow(`
+		SELECT `+photoColumns+`
+		FROM photos p
+		JOIN users u ON p.user_id = u.id
+		WHERE p.id = ?
+	`, id))
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -370,11 +1168,12 @@ func (d *Database) GetPhotoByID(id int64) (*Photo, error) {
 
 // GetPhotoByFilename retrieves a photo by filename and user ID
 func (d *Database) GetPhotoByFilename(filename string, userID int64) (*Photo, error) {
-	photo := &Photo{}
-	err := d.db.QueryRow(
-		"SELECT id, filename, user_id, is_shared, COALESCE(is_archived, FALSE), size, uploaded_at FROM photos WHERE filename = ? AND user_id = ?",
-		filename, userID,
-	).Scan(&photo.ID, &photo.Filename, &photo.UserID, &photo.IsShared, &photo.IsArchived, &photo.Size, &photo.UploadedAt)
+	photo, err := scanPhoto(d.db.QueryRow(`
+		SELECT `+photoColumns+`
+		FROM photos p
+		JOIN users u ON p.user_id = u.id
+		WHERE p.filename = ? AND p.user_id = ?
+	`, filename, userID))
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -387,23 +1186,75 @@ func (d *Database) GetPhotoByFilename(filename string, userID int64) (*Photo, er
 }
 
 // SetPhotoShared sets the shared status of a photo
+// Sharing publishes the photo to the owner's current group
 func (d *Database) SetPhotoShared(id int64, shared bool) error {
-	_, err := d.db.Exec("UPDATE photos SET is_shared = ? WHERE id = ?", shared, id)
+	_, err := d.db.Exec(`
+		UPDATE photos SET is_shared = ?, updated_at = `+sqliteNowMillis+`,
+			group_id = CASE WHEN ? THEN (SELECT group_id FROM users WHERE users.id = photos.user_id) END
+		WHERE id = ?
+	`, shared, shared, id)
 	return err
 }
 
-// DeletePhoto deletes a photo record
+// TogglePhotoShared flips the shared status of a photo in a single statement, so
+// concurrent toggles can't both read the same state, and returns the new status
+// Sharing publishes the photo to the owner's current group
+func (d *Database) TogglePhotoShared(id int64) (bool, error) {
+	var shared bool
+	err := d.db.QueryRow(`
+		UPDATE photos SET is_shared = NOT is_shared, updated_at = `+sqliteNowMillis+`,
+			group_id = CASE WHEN NOT is_shared THEN (SELECT group_id FROM users WHERE users.id = photos.user_id) END
+		WHERE id = ?
+		RETURNING is_shared
+	`, id).Scan(&shared)
+	if err != nil {
+		return false, fmt.Errorf("failed to toggle shared status: %v", err)
+	}
+	return shared, nil
+}
+
+// DeletePhoto deletes a photo record and its embedding in one transaction
 func (d *Database) DeletePhoto(id int64) error {
-	_, err := d.db.Exec("DELETE FROM photos WHERE id = ?", id)
-	return err
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Remove dependent rows explicitly rather than relying on foreign key cascades
+	if _, err := tx.Exec("DELETE FROM photo_embeddings WHERE photo_id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete embedding: %v", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM photo_groups WHERE photo_id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete group assignment: %v", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM photo_comments WHERE photo_id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete comments: %v", err)
+	}
+
+	// Leave a tombstone for syncing clients, dropping ones past TombstoneDays
+	if _, err := tx.Exec("INSERT OR REPLACE INTO photo_tombstones (photo_id, user_id, deleted_at) SELECT id, user_id, "+sqliteNowMillis+" FROM photos WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to record tombstone: %v", err)
+	}
+	if _, err := tx.Exec("DELETE FROM photo_tombstones WHERE deleted_at < datetime('now', ?)", fmt.Sprintf("-%d days", TombstoneDays)); err != nil {
+		return fmt.Errorf("failed to prune tombstones: %v", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM photos WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete photo: %v", err)
+	}
+
+	return tx.Commit()
 }
 
-// Helper function to scan photo rows
+// Helper function to scan photo rows selected with photoColumns
 func (d *Database) scanPhotos(rows *sql.Rows) ([]*Photo, error) {
 	photos := make([]*Photo, 0)
 	for rows.Next() {
-		photo := &Photo{}
-		if err := rows.Scan(&photo.ID, &photo.Filename, &photo.UserID, &photo.IsShared, &photo.Size, &photo.UploadedAt); err != nil {
+		photo, err := scanPhoto(rows)
+		if err != nil {
 			return nil, fmt.Errorf("failed to scan photo: %v", err)
 		}
 		photos = append(photos, photo)
@@ -418,6 +1269,109 @@ func (d *Database) GetUserPhotoCount(userID int64) (int, error) {
 	return count, err
 }
 
+// CountPhotosByUser counts the photos GetPhotosByUser would return
+func (d *Database) CountPhotosByUser(userID int64) (int, error) {
+	var count int
+	if err := d.db.QueryRow(`
+		SELECT COUNT(*) FROM photos
+		WHERE user_id = ? AND (is_archived = FALSE OR is_archived IS NULL)
+	`, userID).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count photos: %v", err)
+	}
+	return count, nil
+}
+
+// CountSharedPhotos counts the photos GetSharedPhotos would return
+func (d *Database) CountSharedPhotos(groupID int64) (int, error) {
+	var count int
+	if err := d.db.QueryRow(`
+		SELECT COUNT(*) FROM photos
+		WHERE is_shared = TRUE AND (is_archived = FALSE OR is_archived IS NULL)
+		AND COALESCE(group_id, 0) = ?
+	`, groupID).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count shared photos: %v", err)
+	}
+	return count, nil
+}
+
+// CountArchivedPhotos counts the photos GetArchivedPhotos would return
+func (d *Database) CountArchivedPhotos(userID int64) (int, error) {
+	var count int
+	if err := d.db.QueryRow(`
+		SELECT COUNT(*) FROM photos WHERE user_id = ? AND is_archived = TRUE
+	`, userID).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count archived photos: %v", err)
+	}
+	return count, nil
+}
+
+// PhotoStorageStats aggregates a user's photo counts and sizes
+type PhotoStorageStats struct {
+	ArchivedPhotos int   `json:"archived_photos"`
+	TotalBytes     int64 `json:"total_bytes"`    // originals, archived or not
+	ArchivedBytes  int64 `json:"archived_bytes"` // originals in the archive
+}
+
+// GetPhotoStorageStats returns archive and storage totals for a user
+func (d *Database) GetPhotoStorageStats(userID int64) (*PhotoStorageStats, error) {
+	stats := &PhotoStorageStats{}
+	err := d.db.QueryRow(`
+		SELECT
+			COALESCE(SUM(CASE WHEN is_archived = TRUE THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(size), 0),
+			COALESCE(SUM(CASE WHEN is_archived = TRUE THEN size ELSE 0 END), 0)
+		FROM photos
+		WHERE user_id = ?
+	`, userID).Scan(&stats.ArchivedPhotos, &stats.TotalBytes, &stats.ArchivedBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get storage stats: %v", err)
+	}
+	return stats, nil
+}
+
+// UserStorageStats aggregates photo counts and sizes for one user (admin stats)
+type UserStorageStats struct {
+	UserID         int64  `json:"user_id"`
+	Username       string `json:"username"`
+	Photos         int    `json:"photos"`
+	SharedPhotos   int    `json:"shared_photos"`
+	ArchivedPhotos int    `json:"archived_photos"`
+	Bytes          int64  `json:"bytes"`                // sum of recorded original sizes
+	DiskBytes      *int64 `json:"disk_bytes,omitempty"` // actual usage on disk, when measurable
+}
+
+// GetStorageStatsByUser returns photo counts and recorded sizes for every user, largest first
+func (d *Database) GetStorageStatsByUser() ([]*UserStorageStats, error) {
+	rows, err := d.db.Query(`
+		SELECT
+			u.id,
+			u.username,
+			COUNT(p.id),
+			COALESCE(SUM(CASE WHEN p.is_shared = TRUE THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN p.is_archived = TRUE THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(p.size), 0)
+		FROM users u
+		LEFT JOIN photos p ON p.user_id = u.id
+		GROUP BY u.id, u.username
+		ORDER BY COALESCE(SUM(p.size), 0) DESC, u.id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get storage stats: %v", err)
+	}
+	defer rows.Close()
+
+	stats := make([]*UserStorageStats, 0)
+	for rows.Next() {
+		s := &UserStorageStats{}
+		if err := rows.Scan(&s.UserID, &s.Username, &s.Photos, &s.SharedPhotos, &s.ArchivedPhotos, &s.Bytes); err != nil {
+			return nil, fmt.Errorf("failed to scan storage stats: %v", err)
+		}
+		stats = append(stats, s)
+	}
+
+	return stats, rows.Err()
+}
+
 // GetTotalPhotoCount returns the total number of photos
 func (d *Database) GetTotalPhotoCount() (int, error) {
 	var count int
@@ -428,9 +1382,10 @@ func (d *Database) GetTotalPhotoCount() (int, error) {
 // Archive methods
 
 // ArchivePhoto marks a photo as archived
+// Already archived photos keep their original archived_at, so retries are harmless
 func (d *Database) ArchivePhoto(id int64) error {
 	_, err := d.db.Exec(
-		"UPDATE photos SET is_archived = TRUE, archived_at = CURRENT_TIMESTAMP WHERE id = ?",
+		"UPDATE photos SET is_archived = TRUE, archived_at = CURRENT_TIMESTAMP, updated_at = "+sqliteNowMillis+" WHERE id = ? AND COALESCE(is_archived, FALSE) = FALSE",
 		id,
 	)
 	return err
@@ -439,16 +1394,22 @@ func (d *Database) ArchivePhoto(id int64) error {
 // UnarchivePhoto restores a photo from archive
 func (d *Database) UnarchivePhoto(id int64) error {
 	_, err := d.db.Exec(
-		"UPDATE photos SET is_archived = FALSE, archived_at = NULL WHERE id = ?",
+		"UPDATE photos SET is_archived = FALSE, archived_at = NULL, thumbnail_dropped = FALSE, updated_at = "+sqliteNowMillis+" WHERE id = ?",
 		id,
 	)
 	return err
 }
 
+// SetThumbnailDropped records whether an archived photo's thumbnail was deleted
+func (d *Database) SetThumbnailDropped(id int64, dropped bool) error {
+	_, err := d.db.Exec("UPDATE photos SET thumbnail_dropped = ? WHERE id = ?", dropped, id)
+	return err
+}
+
 // GetArchivedPhotos returns all archived photos for a user
 func (d *Database) GetArchivedPhotos(userID int64) ([]*Photo, error) {
 	rows, err := d.db.Query(`
-		SELECT p.id, p.filename, p.user_id, u.username, p.is_shared, p.is_archived, p.archived_at, p.size, p.uploaded_at
+		SELECT `+photoColumns+`
 		FROM photos p
 		JOIN users u ON p.user_id = u.id
 		WHERE p.user_id = ? AND p.is_archived = TRUE
@@ -459,13 +1420,13 @@ func (d *Database) GetArchivedPhotos(userID int64) ([]*Photo, error) {
 	}
 	defer rows.Close()
 
-	return d.scanPhotosWithArchive(rows)
+	return d.scanPhotos(rows)
 }
 
 // GetNonArchivedPhotos returns all non-archived photos for a user
 func (d *Database) GetNonArchivedPhotos(userID int64) ([]*Photo, error) {
 	rows, err := d.db.Query(`
-		SELECT p.id, p.filename, p.user_id, u.username, p.is_shared, COALESCE(p.is_archived, FALSE), p.archived_at, p.size, p.uploaded_at
+		SELECT `+photoColumns+`
 		FROM photos p
 		JOIN users u ON p.user_id = u.id
 		WHERE p.user_id = ? AND (p.is_archived = FALSE OR p.is_archived IS NULL)
@@ -476,44 +1437,300 @@ func (d *Database) GetNonArchivedPhotos(userID int64) ([]*Photo, error) {
 	}
 	defer rows.Close()
 
-	return d.scanPhotosWithArchive(rows)
+	return d.scanPhotos(rows)
 }
 
-// Helper function to scan photos with archive fields
-func (d *Database) scanPhotosWithArchive(rows *sql.Rows) ([]*Photo, error) {
-	photos := make([]*Photo, 0)
-	for rows.Next() {
-		photo := &Photo{}
-		var archivedAt sql.NullTime
-		if err := rows.Scan(
-			&photo.ID, &photo.Filename, &photo.UserID, &photo.Username,
-			&photo.IsShared, &photo.IsArchived, &archivedAt, &photo.Size, &photo.UploadedAt,
-		); err != nil {
-			return nil, fmt.Errorf("failed to scan photo: %v", err)
-		}
-		if archivedAt.Valid {
-			photo.ArchivedAt = &archivedAt.Time
-		}
-		photos = append(photos, photo)
+// GetPhotosOlderThan returns a user's non-archived photos uploaded more than the given number of days ago
+func (d *Database) GetPhotosOlderThan(userID int64, days int) ([]*Photo, error) {
+	rows, err := d.db.Query(`
+		SELECT `+photoColumns+`
+		FROM photos p
+		JOIN users u ON p.user_id = u.id
+		WHERE p.user_id = ? AND (p.is_archived = FALSE OR p.is_archived IS NULL)
+		AND p.uploaded_at < datetime('now', ?)
+		ORDER BY p.uploaded_at ASC
+	`, userID, fmt.Sprintf("-%d days", days))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query old photos: %v", err)
 	}
-	return photos, nil
-}
-
-// Embedding methods
+	defer rows.Close()
 
-// SaveEmbedding saves a CLIP embedding for a photo
-func (d *Database) SaveEmbedding(photoID int64, embedding []byte) error {
-	_, err := d.db.Exec(`
-		INSERT INTO photo_embeddings (photo_id, embedding) VALUES (?, ?)
-		ON CONFLICT(photo_id) DO UPDATE SET embedding = ?, created_at = CURRENT_TIMESTAMP
-	`, photoID, embedding, embedding)
-	return err
+	return d.scanPhotos(rows)
 }
 
-// GetEmbedding retrieves the embedding for a photo
-func (d *Database) GetEmbedding(photoID int64) ([]byte, error) {
-	var embedding []byte
-	err := d.db.QueryRow(
+// GetPhotosUploadedSince returns a user's non-archived photos uploaded at or after since, newest first
+func (d *Database) GetPhotosUploadedSince(userID int64, since time.Time) ([]*Photo, error) {
+	rows, err := d.db.Query(`
+		SELECT `+photoColumns+`
+		FROM photos p
+		JOIN users u ON p.user_id = u.id
+		WHERE p.user_id = ? AND (p.is_archived = FALSE OR p.is_archived IS NULL)
+		AND p.uploaded_at >= ?
+		ORDER BY p.uploaded_at DESC, p.id DESC
+	`, userID, since.UTC().Format(sqliteTimeLayout))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query recent photos: %v", err)
+	}
+	defer rows.Close()
+
+	return d.scanPhotos(rows)
+}
+
+// Location methods
+
+// SetPhotoLocation stores GPS coordinates for a photo
+func (d *Database) SetPhotoLocation(id int64, latitude, longitude float64) error {
+	_, err := d.db.Exec(
+		"UPDATE photos SET latitude = ?, longitude = ?, updated_at = "+sqliteNowMillis+" WHERE id = ?",
+		latitude, longitude, id,
+	)
+	return err
+}
+
+// GetGeotaggedPhotos returns non-archived photos that have GPS coordinates
+// userID > 0 restricts to that user's photos, sharedOnly restricts to the family
+// area of groupID (0 = users in no group)
+func (d *Database) GetGeotaggedPhotos(userID int64, sharedOnly bool, groupID int64) ([]*Photo, error) {
+	query := `
+		SELECT ` + photoColumns + `
+		FROM photos p
+		JOIN users u ON p.user_id = u.id
+		WHERE p.latitude IS NOT NULL AND p.longitude IS NOT NULL
+		AND (p.is_archived = FALSE OR p.is_archived IS NULL)`
+	args := []interface{}{}
+
+	if userID > 0 {
+		query += " AND p.user_id = ?"
+		args = append(args, userID)
+	}
+	if sharedOnly {
+		query += " AND p.is_shared = TRUE AND COALESCE(p.group_id, 0) = ?"
+		args = append(args, groupID)
+	}
+	query += " ORDER BY p.uploaded_at DESC"
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query geotagged photos: %v", err)
+	}
+	defer rows.Close()
+
+	return d.scanPhotos(rows)
+}
+
+// Dimension methods
+
+// SetPhotoDimensions stores the pixel dimensions of a photo
+func (d *Database) SetPhotoDimensions(id int64, width, height int) error {
+	_, err := d.db.Exec("UPDATE photos SET width = ?, height = ? WHERE id = ?", width, height, id)
+	return err
+}
+
+// GetPhotosWithoutDimensions returns photos (archived or not) whose dimensions were never measured
+func (d *Database) GetPhotosWithoutDimensions() ([]*Photo, error) {
+	rows, err := d.db.Query(`
+		SELECT ` + photoColumns + `
+		FROM photos p
+		JOIN users u ON p.user_id = u.id
+		WHERE p.width IS NULL OR p.height IS NULL
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query photos: %v", err)
+	}
+	defer rows.Close()
+
+	return d.scanPhotos(rows)
+}
+
+// GetPhotoIDsByContentHash returns a user's photos (archived or not) whose file hashes to contentHash
+func (d *Database) GetPhotoIDsByContentHash(userID int64, contentHash string) ([]int64, error) {
+	rows, err := d.db.Query(
+		"SELECT id FROM photos WHERE user_id = ? AND content_hash = ? ORDER BY id",
+		userID, contentHash,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query photos: %v", err)
+	}
+	defer rows.Close()
+
+	ids := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan photo: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetPhotosByContentHash retrieves every user's photos whose file has the given SHA-256, oldest first
+// Callers filter them down to the ones the session may view
+func (d *Database) GetPhotosByContentHash(contentHash string) ([]*Photo, error) {
+	rows, err := d.db.Query(`
+		SELECT `+photoColumns+`
+		FROM photos p
+		JOIN users u ON p.user_id = u.id
+		WHERE p.content_hash = ?
+		ORDER BY p.id
+	`, contentHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query photos: %v", err)
+	}
+	defer rows.Close()
+
+	return d.scanPhotos(rows)
+}
+
+// Perceptual hash methods
+
+// SetPhotoPHash stores the perceptual hash of a photo ("" marks it as unhashable)
+func (d *Database) SetPhotoPHash(id int64, phash string) error {
+	_, err := d.db.Exec("UPDATE photos SET phash = ? WHERE id = ?", phash, id)
+	return err
+}
+
+// GetPhotosWithoutPHash returns photos (archived or not) whose perceptual hash was never computed
+func (d *Database) GetPhotosWithoutPHash() ([]*Photo, error) {
+	rows, err := d.db.Query(`
+		SELECT ` + photoColumns + `
+		FROM photos p
+		JOIN users u ON p.user_id = u.id
+		WHERE p.phash IS NULL
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query photos: %v", err)
+	}
+	defer rows.Close()
+
+	return d.scanPhotos(rows)
+}
+
+// sqliteTimeLayout matches CURRENT_TIMESTAMP so stored dates compare correctly in SQL
+const sqliteTimeLayout = "2006-01-02 15:04:05"
+
+// sqliteNowMillis is CURRENT_TIMESTAMP with milliseconds, for updated_at and
+// tombstones, which sync clients compare against; sqliteMillisLayout formats
+// values to compare with it
+const (
+	sqliteNowMillis    = `strftime('%Y-%m-%d %H:%M:%f', 'now')`
+	sqliteMillisLayout = "2006-01-02 15:04:05.000"
+)
+
+// SetPhotoTakenAt records the capture time read from a photo's EXIF data
+func (d *Database) SetPhotoTakenAt(id int64, takenAt time.Time) error {
+	_, err := d.db.Exec("UPDATE photos SET taken_at = ? WHERE id = ?", takenAt.UTC().Format(sqliteTimeLayout), id)
+	return err
+}
+
+// SetPhotoTakenAtOverride sets or (with nil) clears a manual capture date
+func (d *Database) SetPhotoTakenAtOverride(id int64, takenAt *time.Time) error {
+	var value interface{}
+	if takenAt != nil {
+		value = takenAt.UTC().Format(sqliteTimeLayout)
+	}
+	_, err := d.db.Exec("UPDATE photos SET taken_at_override = ?, updated_at = "+sqliteNowMillis+" WHERE id = ?", value, id)
+	return err
+}
+
+// SetPhotoCaption sets a photo's caption ("" clears it)
+func (d *Database) SetPhotoCaption(id int64, caption string) error {
+	_, err := d.db.Exec("UPDATE photos SET caption = NULLIF(?, ''), updated_at = "+sqliteNowMillis+" WHERE id = ?", caption, id)
+	return err
+}
+
+// SetPhotoBlurhash stores the blurhash placeholder of a photo
+func (d *Database) SetPhotoBlurhash(id int64, blurhash string) error {
+	_, err := d.db.Exec("UPDATE photos SET blurhash = ? WHERE id = ?", blurhash, id)
+	return err
+}
+
+// GetPhotosIncludingArchived returns every photo, archived or not
+// If userID is 0, photos of all users are returned
+func (d *Database) GetPhotosIncludingArchived(userID int64) ([]*Photo, error) {
+	query := `
+		SELECT ` + photoColumns + `
+		FROM photos p
+		JOIN users u ON p.user_id = u.id
+	`
+	args := []interface{}{}
+	if userID != 0 {
+		query += ` WHERE p.user_id = ?`
+		args = append(args, userID)
+	}
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query photos: %v", err)
+	}
+	defer rows.Close()
+
+	return d.scanPhotos(rows)
+}
+
+// GetPhotosForBlurhash returns photos (archived or not) that need a blurhash
+// If force is true, every photo is returned regardless of its current blurhash
+func (d *Database) GetPhotosForBlurhash(force bool) ([]*Photo, error) {
+	query := `
+		SELECT ` + photoColumns + `
+		FROM photos p
+		JOIN users u ON p.user_id = u.id
+	`
+	if !force {
+		query += ` WHERE p.blurhash IS NULL OR p.blurhash = ''`
+	}
+
+	rows, err := d.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query photos: %v", err)
+	}
+	defer rows.Close()
+
+	return d.scanPhotos(rows)
+}
+
+// Embedding methods
+
+// SaveEmbedding saves a CLIP embedding for a photo along with its dimension
+func (d *Database) SaveEmbedding(photoID int64, embedding []byte, dimension int) error {
+	_, err := d.db.Exec(`
+		INSERT INTO photo_embeddings (photo_id, embedding, dimension) VALUES (?, ?, ?)
+		ON CONFLICT(photo_id) DO UPDATE SET embedding = ?, dimension = ?, created_at = CURRENT_TIMESTAMP
+	`, photoID, embedding, dimension, embedding, dimension)
+	return err
+}
+
+// GetEmbeddingDimensions counts a user's embeddings (non-archived photos) per dimension
+// More than one entry means embeddings came from different models
+func (d *Database) GetEmbeddingDimensions(userID int64) (map[int]int, error) {
+	rows, err := d.db.Query(`
+		SELECT COALESCE(pe.dimension, 0), COUNT(*)
+		FROM photo_embeddings pe
+		JOIN photos p ON pe.photo_id = p.id
+		WHERE p.user_id = ? AND (p.is_archived = FALSE OR p.is_archived IS NULL)
+		GROUP BY COALESCE(pe.dimension, 0)
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query embedding dimensions: %v", err)
+	}
+	defer rows.Close()
+
+	dimensions := make(map[int]int)
+	for rows.Next() {
+		var dimension, count int
+		if err := rows.Scan(&dimension, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan embedding dimension: %v", err)
+		}
+		dimensions[dimension] = count
+	}
+
+	return dimensions, nil
+}
+
+// GetEmbedding retrieves the embedding for a photo
+func (d *Database) GetEmbedding(photoID int64) ([]byte, error) {
+	var embedding []byte
+	err := d.db.QueryRow(
 		"SELECT embedding FROM photo_embeddings WHERE photo_id = ?",
 		photoID,
 	).Scan(&embedding)
@@ -529,6 +1746,7 @@ func (d *Database) GetEmbedding(photoID int64) ([]byte, error) {
 }
 
 // GetAllEmbeddings retrieves embeddings for all non-archived photos of a user
+// The map has no order; clustering walks it by sorted photo ID (sortedPhotoIDs)
 func (d *Database) GetAllEmbeddings(userID int64) (map[int64][]byte, error) {
 	rows, err := d.db.Query(`
 		SELECT pe.photo_id, pe.embedding
@@ -557,8 +1775,9 @@ func (d *Database) GetAllEmbeddings(userID int64) (map[int64][]byte, error) {
 // GetPhotosWithoutEmbeddings returns photos that don't have embeddings yet
 func (d *Database) GetPhotosWithoutEmbeddings(userID int64) ([]*Photo, error) {
 	rows, err := d.db.Query(`
-		SELECT p.id, p.filename, p.user_id, p.is_shared, p.size, p.uploaded_at
+		SELECT `+photoColumns+`
 		FROM photos p
+		JOIN users u ON p.user_id = u.id
 		LEFT JOIN photo_embeddings pe ON p.id = pe.photo_id
 		WHERE p.user_id = ? AND pe.photo_id IS NULL AND (p.is_archived = FALSE OR p.is_archived IS NULL)
 	`, userID)
@@ -570,6 +1789,18 @@ func (d *Database) GetPhotosWithoutEmbeddings(userID int64) ([]*Photo, error) {
 	return d.scanPhotos(rows)
 }
 
+// GetPhotosWithoutEmbeddingsCount counts the photos GetPhotosWithoutEmbeddings would return
+func (d *Database) GetPhotosWithoutEmbeddingsCount(userID int64) (int, error) {
+	var count int
+	err := d.db.QueryRow(`
+		SELECT COUNT(*)
+		FROM photos p
+		LEFT JOIN photo_embeddings pe ON p.id = pe.photo_id
+		WHERE p.user_id = ? AND pe.photo_id IS NULL AND (p.is_archived = FALSE OR p.is_archived IS NULL)
+	`, userID).Scan(&count)
+	return count, err
+}
+
 // DeleteEmbedding deletes the embedding for a photo
 func (d *Database) DeleteEmbedding(photoID int64) error {
 	_, err := d.db.Exec("DELETE FROM photo_embeddings WHERE photo_id = ?", photoID)
@@ -600,3 +1831,590 @@ func (d *Database) GetEmbeddingCount(userID int64) (int, error) {
 	return count, err
 }
 
+// GetPhotoGroupAssignments returns a user's stored cluster assignments (photo ID -> group ID)
+// and the similarity threshold they were computed with
+// Photos whose embedding changed after they were assigned are left out so they get re-evaluated
+func (d *Database) GetPhotoGroupAssignments(userID int64) (map[int64]int64, float64, error) {
+	rows, err := d.db.Query(`
+		SELECT pg.photo_id, pg.group_id, pg.threshold
+		FROM photo_groups pg
+		JOIN photo_embeddings pe ON pe.photo_id = pg.photo_id
+		WHERE pg.user_id = ? AND pe.created_at <= pg.updated_at
+	`, userID)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query photo groups: %v", err)
+	}
+	defer rows.Close()
+
+	assignments := make(map[int64]int64)
+	var threshold float64
+	for rows.Next() {
+		var photoID, groupID int64
+		if err := rows.Scan(&photoID, &groupID, &threshold); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan photo group: %v", err)
+		}
+		assignments[photoID] = groupID
+	}
+
+	return assignments, threshold, rows.Err()
+}
+
+// CountSimilarPhotos returns how many other active photos share a photo's stored
+// similarity group (0 if it isn't grouped or grouping hasn't run)
+func (d *Database) CountSimilarPhotos(photoID int64) (int, error) {
+	var count int
+	err := d.db.QueryRow(`
+		SELECT COUNT(*)
+		FROM photo_groups pg
+		JOIN photo_groups other ON other.user_id = pg.user_id AND other.group_id = pg.group_id AND other.photo_id != pg.photo_id
+		JOIN photos p ON p.id = other.photo_id
+		WHERE pg.photo_id = ? AND pg.group_id > 0
+		AND (p.is_archived = FALSE OR p.is_archived IS NULL)
+	`, photoID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count similar photos: %v", err)
+	}
+	return count, nil
+}
+
+// AddAccessCounts adds buffered view and download counts to their photos
+// Counts for photos deleted in the meantime are dropped
+func (d *Database) AddAccessCounts(counts map[int64]AccessCounts) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		UPDATE photos
+		SET view_count = COALESCE(view_count, 0) + ?,
+			download_count = COALESCE(download_count, 0) + ?
+		WHERE id = ?
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare access count update: %v", err)
+	}
+	defer stmt.Close()
+
+	for photoID, c := range counts {
+		if _, err := stmt.Exec(c.Views, c.Downloads, photoID); err != nil {
+			return fmt.Errorf("failed to update access counts: %v", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetNextPhotoGroupID returns a group ID higher than any the user has stored
+func (d *Database) GetNextPhotoGroupID(userID int64) (int64, error) {
+	var maxID int64
+	err := d.db.QueryRow(
+		"SELECT COALESCE(MAX(group_id), 0) FROM photo_groups WHERE user_id = ?",
+		userID,
+	).Scan(&maxID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get next group ID: %v", err)
+	}
+	return maxID + 1, nil
+}
+
+// SavePhotoGroupAssignments replaces a user's stored cluster assignments
+func (d *Database) SavePhotoGroupAssignments(userID int64, assignments map[int64]int64, threshold float64) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM photo_groups WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("failed to clear photo groups: %v", err)
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO photo_groups (photo_id, user_id, group_id, threshold, updated_at)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare photo group insert: %v", err)
+	}
+	defer stmt.Close()
+
+	for photoID, groupID := range assignments {
+		if _, err := stmt.Exec(photoID, userID, groupID, threshold); err != nil {
+			return fmt.Errorf("failed to save photo group: %v", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Comment methods
+
+// Comment is a message left on a photo
+type Comment struct {
+	ID        int64     `json:"id"`
+	PhotoID   int64     `json:"photo_id"`
+	UserID    int64     `json:"user_id"`
+	Username  string    `json:"username"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CountComments returns the number of comments on a photo
+func (d *Database) CountComments(photoID int64) (int, error) {
+	var count int
+	if err := d.db.QueryRow("SELECT COUNT(*) FROM photo_comments WHERE photo_id = ?", photoID).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count comments: %v", err)
+	}
+	return count, nil
+}
+
+// CreateComment adds a comment to a photo
+func (d *Database) CreateComment(photoID, userID int64, body string) (*Comment, error) {
+	result, err := d.db.Exec(
+		"INSERT INTO photo_comments (photo_id, user_id, body) VALUES (?, ?, ?)",
+		photoID, userID, body,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create comment: %v", err)
+	}
+
+	id, _ := result.LastInsertId()
+
+	comment := &Comment{}
+	err = d.db.QueryRow(`
+		SELECT c.id, c.photo_id, c.user_id, u.username, c.body, c.created_at
+		FROM photo_comments c
+		JOIN users u ON c.user_id = u.id
+		WHERE c.id = ?
+	`, id).Scan(&comment.ID, &comment.PhotoID, &comment.UserID, &comment.Username, &comment.Body, &comment.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read comment: %v", err)
+	}
+
+	return comment, nil
+}
+
+// GetComments returns a photo's comments, oldest first
+func (d *Database) GetComments(photoID int64) ([]*Comment, error) {
+	rows, err := d.db.Query(`
+		SELECT c.id, c.photo_id, c.user_id, u.username, c.body, c.created_at
+		FROM photo_comments c
+		JOIN users u ON c.user_id = u.id
+		WHERE c.photo_id = ?
+		ORDER BY c.created_at ASC, c.id ASC
+	`, photoID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get comments: %v", err)
+	}
+	defer rows.Close()
+
+	comments := make([]*Comment, 0)
+	for rows.Next() {
+		comment := &Comment{}
+		if err := rows.Scan(&comment.ID, &comment.PhotoID, &comment.UserID, &comment.Username, &comment.Body, &comment.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan comment: %v", err)
+		}
+		comments = append(comments, comment)
+	}
+
+	return comments, rows.Err()
+}
+
+// Invite methods
+
+// Invite is a registration code handed out by an admin
+type Invite struct {
+	Code       string     `json:"code"`
+	CreatedBy  string     `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	MaxUses    int        `json:"max_uses"` // 0 = unlimited
+	Uses       int        `json:"uses"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	UsedBy     []string   `json:"used_by"` // usernames that registered with this code
+}
+
+// Usable reports whether the invite can still be redeemed
+func (i *Invite) Usable() bool {
+	if i.ExpiresAt != nil && !time.Now().Before(*i.ExpiresAt) {
+		return false
+	}
+	return i.MaxUses == 0 || i.Uses < i.MaxUses
+}
+
+// CreateInvite stores a new invite code
+// expiresAt may be nil for a code that never expires; maxUses 0 allows unlimited uses
+func (d *Database) CreateInvite(code string, createdBy int64, expiresAt *time.Time, maxUses int) error {
+	var expires interface{}
+	if expiresAt != nil {
+		expires = expiresAt.UTC().Format(sqliteTimeLayout)
+	}
+	_, err := d.db.Exec(
+		"INSERT INTO invite_codes (code, created_by, expires_at, max_uses) VALUES (?, ?, ?, ?)",
+		code, createdBy, expires, maxUses,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create invite: %v", err)
+	}
+	return nil
+}
+
+// GetInvites returns all invite codes, newest first, with the users who redeemed them
+func (d *Database) GetInvites() ([]*Invite, error) {
+	rows, err := d.db.Query(`
+		SELECT i.code, COALESCE(u.username, ''), i.created_at, i.expires_at, i.max_uses, i.uses, i.last_used_at
+		FROM invite_codes i
+		LEFT JOIN users u ON i.created_by = u.id
+		ORDER BY i.created_at DESC, i.rowid DESC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get invites: %v", err)
+	}
+	defer rows.Close()
+
+	invites := make([]*Invite, 0)
+	byCode := make(map[string]*Invite)
+	for rows.Next() {
+		invite := &Invite{UsedBy: make([]string, 0)}
+		var expiresAt, lastUsedAt sql.NullTime
+		if err := rows.Scan(&invite.Code, &invite.CreatedBy, &invite.CreatedAt, &expiresAt, &invite.MaxUses, &invite.Uses, &lastUsedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan invite: %v", err)
+		}
+		if expiresAt.Valid {
+			invite.ExpiresAt = &expiresAt.Time
+		}
+		if lastUsedAt.Valid {
+			invite.LastUsedAt = &lastUsedAt.Time
+		}
+		invites = append(invites, invite)
+		byCode[invite.Code] = invite
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get invites: %v", err)
+	}
+
+	users, err := d.db.Query("SELECT invite_code, username FROM users WHERE invite_code IS NOT NULL ORDER BY created_at")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get invite usage: %v", err)
+	}
+	defer users.Close()
+
+	for users.Next() {
+		var code, username string
+		if err := users.Scan(&code, &username); err != nil {
+			return nil, fmt.Errorf("failed to scan invite usage: %v", err)
+		}
+		if invite, ok := byCode[code]; ok {
+			invite.UsedBy = append(invite.UsedBy, username)
+		}
+	}
+
+	return invites, users.Err()
+}
+
+// DeleteInvite revokes an invite code; users who already redeemed it are unaffected
+// Returns false if the code doesn't exist
+func (d *Database) DeleteInvite(code string) (bool, error) {
+	result, err := d.db.Exec("DELETE FROM invite_codes WHERE code = ?", code)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete invite: %v", err)
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// Group methods
+
+// errGroupExists means a group with the same name (ignoring case) already exists
+var errGroupExists = errors.New("group already exists")
+
+// Group is a household whose members share a family area of their own
+type Group struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	Members   []string  `json:"members"` // usernames
+}
+
+// CreateGroup adds a group
+func (d *Database) CreateGroup(name string) (*Group, error) {
+	var count int
+	if err := d.db.QueryRow("SELECT COUNT(*) FROM user_groups WHERE name = ?", name).Scan(&count); err != nil {
+		return nil, fmt.Errorf("failed to check group name: %v", err)
+	}
+	if count > 0 {
+		return nil, errGroupExists
+	}
+
+	result, err := d.db.Exec("INSERT INTO user_groups (name) VALUES (?)", name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create group: %v", err)
+	}
+
+	id, _ := result.LastInsertId()
+	return &Group{ID: id, Name: name, CreatedAt: time.Now().UTC(), Members: make([]string, 0)}, nil
+}
+
+// GetGroups returns all groups by name, with their members
+func (d *Database) GetGroups() ([]*Group, error) {
+	rows, err := d.db.Query("SELECT id, name, created_at FROM user_groups ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get groups: %v", err)
+	}
+	defer rows.Close()
+
+	groups := make([]*Group, 0)
+	byID := make(map[int64]*Group)
+	for rows.Next() {
+		group := &Group{Members: make([]string, 0)}
+		if err := rows.Scan(&group.ID, &group.Name, &group.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan group: %v", err)
+		}
+		groups = append(groups, group)
+		byID[group.ID] = group
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get groups: %v", err)
+	}
+
+	members, err := d.db.Query("SELECT group_id, username FROM users WHERE group_id IS NOT NULL ORDER BY username")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get group members: %v", err)
+	}
+	defer members.Close()
+
+	for members.Next() {
+		var groupID int64
+		var username string
+		if err := members.Scan(&groupID, &username); err != nil {
+			return nil, fmt.Errorf("failed to scan group member: %v", err)
+		}
+		if group, ok := byID[groupID]; ok {
+			group.Members = append(group.Members, username)
+		}
+	}
+
+	return groups, members.Err()
+}
+
+// GroupExists reports whether a group ID exists
+func (d *Database) GroupExists(id int64) (bool, error) {
+	var count int
+	if err := d.db.QueryRow("SELECT COUNT(*) FROM user_groups WHERE id = ?", id).Scan(&count); err != nil {
+		return false, fmt.Errorf("failed to get group: %v", err)
+	}
+	return count > 0, nil
+}
+
+// DeleteGroup removes a group; its members and their shared photos move to the
+// ungrouped family area. Returns false if the group doesn't exist
+func (d *Database) DeleteGroup(id int64) (bool, error) {
+	result, err := d.db.Exec("DELETE FROM user_groups WHERE id = ?", id)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete group: %v", err)
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// SetUserGroup moves a user into a group (0 = no group)
+// The user's shared photos move with them, so they stay visible to the same
+// people as the user's future shares
+func (d *Database) SetUserGroup(userID, groupID int64) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE users SET group_id = NULLIF(?, 0) WHERE id = ?", groupID, userID); err != nil {
+		return fmt.Errorf("failed to set user group: %v", err)
+	}
+	if _, err := tx.Exec("UPDATE photos SET group_id = NULLIF(?, 0), updated_at = "+sqliteNowMillis+" WHERE user_id = ? AND is_shared = TRUE", groupID, userID); err != nil {
+		return fmt.Errorf("failed to move shared photos: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to set user group: %v", err)
+	}
+	return nil
+}
+
+// Thumbnail failure methods
+
+// ThumbnailFailure records a photo whose thumbnail fails to generate
+type ThumbnailFailure struct {
+	PhotoID       int64     `json:"photo_id"`
+	Filename      string    `json:"filename"`
+	Username      string    `json:"username"`
+	IsArchived    bool      `json:"is_archived"`
+	Attempts      int       `json:"attempts"`
+	LastError     string    `json:"last_error"`
+	FirstFailedAt time.Time `json:"first_failed_at"`
+	LastFailedAt  time.Time `json:"last_failed_at"`
+}
+
+// RecordThumbnailFailure counts a failed thumbnail generation for a photo
+func (d *Database) RecordThumbnailFailure(photoID int64, message string) error {
+	_, err := d.db.Exec(`
+		INSERT INTO thumbnail_failures (photo_id, last_error) VALUES (?, ?)
+		ON CONFLICT(photo_id) DO UPDATE SET
+			attempts = attempts + 1,
+			last_error = excluded.last_error,
+			last_failed_at = CURRENT_TIMESTAMP
+	`, photoID, message)
+	if err != nil {
+		return fmt.Errorf("failed to record thumbnail failure: %v", err)
+	}
+	return nil
+}
+
+// ClearThumbnailFailure forgets earlier failures once a photo's thumbnail was generated
+func (d *Database) ClearThumbnailFailure(photoID int64) error {
+	_, err := d.db.Exec("DELETE FROM thumbnail_failures WHERE photo_id = ?", photoID)
+	return err
+}
+
+// HasThumbnailFailure reports whether a photo's thumbnail failed to generate
+// and hasn't been generated successfully since
+func (d *Database) HasThumbnailFailure(photoID int64) (bool, error) {
+	var exists bool
+	err := d.db.QueryRow("SELECT EXISTS(SELECT 1 FROM thumbnail_failures WHERE photo_id = ?)", photoID).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check thumbnail failure: %v", err)
+	}
+	return exists, nil
+}
+
+// GetThumbnailFailures returns photos whose thumbnails failed at least minAttempts
+// times, most attempts first
+func (d *Database) GetThumbnailFailures(minAttempts int) ([]*ThumbnailFailure, error) {
+	rows, err := d.db.Query(`
+		SELECT f.photo_id, p.filename, u.username, COALESCE(p.is_archived, FALSE),
+			f.attempts, f.last_error, f.first_failed_at, f.last_failed_at
+		FROM thumbnail_failures f
+		JOIN photos p ON f.photo_id = p.id
+		JOIN users u ON p.user_id = u.id
+		WHERE f.attempts >= ?
+		ORDER BY f.attempts DESC, f.last_failed_at DESC
+	`, minAttempts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get thumbnail failures: %v", err)
+	}
+	defer rows.Close()
+
+	failures := make([]*ThumbnailFailure, 0)
+	for rows.Next() {
+		f := &ThumbnailFailure{}
+		if err := rows.Scan(&f.PhotoID, &f.Filename, &f.Username, &f.IsArchived,
+			&f.Attempts, &f.LastError, &f.FirstFailedAt, &f.LastFailedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan thumbnail failure: %v", err)
+		}
+		failures = append(failures, f)
+	}
+	return failures, rows.Err()
+}
+
+// Public link methods
+
+// PublicLink grants access to one photo without an account until it expires
+type PublicLink struct {
+	ID         int64      `json:"id"`
+	PhotoID    int64      `json:"photo_id"`
+	CreatedBy  int64      `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	Uses       int        `json:"uses"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// CreatePublicLink stores a link by the hash of its token and drops expired links
+func (d *Database) CreatePublicLink(tokenHash string, photoID, createdBy int64, expiresAt time.Time) (*PublicLink, error) {
+	now := time.Now().UTC()
+	if _, err := d.db.Exec("DELETE FROM public_links WHERE expires_at <= ?", now.Format(sqliteTimeLayout)); err != nil {
+		return nil, fmt.Errorf("failed to prune public links: %v", err)
+	}
+
+	result, err := d.db.Exec(
+		"INSERT INTO public_links (token_hash, photo_id, created_by, expires_at) VALUES (?, ?, ?, ?)",
+		tokenHash, photoID, createdBy, expiresAt.UTC().Format(sqliteTimeLayout),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create public link: %v", err)
+	}
+
+	id, _ := result.LastInsertId()
+	return &PublicLink{
+		ID:        id,
+		PhotoID:   photoID,
+		CreatedBy: createdBy,
+		CreatedAt: now.Truncate(time.Second),
+		ExpiresAt: expiresAt.UTC().Truncate(time.Second),
+	}, nil
+}
+
+// UsePublicLink returns the photo an unexpired link grants access to and counts the use
+// Returns nil, nil if the token is unknown, revoked or expired
+func (d *Database) UsePublicLink(tokenHash string) (*Photo, error) {
+	result, err := d.db.Exec(`
+		UPDATE public_links SET uses = uses + 1, last_used_at = CURRENT_TIMESTAMP
+		WHERE token_hash = ? AND expires_at > ?
+	`, tokenHash, time.Now().UTC().Format(sqliteTimeLayout))
+	if err != nil {
+		return nil, fmt.Errorf("failed to use public link: %v", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, nil
+	}
+
+	var photoID int64
+	err = d.db.QueryRow("SELECT photo_id FROM public_links WHERE token_hash = ?", tokenHash).Scan(&photoID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get public link: %v", err)
+	}
+	return d.GetPhotoByID(photoID)
+}
+
+// GetPublicLinks returns a photo's unexpired links, newest first
+func (d *Database) GetPublicLinks(photoID int64) ([]*PublicLink, error) {
+	rows, err := d.db.Query(`
+		SELECT id, photo_id, created_by, created_at, expires_at, uses, last_used_at
+		FROM public_links
+		WHERE photo_id = ? AND expires_at > ?
+		ORDER BY created_at DESC, id DESC
+	`, photoID, time.Now().UTC().Format(sqliteTimeLayout))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get public links: %v", err)
+	}
+	defer rows.Close()
+
+	links := make([]*PublicLink, 0)
+	for rows.Next() {
+		link := &PublicLink{}
+		var lastUsedAt sql.NullTime
+		if err := rows.Scan(&link.ID, &link.PhotoID, &link.CreatedBy, &link.CreatedAt, &link.ExpiresAt, &link.Uses, &lastUsedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan public link: %v", err)
+		}
+		if lastUsedAt.Valid {
+			link.LastUsedAt = &lastUsedAt.Time
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+// DeletePublicLink revokes one of a photo's links
+// Returns false if the photo has no such link
+func (d *Database) DeletePublicLink(photoID, linkID int64) (bool, error) {
+	result, err := d.db.Exec("DELETE FROM public_links WHERE id = ? AND photo_id = ?", linkID, photoID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete public link: %v", err)
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
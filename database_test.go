@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrentWrites(t *testing.T) {
+	for _, mode := range []string{"wal", "delete"} {
+		t.Run(mode, func(t *testing.T) {
+			opts := DefaultConfig().GetDatabaseOptions()
+			opts.JournalMode = mode
+
+			db, err := NewDatabase(filepath.Join(t.TempDir(), "test.db"), opts)
+			if err != nil {
+				t.Fatalf("NewDatabase: %v", err)
+			}
+			defer db.Close()
+			db.SetBcryptCost(4)
+
+			user, err := db.CreateUser("alice", "test-password")
+			if err != nil {
+				t.Fatalf("CreateUser: %v", err)
+			}
+
+			const writers, perWriter = 8, 25
+
+			var wg sync.WaitGroup
+			errs := make(chan error, writers*perWriter*2)
+			for w := 0; w < writers; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < perWriter; i++ {
+						photo, err := db.CreatePhoto(fmt.Sprintf("w%d-%d.jpg", w, i), "", user.ID, 1024, 64, 48, "")
+						if err != nil {
+							errs <- fmt.Errorf("CreatePhoto: %v", err)
+							continue
+						}
+						if err := db.SetPhotoCaption(photo.ID, "caption"); err != nil {
+							errs <- fmt.Errorf("SetPhotoCaption: %v", err)
+						}
+						// Reads in between, as the gallery does while uploads run
+						if _, err := db.GetPhotosByUser(user.ID); err != nil {
+							errs <- fmt.Errorf("GetPhotosByUser: %v", err)
+						}
+					}
+				}(w)
+			}
+			wg.Wait()
+			close(errs)
+
+			for err := range errs {
+				t.Error(err)
+			}
+
+			photos, err := db.GetPhotosByUser(user.ID)
+			if err != nil {
+				t.Fatalf("GetPhotosByUser: %v", err)
+			}
+			if len(photos) != writers*perWriter {
+				t.Errorf("%d photos stored, want %d", len(photos), writers*perWriter)
+			}
+		})
+	}
+}
+
+func TestGetPhotosChangedSinceCursorSurvivesEdits(t *testing.T) {
+	db, err := NewDatabase(filepath.Join(t.TempDir(), "test.db"), DefaultConfig().GetDatabaseOptions())
+	if err != nil {
+		t.Fatalf("NewDatabase: %v", err)
+	}
+	defer db.Close()
+	db.SetBcryptCost(4)
+
+	user, err := db.CreateUser("alice", "test-password")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	const count, pageSize = 6, 2
+	since := time.Now().Add(-time.Minute)
+	for i := 0; i < count; i++ {
+		if _, err := db.CreatePhoto(fmt.Sprintf("p%d.jpg", i), "", user.ID, 1024, 64, 48, ""); err != nil {
+			t.Fatalf("CreatePhoto: %v", err)
+		}
+	}
+
+	seen := make(map[int64]bool)
+	var after *SyncCursor
+	for page := 0; ; page++ {
+		photos, total, err := db.GetPhotosChangedSince(user.ID, since, after, pageSize)
+		if err != nil {
+			t.Fatalf("GetPhotosChangedSince: %v", err)
+		}
+		if total < count {
+			t.Fatalf("total %d, want at least %d", total, count)
+		}
+		if len(photos) == 0 {
+			break
+		}
+		for _, photo := range photos {
+			seen[photo.ID] = true
+		}
+
+		// Editing a photo that was already read moves it behind the rest
+		if page == 0 {
+			time.Sleep(5 * time.Millisecond)
+			if err := db.SetPhotoCaption(photos[0].ID, "edited"); err != nil {
+				t.Fatalf("SetPhotoCaption: %v", err)
+			}
+		}
+
+		last := photos[len(photos)-1]
+		after = &SyncCursor{UpdatedAt: last.UpdatedAt, PhotoID: last.ID}
+		if page > count {
+			t.Fatal("paging never ends")
+		}
+	}
+
+	if len(seen) != count {
+		t.Errorf("saw %d of %d photos", len(seen), count)
+	}
+}
+
+func TestUpdatedAtBackfillHasMilliseconds(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := NewDatabase(path, DefaultConfig().GetDatabaseOptions())
+	if err != nil {
+		t.Fatalf("NewDatabase: %v", err)
+	}
+	db.SetBcryptCost(4)
+
+	user, err := db.CreateUser("alice", "test-password")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	photo, err := db.CreatePhoto("beach.jpg", "", user.ID, 1024, 64, 48, "")
+	if err != nil {
+		t.Fatalf("CreatePhoto: %v", err)
+	}
+
+	// As an earlier backfill from uploaded_at left it
+	if _, err := db.db.Exec(`UPDATE photos SET updated_at = '2024-01-02 15:04:05' WHERE id = ?`, photo.ID); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, err = NewDatabase(path, DefaultConfig().GetDatabaseOptions())
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer db.Close()
+
+	var updatedAt string
+	if err := db.db.QueryRow(`SELECT updated_at || '' FROM photos WHERE id = ?`, photo.ID).Scan(&updatedAt); err != nil {
+		t.Fatal(err)
+	}
+	if updatedAt != "2024-01-02 15:04:05.000" {
+		t.Errorf("updated_at %q, want 2024-01-02 15:04:05.000", updatedAt)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+// diskSpace is not implemented on this platform; free-space checks are skipped
+func diskSpace(path string) (free, total uint64, err error) {
+	return 0, 0, errors.New("disk space not available on this platform")
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ==================== CLIP SERVICE ====================
+
+// EmbeddingRequest is the request to generate an embedding
+type EmbeddingRequest struct {
+	ImageBase64 string `json:"image_base64"`
+	ImageID     string `json:"image_id,omitempty"`
+}
+
+// BatchEmbeddingRequest is a batch request for multiple embeddings
+type BatchEmbeddingRequest struct {
+	Images []EmbeddingRequest `json:"images"`
+}
+
+// BatchEmbeddingResponse is the response for batch embeddings
+type BatchEmbeddingResponse struct {
+	Embeddings []EmbeddingResponse `json:"embeddings"`
+}
+
+// clipEmbedder talks to the bundled CLIP service's /health and /embed endpoints
+type clipEmbedder struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// newCLIPEmbedder creates a client for the CLIP service at baseURL
+func newCLIPEmbedder(baseURL string) *clipEmbedder {
+	if baseURL == "" {
+		baseURL = "http://127.0.0.1:8081"
+	}
+	return &clipEmbedder{
+		baseURL: baseURL,
+		httpClient: &http.Client{
+			Timeout: 60 * time.Second, // Longer timeout for model inference
+		},
+	}
+}
+
+// Health fetches the CLIP service's health report
+func (e *clipEmbedder) Health() (*HealthResponse, error) {
+	resp, err := e.httpClient.Get(e.baseURL + "/health")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("health check returned status %d", resp.StatusCode)
+	}
+
+	var health HealthResponse
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		return nil, err
+	}
+	return &health, nil
+}
+
+// Embed sends one image to /embed
+func (e *clipEmbedder) Embed(imageData []byte, imageID string) (*EmbeddingResponse, error) {
+	// Create request
+	req := EmbeddingRequest{
+		ImageBase64: base64.StdEncoding.EncodeToString(imageData),
+		ImageID:     imageID,
+	}
+
+	reqBody, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	// Send request
+	resp, err := e.httpClient.Post(
+		e.baseURL+"/embed",
+		"application/json",
+		bytes.NewReader(reqBody),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("embedding service error: %s", string(body))
+	}
+
+	var embResp EmbeddingResponse
+	if err := json.NewDecoder(resp.Body).Decode(&embResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &embResp, nil
+}
+
+// ==================== OPENAI-COMPATIBLE ====================
+
+// openAIEmbedder calls an OpenAI-style POST {base}/embeddings endpoint
+// Images are sent as data URLs in "input", which multimodal embedding servers
+// (e.g. Infinity or vLLM serving a CLIP/SigLIP model) accept. Such APIs have no
+// health endpoint, so listing models stands in for one
+type openAIEmbedder struct {
+	config     EmbeddingConfig
+	httpClient *http.Client
+}
+
+// newOpenAIEmbedder creates a client for an OpenAI-compatible embeddings API
+func newOpenAIEmbedder(config EmbeddingConfig) *openAIEmbedder {
+	config.BaseURL = strings.TrimSuffix(config.BaseURL, "/")
+	return &openAIEmbedder{
+		config: config,
+		httpClient: &http.Client{
+			Timeout: 60 * time.Second, // Longer timeout for model inference
+		},
+	}
+}
+
+// newRequest builds a request to the API with authorization set
+func (e *openAIEmbedder) newRequest(method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, e.config.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if e.config.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+e.config.APIKey)
+	}
+	return req, nil
+}
+
+// Health checks that GET {base}/models answers
+// The dimension isn't advertised; it is pinned by the first embedding instead
+func (e *openAIEmbedder) Health() (*HealthResponse, error) {
+	req, err := e.newRequest(http.MethodGet, "/models", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("health check returned status %d", resp.StatusCode)
+	}
+
+	return &HealthResponse{
+		Status:      "healthy",
+		ModelLoaded: true,
+		Device:      "remote",
+		Model:       e.config.Model,
+	}, nil
+}
+
+// Embed sends one image as a data URL
+func (e *openAIEmbedder) Embed(imageData []byte, imageID string) (*EmbeddingResponse, error) {
+	dataURL := fmt.Sprintf("data:%s;base64,%s", http.DetectContentType(imageData), base64.StdEncoding.EncodeToString(imageData))
+
+	reqBody, err := json.Marshal(map[string]interface{}{
+		"model": e.config.Model,
+		"input": []string{dataURL},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := e.newRequest(http.MethodPost, "/embeddings", bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("embedding API error (%d): %s", resp.StatusCode, string(body))
+	}
+
+	var apiResp struct {
+		Data []struct {
+			Embedding []float64 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if len(apiResp.Data) == 0 {
+		return nil, fmt.Errorf("embedding API returned no embeddings")
+	}
+
+	embedding := apiResp.Data[0].Embedding
+	return &EmbeddingResponse{
+		ImageID:   imageID,
+		Embedding: embedding,
+		Dimension: len(embedding),
+	}, nil
+}
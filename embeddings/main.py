@@ -24,6 +24,7 @@ logger = logging.getLogger(__name__)
 model: Optional[CLIPModel] = None
 processor: Optional[CLIPProcessor] = None
 device: str = "cpu"
+model_name: str = "openai/clip-vit-base-patch32"
 
 
 @asynccontextmanager
@@ -38,7 +39,6 @@ async def lifespan(app: FastAPI):
     logger.info(f"Using device: {device}")
     
     # Load model and processor
-    model_name = "openai/clip-vit-base-patch32"
     model = CLIPModel.from_pretrained(model_name).to(device)
     processor = CLIPProcessor.from_pretrained(model_name)
     
@@ -98,6 +98,8 @@ class HealthResponse(BaseModel):
     status: str
     model_loaded: bool
     device: str
+    model: str
+    dimension: int  # 0 until the model is loaded
 
 
 def decode_image(image_base64: str) -> Image.Image:
@@ -143,7 +145,9 @@ async def health_check():
     return HealthResponse(
         status="healthy",
         model_loaded=model is not None,
-        device=device
+        device=device,
+        model=model_name,
+        dimension=model.config.projection_dim if model is not None else 0
     )
 
 
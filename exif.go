@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// EXIF tag IDs used by Mnemosyne
+const (
+	exifTagGPSInfo      = 0x8825
+	exifTagGPSLatRef    = 0x0001
+	exifTagGPSLatitude  = 0x0002
+	exifTagGPSLonRef    = 0x0003
+	exifTagGPSLongitude = 0x0004
+
+	exifTagOrientation      = 0x0112
+	exifTagDateTime         = 0x0132
+	exifTagExifIFD          = 0x8769
+	exifTagDateTimeOriginal = 0x9003
+)
+
+// ExifData holds the subset of EXIF metadata Mnemosyne cares about
+type ExifData struct {
+	Latitude  *float64   `json:"latitude,omitempty"`
+	Longitude *float64   `json:"longitude,omitempty"`
+	TakenAt   *time.Time `json:"taken_at,omitempty"` // camera wall-clock time (EXIF has no time zone)
+}
+
+// exifDateLayout is the EXIF date/time format
+const exifDateLayout = "2006:01:02 15:04:05"
+
+// HasLocation returns true if GPS coordinates were found
+func (e *ExifData) HasLocation() bool {
+	return e != nil && e.Latitude != nil && e.Longitude != nil
+}
+
+// tiffReader reads values from a TIFF-structured EXIF block
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// ifdEntry is a single directory entry in an IFD
+type ifdEntry struct {
+	tag         uint16
+	typ         uint16
+	count       uint32
+	valueOffset uint32
+	raw         []byte // the 4 raw value/offset bytes
+}
+
+// extractExif parses EXIF metadata from JPEG data
+// Returns an empty ExifData (not an error) if the image simply has no EXIF block
+func extractExif(data []byte) (*ExifData, error) {
+	tiff, err := findJPEGExif(data)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ExifData{}
+	if tiff == nil {
+		return result, nil
+	}
+
+	r, err := newTIFFReader(tiff)
+	if err != nil {
+		return nil, err
+	}
+
+	ifd0, err := r.readIFD(r.order.Uint32(tiff[4:8]))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range ifd0 {
+		switch entry.tag {
+		case exifTagGPSInfo:
+			gps, err := r.readIFD(entry.valueOffset)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read GPS IFD: %v", err)
+			}
+			r.parseGPS(gps, result)
+		case exifTagExifIFD:
+			// DateTimeOriginal (when the shutter fired) beats the IFD0 modification time
+			if exifIFD, err := r.readIFD(entry.valueOffset); err == nil {
+				for _, e := range exifIFD {
+					if e.tag == exifTagDateTimeOriginal {
+						if t, ok := r.readDate(e); ok {
+							result.TakenAt = &t
+						}
+					}
+				}
+			}
+		case exifTagDateTime:
+			if result.TakenAt == nil {
+				if t, ok := r.readDate(entry); ok {
+					result.TakenAt = &t
+				}
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// findJPEGExif locates the TIFF block inside a JPEG APP1 segment
+// Returns nil if the data is not a JPEG or has no EXIF segment
+func findJPEGExif(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, nil
+	}
+
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return nil, fmt.Errorf("invalid JPEG marker at offset %d", pos)
+		}
+
+		marker := data[pos+1]
+
+		// Start of scan or end of image: no more metadata segments
+		if marker == 0xDA || marker == 0xD9 {
+			return nil, nil
+		}
+
+		length := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+		if length < 2 || pos+2+length > len(data) {
+			return nil, fmt.Errorf("truncated JPEG segment")
+		}
+
+		segment := data[pos+4 : pos+2+length]
+		if marker == 0xE1 && len(segment) >= 6 && string(segment[:6]) == "Exif\x00\x00" {
+			return segment[6:], nil
+		}
+
+		pos += 2 + length
+	}
+
+	return nil, nil
+}
+
+// newTIFFReader validates the TIFF header and determines byte order
+func newTIFFReader(data []byte) (*tiffReader, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("EXIF block too small")
+	}
+
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("invalid TIFF byte order")
+	}
+
+	if order.Uint16(data[2:4]) != 0x002A {
+		return nil, fmt.Errorf("invalid TIFF magic")
+	}
+
+	return &tiffReader{data: data, order: order}, nil
+}
+
+// readIFD reads all entries of the IFD at the given offset
+func (r *tiffReader) readIFD(offset uint32) ([]ifdEntry, error) {
+	if int(offset)+2 > len(r.data) {
+		return nil, fmt.Errorf("IFD offset out of range")
+	}
+
+	count := int(r.order.Uint16(r.data[offset : offset+2]))
+	start := int(offset) + 2
+	if start+count*12 > len(r.data) {
+		return nil, fmt.Errorf("IFD entries out of range")
+	}
+
+	entries := make([]ifdEntry, 0, count)
+	for i := 0; i < count; i++ {
+		e := r.data[start+i*12 : start+(i+1)*12]
+		entries = append(entries, ifdEntry{
+			tag:         r.order.Uint16(e[0:2]),
+			typ:         r.order.Uint16(e[2:4]),
+			count:       r.order.Uint32(e[4:8]),
+			valueOffset: r.order.Uint32(e[8:12]),
+			raw:         e[8:12],
+		})
+	}
+
+	return entries, nil
+}
+
+// readRationals reads an array of unsigned RATIONAL values (TIFF type 5)
+func (r *tiffReader) readRationals(entry ifdEntry) ([]float64, error) {
+	if entry.typ != 5 {
+		return nil, fmt.Errorf("unexpected EXIF type %d for rational", entry.typ)
+	}
+
+	end := int(entry.valueOffset) + int(entry.count)*8
+	if end > len(r.data) {
+		return nil, fmt.Errorf("rational value out of range")
+	}
+
+	values := make([]float64, entry.count)
+	for i := range values {
+		off := int(entry.valueOffset) + i*8
+		num := r.order.Uint32(r.data[off : off+4])
+		den := r.order.Uint32(r.data[off+4 : off+8])
+		if den == 0 {
+			return nil, fmt.Errorf("zero denominator in rational")
+		}
+		values[i] = float64(num) / float64(den)
+	}
+
+	return values, nil
+}
+
+// readASCII reads an ASCII string value (TIFF type 2)
+func (r *tiffReader) readASCII(entry ifdEntry) (string, error) {
+	if entry.typ != 2 {
+		return "", fmt.Errorf("unexpected EXIF type %d for ASCII", entry.typ)
+	}
+
+	var value []byte
+	if entry.count <= 4 {
+		value = entry.raw[:entry.count]
+	} else {
+		end := int(entry.valueOffset) + int(entry.count)
+		if end > len(r.data) {
+			return "", fmt.Errorf("ASCII value out of range")
+		}
+		value = r.data[entry.valueOffset:end]
+	}
+
+	return strings.TrimRight(string(value), "\x00 "), nil
+}
+
+// readDate reads an EXIF date/time value
+// Cameras without a set clock write zeros or blanks, which are ignored
+func (r *tiffReader) readDate(entry ifdEntry) (time.Time, bool) {
+	value, err := r.readASCII(entry)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	t, err := time.Parse(exifDateLayout, value)
+	if err != nil || t.Year() < 1800 {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// parseGPS fills in latitude and longitude from GPS IFD entries
+func (r *tiffReader) parseGPS(entries []ifdEntry, result *ExifData) {
+	var latRef, lonRef byte
+	var lat, lon []float64
+
+	for _, entry := range entries {
+		switch entry.tag {
+		case exifTagGPSLatRef:
+			latRef = entry.raw[0]
+		case exifTagGPSLonRef:
+			lonRef = entry.raw[0]
+		case exifTagGPSLatitude:
+			lat, _ = r.readRationals(entry)
+		case exifTagGPSLongitude:
+			lon, _ = r.readRationals(entry)
+		}
+	}
+
+	if len(lat) != 3 || len(lon) != 3 {
+		return
+	}
+
+	latitude := lat[0] + lat[1]/60 + lat[2]/3600
+	longitude := lon[0] + lon[1]/60 + lon[2]/3600
+	if latRef == 'S' {
+		latitude = -latitude
+	}
+	if lonRef == 'W' {
+		longitude = -longitude
+	}
+
+	// Reject out-of-range coordinates from broken writers
+	if latitude < -90 || latitude > 90 || longitude < -180 || longitude > 180 {
+		return
+	}
+
+	// 0,0 is what many cameras write when they have no fix
+	if latitude == 0 && longitude == 0 {
+		return
+	}
+
+	result.Latitude = &latitude
+	result.Longitude = &longitude
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// stripMetadata returns a copy of an image without its EXIF, XMP and text metadata
+// Pixels are untouched: only metadata segments are dropped, so nothing is re-encoded.
+// A JPEG keeps its orientation so it isn't shown sideways
+func stripMetadata(data []byte, mimeType string) ([]byte, error) {
+	switch mimeType {
+	case "image/jpeg":
+		return stripJPEGMetadata(data)
+	case "image/png":
+		return stripPNGMetadata(data)
+	case "image/webp":
+		return stripWebPMetadata(data)
+	case "image/gif":
+		// GIFs carry no EXIF
+		return data, nil
+	}
+	return nil, fmt.Errorf("unsupported image format")
+}
+
+// stripJPEGMetadata drops APP1 (EXIF, XMP), APP13 (IPTC) and comment segments
+// Other segments, including color profiles, and the image data are copied as is
+func stripJPEGMetadata(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, fmt.Errorf("not a JPEG")
+	}
+
+	var kept [][]byte
+	orientation := 0
+	pos := 2
+	for {
+		if pos+4 > len(data) {
+			return nil, fmt.Errorf("truncated JPEG")
+		}
+		if data[pos] != 0xFF {
+			return nil, fmt.Errorf("invalid JPEG marker at offset %d", pos)
+		}
+
+		marker := data[pos+1]
+
+		// Start of scan or end of image: the rest is image data
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+
+		length := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+		if length < 2 || pos+2+length > len(data) {
+			return nil, fmt.Errorf("truncated JPEG segment")
+		}
+
+		segment := data[pos+4 : pos+2+length]
+		switch {
+		case marker == 0xE1 && len(segment) >= 6 && string(segment[:6]) == "Exif\x00\x00":
+			orientation = exifOrientation(segment[6:])
+		case marker == 0xE1, marker == 0xED, marker == 0xFE:
+			// XMP, IPTC and comments
+		default:
+			kept = append(kept, data[pos:pos+2+length])
+		}
+
+		pos += 2 + length
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xFF, 0xD8)
+
+	// JFIF requires its APP0 segment first; the orientation goes right after it
+	if len(kept) > 0 && kept[0][1] == 0xE0 {
+		out = append(out, kept[0]...)
+		kept = kept[1:]
+	}
+	if orientation > 1 {
+		out = append(out, orientationExif(orientation)...)
+	}
+	for _, segment := range kept {
+		out = append(out, segment...)
+	}
+
+	return append(out, data[pos:]...), nil
+}
+
+// exifOrientation reads the Orientation tag from a TIFF block (0 if absent)
+func exifOrientation(tiff []byte) int {
+	r, err := newTIFFReader(tiff)
+	if err != nil {
+		return 0
+	}
+
+	ifd0, err := r.readIFD(r.order.Uint32(tiff[4:8]))
+	if err != nil {
+		return 0
+	}
+
+	for _, entry := range ifd0 {
+		if entry.tag == exifTagOrientation && entry.typ == 3 {
+			return int(r.order.Uint16(entry.raw[0:2]))
+		}
+	}
+	return 0
+}
+
+// orientationExif builds an APP1 segment whose EXIF holds only the orientation
+func orientationExif(orientation int) []byte {
+	var tiff bytes.Buffer
+	tiff.WriteString("MM\x00\x2A")
+	binary.Write(&tiff, binary.BigEndian, uint32(8)) // IFD0 follows the header
+	binary.Write(&tiff, binary.BigEndian, uint16(1)) // one entry
+	binary.Write(&tiff, binary.BigEndian, uint16(exifTagOrientation))
+	binary.Write(&tiff, binary.BigEndian, uint16(3)) // SHORT
+	binary.Write(&tiff, binary.BigEndian, uint32(1))
+	binary.Write(&tiff, binary.BigEndian, uint16(orientation))
+	binary.Write(&tiff, binary.BigEndian, uint16(0)) // value padding
+	binary.Write(&tiff, binary.BigEndian, uint32(0)) // no next IFD
+
+	payload := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:4], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// stripPNGMetadata drops eXIf and text chunks (which can hold XMP)
+func stripPNGMetadata(data []byte) ([]byte, error) {
+	const signature = "\x89PNG\r\n\x1a\n"
+	if len(data) < len(signature) || string(data[:len(signature)]) != signature {
+		return nil, fmt.Errorf("not a PNG")
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:len(signature)]...)
+
+	pos := len(signature)
+	for pos < len(data) {
+		if pos+8 > len(data) {
+			return nil, fmt.Errorf("truncated PNG chunk")
+		}
+		end := pos + 12 + int(binary.BigEndian.Uint32(data[pos:pos+4])) // length, type, data, CRC
+		if end > len(data) || end < pos {
+			return nil, fmt.Errorf("truncated PNG chunk")
+		}
+
+		switch string(data[pos+4 : pos+8]) {
+		case "eXIf", "tEXt", "zTXt", "iTXt":
+		default:
+			out = append(out, data[pos:end]...)
+		}
+		pos = end
+	}
+
+	return out, nil
+}
+
+// stripWebPMetadata drops the EXIF and XMP chunks of an extended WebP
+// Simple WebPs have nowhere to keep metadata and are returned unchanged
+func stripWebPMetadata(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, fmt.Errorf("not a WebP")
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:12]...)
+
+	pos := 12
+	for pos < len(data) {
+		if pos+8 > len(data) {
+			return nil, fmt.Errorf("truncated WebP chunk")
+		}
+		size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		end := pos + 8 + size + size%2 // chunks are padded to an even size
+		if end > len(data) || end < pos {
+			return nil, fmt.Errorf("truncated WebP chunk")
+		}
+
+		switch string(data[pos : pos+4]) {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunk := append([]byte(nil), data[pos:end]...)
+			if len(chunk) > 8 {
+				chunk[8] &^= 0x08 | 0x04 // EXIF and XMP present flags
+			}
+			out = append(out, chunk...)
+		default:
+			out = append(out, data[pos:end]...)
+		}
+		pos = end
+	}
+
+	binary.LittleEndian.PutUint32(out[4:8], uint32(len(out)-8))
+	return out, nil
+}
+
+// OpenStripped opens a copy of a photo's original without its metadata (see stripMetadata)
+// Copies of active photos are cached with the format variants and rebuilt when
+// the original is newer; archived photos are stripped on each request
+func (pm *PhotoManager) OpenStripped(photo *Photo) (io.ReadSeekCloser, fs.FileInfo, error) {
+	file, info, err := pm.OpenOriginal(photo)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key := path.Join(pm.getVariantsDir(photo.UserID, photo.Filename), "stripped"+strings.ToLower(path.Ext(photo.Filename)))
+	if !photo.IsArchived {
+		if cached, err := pm.storage.Stat(key); err == nil && !cached.ModTime().Before(info.ModTime()) {
+			file.Close()
+			pm.cache.touch(key)
+			return pm.open(key)
+		}
+	}
+
+	data, err := io.ReadAll(file)
+	file.Close()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read original: %v", err)
+	}
+
+	mimeType, err := validateImageMagicBytes(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	stripped, err := stripMetadata(data, mimeType)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to strip metadata: %v", err)
+	}
+
+	if photo.IsArchived {
+		return memFile{bytes.NewReader(stripped)}, info, nil
+	}
+
+	if err := pm.storage.Save(key, bytes.NewReader(stripped)); err != nil {
+		return nil, nil, fmt.Errorf("failed to save stripped copy: %v", err)
+	}
+	pm.cache.added(key)
+
+	return pm.open(key)
+}
+
+// stripsFor reports whether viewerID gets photo without its metadata: with
+// strip_exif_for_others, everyone but the owner does
+func (app *App) stripsFor(photo *Photo, viewerID int64) bool {
+	return app.config.StripExifForOthers && viewerID != photo.UserID
+}
+
+// openOriginalFor opens a photo's original as viewerID may receive it (see stripsFor)
+func (app *App) openOriginalFor(photo *Photo, viewerID int64) (io.ReadSeekCloser, fs.FileInfo, error) {
+	return app.photoMgr.openOriginal(photo, app.stripsFor(photo, viewerID))
+}
+
+// openOriginal opens a photo's original, or its stripped copy if stripped is set
+func (pm *PhotoManager) openOriginal(photo *Photo, stripped bool) (io.ReadSeekCloser, fs.FileInfo, error) {
+	if stripped {
+		return pm.OpenStripped(photo)
+	}
+	return pm.OpenOriginal(photo)
+}
+
+// hideLocationsFor clears the coordinates of photos viewerID doesn't own when
+// strip_exif_for_others is set, so locations reach nobody but the owner
+func (app *App) hideLocationsFor(viewerID int64, photos ...*Photo) {
+	if !app.config.StripExifForOthers {
+		return
+	}
+	for _, photo := range photos {
+		if photo.UserID != viewerID {
+			photo.Latitude = nil
+			photo.Longitude = nil
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// MaxMapZoom is the deepest zoom level accepted for server-side clustering
+const MaxMapZoom = 20
+
+// GeoJSONFeatureCollection is a GeoJSON FeatureCollection
+type GeoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []GeoJSONFeature `json:"features"`
+}
+
+// GeoJSONFeature is a GeoJSON Feature with a Point geometry
+type GeoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   GeoJSONPoint           `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// GeoJSONPoint is a GeoJSON Point geometry ([longitude, latitude])
+type GeoJSONPoint struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+// newPointFeature creates a point feature at the given coordinates
+func newPointFeature(lat, lon float64, properties map[string]interface{}) GeoJSONFeature {
+	return GeoJSONFeature{
+		Type: "Feature",
+		Geometry: GeoJSONPoint{
+			Type:        "Point",
+			Coordinates: [2]float64{lon, lat},
+		},
+		Properties: properties,
+	}
+}
+
+// photoFeature converts a geotagged photo to a GeoJSON feature
+func photoFeature(photo *Photo) GeoJSONFeature {
+	return newPointFeature(*photo.Latitude, *photo.Longitude, map[string]interface{}{
+		"photo_id":      photo.ID,
+		"filename":      photo.Filename,
+		"username":      photo.Username,
+		"uploaded_at":   photo.UploadedAt,
+		"thumbnail_url": photo.ThumbnailURL,
+		"original_url":  photo.OriginalURL,
+	})
+}
+
+// clusterPhotosByGrid groups photos into grid cells sized for the given zoom level
+// Cells with a single photo are returned as plain photo features
+func clusterPhotosByGrid(photos []*Photo, zoom int) []GeoJSONFeature {
+	// Same tiling as web maps: the world is 2^zoom cells wide
+	cellSize := 360.0 / math.Pow(2, float64(zoom))
+
+	type cellKey struct{ x, y int }
+	cells := make(map[cellKey][]*Photo)
+	order := make([]cellKey, 0)
+
+	for _, photo := range photos {
+		key := cellKey{
+			x: int(math.Floor((*photo.Longitude + 180) / cellSize)),
+			y: int(math.Floor((*photo.Latitude + 90) / cellSize)),
+		}
+		if _, exists := cells[key]; !exists {
+			order = append(order, key)
+		}
+		cells[key] = append(cells[key], photo)
+	}
+
+	features := make([]GeoJSONFeature, 0, len(order))
+	for _, key := range order {
+		members := cells[key]
+		if len(members) == 1 {
+			features = append(features, photoFeature(members[0]))
+			continue
+		}
+
+		// Place the cluster at the centroid of its members
+		var sumLat, sumLon float64
+		photoIDs := make([]int64, len(members))
+		for i, photo := range members {
+			sumLat += *photo.Latitude
+			sumLon += *photo.Longitude
+			photoIDs[i] = photo.ID
+		}
+		n := float64(len(members))
+
+		features = append(features, newPointFeature(sumLat/n, sumLon/n, map[string]interface{}{
+			"cluster":       true,
+			"point_count":   len(members),
+			"photo_ids":     photoIDs,
+			"thumbnail_url": members[0].ThumbnailURL, // most recent photo as preview
+		}))
+	}
+
+	return features
+}
+
+// HandlePhotoMap returns geotagged photos as GeoJSON
+// Query params:
+//   - scope: "my" (default), "shared" (the caller's family area) or "all" (admin only)
+//   - zoom: optional 0-20, clusters photos server-side into a grid for that zoom level
+func (app *App) HandlePhotoMap(w http.ResponseWriter, r *http.Request) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var photos []*Photo
+	switch r.URL.Query().Get("scope") {
+	case "", "my":
+		photos, err = app.db.GetGeotaggedPhotos(session.UserID, false, 0)
+	case "shared":
+		photos, err = app.db.GetGeotaggedPhotos(0, true, session.GroupID)
+	case "all":
+		if !session.IsAdmin() {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		photos, err = app.db.GetGeotaggedPhotos(0, false, 0)
+	default:
+		http.Error(w, "Invalid scope", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to list photos", http.StatusInternalServerError)
+		return
+	}
+
+	// Other people's photos have no location to show when it is stripped
+	app.hideLocationsFor(session.UserID, photos...)
+	located := photos[:0]
+	for _, photo := range photos {
+		if photo.Latitude != nil && photo.Longitude != nil {
+			app.photoMgr.BuildPhotoURLs(photo)
+			located = append(located, photo)
+		}
+	}
+	photos = located
+
+	collection := GeoJSONFeatureCollection{
+		Type:     "FeatureCollection",
+		Features: make([]GeoJSONFeature, 0, len(photos)),
+	}
+
+	if zoomStr := r.URL.Query().Get("zoom"); zoomStr != "" {
+		zoom, err := strconv.Atoi(zoomStr)
+		if err != nil || zoom < 0 || zoom > MaxMapZoom {
+			http.Error(w, "Invalid zoom level", http.StatusBadRequest)
+			return
+		}
+		collection.Features = clusterPhotosByGrid(photos, zoom)
+	} else {
+		for _, photo := range photos {
+			collection.Features = append(collection.Features, photoFeature(photo))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	json.NewEncoder(w).Encode(collection)
+}
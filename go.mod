@@ -6,6 +6,5 @@ require (
 	github.com/disintegration/imaging v1.6.2
 	github.com/mattn/go-sqlite3 v1.14.32
 	golang.org/x/crypto v0.45.0
+	golang.org/x/image v0.0.0-20191009234506-e7c1f5e7dbb8
 )
-
-require golang.org/x/image v0.0.0-20191009234506-e7c1f5e7dbb8 // indirect
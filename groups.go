@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// HandleAPIListGroups returns all groups with their members (admin only)
+func (app *App) HandleAPIListGroups(w http.ResponseWriter, r *http.Request) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !session.IsAdmin() {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	groups, err := app.db.GetGroups()
+	if err != nil {
+		http.Error(w, "Failed to get groups", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(groups)
+}
+
+// HandleAPICreateGroup creates a group (admin only)
+// Body: {"name": "The Smiths"}
+func (app *App) HandleAPICreateGroup(w http.ResponseWriter, r *http.Request) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !session.IsAdmin() {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if err := app.sessionMgr.ValidateCSRF(r, session); err != nil {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	// Limit request body size
+	r.Body = http.MaxBytesReader(w, r.Body, SmallJSONBodyBytes)
+
+	var body struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	name := strings.TrimSpace(body.Name)
+	if name == "" {
+		http.Error(w, "Group name is required", http.StatusBadRequest)
+		return
+	}
+	if utf8.RuneCountInString(name) > MaxGroupNameLength {
+		http.Error(w, fmt.Sprintf("Group name must be at most %d characters", MaxGroupNameLength), http.StatusBadRequest)
+		return
+	}
+
+	group, err := app.db.CreateGroup(name)
+	if errors.Is(err, errGroupExists) {
+		http.Error(w, "A group with that name already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to create group", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Admin %s created group %q (ID %d)", session.Username, group.Name, group.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"group":  group,
+	})
+}
+
+// HandleAPIDeleteGroup deletes a group (admin only)
+// Its members and their shared photos move to the ungrouped family area
+func (app *App) HandleAPIDeleteGroup(w http.ResponseWriter, r *http.Request) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !session.IsAdmin() {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if err := app.sessionMgr.ValidateCSRF(r, session); err != nil {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	groupID, err := strconv.ParseInt(r.PathValue("groupID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid group ID", http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := app.db.DeleteGroup(groupID)
+	if err != nil {
+		http.Error(w, "Failed to delete group", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.NotFound(w, r)
+		return
+	}
+
+	app.sessionMgr.ClearGroup(groupID)
+
+	log.Printf("Admin %s deleted group %d", session.Username, groupID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "Group deleted",
+	})
+}
+
+// HandleAPISetUserGroup moves a user into a group (admin only)
+// Body: {"group_id": 3}; 0 or null removes the user from their group.
+// The user's shared photos move along with them
+func (app *App) HandleAPISetUserGroup(w http.ResponseWriter, r *http.Request) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !session.IsAdmin() {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if err := app.sessionMgr.ValidateCSRF(r, session); err != nil {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	userID, err := strconv.ParseInt(r.PathValue("userID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	// Limit request body size
+	r.Body = http.MaxBytesReader(w, r.Body, SmallJSONBodyBytes)
+
+	var body struct {
+		GroupID int64 `json:"group_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	user, err := app.db.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if body.GroupID != 0 {
+		exists, err := app.db.GroupExists(body.GroupID)
+		if err != nil {
+			http.Error(w, "Failed to get group", http.StatusInternalServerError)
+			return
+		}
+		if !exists {
+			http.Error(w, "Group not found", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := app.db.SetUserGroup(userID, body.GroupID); err != nil {
+		http.Error(w, "Failed to update group", http.StatusInternalServerError)
+		return
+	}
+
+	app.sessionMgr.SetUserGroup(userID, body.GroupID)
+
+	log.Printf("Admin %s moved user %s to group %d", session.Username, user.Username, body.GroupID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "Group updated",
+	})
+}
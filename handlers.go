@@ -1,22 +1,34 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"html/template"
+	"io"
 	"io/fs"
 	"log"
+	"math"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 )
 
 // App holds the application state
 type App struct {
-	config     *Config
-	db         *Database
-	sessionMgr *SessionManager
-	photoMgr   *PhotoManager
-	templates  *template.Template
+	config       *Config
+	db           *Database
+	sessionMgr   *SessionManager
+	photoMgr     *PhotoManager
+	autoArchiver *AutoArchiver
+	backuper     *Backuper
+	counter      *AccessCounter
+	aiLimiter    *aiLimiter
+	autoEmbedder *AutoEmbedder
+	templates    *template.Template
 }
 
 // HandleLogin shows the login page or processes login
@@ -27,8 +39,16 @@ func (app *App) HandleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Nobody can log in before setup has created the first account
+	if app.setupPending() {
+		http.Redirect(w, r, "/setup", http.StatusSeeOther)
+		return
+	}
+
 	if r.Method == http.MethodGet {
-		if err := app.templates.ExecuteTemplate(w, "login.html", nil); err != nil {
+		if err := app.templates.ExecuteTemplate(w, "login.html", map[string]interface{}{
+			"AllowRegistration": app.registrationOpen(),
+		}); err != nil {
 			log.Printf("Template error: %v", err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 		}
@@ -36,6 +56,7 @@ func (app *App) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if r.Method == http.MethodPost {
+		r.Body = http.MaxBytesReader(w, r.Body, MaxFormBodyBytes)
 		if err := r.ParseForm(); err != nil {
 			http.Error(w, "Invalid form data", http.StatusBadRequest)
 			return
@@ -45,8 +66,14 @@ func (app *App) HandleLogin(w http.ResponseWriter, r *http.Request) {
 		password := r.FormValue("password")
 
 		if err := app.sessionMgr.Login(w, r, username, password); err != nil {
-			if tmplErr := app.templates.ExecuteTemplate(w, "login.html", map[string]string{
-				"Error": err.Error(),
+			// The form still shows the message; clients can see the lockout in the status
+			if status := loginFailureStatus(w, err, http.StatusOK); status != http.StatusOK {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				w.WriteHeader(status)
+			}
+			if tmplErr := app.templates.ExecuteTemplate(w, "login.html", map[string]interface{}{
+				"Error":             err.Error(),
+				"AllowRegistration": app.registrationOpen(),
 			}); tmplErr != nil {
 				log.Printf("Template error: %v", tmplErr)
 				http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -61,6 +88,27 @@ func (app *App) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 }
 
+// loginFailureStatus picks the status for a failed login: 429 with a Retry-After
+// header (whole seconds, rounded up) during a brute-force lockout, otherwise status
+func loginFailureStatus(w http.ResponseWriter, err error, status int) int {
+	var lockout *LockoutError
+	if !errors.As(err, &lockout) {
+		return status
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(lockout.Remaining.Seconds()))))
+	return http.StatusTooManyRequests
+}
+
+// registrationOpen reports whether new accounts can be created
+// The first account can always be created so a fresh instance can get an admin
+func (app *App) registrationOpen() bool {
+	if app.config.AllowRegistration {
+		return true
+	}
+	count, err := app.db.CountUsers()
+	return err == nil && count == 0
+}
+
 // HandleRegister shows the registration page or processes registration
 func (app *App) HandleRegister(w http.ResponseWriter, r *http.Request) {
 	// If already logged in, redirect to gallery
@@ -69,8 +117,22 @@ func (app *App) HandleRegister(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The first account comes from setup, not from whoever registers first
+	if app.setupPending() {
+		http.Redirect(w, r, "/setup", http.StatusSeeOther)
+		return
+	}
+
+	// With open registration closed, only invite code holders can sign up
+	inviteOnly := !app.registrationOpen()
+
 	if r.Method == http.MethodGet {
-		if err := app.templates.ExecuteTemplate(w, "register.html", nil); err != nil {
+		count, countErr := app.db.CountUsers()
+		if err := app.templates.ExecuteTemplate(w, "register.html", map[string]interface{}{
+			"InviteOnly":     inviteOnly,
+			"InviteCode":     r.URL.Query().Get("invite"),
+			"FirstUserAdmin": countErr == nil && count == 0,
+		}); err != nil {
 			log.Printf("Template error: %v", err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 		}
@@ -78,6 +140,7 @@ func (app *App) HandleRegister(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if r.Method == http.MethodPost {
+		r.Body = http.MaxBytesReader(w, r.Body, MaxFormBodyBytes)
 		if err := r.ParseForm(); err != nil {
 			http.Error(w, "Invalid form data", http.StatusBadRequest)
 			return
@@ -86,10 +149,13 @@ func (app *App) HandleRegister(w http.ResponseWriter, r *http.Request) {
 		username := r.FormValue("username")
 		password := r.FormValue("password")
 		confirmPassword := r.FormValue("confirm_password")
+		inviteCode := r.FormValue("invite_code")
 
 		if password != confirmPassword {
-			if tmplErr := app.templates.ExecuteTemplate(w, "register.html", map[string]string{
-				"Error": "Passwords do not match",
+			if tmplErr := app.templates.ExecuteTemplate(w, "register.html", map[string]interface{}{
+				"Error":      "Passwords do not match",
+				"InviteOnly": inviteOnly,
+				"InviteCode": inviteCode,
 			}); tmplErr != nil {
 				log.Printf("Template error: %v", tmplErr)
 				http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -97,10 +163,21 @@ func (app *App) HandleRegister(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		user, err := app.sessionMgr.Register(username, password)
+		var user *User
+		var err error
+		if inviteOnly {
+			user, err = app.sessionMgr.RegisterWithInvite(username, password, inviteCode)
+		} else {
+			user, err = app.sessionMgr.Register(username, password)
+		}
 		if err != nil {
-			if tmplErr := app.templates.ExecuteTemplate(w, "register.html", map[string]string{
-				"Error": err.Error(),
+			if inviteOnly && err == errInvalidInvite {
+				w.WriteHeader(http.StatusForbidden)
+			}
+			if tmplErr := app.templates.ExecuteTemplate(w, "register.html", map[string]interface{}{
+				"Error":      err.Error(),
+				"InviteOnly": inviteOnly,
+				"InviteCode": inviteCode,
 			}); tmplErr != nil {
 				log.Printf("Template error: %v", tmplErr)
 				http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -123,25 +200,158 @@ func (app *App) HandleRegister(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 }
 
+// setupPending reports whether first-run setup is enabled and still needed (no accounts yet)
+func (app *App) setupPending() bool {
+	if !app.config.FirstRunSetup {
+		return false
+	}
+	count, err := app.db.CountUsers()
+	return err == nil && count == 0
+}
+
+// HandleSetup shows the first-run page or creates the admin account from it
+// Only available until the first account exists; afterwards it redirects home
+func (app *App) HandleSetup(w http.ResponseWriter, r *http.Request) {
+	if !app.setupPending() {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		if err := app.templates.ExecuteTemplate(w, "setup.html", map[string]interface{}{
+			"DefaultShared": app.config.DefaultVisibility == VisibilityShared,
+		}); err != nil {
+			log.Printf("Template error: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, MaxFormBodyBytes)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	username := r.FormValue("username")
+	password := r.FormValue("password")
+	defaultShared := r.FormValue("default_shared") == "1"
+
+	renderError := func(message string) {
+		if tmplErr := app.templates.ExecuteTemplate(w, "setup.html", map[string]interface{}{
+			"Error":         message,
+			"Username":      username,
+			"DefaultShared": defaultShared,
+		}); tmplErr != nil {
+			log.Printf("Template error: %v", tmplErr)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	}
+
+	if password != r.FormValue("confirm_password") {
+		renderError("Passwords do not match")
+		return
+	}
+
+	user, err := app.sessionMgr.SetupAdmin(username, password)
+	if err == errSetupDone {
+		// Someone else finished setup first
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		renderError(err.Error())
+		return
+	}
+
+	log.Printf("First-run setup created admin '%s'", user.Username)
+
+	// Initial preferences (best effort; they can be changed later)
+	if defaultShared {
+		settings := &UserSettings{UserID: user.ID, DefaultShared: true}
+		if err := app.db.UpdateUserSettings(settings); err != nil {
+			log.Printf("Failed to save initial settings for %s: %v", user.Username, err)
+		}
+	}
+
+	app.sessionMgr.Login(w, r, username, password)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
 // HandleLogout logs out the user
 func (app *App) HandleLogout(w http.ResponseWriter, r *http.Request) {
 	app.sessionMgr.Logout(w, r)
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
 }
 
+// HandleIssueToken exchanges credentials for a bearer token (for SPAs and mobile apps)
+func (app *App) HandleIssueToken(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, MaxFormBodyBytes)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	session, err := app.sessionMgr.IssueToken(r, req.Username, req.Password)
+	if err != nil {
+		http.Error(w, err.Error(), loginFailureStatus(w, err, http.StatusUnauthorized))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":     "success",
+		"token":      session.Token,
+		"token_type": "Bearer",
+		"expires_at": session.ExpiresAt.UTC().Format(time.RFC3339),
+	})
+}
+
+// HandleRevokeToken ends the session behind the presented bearer token
+func (app *App) HandleRevokeToken(w http.ResponseWriter, r *http.Request) {
+	if bearerToken(r) == "" {
+		http.Error(w, "Bearer token required", http.StatusBadRequest)
+		return
+	}
+
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	app.sessionMgr.RevokeToken(session)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "Token revoked",
+	})
+}
+
 // HandleGallery shows the gallery page
 func (app *App) HandleGallery(w http.ResponseWriter, r *http.Request) {
 	session, err := app.sessionMgr.ValidateSession(r)
 	if err != nil {
+		if app.setupPending() {
+			http.Redirect(w, r, "/setup", http.StatusSeeOther)
+			return
+		}
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
 
 	if err := app.templates.ExecuteTemplate(w, "gallery.html", map[string]interface{}{
-		"CSRFToken": session.CSRFToken,
-		"Username":  session.Username,
-		"IsAdmin":   session.IsAdmin(),
-		"UserID":    session.UserID,
+		"CSRFToken":    session.CSRFToken,
+		"Username":     session.Username,
+		"IsAdmin":      session.IsAdmin(),
+		"UserID":       session.UserID,
+		"Impersonator": session.ImpersonatorName,
 	}); err != nil {
 		log.Printf("Template error: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -280,7 +490,7 @@ func (app *App) HandleAPIUpdateUserRole(w http.ResponseWriter, r *http.Request)
 		Role string `json:"role"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeBodyError(w, err)
 		return
 	}
 
@@ -307,6 +517,95 @@ func (app *App) HandleAPIUpdateUserRole(w http.ResponseWriter, r *http.Request)
 	})
 }
 
+// HandleAPIImpersonateUser switches the admin's browser to a session acting as another user (admin only)
+func (app *App) HandleAPIImpersonateUser(w http.ResponseWriter, r *http.Request) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !session.IsAdmin() {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if err := app.sessionMgr.ValidateCSRF(r, session); err != nil {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	userIDStr := r.PathValue("userID")
+	userID, err := strconv.ParseInt(userIDStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if userID == session.UserID {
+		http.Error(w, "Cannot impersonate yourself", http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.db.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	// Acting as another admin would hand out their privileges under a different audit trail
+	if user.Role == "admin" {
+		http.Error(w, "Cannot impersonate another admin", http.StatusBadRequest)
+		return
+	}
+
+	if err := app.sessionMgr.Impersonate(w, r, session, user); err != nil {
+		log.Printf("Impersonation of user %d by %s failed: %v", userID, session.Username, err)
+		http.Error(w, "Failed to impersonate user", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": fmt.Sprintf("Now acting as %s", user.Username),
+	})
+}
+
+// HandleStopImpersonating ends impersonation and restores the admin's own session
+func (app *App) HandleStopImpersonating(w http.ResponseWriter, r *http.Request) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := app.sessionMgr.ValidateCSRF(r, session); err != nil {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	if !session.IsImpersonating() {
+		http.Error(w, "Not impersonating", http.StatusBadRequest)
+		return
+	}
+
+	if err := app.sessionMgr.StopImpersonating(w, r, session); err != nil {
+		http.Error(w, "Admin session expired, please log in again", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "Stopped impersonating",
+	})
+}
+
 // HandleAPIGetStats returns system stats (admin only)
 func (app *App) HandleAPIGetStats(w http.ResponseWriter, r *http.Request) {
 	session, err := app.sessionMgr.ValidateSession(r)
@@ -323,10 +622,329 @@ func (app *App) HandleAPIGetStats(w http.ResponseWriter, r *http.Request) {
 	users, _ := app.db.GetAllUsers()
 	totalPhotos, _ := app.db.GetTotalPhotoCount()
 
+	perUser, err := app.db.GetStorageStatsByUser()
+	if err != nil {
+		http.Error(w, "Failed to get storage stats", http.StatusInternalServerError)
+		return
+	}
+
+	var sharedPhotos, archivedPhotos int
+	var totalBytes int64
+	for _, s := range perUser {
+		sharedPhotos += s.SharedPhotos
+		archivedPhotos += s.ArchivedPhotos
+		totalBytes += s.Bytes
+	}
+
+	var averagePhotoBytes int64
+	if totalPhotos > 0 {
+		averagePhotoBytes = totalBytes / int64(totalPhotos)
+	}
+
+	stats := map[string]interface{}{
+		"total_users":         len(users),
+		"total_photos":        totalPhotos,
+		"shared_photos":       sharedPhotos,
+		"archived_photos":     archivedPhotos,
+		"total_bytes":         totalBytes,
+		"average_photo_bytes": averagePhotoBytes,
+		"users":               perUser,
+	}
+
+	stats["active_sessions"], stats["login_attempts_tracked"] = app.sessionMgr.Counts()
+
+	// Actual usage can differ from recorded sizes (thumbnails, variants, re-encoding, orphans)
+	diskPerUser, diskTotal, err := app.photoMgr.DiskUsage()
+	if err != nil {
+		log.Printf("Skipping disk usage in admin stats: %v", err)
+	} else {
+		stats["disk_bytes"] = diskTotal
+		for _, s := range perUser {
+			bytes := diskPerUser[s.UserID]
+			s.DiskBytes = &bytes
+		}
+	}
+
+	free, total, err := app.photoMgr.DiskSpace()
+	if err != nil {
+		log.Printf("Skipping free space in admin stats: %v", err)
+	} else {
+		low := app.config.LowDiskWarningMB > 0 && free < uint64(app.config.LowDiskWarningMB)<<20
+		stats["disk_free_bytes"] = free
+		stats["disk_total_bytes"] = total
+		stats["disk_low"] = low
+		if low {
+			stats["storage_warning"] = fmt.Sprintf("Only %d MB free on the storage volume; uploads will fail when it fills up", free>>20)
+			log.Printf("Warning: only %d MB free on the storage volume (low_disk_warning_mb is %d)", free>>20, app.config.LowDiskWarningMB)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// HandleAPIRebuildBlurhashes recomputes blurhash placeholders from thumbnails
+// By default only photos without a blurhash are processed; ?force=1 rebuilds all
+func (app *App) HandleAPIRebuildBlurhashes(w http.ResponseWriter, r *http.Request) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !session.IsAdmin() {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if err := app.sessionMgr.ValidateCSRF(r, session); err != nil {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	force := r.URL.Query().Get("force") == "1"
+
+	updated, failed, err := app.photoMgr.RebuildBlurhashes(force)
+	if err != nil {
+		http.Error(w, "Failed to rebuild blurhashes", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Admin %s rebuilt blurhashes: %d updated, %d failed", session.Username, updated, failed)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "success",
+		"message": fmt.Sprintf("Rebuilt %d blurhash(es)", updated),
+		"updated": updated,
+		"failed":  failed,
+	})
+}
+
+// HandleAPIListInvites lists invite codes and who redeemed them (admin only)
+func (app *App) HandleAPIListInvites(w http.ResponseWriter, r *http.Request) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !session.IsAdmin() {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	invites, err := app.db.GetInvites()
+	if err != nil {
+		http.Error(w, "Failed to get invites", http.StatusInternalServerError)
+		return
+	}
+
+	type InviteWithStatus struct {
+		*Invite
+		Usable bool `json:"usable"`
+	}
+
+	result := make([]InviteWithStatus, len(invites))
+	for i, invite := range invites {
+		result[i] = InviteWithStatus{Invite: invite, Usable: invite.Usable()}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// HandleAPICreateInvite generates an invite code (admin only)
+// Body: {"max_uses": 1, "expires_in_hours": 72}; max_uses 0 is unlimited,
+// expires_in_hours 0 never expires. Defaults to a single use and no expiry
+func (app *App) HandleAPICreateInvite(w http.ResponseWriter, r *http.Request) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !session.IsAdmin() {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if err := app.sessionMgr.ValidateCSRF(r, session); err != nil {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	// Limit request body size
+	r.Body = http.MaxBytesReader(w, r.Body, SmallJSONBodyBytes)
+
+	body := struct {
+		MaxUses        int `json:"max_uses"`
+		ExpiresInHours int `json:"expires_in_hours"`
+	}{MaxUses: 1}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+		writeBodyError(w, err)
+		return
+	}
+
+	if body.MaxUses < 0 {
+		http.Error(w, "max_uses cannot be negative", http.StatusBadRequest)
+		return
+	}
+	if body.ExpiresInHours < 0 {
+		http.Error(w, "expires_in_hours cannot be negative", http.StatusBadRequest)
+		return
+	}
+
+	var expiresAt *time.Time
+	if body.ExpiresInHours > 0 {
+		t := time.Now().Add(time.Duration(body.ExpiresInHours) * time.Hour)
+		expiresAt = &t
+	}
+
+	code, err := generateInviteCode(InviteCodeLength)
+	if err != nil {
+		http.Error(w, "Failed to generate invite code", http.StatusInternalServerError)
+		return
+	}
+
+	if err := app.db.CreateInvite(code, session.UserID, expiresAt, body.MaxUses); err != nil {
+		http.Error(w, "Failed to create invite", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Admin %s created invite code (max uses %d, expires in %dh)", session.Username, body.MaxUses, body.ExpiresInHours)
+
+	response := map[string]interface{}{
+		"status":   "success",
+		"code":     code,
+		"max_uses": body.MaxUses,
+		"url":      "/register?invite=" + code,
+	}
+	if expiresAt != nil {
+		response["expires_at"] = expiresAt.UTC().Format(time.RFC3339)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// HandleAPIDeleteInvite revokes an invite code (admin only)
+func (app *App) HandleAPIDeleteInvite(w http.ResponseWriter, r *http.Request) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !session.IsAdmin() {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if err := app.sessionMgr.ValidateCSRF(r, session); err != nil {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	deleted, err := app.db.DeleteInvite(r.PathValue("code"))
+	if err != nil {
+		http.Error(w, "Failed to delete invite", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "Invite revoked",
+	})
+}
+
+// HandleAPIListLoginAttempts pages through failed logins held in memory, most recent first (admin only)
+// Shows which IPs are guessing, the usernames they tried and whether they're locked out.
+// Query params: limit, offset, ip (prefix), username (any tried, case-insensitive), locked=1
+func (app *App) HandleAPIListLoginAttempts(w http.ResponseWriter, r *http.Request) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !session.IsAdmin() {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	limit, offset, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	ipPrefix := query.Get("ip")
+	username := query.Get("username")
+	lockedOnly := query.Get("locked") == "1"
+
+	matches := []LoginAttemptInfo{}
+	for _, attempt := range app.sessionMgr.LoginAttempts() {
+		if !strings.HasPrefix(attempt.IP, ipPrefix) {
+			continue
+		}
+		if lockedOnly && !attempt.Locked {
+			continue
+		}
+		if username != "" && !slices.ContainsFunc(attempt.Usernames, func(u string) bool { return strings.EqualFold(u, username) }) {
+			continue
+		}
+		matches = append(matches, attempt)
+	}
+
+	total := len(matches)
+	page := matches[min(offset, total):min(offset+limit, total)]
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"total_users":  len(users),
-		"total_photos": totalPhotos,
+		"attempts": page,
+		"total":    total,
+		"limit":    limit,
+		"offset":   offset,
+		"has_more": offset+len(page) < total,
+	})
+}
+
+// HandleAPIClearLoginAttempts forgets an IP's failed logins, lifting its lockout (admin only)
+func (app *App) HandleAPIClearLoginAttempts(w http.ResponseWriter, r *http.Request) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !session.IsAdmin() {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if err := app.sessionMgr.ValidateCSRF(r, session); err != nil {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	ip := r.PathValue("ip")
+	if !app.sessionMgr.ClearLoginAttempts(ip) {
+		http.NotFound(w, r)
+		return
+	}
+
+	log.Printf("Admin %s cleared failed login attempts for %s", session.Username, ip)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "Login attempts cleared",
 	})
 }
 
@@ -343,15 +961,120 @@ func securityHeadersMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// corsMiddleware lets browser clients on the listed origins call the API
+// Allowed origins are echoed back (never "*") so credentialed requests work;
+// requests from any other origin get no CORS headers and stay same-origin only
+func corsMiddleware(allowedOrigins []string, next http.Handler) http.Handler {
+	allowed := make(map[string]bool, len(allowedOrigins))
+	for _, origin := range allowedOrigins {
+		allowed[strings.TrimSuffix(origin, "/")] = true
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		w.Header().Add("Vary", "Origin")
+		if origin == "" || !allowed[origin] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		w.Header().Set("Access-Control-Expose-Headers", "Content-Disposition, Content-Length")
+
+		// Answer preflight requests here; the router has no OPTIONS routes
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-CSRF-Token")
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(CORSMaxAgeSeconds))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 // loggingMiddleware logs HTTP requests
-func loggingMiddleware(next http.Handler) http.Handler {
+// With logBodies, JSON bodies of /api/ requests are logged too (see requestBodyForLog)
+func loggingMiddleware(next http.Handler, logBodies bool) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
+
+		body := ""
+		if logBodies && strings.HasPrefix(r.URL.Path, "/api/") {
+			body = requestBodyForLog(r)
+		}
+
 		next.ServeHTTP(w, r)
+
+		if body != "" {
+			log.Printf("%s %s %s body=%s", r.Method, r.URL.Path, time.Since(start), body)
+			return
+		}
 		log.Printf("%s %s %s", r.Method, r.URL.Path, time.Since(start))
 	})
 }
 
+// sensitiveLogFields are substrings of JSON keys whose values are never logged
+var sensitiveLogFields = []string{"password", "api_key", "apikey", "token", "secret"}
+
+// requestBodyForLog reads a JSON request body for the debug log and puts it
+// back for the handler. Secret fields are redacted at any depth and the result
+// is cut to LogBodyBytes. Bodies that aren't JSON, or are too large or malformed
+// to redact reliably, are described rather than logged. Returns "" for no body
+func requestBodyForLog(r *http.Request) string {
+	if r.Body == nil || r.Body == http.NoBody || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		return ""
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, MaxJSONBodyBytes+1))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(data), r.Body), r.Body}
+	if err != nil || len(data) == 0 {
+		return ""
+	}
+	if len(data) > MaxJSONBodyBytes {
+		return "(too large to log)"
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Sprintf("(invalid JSON, %d bytes)", len(data))
+	}
+
+	redacted, err := json.Marshal(redactLogValue(value))
+	if err != nil {
+		return fmt.Sprintf("(%d bytes)", len(data))
+	}
+	if len(redacted) > LogBodyBytes {
+		return string(redacted[:LogBodyBytes]) + "...(truncated)"
+	}
+	return string(redacted)
+}
+
+// redactLogValue replaces the values of sensitive keys in decoded JSON
+func redactLogValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			lower := strings.ToLower(key)
+			if slices.ContainsFunc(sensitiveLogFields, func(s string) bool { return strings.Contains(lower, s) }) {
+				v[key] = "[redacted]"
+			} else {
+				v[key] = redactLogValue(field)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactLogValue(item)
+		}
+	}
+	return value
+}
+
 // SetupRoutes configures all HTTP routes
 func (app *App) SetupRoutes() http.Handler {
 	mux := http.NewServeMux()
@@ -360,8 +1083,13 @@ func (app *App) SetupRoutes() http.Handler {
 	mux.HandleFunc("GET /login", app.HandleLogin)
 	mux.HandleFunc("POST /login", app.HandleLogin)
 	mux.HandleFunc("GET /register", app.HandleRegister)
+	mux.HandleFunc("GET /setup", app.HandleSetup)
+	mux.HandleFunc("POST /setup", app.HandleSetup)
 	mux.HandleFunc("POST /register", app.HandleRegister)
 	mux.HandleFunc("GET /logout", app.HandleLogout)
+	mux.HandleFunc("POST /api/auth/token", app.HandleIssueToken)
+	mux.HandleFunc("DELETE /api/auth/token", app.HandleRevokeToken)
+	mux.HandleFunc("GET /api/public/{token}", app.HandleGetPublicPhoto)
 
 	// Protected routes
 	mux.HandleFunc("GET /", app.HandleGallery)
@@ -371,11 +1099,33 @@ func (app *App) SetupRoutes() http.Handler {
 	mux.HandleFunc("POST /api/photos/upload", app.HandleUpload)
 	mux.HandleFunc("GET /api/photos/my", app.HandleListMyPhotos)
 	mux.HandleFunc("GET /api/photos/shared", app.HandleListSharedPhotos)
+	mux.HandleFunc("GET /api/photos/my/count", app.HandleCountMyPhotos)
+	mux.HandleFunc("GET /api/photos/shared/count", app.HandleCountSharedPhotos)
+	mux.HandleFunc("GET /api/photos/archived/count", app.HandleCountArchivedPhotos)
 	mux.HandleFunc("GET /api/photos/all", app.HandleListAllPhotos)
+	mux.HandleFunc("GET /api/photos/recent", app.HandleListRecentPhotos)
+	mux.HandleFunc("GET /api/photos/changed-since", app.HandleListChangedPhotos)
+	mux.HandleFunc("GET /api/photos/map", app.HandlePhotoMap)
+	mux.HandleFunc("GET /api/photos/duplicates/perceptual", app.HandleFindPerceptualDuplicates)
+	mux.HandleFunc("GET /api/photos/by-hash", app.HandleGetPhotoByHash)
+	mux.HandleFunc("GET /api/photos/exists", app.HandlePhotoExists)
 	mux.HandleFunc("GET /api/photos/original/{userID}/{filename}", app.HandleGetOriginal)
 	mux.HandleFunc("GET /api/photos/thumbnail/{userID}/{filename}", app.HandleGetThumbnail)
+	mux.HandleFunc("GET /api/photos/{photoID}/original", app.HandleGetOriginalByID)
+	mux.HandleFunc("GET /api/photos/{photoID}/thumbnail", app.HandleGetThumbnailByID)
+	mux.HandleFunc("POST /api/photos/thumbnails/repair", app.HandleRepairThumbnails)
+	mux.HandleFunc("GET /api/photos/{photoID}", app.HandleGetPhoto)
 	mux.HandleFunc("DELETE /api/photos/{photoID}", app.HandleDeletePhoto)
 	mux.HandleFunc("POST /api/photos/{photoID}/share", app.HandleSharePhoto)
+	mux.HandleFunc("POST /api/photos/{photoID}/view", app.HandleRecordView)
+	mux.HandleFunc("POST /api/photos/{photoID}/reembed", app.HandleReembedPhoto)
+	mux.HandleFunc("POST /api/photos/{photoID}/public-link", app.HandleCreatePublicLink)
+	mux.HandleFunc("GET /api/photos/{photoID}/public-links", app.HandleListPublicLinks)
+	mux.HandleFunc("DELETE /api/photos/{photoID}/public-links/{linkID}", app.HandleDeletePublicLink)
+	mux.HandleFunc("PATCH /api/photos/{photoID}/date", app.HandleSetPhotoDate)
+	mux.HandleFunc("PATCH /api/photos/{photoID}/caption", app.HandleSetPhotoCaption)
+	mux.HandleFunc("GET /api/photos/{photoID}/comments", app.HandleListComments)
+	mux.HandleFunc("POST /api/photos/{photoID}/comments", app.HandleAddComment)
 
 	// Bulk operations
 	mux.HandleFunc("POST /api/photos/bulk/share", app.HandleBulkShare)
@@ -388,17 +1138,40 @@ func (app *App) SetupRoutes() http.Handler {
 	mux.HandleFunc("GET /api/photos/archived", app.HandleListArchivedPhotos)
 	mux.HandleFunc("POST /api/photos/bulk/archive", app.HandleBulkArchive)
 
+	// Account settings
+	mux.HandleFunc("GET /api/account/auto-archive", app.HandleGetAutoArchive)
+	mux.HandleFunc("PUT /api/account/auto-archive", app.HandleUpdateAutoArchive)
+	mux.HandleFunc("GET /api/account/settings", app.HandleGetSettings)
+	mux.HandleFunc("PUT /api/account/settings", app.HandleUpdateSettings)
+
 	// Photo Selector / AI Features
 	mux.HandleFunc("GET /api/organize/status", app.HandleOrganizeStatus)
 	mux.HandleFunc("POST /api/organize/generate-embeddings", app.HandleGenerateEmbeddings)
+	mux.HandleFunc("DELETE /api/organize/embeddings", app.HandleDeleteEmbeddings)
 	mux.HandleFunc("POST /api/organize/find-groups", app.HandleFindGroups)
 	mux.HandleFunc("POST /api/organize/analyze-group", app.HandleAnalyzeGroup)
+	mux.HandleFunc("POST /api/photos/compare", app.HandleComparePhotos)
 
 	// Admin API routes
 	mux.HandleFunc("GET /api/admin/users", app.HandleAPIGetUsers)
 	mux.HandleFunc("DELETE /api/admin/users/{userID}", app.HandleAPIDeleteUser)
 	mux.HandleFunc("PUT /api/admin/users/{userID}/role", app.HandleAPIUpdateUserRole)
+	mux.HandleFunc("PUT /api/admin/users/{userID}/group", app.HandleAPISetUserGroup)
+	mux.HandleFunc("GET /api/admin/users/{userID}/photos", app.HandleAPIListUserPhotos)
+	mux.HandleFunc("POST /api/admin/users/{userID}/impersonate", app.HandleAPIImpersonateUser)
+	mux.HandleFunc("POST /api/impersonation/stop", app.HandleStopImpersonating)
+	mux.HandleFunc("GET /api/admin/groups", app.HandleAPIListGroups)
+	mux.HandleFunc("POST /api/admin/groups", app.HandleAPICreateGroup)
+	mux.HandleFunc("DELETE /api/admin/groups/{groupID}", app.HandleAPIDeleteGroup)
+	mux.HandleFunc("GET /api/admin/invites", app.HandleAPIListInvites)
+	mux.HandleFunc("POST /api/admin/invites", app.HandleAPICreateInvite)
+	mux.HandleFunc("DELETE /api/admin/invites/{code}", app.HandleAPIDeleteInvite)
+	mux.HandleFunc("GET /api/admin/login-attempts", app.HandleAPIListLoginAttempts)
+	mux.HandleFunc("DELETE /api/admin/login-attempts/{ip}", app.HandleAPIClearLoginAttempts)
 	mux.HandleFunc("GET /api/admin/stats", app.HandleAPIGetStats)
+	mux.HandleFunc("POST /api/admin/blurhash/rebuild", app.HandleAPIRebuildBlurhashes)
+	mux.HandleFunc("GET /api/admin/thumbnails/failures", app.HandleAPIThumbnailFailures)
+	mux.HandleFunc("POST /api/admin/backup", app.HandleAPIBackup)
 
 	// Static files
 	staticSubFS, err := fs.Sub(staticFS, "static")
@@ -410,7 +1183,11 @@ func (app *App) SetupRoutes() http.Handler {
 
 	// Apply middleware
 	handler := securityHeadersMiddleware(mux)
-	handler = loggingMiddleware(handler)
+	if app.config.CompressResponses {
+		handler = gzipMiddleware(handler)
+	}
+	handler = corsMiddleware(app.config.CORSAllowedOrigins, handler)
+	handler = loggingMiddleware(handler, app.config.LogRequestBodies)
 
 	return handler
 }
@@ -4,49 +4,82 @@ import (
 	"bytes"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"image"
 	"io"
+	"log"
+	"math"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/disintegration/imaging"
 )
 
 // LLMProvider represents the supported LLM providers
 type LLMProvider string
 
 const (
-	ProviderOpenAI  LLMProvider = "openai"
-	ProviderAzure   LLMProvider = "azure"
-	ProviderGemini  LLMProvider = "gemini"
-	ProviderCustom  LLMProvider = "custom"
+	ProviderOpenAI LLMProvider = "openai"
+	ProviderAzure  LLMProvider = "azure"
+	ProviderGemini LLMProvider = "gemini"
+	ProviderCustom LLMProvider = "custom"
 )
 
 // LLMConfig contains configuration for the LLM service
 type LLMConfig struct {
-	Provider        LLMProvider `json:"provider"`         // openai, azure, gemini, custom
-	APIKey          string      `json:"api_key"`          // API key for the provider
-	BaseURL         string      `json:"base_url"`         // Base URL (for Azure/custom)
-	Model           string      `json:"model"`            // Model name (e.g., gpt-4o, gemini-1.5-pro)
-	AzureDeployment string      `json:"azure_deployment"` // Azure deployment name
-	AzureAPIVersion string      `json:"azure_api_version"` // Azure API version
+	Provider          LLMProvider  `json:"provider"`            // openai, azure, gemini, custom
+	APIKey            string       `json:"api_key"`             // API key for the provider
+	BaseURL           string       `json:"base_url"`            // Base URL (for Azure/custom)
+	Model             string       `json:"model"`               // Model name (e.g., gpt-4o, gemini-1.5-pro)
+	AzureDeployment   string       `json:"azure_deployment"`    // Azure deployment name
+	AzureAPIVersion   string       `json:"azure_api_version"`   // Azure API version
+	PromptTemplate    string       `json:"prompt_template"`     // Custom analysis prompt (empty = built-in)
+	ScoreWeights      ScoreWeights `json:"score_weights"`       // Weights for recomputing overall_score (all zero = trust the model)
+	FallbackModel     string       `json:"fallback_model"`      // Model (Azure: deployment) to retry with if the primary call fails transiently
+	ImageMaxDimension int          `json:"image_max_dimension"` // Longest side images are shrunk to before sending (0 = send originals)
+}
+
+// LLMAlternative is a provider/model that analysis requests may pick instead
+// of the configured default. Empty api_key and base_url are taken from the
+// default when the provider is the same. For Azure, model is the deployment name
+type LLMAlternative struct {
+	Provider        LLMProvider `json:"provider"`
+	Model           string      `json:"model"`
+	APIKey          string      `json:"api_key,omitempty"`
+	BaseURL         string      `json:"base_url,omitempty"`
+	AzureAPIVersion string      `json:"azure_api_version,omitempty"`
+}
+
+// ScoreWeights weights the per-criterion scores when recomputing overall_score
+type ScoreWeights struct {
+	Sharpness   float64 `json:"sharpness"`
+	Exposure    float64 `json:"exposure"`
+	Composition float64 `json:"composition"`
+	FaceQuality float64 `json:"face_quality"`
 }
 
 // LLMClient handles communication with LLM providers
 type LLMClient struct {
 	config     LLMConfig
 	httpClient *http.Client
+	limiter    *aiLimiter // shared cap on concurrent AI calls (nil = unlimited)
 }
 
 // PhotoAnalysis represents the AI analysis of a photo
 type PhotoAnalysis struct {
-	PhotoID     int64   `json:"photo_id"`
-	Sharpness   int     `json:"sharpness"`   // 0-100
-	Exposure    int     `json:"exposure"`    // 0-100
-	Composition int     `json:"composition"` // 0-100
-	FaceQuality int     `json:"face_quality"` // 0-100
-	OverallScore int    `json:"overall_score"` // 0-100
-	Issues      []string `json:"issues"`      // List of detected issues
+	PhotoID      int64    `json:"photo_id"`
+	Sharpness    int      `json:"sharpness"`     // 0-100
+	Exposure     int      `json:"exposure"`      // 0-100
+	Composition  int      `json:"composition"`   // 0-100
+	FaceQuality  int      `json:"face_quality"`  // 0-100
+	OverallScore int      `json:"overall_score"` // 0-100
+	Issues       []string `json:"issues"`        // List of detected issues
 }
 
 // BestPhotoResult represents the result of best photo selection
@@ -54,6 +87,10 @@ type BestPhotoResult struct {
 	BestPhotoID int64           `json:"best_photo_id"`
 	Reasoning   string          `json:"reasoning"`
 	Analyses    []PhotoAnalysis `json:"analyses"`
+	Model       string          `json:"model,omitempty"` // Model (or Azure deployment) that produced the answer
+
+	// defaulted is set when the model's answer was unusable and the first photo was picked instead
+	defaulted bool
 }
 
 // NewLLMClient creates a new LLM client with the given configuration
@@ -103,6 +140,86 @@ func (c *LLMClient) SelectBestPhoto(photoPaths []string, photoIDs []int64) (*Bes
 		}, nil
 	}
 
+	model := c.activeModel()
+	result, err := c.selectBestPhoto(photoPaths, photoIDs)
+
+	// Retry once with the fallback model, but only for transient failures
+	// (timeouts, rate limits, server errors); a bad key or request would fail there too
+	if isTransientLLMError(err) && c.config.FallbackModel != "" && c.config.FallbackModel != model {
+		log.Printf("LLM model %s failed (%v), retrying with fallback model %s", model, err, c.config.FallbackModel)
+
+		primaryErr := err
+		model = c.config.FallbackModel
+		result, err = c.withModel(model).selectBestPhoto(photoPaths, photoIDs)
+		if err != nil {
+			return nil, fmt.Errorf("primary model failed: %v; fallback model %s failed: %w", primaryErr, model, err)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result.Model = model
+
+	// Recompute scores server-side if weights are configured
+	applyScoreWeights(result, c.config.ScoreWeights, photoIDs)
+
+	return result, nil
+}
+
+// ComparePhotos compares exactly two photos head-to-head
+// Uses the comparison prompt but otherwise behaves like SelectBestPhoto (fallback, weights)
+func (c *LLMClient) ComparePhotos(photoPaths []string, photoIDs []int64) (*BestPhotoResult, error) {
+	if len(photoPaths) != 2 || len(photoIDs) != 2 {
+		return nil, fmt.Errorf("comparison requires exactly two photos")
+	}
+
+	clone := *c
+	clone.config.PromptTemplate = defaultComparisonPrompt
+	return clone.SelectBestPhoto(photoPaths, photoIDs)
+}
+
+// llmAPIError is a non-200 answer from a provider's API
+type llmAPIError struct {
+	Provider   string
+	StatusCode int
+	Body       string
+}
+
+func (e *llmAPIError) Error() string {
+	return fmt.Sprintf("%s API error (%d): %s", e.Provider, e.StatusCode, e.Body)
+}
+
+// isTransientLLMError reports whether a failed call might succeed on another
+// model: network failures and timeouts, rate limits (429) and server errors
+// (5xx). Other 4xx answers, including auth errors, and unusable responses don't
+// qualify; neither does a busy limiter, which would only keep the fallback waiting too
+func isTransientLLMError(err error) bool {
+	if err == nil || errors.Is(err, errAIBusy) {
+		return false
+	}
+
+	var apiErr *llmAPIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusRequestTimeout ||
+			apiErr.StatusCode == http.StatusTooManyRequests ||
+			apiErr.StatusCode >= 500
+	}
+
+	// httpClient.Do reports timeouts and connection failures as *url.Error
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// selectBestPhoto dispatches the request to the configured provider
+// once the limiter has a free slot
+func (c *LLMClient) selectBestPhoto(photoPaths []string, photoIDs []int64) (*BestPhotoResult, error) {
+	release, err := c.limiter.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	switch c.config.Provider {
 	case ProviderOpenAI, ProviderAzure, ProviderCustom:
 		return c.selectBestPhotoOpenAI(photoPaths, photoIDs)
@@ -113,35 +230,149 @@ func (c *LLMClient) SelectBestPhoto(photoPaths []string, photoIDs []int64) (*Bes
 	}
 }
 
+// activeModel returns the model (or Azure deployment) requests are sent to
+func (c *LLMClient) activeModel() string {
+	if c.config.Provider == ProviderAzure {
+		return c.config.AzureDeployment
+	}
+	return c.config.Model
+}
+
+// withModel returns a copy of the client that targets a different model
+// For Azure the model is the deployment name
+func (c *LLMClient) withModel(model string) *LLMClient {
+	clone := *c
+	if clone.config.Provider == ProviderAzure {
+		clone.config.AzureDeployment = model
+	} else {
+		clone.config.Model = model
+	}
+	return &clone
+}
+
+// acceptsImageType reports whether the provider's vision API takes images of a MIME type
+// Gemini doesn't take GIFs; every provider takes JPEG, PNG and WebP
+func (c *LLMClient) acceptsImageType(mimeType string) bool {
+	switch mimeType {
+	case "image/jpeg", "image/png", "image/webp":
+		return true
+	case "image/gif":
+		return c.config.Provider != ProviderGemini
+	}
+	return false
+}
+
+// loadLLMImage reads a photo for a vision request and returns its MIME type and bytes
+// The type comes from the file's content, not its name. Photos larger than
+// ImageMaxDimension are shrunk and re-encoded as JPEG: judging which of a few
+// shots is sharpest doesn't need full resolution, and image tokens are billed
+// by size. Photos in a format the provider doesn't accept are re-encoded as
+// JPEG too, or rejected if they can't be decoded (e.g. AVIF, which this build
+// has no decoder for). Other photos are sent as-is
+func (c *LLMClient) loadLLMImage(path string) (string, []byte, error) {
+	imageData, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// An unrecognized type is never accepted, so such files must decode below
+	mimeType, _ := validateImageMagicBytes(imageData)
+	convert := !c.acceptsImageType(mimeType)
+
+	maxDim := c.config.ImageMaxDimension
+	if !convert {
+		if maxDim <= 0 {
+			return mimeType, imageData, nil
+		}
+
+		// Check the header first so photos that are already small skip a full decode
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(imageData))
+		if err != nil || (cfg.Width <= maxDim && cfg.Height <= maxDim) {
+			return mimeType, imageData, nil
+		}
+	}
+
+	// Re-encoding drops EXIF, so bake the orientation into the pixels
+	img, err := decodeImage(bytes.NewReader(imageData), imaging.AutoOrientation(true))
+	if err != nil {
+		if convert {
+			return "", nil, fmt.Errorf("%s is in a format that can't be sent for analysis", filepath.Base(path))
+		}
+		log.Printf("LLM: sending %s at full size, failed to decode: %v", filepath.Base(path), err)
+		return mimeType, imageData, nil
+	}
+
+	if maxDim > 0 && (img.Bounds().Dx() > maxDim || img.Bounds().Dy() > maxDim) {
+		img = imaging.Fit(img, maxDim, maxDim, imaging.Lanczos)
+	}
+
+	var buf bytes.Buffer
+	if err := imaging.Encode(&buf, img, imaging.JPEG, imaging.JPEGQuality(LLMImageQuality)); err != nil {
+		return "", nil, fmt.Errorf("failed to re-encode image: %w", err)
+	}
+	return "image/jpeg", buf.Bytes(), nil
+}
+
+// llmImage is a photo ready to embed in a vision request
+type llmImage struct {
+	mimeType string
+	base64   string
+}
+
+// loadLLMImages reads, shrinks and base64-encodes photos for a vision request
+// Up to LLMImageWorkers photos are processed at once, which bounds how many
+// decoded images are in memory; results keep the order of paths
+func (c *LLMClient) loadLLMImages(paths []string) ([]llmImage, error) {
+	images := make([]llmImage, len(paths))
+	errs := make([]error, len(paths))
+
+	sem := make(chan struct{}, LLMImageWorkers)
+	var wg sync.WaitGroup
+	for i, path := range paths {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, path string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			mimeType, data, err := c.loadLLMImage(path)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			images[i] = llmImage{mimeType: mimeType, base64: base64.StdEncoding.EncodeToString(data)}
+		}(i, path)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("failed to read image %d: %w", i+1, err)
+		}
+	}
+	return images, nil
+}
+
 // selectBestPhotoOpenAI uses OpenAI/Azure/Custom API to select the best photo
 func (c *LLMClient) selectBestPhotoOpenAI(photoPaths []string, photoIDs []int64) (*BestPhotoResult, error) {
 	// Build the messages with images
 	content := []map[string]interface{}{
 		{
 			"type": "text",
-			"text": buildPhotoAnalysisPrompt(photoIDs),
+			"text": buildPhotoAnalysisPrompt(photoIDs, c.config.PromptTemplate),
 		},
 	}
 
 	// Add each photo as an image
-	for i, path := range photoPaths {
-		imageData, err := os.ReadFile(path)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read image %d: %w", i+1, err)
-		}
-
-		// Determine MIME type
-		mimeType := "image/jpeg"
-		if strings.HasSuffix(strings.ToLower(path), ".png") {
-			mimeType = "image/png"
-		} else if strings.HasSuffix(strings.ToLower(path), ".webp") {
-			mimeType = "image/webp"
-		}
-
+	images, err := c.loadLLMImages(photoPaths)
+	if err != nil {
+		return nil, err
+	}
+	for _, img := range images {
 		content = append(content, map[string]interface{}{
 			"type": "image_url",
 			"image_url": map[string]string{
-				"url": fmt.Sprintf("data:%s;base64,%s", mimeType, base64.StdEncoding.EncodeToString(imageData)),
+				"url": fmt.Sprintf("data:%s;base64,%s", img.mimeType, img.base64),
 			},
 		})
 	}
@@ -201,7 +432,7 @@ func (c *LLMClient) selectBestPhotoOpenAI(photoPaths []string, photoIDs []int64)
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("LLM API error (%d): %s", resp.StatusCode, string(body))
+		return nil, &llmAPIError{Provider: "LLM", StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
 	// Parse response
@@ -230,29 +461,20 @@ func (c *LLMClient) selectBestPhotoGemini(photoPaths []string, photoIDs []int64)
 	// Build parts array with prompt and images
 	parts := []map[string]interface{}{
 		{
-			"text": buildPhotoAnalysisPrompt(photoIDs),
+			"text": buildPhotoAnalysisPrompt(photoIDs, c.config.PromptTemplate),
 		},
 	}
 
 	// Add each photo as inline data
-	for i, path := range photoPaths {
-		imageData, err := os.ReadFile(path)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read image %d: %w", i+1, err)
-		}
-
-		// Determine MIME type
-		mimeType := "image/jpeg"
-		if strings.HasSuffix(strings.ToLower(path), ".png") {
-			mimeType = "image/png"
-		} else if strings.HasSuffix(strings.ToLower(path), ".webp") {
-			mimeType = "image/webp"
-		}
-
+	images, err := c.loadLLMImages(photoPaths)
+	if err != nil {
+		return nil, err
+	}
+	for _, img := range images {
 		parts = append(parts, map[string]interface{}{
 			"inline_data": map[string]string{
-				"mime_type": mimeType,
-				"data":      base64.StdEncoding.EncodeToString(imageData),
+				"mime_type": img.mimeType,
+				"data":      img.base64,
 			},
 		})
 	}
@@ -297,7 +519,7 @@ func (c *LLMClient) selectBestPhotoGemini(photoPaths []string, photoIDs []int64)
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("Gemini API error (%d): %s", resp.StatusCode, string(body))
+		return nil, &llmAPIError{Provider: "Gemini", StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
 	// Parse response
@@ -322,17 +544,17 @@ func (c *LLMClient) selectBestPhotoGemini(photoPaths []string, photoIDs []int64)
 	return parsePhotoAnalysisResponse(apiResp.Candidates[0].Content.Parts[0].Text, photoIDs)
 }
 
-// buildPhotoAnalysisPrompt creates the prompt for photo analysis
-func buildPhotoAnalysisPrompt(photoIDs []int64) string {
-	photoList := ""
-	for i, id := range photoIDs {
-		photoList += fmt.Sprintf("- Photo %d (ID: %d)\n", i+1, id)
-	}
+// Prompt template placeholders
+const (
+	promptPlaceholderCount  = "{photo_count}"
+	promptPlaceholderPhotos = "{photo_list}"
+)
 
-	return fmt.Sprintf(`You are an expert photo curator. Analyze the following %d photos and determine which one is the best.
+// defaultPhotoAnalysisPrompt is the built-in prompt used when no override is configured
+const defaultPhotoAnalysisPrompt = `You are an expert photo curator. Analyze the following {photo_count} photos and determine which one is the best.
 
 Photos to analyze:
-%s
+{photo_list}
 
 For each photo, evaluate:
 1. **Sharpness/Focus** (0-100): Is the subject in focus? Is the image sharp?
@@ -357,14 +579,154 @@ Respond in this exact JSON format:
       "issues": ["<issue1>", "<issue2>"]
     }
   ]
-}`, len(photoIDs), photoList)
+}`
+
+// defaultComparisonPrompt is used for head-to-head comparison of exactly two photos
+// It asks for the same JSON shape as the group prompt so the same parser applies
+const defaultComparisonPrompt = `You are an expert photo curator helping someone decide which of two similar photos to keep.
+
+Photos to compare:
+{photo_list}
+
+Compare them directly on:
+1. **Sharpness/Focus** (0-100)
+2. **Exposure/Brightness** (0-100)
+3. **Composition** (0-100)
+4. **Face Quality** (0-100): eyes open, natural expressions (use 50 if there are no faces)
+
+Pick the one worth keeping. In the reasoning, name the specific differences that decided it (e.g. "photo 2 has closed eyes").
+
+Respond in this exact JSON format:
+{
+  "best_photo_id": <the ID of the better photo>,
+  "reasoning": "<1-2 sentences naming the deciding differences>",
+  "analyses": [
+    {
+      "photo_id": <photo ID>,
+      "sharpness": <0-100>,
+      "exposure": <0-100>,
+      "composition": <0-100>,
+      "face_quality": <0-100>,
+      "overall_score": <0-100>,
+      "issues": ["<issue1>", "<issue2>"]
+    }
+  ]
+}`
+
+// requiredPromptFields are the JSON keys a prompt must ask for so the response can be parsed
+var requiredPromptFields = []string{
+	"best_photo_id", "reasoning", "analyses", "photo_id",
+	"sharpness", "exposure", "composition", "face_quality", "overall_score",
+}
+
+// validatePromptTemplate checks that a custom prompt still requests the JSON shape we parse
+func validatePromptTemplate(template string) error {
+	if !strings.Contains(template, promptPlaceholderPhotos) {
+		return fmt.Errorf("prompt template must contain %s so the model knows the photo IDs", promptPlaceholderPhotos)
+	}
+
+	for _, field := range requiredPromptFields {
+		if !strings.Contains(template, field) {
+			return fmt.Errorf("prompt template must ask for the %q field", field)
+		}
+	}
+
+	return nil
+}
+
+// buildPhotoAnalysisPrompt creates the prompt for photo analysis
+// An empty template uses the built-in default
+func buildPhotoAnalysisPrompt(photoIDs []int64, template string) string {
+	if template == "" {
+		template = defaultPhotoAnalysisPrompt
+	}
+
+	photoList := ""
+	for i, id := range photoIDs {
+		photoList += fmt.Sprintf("- Photo %d (ID: %d)\n", i+1, id)
+	}
+
+	replacer := strings.NewReplacer(
+		promptPlaceholderCount, fmt.Sprintf("%d", len(photoIDs)),
+		promptPlaceholderPhotos, photoList,
+	)
+	return replacer.Replace(template)
+}
+
+// IsSet returns true if any weight is configured
+func (sw ScoreWeights) IsSet() bool {
+	return sw.Sharpness != 0 || sw.Exposure != 0 || sw.Composition != 0 || sw.FaceQuality != 0
+}
+
+// Validate checks that weights are non-negative
+func (sw ScoreWeights) Validate() error {
+	if sw.Sharpness < 0 || sw.Exposure < 0 || sw.Composition < 0 || sw.FaceQuality < 0 {
+		return fmt.Errorf("score weights cannot be negative")
+	}
+	return nil
+}
+
+// Score computes the weighted overall score (0-100) for an analysis
+func (sw ScoreWeights) Score(a PhotoAnalysis) int {
+	total := sw.Sharpness + sw.Exposure + sw.Composition + sw.FaceQuality
+	if total == 0 {
+		return a.OverallScore
+	}
+
+	weighted := sw.Sharpness*float64(a.Sharpness) +
+		sw.Exposure*float64(a.Exposure) +
+		sw.Composition*float64(a.Composition) +
+		sw.FaceQuality*float64(a.FaceQuality)
+
+	return int(math.Round(weighted / total))
+}
+
+// applyScoreWeights recomputes overall scores with the configured weights
+// and re-selects the best photo by the recomputed score
+func applyScoreWeights(result *BestPhotoResult, weights ScoreWeights, photoIDs []int64) {
+	if !weights.IsSet() || len(result.Analyses) == 0 {
+		return
+	}
+
+	valid := make(map[int64]bool, len(photoIDs))
+	for _, id := range photoIDs {
+		valid[id] = true
+	}
+
+	bestScore := -1
+	var bestID int64
+	modelBestScore := -1
+	for i := range result.Analyses {
+		analysis := &result.Analyses[i]
+		analysis.OverallScore = weights.Score(*analysis)
+
+		if !valid[analysis.PhotoID] {
+			continue
+		}
+		if analysis.OverallScore > bestScore {
+			bestScore = analysis.OverallScore
+			bestID = analysis.PhotoID
+		}
+		if analysis.PhotoID == result.BestPhotoID {
+			modelBestScore = analysis.OverallScore
+		}
+	}
+
+	// Keep the model's pick on ties so its reasoning still applies
+	if bestScore < 0 || modelBestScore == bestScore {
+		return
+	}
+
+	result.Reasoning = fmt.Sprintf("Highest weighted score (%d). Model's original pick was photo %d: %s",
+		bestScore, result.BestPhotoID, result.Reasoning)
+	result.BestPhotoID = bestID
 }
 
 // parsePhotoAnalysisResponse parses the LLM response into a structured result
 func parsePhotoAnalysisResponse(content string, photoIDs []int64) (*BestPhotoResult, error) {
 	// Try to extract JSON from the response
 	content = strings.TrimSpace(content)
-	
+
 	// Handle markdown code blocks
 	if strings.HasPrefix(content, "```") {
 		lines := strings.Split(content, "\n")
@@ -388,23 +750,42 @@ func parsePhotoAnalysisResponse(content string, photoIDs []int64) (*BestPhotoRes
 	}
 
 	// Validate best_photo_id is in our list
-	validID := false
+	valid := make(map[int64]bool, len(photoIDs))
 	for _, id := range photoIDs {
-		if id == result.BestPhotoID {
-			validID = true
-			break
+		valid[id] = true
+	}
+
+	if !valid[result.BestPhotoID] && len(photoIDs) > 0 {
+		if bestID, score, ok := highestScoredPhoto(result.Analyses, valid); ok {
+			// The model scored the photos but didn't name one; trust its scores
+			result.BestPhotoID = bestID
+			result.Reasoning = strings.TrimSpace(fmt.Sprintf("Highest overall score (%d); the model did not name a valid best photo. %s",
+				score, result.Reasoning))
+		} else {
+			// Default to first photo if LLM gave invalid ID and no usable scores
+			result.BestPhotoID = photoIDs[0]
+			result.Reasoning = "Selected first photo (LLM response was invalid)"
+			result.defaulted = true
 		}
 	}
 
-	if !validID && len(photoIDs) > 0 {
-		// Default to first photo if LLM gave invalid ID
-		result.BestPhotoID = photoIDs[0]
-		result.Reasoning = "Selected first photo (LLM response was invalid)"
-	}
-
 	return &result, nil
 }
 
+// highestScoredPhoto returns the analysed photo with the highest overall_score
+// Analyses for photos outside valid are ignored; the first one wins ties
+func highestScoredPhoto(analyses []PhotoAnalysis, valid map[int64]bool) (int64, int, bool) {
+	var bestID int64
+	bestScore := -1
+	for _, analysis := range analyses {
+		if valid[analysis.PhotoID] && analysis.OverallScore > bestScore {
+			bestID = analysis.PhotoID
+			bestScore = analysis.OverallScore
+		}
+	}
+	return bestID, bestScore, bestScore >= 0
+}
+
 // IsConfigured checks if the LLM client has valid configuration
 func (c *LLMClient) IsConfigured() bool {
 	return c.config.APIKey != "" && c.config.Provider != ""
@@ -414,4 +795,3 @@ func (c *LLMClient) IsConfigured() bool {
 func (c *LLMClient) GetProvider() LLMProvider {
 	return c.config.Provider
 }
-
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestParsePhotoAnalysisResponse(t *testing.T) {
+	photoIDs := []int64{7, 8, 9}
+
+	tests := []struct {
+		name          string
+		content       string
+		wantErr       bool
+		wantBest      int64
+		wantDefaulted bool
+	}{
+		{
+			name:     "valid",
+			content:  `{"best_photo_id": 8, "reasoning": "sharpest", "analyses": []}`,
+			wantBest: 8,
+		},
+		{
+			name:     "markdown code block",
+			content:  "```json\n{\"best_photo_id\": 9, \"reasoning\": \"ok\"}\n```",
+			wantBest: 9,
+		},
+		{
+			name:    "not JSON",
+			content: "The second photo is the best one.",
+			wantErr: true,
+		},
+		{
+			name:    "truncated JSON",
+			content: `{"best_photo_id": 8, "reasoning": "sha`,
+			wantErr: true,
+		},
+		{
+			name: "unknown id falls back to highest score",
+			content: `{"best_photo_id": 42, "analyses": [
+				{"photo_id": 7, "overall_score": 60},
+				{"photo_id": 9, "overall_score": 85},
+				{"photo_id": 8, "overall_score": 70}]}`,
+			wantBest: 9,
+		},
+		{
+			name: "scores for unknown ids are ignored",
+			content: `{"best_photo_id": 42, "analyses": [
+				{"photo_id": 42, "overall_score": 99},
+				{"photo_id": 8, "overall_score": 50}]}`,
+			wantBest: 8,
+		},
+		{
+			name:          "unknown id without scores defaults to first",
+			content:       `{"best_photo_id": 42, "reasoning": "photo 42"}`,
+			wantBest:      7,
+			wantDefaulted: true,
+		},
+		{
+			name:          "missing id defaults to first",
+			content:       `{"reasoning": "they are all fine"}`,
+			wantBest:      7,
+			wantDefaulted: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := parsePhotoAnalysisResponse(tt.content, photoIDs)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %+v, want an error", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.BestPhotoID != tt.wantBest {
+				t.Errorf("best photo %d, want %d", result.BestPhotoID, tt.wantBest)
+			}
+			if result.defaulted != tt.wantDefaulted {
+				t.Errorf("defaulted %t, want %t", result.defaulted, tt.wantDefaulted)
+			}
+		})
+	}
+}
+
+func TestHighestScoredPhoto(t *testing.T) {
+	valid := map[int64]bool{1: true, 2: true, 3: true}
+
+	tests := []struct {
+		name      string
+		analyses  []PhotoAnalysis
+		wantID    int64
+		wantScore int
+		wantOK    bool
+	}{
+		{"no analyses", nil, 0, 0, false},
+		{"highest wins", []PhotoAnalysis{{PhotoID: 1, OverallScore: 40}, {PhotoID: 2, OverallScore: 90}, {PhotoID: 3, OverallScore: 70}}, 2, 90, true},
+		{"first wins ties", []PhotoAnalysis{{PhotoID: 3, OverallScore: 80}, {PhotoID: 1, OverallScore: 80}}, 3, 80, true},
+		{"zero scores count", []PhotoAnalysis{{PhotoID: 2, OverallScore: 0}}, 2, 0, true},
+		{"unknown ids ignored", []PhotoAnalysis{{PhotoID: 99, OverallScore: 100}, {PhotoID: 1, OverallScore: 10}}, 1, 10, true},
+		{"only unknown ids", []PhotoAnalysis{{PhotoID: 99, OverallScore: 100}}, 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, score, ok := highestScoredPhoto(tt.analyses, valid)
+			if ok != tt.wantOK {
+				t.Fatalf("ok %t, want %t", ok, tt.wantOK)
+			}
+			if ok && (id != tt.wantID || score != tt.wantScore) {
+				t.Errorf("got photo %d with %d, want photo %d with %d", id, score, tt.wantID, tt.wantScore)
+			}
+		})
+	}
+}
+
+func TestIsTransientLLMError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"no error", nil, false},
+		{"busy limiter", errAIBusy, false},
+		{"rate limited", &llmAPIError{Provider: "LLM", StatusCode: http.StatusTooManyRequests}, true},
+		{"request timeout", &llmAPIError{Provider: "LLM", StatusCode: http.StatusRequestTimeout}, true},
+		{"server error", &llmAPIError{Provider: "Gemini", StatusCode: http.StatusServiceUnavailable}, true},
+		{"bad key", &llmAPIError{Provider: "LLM", StatusCode: http.StatusUnauthorized}, false},
+		{"forbidden", &llmAPIError{Provider: "LLM", StatusCode: http.StatusForbidden}, false},
+		{"bad request", &llmAPIError{Provider: "Gemini", StatusCode: http.StatusBadRequest}, false},
+		{"network failure", fmt.Errorf("failed to send request: %w", &url.Error{Op: "Post", URL: "http://llm", Err: errors.New("connection refused")}), true},
+		{"unusable response", errors.New("failed to parse response"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransientLLMError(tt.err); got != tt.want {
+				t.Errorf("isTransientLLMError(%v) = %t, want %t", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectBestPhotoFallback(t *testing.T) {
+	dir := t.TempDir()
+	var paths []string
+	for _, name := range []string{"a.jpg", "b.jpg"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, testJPEG(t, 16, 16), 0600); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	tests := []struct {
+		name         string
+		status       int
+		wantFallback bool
+	}{
+		{"rate limited", http.StatusTooManyRequests, true},
+		{"server error", http.StatusBadGateway, true},
+		{"bad key", http.StatusUnauthorized, false},
+		{"bad request", http.StatusBadRequest, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var models []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var req struct {
+					Model string `json:"model"`
+				}
+				json.NewDecoder(r.Body).Decode(&req)
+				mu.Lock()
+				models = append(models, req.Model)
+				mu.Unlock()
+
+				if req.Model == "primary" {
+					http.Error(w, "primary failed", tt.status)
+					return
+				}
+				content := `{"best_photo_id": 2, "reasoning": "sharper"}`
+				json.NewEncoder(w).Encode(map[string]any{
+					"choices": []map[string]any{{"message": map[string]string{"content": content}}},
+				})
+			}))
+			defer server.Close()
+
+			client := NewLLMClient(LLMConfig{Provider: ProviderCustom, BaseURL: server.URL, Model: "primary", FallbackModel: "fallback"})
+			result, err := client.SelectBestPhoto(paths, []int64{1, 2})
+
+			if tt.wantFallback {
+				if err != nil {
+					t.Fatalf("SelectBestPhoto: %v", err)
+				}
+				if result.Model != "fallback" {
+					t.Errorf("answered by %q, want the fallback model", result.Model)
+				}
+			} else if err == nil {
+				t.Fatalf("got %+v, want the primary model's error", result)
+			}
+
+			wantCalls := 1
+			if tt.wantFallback {
+				wantCalls = 2
+			}
+			if len(models) != wantCalls {
+				t.Errorf("models called: %v, want %d call(s)", models, wantCalls)
+			}
+		})
+	}
+}
@@ -1,14 +1,20 @@
 package main
 
 import (
+	"context"
 	"embed"
+	"errors"
 	"fmt"
 	"html/template"
 	"io/fs"
 	"log"
 	"net"
 	"net/http"
+	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
+	"time"
 )
 
 //go:embed static/*
@@ -40,7 +46,7 @@ func main() {
 
 	// Initialize database
 	dbPath := filepath.Join(config.StoragePath, "mnemosyne.db")
-	db, err := NewDatabase(dbPath)
+	db, err := NewDatabase(dbPath, config.GetDatabaseOptions())
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
@@ -67,11 +73,17 @@ func main() {
 
 	// Start server
 	addr := fmt.Sprintf("%s:%d", config.BindAddress, config.Port)
+	listener, err := listen(config, addr)
+	if err != nil {
+		log.Fatalf("Failed to listen: %v", err)
+	}
 
 	fmt.Println("\n✓ Server is ready!")
-	fmt.Printf("  Listen address: %s\n", addr)
+	fmt.Printf("  Listen address: %s\n", listener.Addr())
 
-	if config.EnableHTTPS {
+	if config.UnixSocket != "" {
+		fmt.Println("  Protocol: Unix socket (reach it through your reverse proxy)")
+	} else if config.EnableHTTPS {
 		fmt.Println("  Protocol: HTTPS (secure)")
 		fmt.Println("\n📱 Access from your devices at:")
 		for _, ip := range ips {
@@ -96,7 +108,9 @@ func main() {
 
 	// Check if any users exist
 	users, _ := db.GetAllUsers()
-	if len(users) == 0 {
+	if len(users) == 0 && config.FirstRunSetup {
+		fmt.Println("\n👤 No users found. Open the server in a browser to set up the admin account.")
+	} else if len(users) == 0 {
 		fmt.Println("\n👤 No users found. The first user to register will become admin.")
 	} else {
 		fmt.Printf("\n👤 %d user(s) registered\n", len(users))
@@ -104,25 +118,113 @@ func main() {
 
 	fmt.Println("\nPress Ctrl+C to stop the server.")
 
-	// Start server
-	if config.EnableHTTPS {
-		if err := http.ListenAndServeTLS(addr, config.CertPath, config.KeyPath, handler); err != nil {
-			log.Fatalf("Server failed: %v", err)
+	// Start server with timeouts so slow or stalled clients can't hold connections forever
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: time.Duration(config.ReadHeaderTimeoutSecs) * time.Second,
+		ReadTimeout:       time.Duration(config.ReadTimeoutSecs) * time.Second,
+		WriteTimeout:      time.Duration(config.WriteTimeoutSecs) * time.Second,
+		IdleTimeout:       time.Duration(config.IdleTimeoutSecs) * time.Second,
+		MaxHeaderBytes:    config.MaxHeaderBytes,
+	}
+
+	// Finish in-flight requests on Ctrl+C / SIGTERM; closing the listener also
+	// removes the Unix socket file
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		<-stop
+		fmt.Println("\nShutting down...")
+
+		ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeoutSecs*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Shutdown: %v", err)
 		}
+	}()
+
+	if config.EnableHTTPS {
+		err = server.ServeTLS(listener, config.CertPath, config.KeyPath)
 	} else {
-		if err := http.ListenAndServe(addr, handler); err != nil {
-			log.Fatalf("Server failed: %v", err)
+		err = server.Serve(listener)
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("Server failed: %v", err)
+	}
+	<-shutdownDone
+
+	// Save counts buffered since the last flush
+	if err := app.counter.Flush(); err != nil {
+		log.Printf("Failed to save access counts: %v", err)
+	}
+}
+
+// listen opens the server's listener: the Unix socket if one is configured, otherwise addr over TCP
+// A socket file left behind by a crash is removed first; any other file at that path is an error
+func listen(config *Config, addr string) (net.Listener, error) {
+	if config.UnixSocket == "" {
+		return net.Listen("tcp", addr)
+	}
+
+	if info, err := os.Lstat(config.UnixSocket); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", config.UnixSocket)
+		}
+		if err := os.Remove(config.UnixSocket); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %v", err)
 		}
 	}
+
+	return net.Listen("unix", config.UnixSocket)
 }
 
 // createApp creates an app instance
 func createApp(config *Config, db *Database) (*App, error) {
+	// Hash new passwords with the configured cost
+	db.SetBcryptCost(config.BcryptCost)
+
+	// Provision the admin from config; nobody becomes admin by registering first
+	if config.AdminUsername != "" {
+		db.SetFirstUserAdmin(false)
+		username := normalizeUsername(config.AdminUsername)
+		created, err := db.EnsureAdmin(username, config.AdminPassword)
+		if err != nil {
+			return nil, err
+		}
+		if created {
+			log.Printf("Created admin account %s from config", username)
+		}
+	}
+
 	// Create session manager
-	sessionMgr := NewSessionManager(db, config.SessionExpHrs)
+	sessionMgr := NewSessionManager(db, config.SessionExpHrs, config.SessionCleanupHours, config.GetCookieOptions())
 
 	// Create photo manager
-	photoMgr := NewPhotoManager(config.StoragePath, config.MaxUploadMB, db)
+	storage, err := NewStorage(config)
+	if err != nil {
+		return nil, err
+	}
+	photoMgr := NewPhotoManager(storage, config.MaxUploadMB, db, config.GetThumbnailOptions(), config.GetNormalizeOptions(), config.FilenameStrategy)
+
+	// Measure dimensions of photos uploaded before they were stored
+	go photoMgr.BackfillDimensions()
+
+	// Hash photos uploaded before perceptual hashes were stored
+	go photoMgr.BackfillPerceptualHashes()
+
+	// Trim derived images to thumbnail_cache_mb (no-op when unlimited)
+	go photoMgr.SweepThumbnailCache()
+
+	// Start auto-archive sweeper (only touches users who opted in)
+	autoArchiver := NewAutoArchiver(db, photoMgr, config.AutoArchiveIntervalHours)
+
+	// Start scheduled database backups (on-demand backups work either way)
+	backuper := NewBackuper(db, config.GetBackupDir(), config.BackupIntervalHours, config.BackupKeep)
+
+	// Buffer view/download counts and write them in batches
+	counter := NewAccessCounter(db)
 
 	// Parse embedded templates
 	templatesSubFS, err := fs.Sub(templatesFS, "templates")
@@ -140,11 +242,20 @@ func createApp(config *Config, db *Database) (*App, error) {
 	}
 
 	app := &App{
-		config:     config,
-		db:         db,
-		sessionMgr: sessionMgr,
-		photoMgr:   photoMgr,
-		templates:  templates,
+		config:       config,
+		db:           db,
+		sessionMgr:   sessionMgr,
+		photoMgr:     photoMgr,
+		autoArchiver: autoArchiver,
+		backuper:     backuper,
+		counter:      counter,
+		aiLimiter:    newAILimiter(config.AIMaxConcurrent, config.AIQueueTimeoutSecs),
+		templates:    templates,
+	}
+
+	// Embed new uploads in the background (nil leaves it to manual runs)
+	if config.AutoEmbedOnUpload {
+		app.autoEmbedder = NewAutoEmbedder(db, photoMgr, app.newEmbeddingService)
 	}
 
 	return app, nil
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"io"
+
+	"github.com/disintegration/imaging"
+)
+
+// NormalizeOptions controls converting uploads to JPEG on the way in
+type NormalizeOptions struct {
+	ToJPEG          bool // Store PNG, WebP and GIF uploads as JPEG
+	KeepTransparent bool // Leave images with transparency as uploaded instead of flattening them onto white
+}
+
+// jpegVersion decodes a stored PNG, WebP or GIF upload for re-encoding as JPEG
+// Returns nil (keep the upload as is) for animated GIFs, which JPEG can't
+// animate, and for images with transparency when it is to be kept; otherwise
+// transparent areas are flattened onto white. The file is decoded straight
+// from storage, never read into memory whole
+func (pm *PhotoManager) jpegVersion(key string) (image.Image, error) {
+	file, err := pm.storage.Open(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open upload: %v", err)
+	}
+	defer file.Close()
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(file, magic); err != nil {
+		return nil, fmt.Errorf("failed to read upload: %v", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to read upload: %v", err)
+	}
+
+	if bytes.Equal(magic, []byte("GIF8")) {
+		all, err := gif.DecodeAll(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode GIF: %v", err)
+		}
+		if len(all.Image) > 1 {
+			return nil, nil
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("failed to read upload: %v", err)
+		}
+	}
+
+	src, err := decodeImage(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %v", err)
+	}
+
+	if !isOpaque(src) {
+		if pm.normalize.KeepTransparent {
+			return nil, nil
+		}
+		flat := image.NewRGBA(image.Rect(0, 0, src.Bounds().Dx(), src.Bounds().Dy()))
+		draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+		draw.Draw(flat, flat.Bounds(), src, src.Bounds().Min, draw.Over)
+		src = flat
+	}
+	return src, nil
+}
+
+// saveJPEG encodes an image as JPEG straight into storage, returning the
+// stored file's content hash and size
+func (pm *PhotoManager) saveJPEG(key string, img image.Image) (string, int64, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(imaging.Encode(pw, img, imaging.JPEG, imaging.JPEGQuality(NormalizeQuality)))
+	}()
+
+	hasher := sha256.New()
+	err := pm.storage.Save(key, io.TeeReader(pr, hasher))
+	// Unblocks the encoder if Save gave up early
+	pr.CloseWithError(err)
+	if err != nil {
+		return "", 0, err
+	}
+
+	info, err := pm.storage.Stat(key)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), info.Size(), nil
+}
+
+// isOpaque reports whether every pixel of an image is fully opaque
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"testing"
+)
+
+func TestSavePhotoNormalizesToJPEG(t *testing.T) {
+	pm := newTestPhotoManager(t, func(c *Config) { c.NormalizeToJPEG = true })
+	user := newTestUser(t, pm.db, "alice")
+
+	// Half transparent, so it is flattened on the way
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 48))
+	for i := range img.Pix {
+		img.Pix[i] = 128
+	}
+	var upload bytes.Buffer
+	if err := png.Encode(&upload, img); err != nil {
+		t.Fatal(err)
+	}
+
+	photo, err := pm.SavePhoto("logo.png", &upload, user.ID, "")
+	if err != nil {
+		t.Fatalf("SavePhoto: %v", err)
+	}
+	if photo.Filename != "logo.jpg" {
+		t.Errorf("stored as %q, want logo.jpg", photo.Filename)
+	}
+	if _, err := pm.storage.Stat(pm.getOriginalKey(user.ID, "logo.png")); err == nil {
+		t.Error("the PNG upload was kept next to its JPEG version")
+	}
+
+	file, err := pm.storage.Open(pm.getOriginalKey(user.ID, photo.Filename))
+	if err != nil {
+		t.Fatalf("open stored JPEG: %v", err)
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256(data)
+	if photo.ContentHash != hex.EncodeToString(sum[:]) {
+		t.Error("content hash doesn't match the stored JPEG")
+	}
+	if photo.Size != int64(len(data)) {
+		t.Errorf("size %d, want %d", photo.Size, len(data))
+	}
+
+	stored, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("stored file is not a JPEG: %v", err)
+	}
+	if r, g, b, _ := stored.At(10, 10).RGBA(); r>>8 < 160 || g>>8 < 160 || b>>8 < 160 {
+		t.Errorf("transparent area not flattened onto white: %v", color.RGBAModel.Convert(stored.At(10, 10)))
+	}
+}
+
+func TestJPEGVersionKeepsAnimatedGIF(t *testing.T) {
+	pm := newTestPhotoManager(t, nil)
+
+	key := pm.getOriginalKey(1, "clip.gif")
+	if err := pm.storage.Save(key, bytes.NewReader(testAnimatedGIF(t))); err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := pm.jpegVersion(key)
+	if err != nil {
+		t.Fatalf("jpegVersion: %v", err)
+	}
+	if img != nil {
+		t.Error("animated GIF would be converted to a still JPEG")
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"log"
+	"math/bits"
+	"net/http"
+	"strconv"
+
+	"github.com/disintegration/imaging"
+)
+
+// Perceptual duplicate detection parameters
+const (
+	DefaultPHashDistance = 5  // Hamming distance treated as "near-identical" when none is given
+	MaxPHashDistance     = 64 // a dHash has 64 bits, so every pair is within this distance
+)
+
+// computeDHash computes a 64-bit difference hash of an image
+// The image is shrunk to 9x8 grayscale and each bit records whether a pixel is
+// brighter than its right-hand neighbour, so resizing, recompression and small
+// exposure changes barely move the hash
+func computeDHash(img image.Image) uint64 {
+	small := imaging.Resize(imaging.Grayscale(img), 9, 8, imaging.Box)
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			left := small.Pix[y*small.Stride+x*4]
+			right := small.Pix[y*small.Stride+(x+1)*4]
+			hash <<= 1
+			if left > right {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// formatPHash encodes a hash the way it is stored in the database (16 hex digits)
+func formatPHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+// parsePHash decodes a stored hash; ok is false for "" and malformed values
+func parsePHash(s string) (hash uint64, ok bool) {
+	hash, err := strconv.ParseUint(s, 16, 64)
+	return hash, s != "" && err == nil
+}
+
+// groupByPHash groups photos whose hashes are within maxDistance bits of each other
+// Grouping is transitive: A~B and B~C puts all three in one group even if A and C
+// are further apart. Photos without a usable hash are skipped; groups keep the
+// order of the input slice and only groups of two or more are returned
+func groupByPHash(photos []*Photo, maxDistance int) [][]*Photo {
+	hashed := make([]*Photo, 0, len(photos))
+	hashes := make([]uint64, 0, len(photos))
+	for _, photo := range photos {
+		if hash, ok := parsePHash(photo.PHash); ok {
+			hashed = append(hashed, photo)
+			hashes = append(hashes, hash)
+		}
+	}
+
+	// Union-find over all pairs; fine for a family library
+	parent := make([]int, len(hashed))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	for i := range hashes {
+		for j := i + 1; j < len(hashes); j++ {
+			if bits.OnesCount64(hashes[i]^hashes[j]) <= maxDistance {
+				parent[find(j)] = find(i)
+			}
+		}
+	}
+
+	members := make(map[int][]*Photo)
+	order := make([]int, 0)
+	for i, photo := range hashed {
+		root := find(i)
+		if _, seen := members[root]; !seen {
+			order = append(order, root)
+		}
+		members[root] = append(members[root], photo)
+	}
+
+	groups := make([][]*Photo, 0)
+	for _, root := range order {
+		if len(members[root]) >= 2 {
+			groups = append(groups, members[root])
+		}
+	}
+	return groups
+}
+
+// BackfillPerceptualHashes hashes photos uploaded before perceptual hashes were stored
+// Photos that can't be decoded are recorded as "" so they aren't retried
+func (pm *PhotoManager) BackfillPerceptualHashes() {
+	photos, err := pm.db.GetPhotosWithoutPHash()
+	if err != nil {
+		log.Printf("Perceptual hash backfill: failed to list photos: %v", err)
+		return
+	}
+	if len(photos) == 0 {
+		return
+	}
+
+	hashed := 0
+	for _, photo := range photos {
+		phash := ""
+
+		if file, _, err := pm.OpenOriginal(photo); err == nil {
+			if img, err := decodeImage(file); err == nil {
+				phash = formatPHash(computeDHash(img))
+				hashed++
+			}
+			file.Close()
+		}
+
+		if err := pm.db.SetPhotoPHash(photo.ID, phash); err != nil {
+			log.Printf("Perceptual hash backfill: failed to update photo %d: %v", photo.ID, err)
+		}
+	}
+
+	log.Printf("Perceptual hash backfill: hashed %d of %d photo(s)", hashed, len(photos))
+}
+
+// HandleFindPerceptualDuplicates groups the user's photos that look near-identical
+// Works offline from hashes computed at upload, so it needs no embedding service
+// Query params:
+//   - distance: maximum Hamming distance between hashes (0-64, default 5)
+func (app *App) HandleFindPerceptualDuplicates(w http.ResponseWriter, r *http.Request) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	distance := DefaultPHashDistance
+	if distanceStr := r.URL.Query().Get("distance"); distanceStr != "" {
+		distance, err = strconv.Atoi(distanceStr)
+		if err != nil || distance < 0 || distance > MaxPHashDistance {
+			http.Error(w, "Invalid distance", http.StatusBadRequest)
+			return
+		}
+	}
+
+	photos, err := app.db.GetNonArchivedPhotos(session.UserID)
+	if err != nil {
+		http.Error(w, "Failed to list photos", http.StatusInternalServerError)
+		return
+	}
+
+	unhashed := 0
+	for _, photo := range photos {
+		if _, ok := parsePHash(photo.PHash); !ok {
+			unhashed++
+		}
+		app.photoMgr.BuildPhotoURLs(photo)
+	}
+
+	type DuplicateGroup struct {
+		Photos []*Photo `json:"photos"`
+	}
+
+	groups := make([]DuplicateGroup, 0)
+	for _, members := range groupByPHash(photos, distance) {
+		groups = append(groups, DuplicateGroup{Photos: members})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":         "success",
+		"distance":       distance,
+		"groups":         groups,
+		"total_groups":   len(groups),
+		"total_analyzed": len(photos) - unhashed,
+		"unhashed":       unhashed,
+	})
+}
@@ -2,621 +2,2415 @@ package main
 
 import (
 	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
 	"io"
+	"io/fs"
+	"log"
 	"net/http"
-	"net/url"
-	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
+	"unicode/utf8"
 
 	"github.com/disintegration/imaging"
+	_ "golang.org/x/image/webp" // register WebP decoder for image.DecodeConfig
 )
 
 // thumbnailSize is now defined in constants.go as ThumbnailSize
 
+// Thumbnail modes
+const (
+	ThumbnailModeFit  = "fit"  // scale to fit within the square, keeping the aspect ratio
+	ThumbnailModeFill = "fill" // scale and center-crop to fill the square
+)
+
+// thumbnailFilters are the resampling filters thumbnail_filter can pick, best
+// quality first; the faster ones help large rebuilds on slow hardware
+var thumbnailFilters = map[string]imaging.ResampleFilter{
+	"lanczos":          imaging.Lanczos,
+	"catmull_rom":      imaging.CatmullRom,
+	"linear":           imaging.Linear,
+	"nearest_neighbor": imaging.NearestNeighbor,
+}
+
+// Filename collision strategies (config filename_strategy, or per upload)
+const (
+	FilenameStrategySuffix    = "suffix"    // append _1, _2, ... when the name is taken
+	FilenameStrategyTimestamp = "timestamp" // append the upload time when the name is taken
+	FilenameStrategyUUID      = "uuid"      // always store under a random name; the uploaded name is kept as original_name
+)
+
+// isFilenameStrategy reports whether s names a filename collision strategy
+func isFilenameStrategy(s string) bool {
+	switch s {
+	case FilenameStrategySuffix, FilenameStrategyTimestamp, FilenameStrategyUUID:
+		return true
+	}
+	return false
+}
+
+// ThumbnailOptions controls how thumbnails are generated
+type ThumbnailOptions struct {
+	Mode          string // ThumbnailModeFit (default) or ThumbnailModeFill
+	Quality       int    // JPEG quality (1-100); 0 uses ThumbnailQuality
+	Filter        string // Resampling filter (a thumbnailFilters key); "" uses Lanczos
+	AnimatedGIFs  bool   // Serve animated GIF originals in place of their static first-frame thumbnails
+	CacheBytes    int64  // Disk budget for thumbnails and cached variants (0 = unlimited)
+	DropOnArchive bool   // Delete thumbnails of archived photos; they are resized from the original on request
+
+	SkipExtensions []string // Extensions (".gif") whose thumbnails are resized per request instead of stored
+}
+
 // PhotoManager handles photo operations
 type PhotoManager struct {
-	storagePath string
+	storage     Storage
 	maxUploadMB int64
 	db          *Database
+	thumbnails  ThumbnailOptions
+	normalize   NormalizeOptions
+	cache       *thumbnailCache // nil when the thumbnail cache is unlimited
+
+	nameStrategy string // default filename collision strategy
+	namesMu      sync.Mutex
+	reserved     map[string]bool // original keys claimed by uploads still in progress
 }
 
 // NewPhotoManager creates a new photo manager
-func NewPhotoManager(storagePath string, maxUploadMB int64, db *Database) *PhotoManager {
-	return &PhotoManager{
-		storagePath: storagePath,
-		maxUploadMB: maxUploadMB,
-		db:          db,
-	}
+func NewPhotoManager(storage Storage, maxUploadMB int64, db *Database, thumbnails ThumbnailOptions, normalize NormalizeOptions, nameStrategy string) *PhotoManager {
+	if nameStrategy == "" {
+		nameStrategy = FilenameStrategySuffix
+	}
+	pm := &PhotoManager{
+		storage:      storage,
+		maxUploadMB:  maxUploadMB,
+		db:           db,
+		thumbnails:   thumbnails,
+		normalize:    normalize,
+		nameStrategy: nameStrategy,
+		reserved:     make(map[string]bool),
+	}
+	if thumbnails.CacheBytes > 0 {
+		pm.cache = newThumbnailCache(pm, thumbnails.CacheBytes)
+	}
+	return pm
 }
 
-// getUserPath returns the storage path for a specific user
-func (pm *PhotoManager) getUserPath(userID int64) string {
-	return filepath.Join(pm.storagePath, "users", fmt.Sprintf("%d", userID))
+// getUserKey returns the storage prefix for a specific user
+func (pm *PhotoManager) getUserKey(userID int64) string {
+	return path.Join("users", fmt.Sprintf("%d", userID))
 }
 
-// getOriginalsPath returns the path to originals for a user
-func (pm *PhotoManager) getOriginalsPath(userID int64) string {
-	return filepath.Join(pm.getUserPath(userID), "originals")
+// getOriginalsDir returns the storage prefix for a user's originals
+func (pm *PhotoManager) getOriginalsDir(userID int64) string {
+	return path.Join(pm.getUserKey(userID), "originals")
 }
 
-// getThumbnailsPath returns the path to thumbnails for a user
-func (pm *PhotoManager) getThumbnailsPath(userID int64) string {
-	return filepath.Join(pm.getUserPath(userID), "thumbnails")
+// getThumbnailsDir returns the storage prefix for a user's thumbnails
+func (pm *PhotoManager) getThumbnailsDir(userID int64) string {
+	return path.Join(pm.getUserKey(userID), "thumbnails")
 }
 
-// EnsureUserDirectories creates storage directories for a user
-func (pm *PhotoManager) EnsureUserDirectories(userID int64) error {
-	dirs := []string{
-		pm.getOriginalsPath(userID),
-		pm.getThumbnailsPath(userID),
-	}
+// getArchivedOriginalsDir returns the storage prefix for a user's archived originals
+func (pm *PhotoManager) getArchivedOriginalsDir(userID int64) string {
+	return path.Join(pm.getUserKey(userID), "archived", "originals")
+}
 
-	for _, dir := range dirs {
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			return fmt.Errorf("failed to create directory %s: %v", dir, err)
-		}
+// getArchivedThumbnailsDir returns the storage prefix for a user's archived thumbnails
+func (pm *PhotoManager) getArchivedThumbnailsDir(userID int64) string {
+	return path.Join(pm.getUserKey(userID), "archived", "thumbnails")
+}
+
+// getOriginalKey returns the storage key of an original photo
+func (pm *PhotoManager) getOriginalKey(userID int64, filename string) string {
+	return path.Join(pm.getOriginalsDir(userID), filename)
+}
+
+// getThumbnailKey returns the storage key of a thumbnail
+func (pm *PhotoManager) getThumbnailKey(userID int64, filename string) string {
+	return path.Join(pm.getThumbnailsDir(userID), filename)
+}
+
+// getArchivedOriginalKey returns the storage key of an archived original photo
+func (pm *PhotoManager) getArchivedOriginalKey(userID int64, filename string) string {
+	return path.Join(pm.getArchivedOriginalsDir(userID), filename)
+}
+
+// getArchivedThumbnailKey returns the storage key of an archived thumbnail
+func (pm *PhotoManager) getArchivedThumbnailKey(userID int64, filename string) string {
+	return path.Join(pm.getArchivedThumbnailsDir(userID), filename)
+}
+
+// keyWithin reports whether key resolves to a file directly inside dir
+// Guards against stored filenames containing ".." or separators
+func keyWithin(dir, key string) bool {
+	return path.Dir(path.Clean(key)) == path.Clean(dir)
+}
+
+// exists reports whether a storage key is present
+func (pm *PhotoManager) exists(key string) bool {
+	_, err := pm.storage.Stat(key)
+	return err == nil
+}
+
+// localPath returns a filesystem path for a storage key
+// Only available when the storage backend keeps plain local files
+func (pm *PhotoManager) localPath(key string) (string, error) {
+	lp, ok := pm.storage.(LocalPather)
+	if !ok {
+		return "", fmt.Errorf("storage backend does not provide local file access")
 	}
+	return lp.LocalPath(key)
+}
 
+// errFileTooLarge is returned by SavePhoto when an upload exceeds max_upload_mb
+var errFileTooLarge = errors.New("file too large")
+
+// errInsufficientStorage is returned when the disk holding the photos is (or
+// would be) full
+var errInsufficientStorage = errors.New("insufficient storage")
+
+// isDiskFull reports whether err came from writing to a full filesystem
+func isDiskFull(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
+
+// CheckFreeSpace returns errInsufficientStorage if storing size more bytes would
+// leave less than MinFreeSpaceMB free on the storage volume
+// Skipped (nil) when the backend or platform can't report free space
+func (pm *PhotoManager) CheckFreeSpace(size int64) error {
+	root, err := pm.localPath("")
+	if err != nil {
+		return nil
+	}
+	free, _, err := diskSpace(root)
+	if err != nil {
+		return nil
+	}
+	if size < 0 {
+		size = 0
+	}
+	if free < uint64(size)+MinFreeSpaceMB<<20 {
+		log.Printf("Warning: only %d MB free in %s; refusing a %d byte upload", free>>20, root, size)
+		return errInsufficientStorage
+	}
 	return nil
 }
 
-// SavePhoto saves an uploaded photo for a user
-func (pm *PhotoManager) SavePhoto(filename string, data []byte, userID int64) (*Photo, error) {
-	// Validate file extension
-	if !isImageFile(filename) {
-		return nil, fmt.Errorf("unsupported file type")
+// DiskSpace returns the free and total bytes of the volume holding the photos
+// Fails when the backend or platform can't report it
+func (pm *PhotoManager) DiskSpace() (free, total uint64, err error) {
+	root, err := pm.localPath("")
+	if err != nil {
+		return 0, 0, err
+	}
+	return diskSpace(root)
+}
+
+// uploadLimiter counts bytes read and fails with errFileTooLarge past limit
+type uploadLimiter struct {
+	r     io.Reader
+	limit int64
+	n     int64
+}
+
+func (l *uploadLimiter) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.n > l.limit {
+		return n, errFileTooLarge
 	}
+	return n, err
+}
+
+// headWriter keeps the first limit bytes written to it and discards the rest
+type headWriter struct {
+	buf   []byte
+	limit int
+}
+
+func (h *headWriter) Write(p []byte) (int, error) {
+	if room := h.limit - len(h.buf); room > 0 {
+		h.buf = append(h.buf, p[:min(room, len(p))]...)
+	}
+	return len(p), nil
+}
 
-	// Validate magic bytes
-	if _, err := validateImageMagicBytes(data); err != nil {
+// SavePhoto streams an uploaded photo to storage for a user
+// The upload is never held in memory: magic bytes are sniffed from the first
+// chunk, and the content hash and EXIF header are captured while it is written.
+// The file is stored with the extension of its actual type, whatever it was uploaded as,
+// or as JPEG when normalize_to_jpeg converts it; that decodes the stored file
+// and encodes straight back to storage, holding only the pixels
+// strategy picks how name collisions are resolved ("" uses the configured default)
+func (pm *PhotoManager) SavePhoto(filename string, r io.Reader, userID int64, strategy string) (*Photo, error) {
+	// The content decides the type; the uploaded name's extension is only a hint
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(12)
+	mimeType, err := validateImageMagicBytes(magic)
+	if err != nil {
 		return nil, fmt.Errorf("invalid image file: %v", err)
 	}
 
-	// Sanitize filename
-	filename = sanitizeFilename(filename)
+	// Sanitize filename, storing it under the extension its content calls for
+	originalName := sanitizeFilename(filename)
 
-	// Ensure user directories exist
-	if err := pm.EnsureUserDirectories(userID); err != nil {
+	// Claim a free name per the collision strategy; held until the upload finishes
+	filename, err = pm.getUniqueFilename(withImageExtension(originalName, mimeType), userID, strategy)
+	if err != nil {
 		return nil, err
 	}
+	defer pm.releaseFilename(filename, userID)
 
-	// Check if file already exists, add suffix if needed
-	filename = pm.getUniqueFilename(filename, userID)
+	originalKey := pm.getOriginalKey(userID, filename)
+	thumbnailKey := pm.getThumbnailKey(userID, filename)
 
-	originalPath := filepath.Join(pm.getOriginalsPath(userID), filename)
-	thumbnailPath := filepath.Join(pm.getThumbnailsPath(userID), filename)
-
-	// Save original
-	if err := os.WriteFile(originalPath, data, 0644); err != nil {
+	// Save original, hashing it and keeping the header for EXIF on the way through
+	hasher := sha256.New()
+	head := &headWriter{limit: ExifScanBytes}
+	limiter := &uploadLimiter{r: br, limit: pm.maxUploadMB << 20}
+	if err := pm.storage.Save(originalKey, io.TeeReader(limiter, io.MultiWriter(hasher, head))); err != nil {
+		// The request body's own cap (http.MaxBytesReader) can trip first
+		var bodyTooLarge *http.MaxBytesError
+		if errors.Is(err, errFileTooLarge) || errors.As(err, &bodyTooLarge) {
+			return nil, errFileTooLarge
+		}
+		if isDiskFull(err) {
+			// Save already removed the partial file
+			log.Printf("Warning: disk full while saving %s for user %d", filename, userID)
+			return nil, errInsufficientStorage
+		}
 		return nil, fmt.Errorf("failed to save photo: %v", err)
 	}
+	contentHash := hex.EncodeToString(hasher.Sum(nil))
+	size := limiter.n
+
+	// Optionally store PNG, WebP and GIF uploads as JPEG, under a .jpg name
+	if pm.normalize.ToJPEG && mimeType != "image/jpeg" {
+		img, err := pm.jpegVersion(originalKey)
+		if err != nil {
+			log.Printf("Warning: keeping %s as uploaded, JPEG conversion failed: %v", filename, err)
+		} else if img != nil {
+			jpegName, err := pm.getUniqueFilename(withImageExtension(originalName, "image/jpeg"), userID, strategy)
+			if err != nil {
+				pm.storage.Delete(originalKey)
+				return nil, err
+			}
+			defer pm.releaseFilename(jpegName, userID)
+
+			jpegKey := pm.getOriginalKey(userID, jpegName)
+			jpegHash, jpegSize, err := pm.saveJPEG(jpegKey, img)
+			pm.storage.Delete(originalKey)
+			if err != nil {
+				if isDiskFull(err) {
+					log.Printf("Warning: disk full while saving %s for user %d", jpegName, userID)
+					return nil, errInsufficientStorage
+				}
+				return nil, fmt.Errorf("failed to save photo: %v", err)
+			}
+
+			filename, originalKey, thumbnailKey = jpegName, jpegKey, pm.getThumbnailKey(userID, jpegName)
+			contentHash, size = jpegHash, jpegSize
+		}
+	}
+
+	// Generate thumbnail (recorded as a failure once the photo has an ID)
+	blurhash, phash, thumbErr := pm.generateThumbnail(originalKey, thumbnailKey)
+	if thumbErr != nil {
+		log.Printf("Warning: failed to generate thumbnail for %s: %v", filename, thumbErr)
+	}
 
-	// Generate thumbnail
-	if err := pm.generateThumbnail(originalPath, thumbnailPath); err != nil {
-		fmt.Printf("Warning: failed to generate thumbnail for %s: %v\n", filename, err)
+	// Read pixel dimensions from the image header (no full decode)
+	width, height := 0, 0
+	if file, err := pm.storage.Open(originalKey); err == nil {
+		if cfg, _, err := image.DecodeConfig(file); err == nil {
+			width, height = cfg.Width, cfg.Height
+		}
+		file.Close()
 	}
 
 	// Save to database
-	photo, err := pm.db.CreatePhoto(filename, userID, int64(len(data)))
+	// Keep the uploaded name for display when it had to be changed
+	if originalName == filename {
+		originalName = ""
+	}
+	photo, err := pm.db.CreatePhoto(filename, originalName, userID, size, width, height, contentHash)
 	if err != nil {
 		// Clean up files if database save fails
-		os.Remove(originalPath)
-		os.Remove(thumbnailPath)
+		pm.storage.Delete(originalKey)
+		pm.storage.Delete(thumbnailKey)
 		return nil, err
 	}
 
-	return photo, nil
-}
+	if thumbErr != nil {
+		pm.recordThumbnailResult(photo.ID, thumbErr)
+	}
 
-// generateThumbnail creates a thumbnail of the image
-func (pm *PhotoManager) generateThumbnail(srcPath, dstPath string) error {
-	src, err := imaging.Open(srcPath)
-	if err != nil {
-		return fmt.Errorf("failed to open image: %v", err)
+	// Record the loading placeholder (best effort)
+	if blurhash != "" {
+		if err := pm.db.SetPhotoBlurhash(photo.ID, blurhash); err == nil {
+			photo.Blurhash = blurhash
+		}
 	}
 
-	thumbnail := imaging.Fit(src, ThumbnailSize, ThumbnailSize, imaging.Lanczos)
+	// Record the perceptual hash for duplicate detection (best effort)
+	if phash != "" {
+		if err := pm.db.SetPhotoPHash(photo.ID, phash); err == nil {
+			photo.PHash = phash
+		}
+	}
 
-	if err := imaging.Save(thumbnail, dstPath); err != nil {
-		return fmt.Errorf("failed to save thumbnail: %v", err)
+	// Record GPS location and capture date if the photo has them (best effort)
+	if exif, err := extractExif(head.buf); err == nil {
+		if exif.HasLocation() {
+			if err := pm.db.SetPhotoLocation(photo.ID, *exif.Latitude, *exif.Longitude); err == nil {
+				photo.Latitude = exif.Latitude
+				photo.Longitude = exif.Longitude
+			}
+		}
+		if exif.TakenAt != nil {
+			if err := pm.db.SetPhotoTakenAt(photo.ID, *exif.TakenAt); err == nil {
+				photo.TakenAt = exif.TakenAt
+			}
+		}
 	}
 
-	return nil
+	return photo, nil
 }
 
-// getUniqueFilename returns a unique filename for a user
-func (pm *PhotoManager) getUniqueFilename(filename string, userID int64) string {
-	originalPath := filepath.Join(pm.getOriginalsPath(userID), filename)
+// resizeThumbnail scales a decoded image down to thumbnail size per the thumbnail mode and filter
+func (pm *PhotoManager) resizeThumbnail(src image.Image) *image.NRGBA {
+	filter, ok := thumbnailFilters[pm.thumbnails.Filter]
+	if !ok {
+		filter = imaging.Lanczos
+	}
 
-	if _, err := os.Stat(originalPath); os.IsNotExist(err) {
-		return filename
+	if pm.thumbnails.Mode == ThumbnailModeFill {
+		// Crop to a square, but never upscale images smaller than the thumbnail
+		size := min(ThumbnailSize, src.Bounds().Dx(), src.Bounds().Dy())
+		return imaging.Fill(src, size, size, imaging.Center, filter)
 	}
+	return imaging.Fit(src, ThumbnailSize, ThumbnailSize, filter)
+}
 
-	// Add counter suffix
-	ext := filepath.Ext(filename)
-	name := filename[:len(filename)-len(ext)]
+// encodeThumbnail encodes a thumbnail at the configured JPEG quality
+func (pm *PhotoManager) encodeThumbnail(thumbnail image.Image, format imaging.Format) ([]byte, error) {
+	quality := pm.thumbnails.Quality
+	if quality == 0 {
+		quality = ThumbnailQuality
+	}
 
-	for i := 1; i < MaxFilenameCounter; i++ {
-		newFilename := fmt.Sprintf("%s_%d%s", name, i, ext)
-		newPath := filepath.Join(pm.getOriginalsPath(userID), newFilename)
-		if _, err := os.Stat(newPath); os.IsNotExist(err) {
-			return newFilename
-		}
+	var buf bytes.Buffer
+	if err := imaging.Encode(&buf, thumbnail, format, imaging.JPEGQuality(quality)); err != nil {
+		return nil, err
 	}
+	return buf.Bytes(), nil
+}
 
-	return filename
+// storesThumbnail reports whether thumbnails of filename are generated and kept;
+// thumbnail_skip_extensions opts types out
+func (pm *PhotoManager) storesThumbnail(filename string) bool {
+	ext := path.Ext(filename)
+	for _, skip := range pm.thumbnails.SkipExtensions {
+		if strings.EqualFold(ext, skip) {
+			return false
+		}
+	}
+	return true
 }
 
-// GetOriginalPath returns the path to an original photo
-func (pm *PhotoManager) GetOriginalPath(photo *Photo) (string, error) {
-	path := filepath.Join(pm.getOriginalsPath(photo.UserID), photo.Filename)
+// decodeImage decodes an image for resizing
+// Animated GIFs yield their first frame drawn onto the full logical screen;
+// the frame itself may only cover part of it, which skews thumbnails
+func decodeImage(r io.Reader, opts ...imaging.DecodeOption) (image.Image, error) {
+	br := bufio.NewReader(r)
+	header, _ := br.Peek(10)
+	if len(header) < 10 || string(header[:4]) != "GIF8" {
+		return imaging.Decode(br, opts...)
+	}
 
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		return "", fmt.Errorf("file not found")
+	// gif.Decode stops after the first frame
+	frame, err := gif.Decode(br)
+	if err != nil {
+		return nil, err
+	}
+
+	// Logical screen size from the GIF header
+	width := int(binary.LittleEndian.Uint16(header[6:8]))
+	height := int(binary.LittleEndian.Uint16(header[8:10]))
+	bounds := frame.Bounds()
+	if width == 0 || height == 0 || bounds == image.Rect(0, 0, width, height) {
+		return frame, nil
 	}
 
-	return path, nil
+	canvas := image.NewNRGBA(image.Rect(0, 0, width, height).Union(bounds))
+	draw.Draw(canvas, bounds, frame, bounds.Min, draw.Over)
+	return canvas, nil
 }
 
-// GetThumbnailPath returns the path to a thumbnail
-func (pm *PhotoManager) GetThumbnailPath(photo *Photo) (string, error) {
-	path := filepath.Join(pm.getThumbnailsPath(photo.UserID), photo.Filename)
+// generateThumbnail creates a thumbnail of the image
+// Returns the blurhash of the thumbnail and the perceptual hash of the source,
+// or "" for either if it could not be computed
+func (pm *PhotoManager) generateThumbnail(srcKey, dstKey string) (string, string, error) {
+	file, err := pm.storage.Open(srcKey)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to open image: %v", err)
+	}
+	src, err := decodeImage(file)
+	file.Close()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to open image: %v", err)
+	}
+
+	phash := formatPHash(computeDHash(src))
+	thumbnail := pm.resizeThumbnail(src)
 
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		// Try to regenerate thumbnail
-		originalPath, err := pm.GetOriginalPath(photo)
+	// Types opted out of stored thumbnails still get their hashes
+	if pm.storesThumbnail(dstKey) {
+		format, err := imaging.FormatFromFilename(dstKey)
 		if err != nil {
-			return "", fmt.Errorf("file not found")
+			return "", "", fmt.Errorf("failed to save thumbnail: %v", err)
 		}
 
-		if err := pm.generateThumbnail(originalPath, path); err != nil {
-			return "", fmt.Errorf("failed to generate thumbnail: %v", err)
+		data, err := pm.encodeThumbnail(thumbnail, format)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to save thumbnail: %v", err)
 		}
+		if err := pm.storage.Save(dstKey, bytes.NewReader(data)); err != nil {
+			return "", "", fmt.Errorf("failed to save thumbnail: %v", err)
+		}
+		pm.cache.added(dstKey)
+	}
+
+	blurhash, err := encodeBlurhash(thumbnail)
+	if err != nil {
+		log.Printf("Warning: failed to compute blurhash for %s: %v", dstKey, err)
+		return "", phash, nil
 	}
 
-	return path, nil
+	return blurhash, phash, nil
 }
 
-// DeletePhoto deletes a photo and its files
-func (pm *PhotoManager) DeletePhoto(photo *Photo) error {
-	originalPath := filepath.Join(pm.getOriginalsPath(photo.UserID), photo.Filename)
-	thumbnailPath := filepath.Join(pm.getThumbnailsPath(photo.UserID), photo.Filename)
+// RebuildBlurhashes computes blurhashes from existing thumbnails
+// If force is false, only photos without a blurhash are processed
+// Returns the number of photos updated and the number that failed
+func (pm *PhotoManager) RebuildBlurhashes(force bool) (updated, failed int, err error) {
+	photos, err := pm.db.GetPhotosForBlurhash(force)
+	if err != nil {
+		return 0, 0, err
+	}
 
-	// Delete embedding if exists
-	pm.db.DeleteEmbedding(photo.ID)
+	for _, photo := range photos {
+		file, _, openErr := pm.OpenThumbnail(photo, false)
+		if openErr != nil {
+			log.Printf("Blurhash rebuild: no thumbnail for photo %d: %v", photo.ID, openErr)
+			failed++
+			continue
+		}
 
-	// Delete from database first
-	if err := pm.db.DeletePhoto(photo.ID); err != nil {
-		return fmt.Errorf("failed to delete photo record: %v", err)
-	}
+		img, decodeErr := decodeImage(file)
+		file.Close()
+		if decodeErr != nil {
+			log.Printf("Blurhash rebuild: failed to decode thumbnail of photo %d: %v", photo.ID, decodeErr)
+			failed++
+			continue
+		}
 
-	// Delete files
-	os.Remove(originalPath)
-	os.Remove(thumbnailPath)
+		blurhash, hashErr := encodeBlurhash(img)
+		if hashErr != nil {
+			log.Printf("Blurhash rebuild: failed for photo %d: %v", photo.ID, hashErr)
+			failed++
+			continue
+		}
 
-	return nil
-}
+		if err := pm.db.SetPhotoBlurhash(photo.ID, blurhash); err != nil {
+			log.Printf("Blurhash rebuild: failed to update photo %d: %v", photo.ID, err)
+			failed++
+			continue
+		}
+		updated++
+	}
 
-// getArchivePath returns the archive storage path for a user
-func (pm *PhotoManager) getArchivePath(userID int64) string {
-	return filepath.Join(pm.getUserPath(userID), "archived")
+	return updated, failed, nil
 }
 
-// getArchivedOriginalsPath returns the path to archived originals for a user
-func (pm *PhotoManager) getArchivedOriginalsPath(userID int64) string {
-	return filepath.Join(pm.getArchivePath(userID), "originals")
-}
+// RepairThumbnails regenerates thumbnails whose file is missing
+// If userID is 0, photos of all users are checked
+// If force is true, every thumbnail is regenerated (e.g. after changing thumbnail_mode)
+// Returns how many thumbnails were missing (or forced), how many were regenerated and how many failed
+func (pm *PhotoManager) RepairThumbnails(userID int64, force bool) (missing, repaired, failed int, err error) {
+	photos, err := pm.db.GetPhotosIncludingArchived(userID)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	for _, photo := range photos {
+		originalKey := pm.getOriginalKey(photo.UserID, photo.Filename)
+		thumbnailKey := pm.getThumbnailKey(photo.UserID, photo.Filename)
+		if photo.IsArchived {
+			originalKey = pm.getArchivedOriginalKey(photo.UserID, photo.Filename)
+			thumbnailKey = pm.getArchivedThumbnailKey(photo.UserID, photo.Filename)
+		}
+
+		if !pm.storesThumbnail(photo.Filename) || (!force && pm.exists(thumbnailKey)) {
+			continue
+		}
+		missing++
+
+		blurhash, _, genErr := pm.generateThumbnail(originalKey, thumbnailKey)
+		pm.recordThumbnailResult(photo.ID, genErr)
+		if genErr != nil {
+			log.Printf("Thumbnail repair: failed for photo %d (%s): %v", photo.ID, photo.Filename, genErr)
+			failed++
+			continue
+		}
+
+		// Keep the placeholder in sync with the new thumbnail
+		if blurhash != "" {
+			pm.db.SetPhotoBlurhash(photo.ID, blurhash)
+		}
+		repaired++
+	}
 
-// getArchivedThumbnailsPath returns the path to archived thumbnails for a user
-func (pm *PhotoManager) getArchivedThumbnailsPath(userID int64) string {
-	return filepath.Join(pm.getArchivePath(userID), "thumbnails")
+	return missing, repaired, failed, nil
 }
 
-// EnsureArchiveDirectories creates archive storage directories for a user
-func (pm *PhotoManager) EnsureArchiveDirectories(userID int64) error {
-	dirs := []string{
-		pm.getArchivedOriginalsPath(userID),
-		pm.getArchivedThumbnailsPath(userID),
+// BackfillDimensions measures photos uploaded before dimensions were stored
+// Photos that can't be measured are recorded as 0x0 so they aren't retried
+func (pm *PhotoManager) BackfillDimensions() {
+	photos, err := pm.db.GetPhotosWithoutDimensions()
+	if err != nil {
+		log.Printf("Dimension backfill: failed to list photos: %v", err)
+		return
+	}
+	if len(photos) == 0 {
+		return
 	}
 
-	for _, dir := range dirs {
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			return fmt.Errorf("failed to create archive directory %s: %v", dir, err)
+	measured := 0
+	for _, photo := range photos {
+		width, height := 0, 0
+
+		if file, _, err := pm.OpenOriginal(photo); err == nil {
+			if cfg, _, err := image.DecodeConfig(file); err == nil {
+				width, height = cfg.Width, cfg.Height
+				measured++
+			}
+			file.Close()
+		}
+
+		if err := pm.db.SetPhotoDimensions(photo.ID, width, height); err != nil {
+			log.Printf("Dimension backfill: failed to update photo %d: %v", photo.ID, err)
 		}
 	}
 
-	return nil
+	log.Printf("Dimension backfill: measured %d of %d photo(s)", measured, len(photos))
 }
 
-// ArchivePhoto moves a photo to the archive folder
-func (pm *PhotoManager) ArchivePhoto(photo *Photo) error {
-	// Ensure archive directories exist
-	if err := pm.EnsureArchiveDirectories(photo.UserID); err != nil {
-		return err
+// getUniqueFilename reserves a filename no other photo of the user has
+// The check and the reservation happen under one lock, so concurrent uploads of
+// the same name get different names. Archived originals count as taken so
+// unarchiving never overwrites a newer upload. Callers must releaseFilename
+// once the original is saved (or the upload failed)
+func (pm *PhotoManager) getUniqueFilename(filename string, userID int64, strategy string) (string, error) {
+	if strategy == "" {
+		strategy = pm.nameStrategy
 	}
 
-	// Current paths
-	originalPath := filepath.Join(pm.getOriginalsPath(photo.UserID), photo.Filename)
-	thumbnailPath := filepath.Join(pm.getThumbnailsPath(photo.UserID), photo.Filename)
+	pm.namesMu.Lock()
+	defer pm.namesMu.Unlock()
 
-	// Archive paths
-	archivedOriginalPath := filepath.Join(pm.getArchivedOriginalsPath(photo.UserID), photo.Filename)
-	archivedThumbnailPath := filepath.Join(pm.getArchivedThumbnailsPath(photo.UserID), photo.Filename)
+	ext := filepath.Ext(filename)
+	name := filename[:len(filename)-len(ext)]
 
-	// Move original file
-	if err := os.Rename(originalPath, archivedOriginalPath); err != nil {
-		return fmt.Errorf("failed to archive original: %v", err)
+	switch strategy {
+	case FilenameStrategyUUID:
+		// Opaque name; a collision would take astronomically bad luck, so just retry
+		for i := 0; i < MaxFilenameCounter; i++ {
+			id, err := generateUUID()
+			if err != nil {
+				return "", fmt.Errorf("failed to generate filename: %v", err)
+			}
+			newFilename := id + strings.ToLower(ext)
+			if pm.filenameFree(newFilename, userID) {
+				pm.reserved[pm.getOriginalKey(userID, newFilename)] = true
+				return newFilename, nil
+			}
+		}
+		return "", fmt.Errorf("failed to generate a free filename")
+	case FilenameStrategyTimestamp:
+		if pm.filenameFree(filename, userID) {
+			break
+		}
+		// Same name within the same second falls through to the counter below
+		name = fmt.Sprintf("%s_%s", name, time.Now().Format("20060102-150405"))
+		filename = name + ext
 	}
 
-	// Move thumbnail (if exists)
-	if _, err := os.Stat(thumbnailPath); err == nil {
-		if err := os.Rename(thumbnailPath, archivedThumbnailPath); err != nil {
-			// Try to restore original if thumbnail move fails
-			os.Rename(archivedOriginalPath, originalPath)
-			return fmt.Errorf("failed to archive thumbnail: %v", err)
-		}
+	if pm.filenameFree(filename, userID) {
+		pm.reserved[pm.getOriginalKey(userID, filename)] = true
+		return filename, nil
 	}
 
-	// Update database
-	if err := pm.db.ArchivePhoto(photo.ID); err != nil {
-		// Try to restore files if database update fails
-		os.Rename(archivedOriginalPath, originalPath)
-		os.Rename(archivedThumbnailPath, thumbnailPath)
-		return fmt.Errorf("failed to update database: %v", err)
+	// Add counter suffix
+	for i := 1; i < MaxFilenameCounter; i++ {
+		newFilename := fmt.Sprintf("%s_%d%s", name, i, ext)
+		if pm.filenameFree(newFilename, userID) {
+			pm.reserved[pm.getOriginalKey(userID, newFilename)] = true
+			return newFilename, nil
+		}
 	}
 
-	return nil
+	return "", fmt.Errorf("too many photos named %s", filename)
 }
 
-// UnarchivePhoto restores a photo from the archive
-func (pm *PhotoManager) UnarchivePhoto(photo *Photo) error {
-	// Archived paths
-	archivedOriginalPath := filepath.Join(pm.getArchivedOriginalsPath(photo.UserID), photo.Filename)
-	archivedThumbnailPath := filepath.Join(pm.getArchivedThumbnailsPath(photo.UserID), photo.Filename)
+// filenameFree reports whether a filename is unused and unreserved; namesMu must be held
+func (pm *PhotoManager) filenameFree(filename string, userID int64) bool {
+	return !pm.reserved[pm.getOriginalKey(userID, filename)] &&
+		!pm.exists(pm.getOriginalKey(userID, filename)) &&
+		!pm.exists(pm.getArchivedOriginalKey(userID, filename))
+}
 
-	// Destination paths
-	originalPath := filepath.Join(pm.getOriginalsPath(photo.UserID), photo.Filename)
-	thumbnailPath := filepath.Join(pm.getThumbnailsPath(photo.UserID), photo.Filename)
+// releaseFilename drops a reservation made by getUniqueFilename
+func (pm *PhotoManager) releaseFilename(filename string, userID int64) {
+	pm.namesMu.Lock()
+	delete(pm.reserved, pm.getOriginalKey(userID, filename))
+	pm.namesMu.Unlock()
+}
 
-	// Move original file
-	if err := os.Rename(archivedOriginalPath, originalPath); err != nil {
-		return fmt.Errorf("failed to restore original: %v", err)
+// OpenOriginal opens the original file of a photo, archived or not
+func (pm *PhotoManager) OpenOriginal(photo *Photo) (io.ReadSeekCloser, fs.FileInfo, error) {
+	dir := pm.getOriginalsDir(photo.UserID)
+	if photo.IsArchived {
+		dir = pm.getArchivedOriginalsDir(photo.UserID)
 	}
 
-	// Move thumbnail (if exists)
-	if _, err := os.Stat(archivedThumbnailPath); err == nil {
-		if err := os.Rename(archivedThumbnailPath, thumbnailPath); err != nil {
-			// Try to restore to archive if move fails
-			os.Rename(originalPath, archivedOriginalPath)
-			return fmt.Errorf("failed to restore thumbnail: %v", err)
-		}
+	key := path.Join(dir, photo.Filename)
+	if !keyWithin(dir, key) {
+		return nil, nil, fmt.Errorf("invalid filename")
 	}
 
-	// Update database
-	if err := pm.db.UnarchivePhoto(photo.ID); err != nil {
-		// Try to restore to archive if database update fails
-		os.Rename(originalPath, archivedOriginalPath)
-		os.Rename(thumbnailPath, archivedThumbnailPath)
-		return fmt.Errorf("failed to update database: %v", err)
+	return pm.open(key)
+}
+
+// errThumbnailUnavailable means a photo's thumbnail can't be generated (e.g. a corrupt original)
+var errThumbnailUnavailable = errors.New("thumbnail could not be generated")
+
+// OpenThumbnail opens the thumbnail of a photo, archived or not
+// Missing thumbnails of active photos are regenerated; types that skip stored
+// thumbnails are resized on the fly. When the original itself is the
+// thumbnail, stripped serves it without its metadata (see OpenStripped)
+func (pm *PhotoManager) OpenThumbnail(photo *Photo, stripped bool) (io.ReadSeekCloser, fs.FileInfo, error) {
+	// GIF thumbnails are static posters unless animation is enabled
+	if pm.thumbnails.AnimatedGIFs && strings.EqualFold(path.Ext(photo.Filename), ".gif") {
+		return pm.openOriginal(photo, stripped)
 	}
 
-	return nil
-}
+	if !pm.storesThumbnail(photo.Filename) {
+		return pm.renderThumbnail(photo, stripped)
+	}
 
-// GetArchivedOriginalPath returns the path to an archived original photo
-func (pm *PhotoManager) GetArchivedOriginalPath(photo *Photo) (string, error) {
-	path := filepath.Join(pm.getArchivedOriginalsPath(photo.UserID), photo.Filename)
+	dir := pm.getThumbnailsDir(photo.UserID)
+	if photo.IsArchived {
+		dir = pm.getArchivedThumbnailsDir(photo.UserID)
+	}
 
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		return "", fmt.Errorf("archived file not found")
+	key := path.Join(dir, photo.Filename)
+	if !keyWithin(dir, key) {
+		return nil, nil, fmt.Errorf("invalid filename")
 	}
 
-	return path, nil
-}
+	if photo.IsArchived {
+		// Dropped on archive to save space, or missing because it was evicted
+		// before archiving; resize without storing it again
+		if photo.ThumbnailDropped || !pm.exists(key) {
+			return pm.renderThumbnail(photo, stripped)
+		}
+		return pm.open(key)
+	}
+
+	if !pm.exists(key) {
+		originalKey := pm.getOriginalKey(photo.UserID, photo.Filename)
+		if !pm.exists(originalKey) {
+			return nil, nil, fmt.Errorf("file not found")
+		}
 
-// GetArchivedThumbnailPath returns the path to an archived thumbnail
-func (pm *PhotoManager) GetArchivedThumbnailPath(photo *Photo) (string, error) {
-	path := filepath.Join(pm.getArchivedThumbnailsPath(photo.UserID), photo.Filename)
+		// Photos that already failed aren't retried on every gallery load;
+		// the repair endpoint retries them
+		if failed, err := pm.db.HasThumbnailFailure(photo.ID); err == nil && failed {
+			return nil, nil, errThumbnailUnavailable
+		}
 
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		return "", fmt.Errorf("archived thumbnail not found")
+		_, _, err := pm.generateThumbnail(originalKey, key)
+		pm.recordThumbnailResult(photo.ID, err)
+		if err != nil {
+			log.Printf("Failed to regenerate thumbnail for photo %d (%s): %v", photo.ID, photo.Filename, err)
+			return nil, nil, fmt.Errorf("%w: %v", errThumbnailUnavailable, err)
+		}
+	} else {
+		pm.cache.touch(key)
 	}
 
-	return path, nil
+	return pm.open(key)
 }
 
-// BuildPhotoURLs adds URL fields to a photo
-func (pm *PhotoManager) BuildPhotoURLs(photo *Photo) {
-	photo.ThumbnailURL = fmt.Sprintf("/api/photos/thumbnail/%d/%s", photo.UserID, url.PathEscape(photo.Filename))
-	photo.OriginalURL = fmt.Sprintf("/api/photos/original/%d/%s", photo.UserID, url.PathEscape(photo.Filename))
+// memFile serves an in-memory image where a file is expected
+type memFile struct {
+	*bytes.Reader
 }
 
-// API Handlers
+func (memFile) Close() error { return nil }
 
-// HandleUpload handles photo upload requests
-func (app *App) HandleUpload(w http.ResponseWriter, r *http.Request) {
-	session, err := app.sessionMgr.ValidateSession(r)
+// renderThumbnail resizes a photo's original on request, for types whose
+// thumbnails aren't stored. Originals that already fit in a thumbnail are
+// served as they are, without their metadata if stripped is set
+func (pm *PhotoManager) renderThumbnail(photo *Photo, stripped bool) (io.ReadSeekCloser, fs.FileInfo, error) {
+	file, info, err := pm.openOriginal(photo, stripped)
 	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
+		return nil, nil, err
 	}
 
-	if err := app.sessionMgr.ValidateCSRF(r, session); err != nil {
-		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
-		return
+	small := false
+	if cfg, _, err := image.DecodeConfig(file); err == nil {
+		small = cfg.Width <= ThumbnailSize && cfg.Height <= ThumbnailSize
 	}
-
-	if err := r.ParseMultipartForm(app.config.MaxUploadMB << 20); err != nil {
-		http.Error(w, "Failed to parse upload", http.StatusBadRequest)
-		return
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	if small {
+		return file, info, nil
 	}
 
-	file, header, err := r.FormFile("photo")
+	src, err := decodeImage(file)
+	file.Close()
 	if err != nil {
-		http.Error(w, "No file uploaded", http.StatusBadRequest)
-		return
+		return nil, nil, fmt.Errorf("%w: %v", errThumbnailUnavailable, err)
 	}
-	defer file.Close()
 
-	maxSize := app.config.MaxUploadMB << 20
-	if header.Size > maxSize {
-		http.Error(w, fmt.Sprintf("File too large (max %dMB)", app.config.MaxUploadMB), http.StatusBadRequest)
-		return
+	// Formats imaging can't write (WebP) are sent as JPEG; serveThumbnail types by content
+	format, err := imaging.FormatFromFilename(photo.Filename)
+	if err != nil {
+		format = imaging.JPEG
 	}
 
-	// SECURITY: Use LimitReader to prevent memory exhaustion even if header.Size is spoofed
-	limitedReader := io.LimitReader(file, maxSize+1) // +1 to detect oversized files
-	data, err := io.ReadAll(limitedReader)
+	data, err := pm.encodeThumbnail(pm.resizeThumbnail(src), format)
 	if err != nil {
-		http.Error(w, "Failed to read file", http.StatusInternalServerError)
-		return
+		return nil, nil, fmt.Errorf("%w: %v", errThumbnailUnavailable, err)
 	}
+	return memFile{bytes.NewReader(data)}, info, nil
+}
 
-	// Double-check size after reading (in case header was spoofed)
-	if int64(len(data)) > maxSize {
-		http.Error(w, fmt.Sprintf("File too large (max %dMB)", app.config.MaxUploadMB), http.StatusBadRequest)
+// recordThumbnailResult keeps the thumbnail failure list current after a
+// generation attempt (err is nil on success); best effort
+func (pm *PhotoManager) recordThumbnailResult(photoID int64, err error) {
+	if err == nil {
+		pm.db.ClearThumbnailFailure(photoID)
 		return
 	}
+	if dbErr := pm.db.RecordThumbnailFailure(photoID, err.Error()); dbErr != nil {
+		log.Printf("Warning: %v", dbErr)
+	}
+}
 
-	photo, err := app.photoMgr.SavePhoto(header.Filename, data, session.UserID)
+// open opens a storage key along with its file info
+func (pm *PhotoManager) open(key string) (io.ReadSeekCloser, fs.FileInfo, error) {
+	info, err := pm.storage.Stat(key)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to save photo: %v", err), http.StatusInternalServerError)
-		return
+		return nil, nil, fmt.Errorf("file not found")
 	}
 
-	app.photoMgr.BuildPhotoURLs(photo)
+	file, err := pm.storage.Open(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open file: %v", err)
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"status":  "success",
-		"message": "Photo uploaded successfully",
-		"photo":   photo,
-	})
+	return file, info, nil
 }
 
-// HandleListMyPhotos lists photos for the current user
-func (app *App) HandleListMyPhotos(w http.ResponseWriter, r *http.Request) {
-	session, err := app.sessionMgr.ValidateSession(r)
+// sniffImageType checks the magic bytes of an opened photo file
+// Returns the detected MIME type and rewinds the file for serving
+func sniffImageType(file io.ReadSeeker) (string, error) {
+	header := make([]byte, 12)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "", fmt.Errorf("failed to read file: %v", err)
+	}
+
+	mimeType, err := validateImageMagicBytes(header[:n])
 	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return "", err
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to rewind file: %v", err)
+	}
+
+	return mimeType, nil
+}
+
+// GetOriginalPath returns the local path to an original photo
+// Used by features that pass files to external tools
+func (pm *PhotoManager) GetOriginalPath(photo *Photo) (string, error) {
+	key := pm.getOriginalKey(photo.UserID, photo.Filename)
+
+	if !pm.exists(key) {
+		return "", fmt.Errorf("file not found")
+	}
+
+	return pm.localPath(key)
+}
+
+// DeletePhoto deletes a photo and its files
+// The record (and its embedding) goes first, in one transaction; a crash
+// afterwards only leaves orphaned files, never a record without files
+func (pm *PhotoManager) DeletePhoto(photo *Photo) error {
+	if err := pm.db.DeletePhoto(photo.ID); err != nil {
+		return fmt.Errorf("failed to delete photo record: %v", err)
+	}
+
+	// Delete files from both locations; whichever doesn't exist is skipped
+	keys := []string{
+		pm.getOriginalKey(photo.UserID, photo.Filename),
+		pm.getThumbnailKey(photo.UserID, photo.Filename),
+		pm.getArchivedOriginalKey(photo.UserID, photo.Filename),
+		pm.getArchivedThumbnailKey(photo.UserID, photo.Filename),
+	}
+	for _, key := range keys {
+		if err := pm.storage.Delete(key); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Warning: failed to delete %s: %v", key, err)
+		}
+	}
+
+	if err := pm.deleteVariants(photo); err != nil {
+		log.Printf("Warning: failed to delete variants of %s: %v", photo.Filename, err)
+	}
+
+	return nil
+}
+
+// ArchivePhoto moves a photo to the archive folder
+// Cached variants are dropped since archived photos are served as-is
+func (pm *PhotoManager) ArchivePhoto(photo *Photo) error {
+	err := pm.relocatePhoto(
+		pm.getOriginalKey(photo.UserID, photo.Filename),
+		pm.getThumbnailKey(photo.UserID, photo.Filename),
+		pm.getArchivedOriginalKey(photo.UserID, photo.Filename),
+		pm.getArchivedThumbnailKey(photo.UserID, photo.Filename),
+		func() error { return pm.db.ArchivePhoto(photo.ID) },
+	)
+	if err != nil {
+		return err
+	}
+
+	if err := pm.deleteVariants(photo); err != nil {
+		log.Printf("Warning: failed to delete variants of %s: %v", photo.Filename, err)
+	}
+
+	// A live thumbnail evicted by the cache had nothing to move, and archived
+	// thumbnails aren't regenerated; mark it dropped so it is rendered instead
+	if pm.thumbnails.DropOnArchive || (pm.storesThumbnail(photo.Filename) && !pm.exists(pm.getArchivedThumbnailKey(photo.UserID, photo.Filename))) {
+		pm.dropArchivedThumbnail(photo)
+	}
+	return nil
+}
+
+// dropArchivedThumbnail deletes an archived photo's thumbnail to save disk space
+// The flag is set first so serving never looks for the missing file; best effort
+func (pm *PhotoManager) dropArchivedThumbnail(photo *Photo) {
+	if err := pm.db.SetThumbnailDropped(photo.ID, true); err != nil {
+		log.Printf("Warning: failed to mark thumbnail of %s as dropped: %v", photo.Filename, err)
+		return
+	}
+	photo.ThumbnailDropped = true
+
+	if err := pm.storage.Delete(pm.getArchivedThumbnailKey(photo.UserID, photo.Filename)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Warning: failed to delete archived thumbnail of %s: %v", photo.Filename, err)
+	}
+}
+
+// UnarchivePhoto restores a photo from the archive
+// A thumbnail dropped on archive is regenerated the next time it is served
+func (pm *PhotoManager) UnarchivePhoto(photo *Photo) error {
+	return pm.relocatePhoto(
+		pm.getArchivedOriginalKey(photo.UserID, photo.Filename),
+		pm.getArchivedThumbnailKey(photo.UserID, photo.Filename),
+		pm.getOriginalKey(photo.UserID, photo.Filename),
+		pm.getThumbnailKey(photo.UserID, photo.Filename),
+		func() error { return pm.db.UnarchivePhoto(photo.ID) },
+	)
+}
+
+// relocatePhoto moves a photo's original and thumbnail, then updates its record
+// Files move first and are moved back if the update fails. If a crash
+// interrupts it, a retry finds the files already at the destination, treats
+// those moves as done and finishes the update, so retrying is always safe
+func (pm *PhotoManager) relocatePhoto(srcOriginal, srcThumbnail, dstOriginal, dstThumbnail string, update func() error) error {
+	if err := pm.moveFile(srcOriginal, dstOriginal); err != nil {
+		return fmt.Errorf("failed to move original: %v", err)
+	}
+
+	// Thumbnails are optional (they can be regenerated)
+	if err := pm.moveFile(srcThumbnail, dstThumbnail); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		pm.moveFile(dstOriginal, srcOriginal)
+		return fmt.Errorf("failed to move thumbnail: %v", err)
+	}
+
+	if err := update(); err != nil {
+		pm.moveFile(dstOriginal, srcOriginal)
+		pm.moveFile(dstThumbnail, srcThumbnail)
+		return fmt.Errorf("failed to update database: %v", err)
+	}
+
+	return nil
+}
+
+// moveFile renames src to dst
+// If src is gone but dst exists, an earlier attempt already moved it and
+// that counts as success; if neither exists the error wraps fs.ErrNotExist
+func (pm *PhotoManager) moveFile(src, dst string) error {
+	if pm.exists(src) {
+		return pm.storage.Rename(src, dst)
+	}
+	if pm.exists(dst) {
+		return nil
+	}
+	return fmt.Errorf("%s: %w", src, fs.ErrNotExist)
+}
+
+// DiskUsage walks the users directory and returns bytes on disk per user ID
+// (originals, thumbnails, archive and cached variants) plus the overall total
+// Only available when the storage backend keeps plain local files
+func (pm *PhotoManager) DiskUsage() (map[int64]int64, int64, error) {
+	root, err := pm.localPath("users")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	perUser := make(map[int64]int64)
+	var total int64
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil // removed while walking
+		}
+		total += info.Size()
+
+		// Attribute the file to the users/{id} directory it lives under
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return nil
+		}
+		first, _, _ := strings.Cut(filepath.ToSlash(rel), "/")
+		if userID, err := strconv.ParseInt(first, 10, 64); err == nil {
+			perUser[userID] += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to measure disk usage: %v", err)
+	}
+
+	return perUser, total, nil
+}
+
+// sortPhotos reorders a listing for the ?sort= parameter
+// "taken" orders by capture date (newest first); anything else keeps upload order
+func sortPhotos(photos []*Photo, order string) {
+	if order != "taken" {
+		return
+	}
+	sort.SliceStable(photos, func(i, j int) bool {
+		return photos[i].CapturedAt().After(photos[j].CapturedAt())
+	})
+}
+
+// BuildPhotoURLs adds URL fields to a photo
+func (pm *PhotoManager) BuildPhotoURLs(photo *Photo) {
+	photo.ThumbnailURL = fmt.Sprintf("/api/photos/%d/thumbnail", photo.ID)
+	photo.OriginalURL = fmt.Sprintf("/api/photos/%d/original", photo.ID)
+}
+
+// API Handlers
+
+// canViewPhoto reports whether a session may see a photo at all
+// Handlers answer 404 for photos that fail this check, exactly as for missing
+// ones, so probing user IDs, filenames or photo IDs reveals nothing
+func canViewPhoto(session *Session, photo *Photo) bool {
+	if photo.UserID == session.UserID || session.IsAdmin() {
+		return true
+	}
+	// Archived photos stay private even if they were shared, and shared ones are
+	// only visible within the group they were shared to
+	return photo.IsShared && !photo.IsArchived && photo.GroupID == session.GroupID
+}
+
+// Errors returned by openUploadedFile
+var (
+	errNoUploadedFile  = errors.New("no file uploaded")
+	errMalformedUpload = errors.New("failed to parse upload")
+)
+
+// openUploadedFile returns the file sent in a multipart form field without buffering it
+// The body is read part by part; if it was already parsed (e.g. by a CSRF token
+// sent as a form field) the spooled copy is used instead
+func openUploadedFile(r *http.Request, field string) (io.ReadCloser, string, error) {
+	if r.MultipartForm != nil {
+		file, header, err := r.FormFile(field)
+		if err != nil {
+			return nil, "", errNoUploadedFile
+		}
+		return file, header.Filename, nil
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, "", errMalformedUpload
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, "", errNoUploadedFile
+		}
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				return nil, "", errFileTooLarge
+			}
+			return nil, "", errMalformedUpload
+		}
+		if part.FormName() == field && part.FileName() != "" {
+			return part, part.FileName(), nil
+		}
+		part.Close()
+	}
+}
+
+// HandleUpload handles photo upload requests
+func (app *App) HandleUpload(w http.ResponseWriter, r *http.Request) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Cap the whole body before anything reads it; without an X-CSRF-Token
+	// header the CSRF check parses the multipart form to find the token
+	maxBody := app.config.MaxUploadMB<<20 + UploadOverheadBytes
+	if r.ContentLength > maxBody {
+		http.Error(w, fmt.Sprintf("File too large (max %dMB)", app.config.MaxUploadMB), http.StatusRequestEntityTooLarge)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBody)
+
+	// FormValue swallows parse errors, so parse here to tell a body over the
+	// cap (no Content-Length) from a missing token
+	if r.Header.Get("X-CSRF-Token") == "" && bearerToken(r) == "" {
+		var tooLarge *http.MaxBytesError
+		if err := r.ParseMultipartForm(UploadMemoryBytes); errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("File too large (max %dMB)", app.config.MaxUploadMB), http.StatusRequestEntityTooLarge)
+			return
+		}
+	}
+
+	if err := app.sessionMgr.ValidateCSRF(r, session); err != nil {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	// Refuse early when the body clearly won't fit (ContentLength is -1 if unknown)
+	if err := app.photoMgr.CheckFreeSpace(r.ContentLength); err != nil {
+		http.Error(w, "Not enough disk space on the server", http.StatusInsufficientStorage)
+		return
+	}
+
+	// Optional per-upload override of the filename collision strategy
+	strategy := r.URL.Query().Get("filename_strategy")
+	if strategy != "" && !isFilenameStrategy(strategy) {
+		http.Error(w, fmt.Sprintf("filename_strategy must be %q, %q or %q", FilenameStrategySuffix, FilenameStrategyTimestamp, FilenameStrategyUUID), http.StatusBadRequest)
+		return
+	}
+
+	file, filename, err := openUploadedFile(r, "photo")
+	if errors.Is(err, errNoUploadedFile) {
+		http.Error(w, "No file uploaded", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, errFileTooLarge) {
+		http.Error(w, fmt.Sprintf("File too large (max %dMB)", app.config.MaxUploadMB), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to parse upload", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	// SavePhoto enforces the size limit while streaming, whatever the client claims
+	photo, err := app.photoMgr.SavePhoto(filename, file, session.UserID, strategy)
+	if errors.Is(err, errFileTooLarge) {
+		http.Error(w, fmt.Sprintf("File too large (max %dMB)", app.config.MaxUploadMB), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if errors.Is(err, errInsufficientStorage) {
+		http.Error(w, "Not enough disk space on the server", http.StatusInsufficientStorage)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to save photo: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Apply the default visibility (best effort)
+	if app.shareUploadsByDefault(session.UserID) {
+		if err := app.db.SetPhotoShared(photo.ID, true); err == nil {
+			photo.IsShared = true
+		}
+	}
+
+	app.photoMgr.BuildPhotoURLs(photo)
+
+	// Keep the similarity index current (no-op unless auto_embed_on_upload is set)
+	app.autoEmbedder.Enqueue(session.UserID, photo.ID)
+
+	// Earlier uploads of the exact same file (best effort)
+	duplicateOf := make([]int64, 0)
+	if photo.ContentHash != "" {
+		if ids, err := app.db.GetPhotoIDsByContentHash(session.UserID, photo.ContentHash); err == nil {
+			for _, id := range ids {
+				if id != photo.ID {
+					duplicateOf = append(duplicateOf, id)
+				}
+			}
+		}
+	}
+
+	// EXIF fields that were found, so the client can show them without another request
+	exif := map[string]interface{}{}
+	if photo.TakenAt != nil {
+		exif["taken_at"] = photo.TakenAt
+	}
+	if photo.Latitude != nil && photo.Longitude != nil {
+		exif["latitude"] = *photo.Latitude
+		exif["longitude"] = *photo.Longitude
+	}
+
+	message := "Photo uploaded successfully"
+	if len(duplicateOf) > 0 {
+		message = "Photo uploaded (duplicate of an existing photo)"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "success",
+		"message": message,
+		"photo":   photo,
+		"metadata": map[string]interface{}{
+			"width":        photo.Width,
+			"height":       photo.Height,
+			"size":         photo.Size,
+			"content_hash": photo.ContentHash,
+			"exif":         exif,
+		},
+		"duplicate":    len(duplicateOf) > 0,
+		"duplicate_of": duplicateOf,
+	})
+}
+
+// HandleRepairThumbnails regenerates missing thumbnails
+// Regular users repair their own photos; admins repair every user's photos
+// ?force=1 regenerates all thumbnails in scope, not just missing ones
+func (app *App) HandleRepairThumbnails(w http.ResponseWriter, r *http.Request) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := app.sessionMgr.ValidateCSRF(r, session); err != nil {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	userID := session.UserID
+	if session.IsAdmin() {
+		userID = 0
+	}
+
+	force := r.URL.Query().Get("force") == "1"
+
+	missing, repaired, failed, err := app.photoMgr.RepairThumbnails(userID, force)
+	if err != nil {
+		http.Error(w, "Failed to repair thumbnails", http.StatusInternalServerError)
+		return
+	}
+
+	if missing > 0 {
+		log.Printf("User %s repaired thumbnails: %d missing, %d regenerated, %d failed", session.Username, missing, repaired, failed)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":   "success",
+		"message":  fmt.Sprintf("Regenerated %d of %d thumbnail(s)", repaired, missing),
+		"missing":  missing,
+		"repaired": repaired,
+		"failed":   failed,
+	})
+}
+
+// HandleAPIThumbnailFailures lists photos whose thumbnails fail to generate (admin only)
+// These are usually corrupt or truncated originals. ?min_attempts= (default 1)
+// hides photos that only failed a few times
+func (app *App) HandleAPIThumbnailFailures(w http.ResponseWriter, r *http.Request) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !session.IsAdmin() {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	minAttempts := 1
+	if v := r.URL.Query().Get("min_attempts"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "min_attempts must be a positive number", http.StatusBadRequest)
+			return
+		}
+		minAttempts = n
+	}
+
+	failures, err := app.db.GetThumbnailFailures(minAttempts)
+	if err != nil {
+		http.Error(w, "Failed to get thumbnail failures", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":   "success",
+		"failures": failures,
+	})
+}
+
+// HandleListMyPhotos lists photos for the current user
+func (app *App) HandleListMyPhotos(w http.ResponseWriter, r *http.Request) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	photos, err := app.db.GetPhotosByUser(session.UserID)
+	if err != nil {
+		http.Error(w, "Failed to list photos", http.StatusInternalServerError)
+		return
+	}
+
+	sortPhotos(photos, r.URL.Query().Get("sort"))
+
+	for _, photo := range photos {
+		app.photoMgr.BuildPhotoURLs(photo)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(photos)
+}
+
+// HandleListSharedPhotos lists photos in the caller's family area (their group's, or the ungrouped one)
+func (app *App) HandleListSharedPhotos(w http.ResponseWriter, r *http.Request) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	if query.Has("limit") || query.Has("offset") || query.Has("uploader") {
+		app.listSharedPhotosPaged(w, r, session)
+		return
+	}
+
+	photos, err := app.db.GetSharedPhotos(session.GroupID)
+	if err != nil {
+		http.Error(w, "Failed to list photos", http.StatusInternalServerError)
+		return
+	}
+
+	sortPhotos(photos, r.URL.Query().Get("sort"))
+
+	for _, photo := range photos {
+		app.photoMgr.BuildPhotoURLs(photo)
+	}
+	app.hideLocationsFor(session.UserID, photos...)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(photos)
+}
+
+// HandleCountMyPhotos returns how many photos HandleListMyPhotos would list
+func (app *App) HandleCountMyPhotos(w http.ResponseWriter, r *http.Request) {
+	app.servePhotoCount(w, r, func(session *Session) (int, error) {
+		return app.db.CountPhotosByUser(session.UserID)
+	})
+}
+
+// HandleCountSharedPhotos returns how many photos are in the caller's family area
+func (app *App) HandleCountSharedPhotos(w http.ResponseWriter, r *http.Request) {
+	app.servePhotoCount(w, r, func(session *Session) (int, error) {
+		return app.db.CountSharedPhotos(session.GroupID)
+	})
+}
+
+// HandleCountArchivedPhotos returns how many photos the user has archived
+func (app *App) HandleCountArchivedPhotos(w http.ResponseWriter, r *http.Request) {
+	app.servePhotoCount(w, r, func(session *Session) (int, error) {
+		return app.db.CountArchivedPhotos(session.UserID)
+	})
+}
+
+// servePhotoCount writes {"count": n} for the session, so the UI can show
+// badge numbers without fetching whole photo lists
+func (app *App) servePhotoCount(w http.ResponseWriter, r *http.Request, count func(*Session) (int, error)) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	n, err := count(session)
+	if err != nil {
+		log.Printf("Failed to count photos for user %s: %v", session.Username, err)
+		http.Error(w, "Failed to count photos", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"count":  n,
+	})
+}
+
+// HandleListRecentPhotos lists the user's recent uploads grouped by upload day
+// Days are calendar days in the server's time zone, newest first; days without uploads are omitted
+// Query params:
+//   - days: how many days back to look, including today (1-366, default 7)
+func (app *App) HandleListRecentPhotos(w http.ResponseWriter, r *http.Request) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	days := DefaultRecentDays
+	if daysStr := r.URL.Query().Get("days"); daysStr != "" {
+		days, err = strconv.Atoi(daysStr)
+		if err != nil || days < 1 || days > MaxRecentDays {
+			http.Error(w, fmt.Sprintf("days must be between 1 and %d", MaxRecentDays), http.StatusBadRequest)
+			return
+		}
+	}
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	since := today.AddDate(0, 0, -(days - 1))
+
+	photos, err := app.db.GetPhotosUploadedSince(session.UserID, since)
+	if err != nil {
+		http.Error(w, "Failed to list photos", http.StatusInternalServerError)
+		return
+	}
+
+	type DayGroup struct {
+		Date   string   `json:"date"` // YYYY-MM-DD
+		Count  int      `json:"count"`
+		Photos []*Photo `json:"photos"`
+	}
+
+	// Photos arrive newest first, so each day's photos are contiguous
+	groups := make([]DayGroup, 0)
+	for _, photo := range photos {
+		app.photoMgr.BuildPhotoURLs(photo)
+		date := photo.UploadedAt.In(now.Location()).Format("2006-01-02")
+		if len(groups) == 0 || groups[len(groups)-1].Date != date {
+			groups = append(groups, DayGroup{Date: date, Photos: make([]*Photo, 0)})
+		}
+		last := &groups[len(groups)-1]
+		last.Photos = append(last.Photos, photo)
+		last.Count++
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"days":   days,
+		"since":  since,
+		"groups": groups,
+		"total":  len(photos),
+	})
+}
+
+// HandleListChangedPhotos lets a client sync the user's photos incrementally
+// It returns photos (archived included) whose updated_at is at or after ts,
+// oldest change first, and the IDs of photos deleted since then. Pass the
+// response's server_time as ts next time; photos changed at that instant may
+// come back twice. Deletions are only remembered for TombstoneDays, so an
+// older ts gets full_sync_required and the client should reload everything
+// Query params:
+//   - ts: RFC 3339 timestamp (required)
+//   - limit, cursor: page through the changed photos by passing the previous
+//     page's next_cursor as cursor (with the same ts); deleted is the same on every page
+func (app *App) HandleListChangedPhotos(w http.ResponseWriter, r *http.Request) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	since, err := time.Parse(time.RFC3339Nano, query.Get("ts"))
+	if err != nil {
+		http.Error(w, "ts must be an RFC 3339 timestamp, e.g. 2024-01-02T15:04:05Z", http.StatusBadRequest)
+		return
+	}
+
+	// Offsets skip photos edited between pages; the cursor doesn't
+	if query.Get("offset") != "" {
+		http.Error(w, "offset is not supported; pass the previous page's next_cursor as cursor", http.StatusBadRequest)
+		return
+	}
+
+	limit, _, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+
+	var after *SyncCursor
+	if cursor := query.Get("cursor"); cursor != "" {
+		after, err = parseSyncCursor(cursor)
+		if err != nil {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Taken before querying, so anything changed during the queries is
+	// picked up by the next sync
+	serverTime := time.Now().UTC()
+
+	// One extra row tells whether another page follows
+	photos, total, err := app.db.GetPhotosChangedSince(session.UserID, since, after, limit+1)
+	if err != nil {
+		http.Error(w, "Failed to list photos", http.StatusInternalServerError)
+		return
+	}
+	hasMore := len(photos) > limit
+	if hasMore {
+		photos = photos[:limit]
+	}
+
+	deleted, err := app.db.GetTombstonesSince(session.UserID, since)
+	if err != nil {
+		http.Error(w, "Failed to list deleted photos", http.StatusInternalServerError)
+		return
+	}
+
+	for _, photo := range photos {
+		app.photoMgr.BuildPhotoURLs(photo)
+	}
+
+	nextCursor := ""
+	if hasMore {
+		last := photos[len(photos)-1]
+		nextCursor = formatSyncCursor(SyncCursor{UpdatedAt: last.UpdatedAt, PhotoID: last.ID})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":             "success",
+		"photos":             photos,
+		"deleted":            deleted,
+		"server_time":        serverTime.Truncate(time.Millisecond),
+		"full_sync_required": since.Before(serverTime.AddDate(0, 0, -TombstoneDays)),
+		"total":              total,
+		"limit":              limit,
+		"has_more":           hasMore,
+		"next_cursor":        nextCursor,
+	})
+}
+
+// formatSyncCursor encodes a changed-photos cursor for clients, which treat it as opaque
+func formatSyncCursor(c SyncCursor) string {
+	return fmt.Sprintf("%d-%d", c.UpdatedAt.UnixMilli(), c.PhotoID)
+}
+
+// parseSyncCursor decodes a cursor made by formatSyncCursor
+func parseSyncCursor(s string) (*SyncCursor, error) {
+	millisStr, idStr, ok := strings.Cut(s, "-")
+	if !ok {
+		return nil, fmt.Errorf("malformed cursor")
+	}
+	millis, err := strconv.ParseInt(millisStr, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("malformed cursor: %v", err)
+	}
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("malformed cursor: %v", err)
+	}
+	return &SyncCursor{UpdatedAt: time.UnixMilli(millis), PhotoID: id}, nil
+}
+
+// photoUnmodified honors If-Unmodified-Since on a request that changes photo,
+// answering 412 if the photo changed after that time, so a syncing client
+// doesn't overwrite an edit it hasn't seen. HTTP dates have whole seconds, so
+// changes within the same second aren't detected. Returns false if it answered
+func photoUnmodified(w http.ResponseWriter, r *http.Request, photo *Photo) bool {
+	header := r.Header.Get("If-Unmodified-Since")
+	if header == "" {
+		return true
+	}
+
+	since, err := http.ParseTime(header)
+	if err != nil {
+		// RFC 9110: an invalid date is ignored
+		return true
+	}
+
+	if photo.UpdatedAt.Truncate(time.Second).After(since) {
+		w.Header().Set("Last-Modified", photo.UpdatedAt.UTC().Format(http.TimeFormat))
+		http.Error(w, "Photo was changed since If-Unmodified-Since", http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+// listSharedPhotosPaged serves one page of the caller's shared feed
+// Query params:
+//   - limit: photos per page (1-500, default 50)
+//   - offset: photos to skip (default 0)
+//   - uploader: only photos uploaded by this username
+//   - sort: "taken" orders by capture date instead of upload date
+func (app *App) listSharedPhotosPaged(w http.ResponseWriter, r *http.Request, session *Session) {
+	query := r.URL.Query()
+
+	limit, offset, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+
+	uploader := strings.TrimSpace(query.Get("uploader"))
+
+	photos, total, err := app.db.GetSharedPhotosPaged(session.GroupID, uploader, query.Get("sort") == "taken", limit, offset)
+	if err != nil {
+		http.Error(w, "Failed to list photos", http.StatusInternalServerError)
+		return
+	}
+
+	for _, photo := range photos {
+		app.photoMgr.BuildPhotoURLs(photo)
+	}
+	app.hideLocationsFor(session.UserID, photos...)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"photos":   photos,
+		"total":    total,
+		"limit":    limit,
+		"offset":   offset,
+		"has_more": offset+len(photos) < total,
+	})
+}
+
+// parsePage reads ?limit= (default DefaultPageSize) and ?offset=
+// Writes the error response and returns false if either is invalid
+func parsePage(w http.ResponseWriter, r *http.Request) (limit, offset int, ok bool) {
+	query := r.URL.Query()
+
+	limit = DefaultPageSize
+	if limitStr := query.Get("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n < 1 || n > MaxPageSize {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", MaxPageSize), http.StatusBadRequest)
+			return 0, 0, false
+		}
+		limit = n
+	}
+
+	if offsetStr := query.Get("offset"); offsetStr != "" {
+		n, err := strconv.Atoi(offsetStr)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid offset", http.StatusBadRequest)
+			return 0, 0, false
+		}
+		offset = n
+	}
+
+	return limit, offset, true
+}
+
+// HandleAPIListUserPhotos pages through one user's photos, private ones included (admin only)
+// For moderation; every request is logged. Query params: limit, offset,
+// sort=taken, archived=1 to include archived photos
+func (app *App) HandleAPIListUserPhotos(w http.ResponseWriter, r *http.Request) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !session.IsAdmin() {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	userID, err := strconv.ParseInt(r.PathValue("userID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	limit, offset, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := app.db.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	query := r.URL.Query()
+	includeArchived := query.Get("archived") == "1"
+
+	photos, total, err := app.db.GetUserPhotosPaged(userID, includeArchived, query.Get("sort") == "taken", limit, offset)
+	if err != nil {
+		http.Error(w, "Failed to list photos", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Admin %s browsed photos of user %s (offset %d, limit %d, archived %t)", session.Username, user.Username, offset, limit, includeArchived)
+
+	for _, photo := range photos {
+		app.photoMgr.BuildPhotoURLs(photo)
+	}
+	app.hideLocationsFor(session.UserID, photos...)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user_id":  user.ID,
+		"username": user.Username,
+		"photos":   photos,
+		"total":    total,
+		"limit":    limit,
+		"offset":   offset,
+		"has_more": offset+len(photos) < total,
+	})
+}
+
+// HandleListAllPhotos lists all photos (admin only)
+func (app *App) HandleListAllPhotos(w http.ResponseWriter, r *http.Request) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !session.IsAdmin() {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	photos, err := app.db.GetAllPhotos()
+	if err != nil {
+		http.Error(w, "Failed to list photos", http.StatusInternalServerError)
+		return
+	}
+
+	sortPhotos(photos, r.URL.Query().Get("sort"))
+
+	for _, photo := range photos {
+		app.photoMgr.BuildPhotoURLs(photo)
+	}
+	app.hideLocationsFor(session.UserID, photos...)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(photos)
+}
+
+// HandleGetOriginal serves original photos
+// Inline by default; ?download=1 serves as an attachment and counts as a download.
+// ?format=jpeg&quality=80 serves a re-encoded copy for slow connections,
+// falling back to the original when the format or source isn't supported
+func (app *App) HandleGetOriginal(w http.ResponseWriter, r *http.Request) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	userIDStr := r.PathValue("userID")
+	filename := r.PathValue("filename")
+
+	userID, err := strconv.ParseInt(userIDStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	// Reject names that could resolve outside the user's directory
+	if !isSafeFilename(filename) {
+		http.Error(w, "Invalid filename", http.StatusBadRequest)
+		return
+	}
+
+	// Get photo from database
+	photo, err := app.db.GetPhotoByFilename(filename, userID)
+	if err != nil || photo == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Check access: owner, shared, or admin
+	// Photos the user can't see look exactly like missing ones
+	if !canViewPhoto(session, photo) {
+		http.NotFound(w, r)
+		return
+	}
+
+	app.serveOriginal(w, r, photo, session.UserID)
+}
+
+// HandleGetThumbnail serves thumbnail images
+func (app *App) HandleGetThumbnail(w http.ResponseWriter, r *http.Request) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	userIDStr := r.PathValue("userID")
+	filename := r.PathValue("filename")
+
+	userID, err := strconv.ParseInt(userIDStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	// Reject names that could resolve outside the user's directory
+	if !isSafeFilename(filename) {
+		http.Error(w, "Invalid filename", http.StatusBadRequest)
+		return
+	}
+
+	// Get photo from database
+	photo, err := app.db.GetPhotoByFilename(filename, userID)
+	if err != nil || photo == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Check access: owner, shared, or admin
+	// Photos the user can't see look exactly like missing ones
+	if !canViewPhoto(session, photo) {
+		http.NotFound(w, r)
+		return
+	}
+
+	app.serveThumbnail(w, r, photo, session.UserID)
+}
+
+// serveOriginal writes a photo's original (or a transcoded variant) to the response
+// The caller has already checked that viewerID may view the photo (0 for public links)
+// Supports ?download=1 and ?format=&quality= as documented on HandleGetOriginal
+func (app *App) serveOriginal(w http.ResponseWriter, r *http.Request, photo *Photo, viewerID int64) {
+	// Transcoded variant for viewing; downloads always get the untouched original
+	query := r.URL.Query()
+	if format := query.Get("format"); format != "" && query.Get("download") != "1" {
+		quality := VariantQuality
+		if q := query.Get("quality"); q != "" {
+			parsed, err := strconv.Atoi(q)
+			if err != nil || parsed < 1 || parsed > 100 {
+				http.Error(w, "Quality must be between 1 and 100", http.StatusBadRequest)
+				return
+			}
+			quality = parsed
+		}
+
+		variant, info, mimeType, err := app.photoMgr.OpenVariant(photo, format, quality)
+		if err == nil {
+			defer variant.Close()
+			w.Header().Set("Content-Type", mimeType)
+			http.ServeContent(w, r, photo.Filename, info.ModTime(), variant)
+			return
+		}
+		if !errors.Is(err, errVariantUnsupported) {
+			log.Printf("Failed to transcode photo %d to %s: %v", photo.ID, format, err)
+		}
+	}
+
+	// Open from the live or archive location based on archived status,
+	// without metadata if the viewer isn't the owner and the server strips it
+	file, info, err := app.openOriginalFor(photo, viewerID)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Failed to open original of photo %d: %v", photo.ID, err)
+		}
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+
+	// Only ever serve real images, typed by their content
+	mimeType, err := sniffImageType(file)
+	if err != nil {
+		log.Printf("Refusing to serve original of photo %d: %v", photo.ID, err)
+		http.Error(w, "Invalid image file", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", mimeType)
+
+	// ?download=1 forces a save dialog with the name the photo was uploaded under
+	// Only these count as downloads; the viewer loads originals too. Range
+	// requests resume a download already counted
+	if query.Get("download") == "1" {
+		w.Header().Set("Content-Disposition", attachmentDisposition(photo.DisplayName()))
+		if r.Method == http.MethodGet && r.Header.Get("Range") == "" {
+			app.counter.RecordDownload(photo.ID)
+		}
+	}
+
+	http.ServeContent(w, r, photo.Filename, info.ModTime(), file)
+}
+
+// serveThumbnail writes a photo's thumbnail to the response
+// The caller has already checked that viewerID may view the photo (0 for public links)
+func (app *App) serveThumbnail(w http.ResponseWriter, r *http.Request, photo *Photo, viewerID int64) {
+	// Open from the live or archive location based on archived status,
+	// without metadata if the viewer isn't the owner and the server strips it
+	stripped := app.stripsFor(photo, viewerID)
+	file, info, err := app.photoMgr.OpenThumbnail(photo, stripped)
+	if errors.Is(err, errThumbnailUnavailable) {
+		servePlaceholderThumbnail(w, r)
+		return
+	}
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+
+	// Only ever serve real images, typed by their content
+	mimeType, err := sniffImageType(file)
+	if err != nil {
+		log.Printf("Refusing to serve thumbnail of photo %d: %v", photo.ID, err)
+		http.Error(w, "Invalid image file", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", mimeType)
+
+	// Thumbnails only change when regenerated or rendered with other
+	// settings, which changes the ETag; the gallery grid then costs a 304 at
+	// most. Callers may set their own Cache-Control (public links must revalidate)
+	w.Header().Set("ETag", thumbnailETag(photo.Filename, info, app.photoMgr.thumbnails, stripped))
+	if w.Header().Get("Cache-Control") == "" {
+		w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d, immutable", ThumbnailMaxAge))
+	}
+
+	http.ServeContent(w, r, photo.Filename, info.ModTime(), file)
+}
+
+// thumbnailETag identifies one version of a thumbnail by its filename, size
+// and modification time. Thumbnails rendered on request report the
+// original's file info, so the render settings are part of the tag too
+func thumbnailETag(filename string, info fs.FileInfo, opts ThumbnailOptions, stripped bool) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d\x00%d\x00%s\x00%d\x00%s\x00%t\x00%t",
+		filename, info.Size(), info.ModTime().UnixNano(),
+		opts.Mode, opts.Quality, opts.Filter, opts.AnimatedGIFs, stripped)))
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// servePlaceholderThumbnail stands in for a thumbnail that can't be generated,
+// so the gallery shows a "broken photo" tile instead of a broken image.
+// It is cached only briefly so a repaired thumbnail shows up soon after
+func servePlaceholderThumbnail(w http.ResponseWriter, r *http.Request) {
+	data, err := staticFS.ReadFile("static/img/thumbnail-placeholder.svg")
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", PlaceholderMaxAge))
+	http.ServeContent(w, r, "thumbnail-placeholder.svg", time.Time{}, bytes.NewReader(data))
+}
+
+// viewablePhotoFromPath validates the session and resolves the {photoID} path
+// value to a photo it may view
+// Writes the error response and returns a nil photo if there is none
+func (app *App) viewablePhotoFromPath(w http.ResponseWriter, r *http.Request) (*Session, *Photo) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, nil
+	}
+
+	return session, app.photoFromPathFor(w, r, session)
+}
+
+// photoFromPathFor resolves the {photoID} path value to a photo an already
+// validated session may view, for handlers that check more (CSRF) first
+// Writes the error response and returns nil if there is none
+func (app *App) photoFromPathFor(w http.ResponseWriter, r *http.Request, session *Session) *Photo {
+	photoID, err := strconv.ParseInt(r.PathValue("photoID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid photo ID", http.StatusBadRequest)
+		return nil
+	}
+
+	// Photos the user can't see look exactly like missing ones
+	photo, err := app.db.GetPhotoByID(photoID)
+	if err != nil || photo == nil || !canViewPhoto(session, photo) {
+		http.NotFound(w, r)
+		return nil
+	}
+
+	return photo
+}
+
+// HandleGetOriginalByID serves an original by photo ID
+// Unlike the filename route, the URL doesn't change if the file is renamed
+func (app *App) HandleGetOriginalByID(w http.ResponseWriter, r *http.Request) {
+	session, photo := app.viewablePhotoFromPath(w, r)
+	if photo == nil {
+		return
+	}
+
+	app.serveOriginal(w, r, photo, session.UserID)
+}
+
+// HandleGetThumbnailByID serves a thumbnail by photo ID
+func (app *App) HandleGetThumbnailByID(w http.ResponseWriter, r *http.Request) {
+	session, photo := app.viewablePhotoFromPath(w, r)
+	if photo == nil {
+		return
+	}
+
+	app.serveThumbnail(w, r, photo, session.UserID)
+}
+
+// HandleDeletePhoto handles photo deletion
+func (app *App) HandleDeletePhoto(w http.ResponseWriter, r *http.Request) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := app.sessionMgr.ValidateCSRF(r, session); err != nil {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	photoIDStr := r.PathValue("photoID")
+	photoID, err := strconv.ParseInt(photoIDStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid photo ID", http.StatusBadRequest)
+		return
+	}
+
+	photo, err := app.db.GetPhotoByID(photoID)
+	if err != nil || photo == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Check access: owner or admin
+	if photo.UserID != session.UserID && !session.IsAdmin() {
+		if !canViewPhoto(session, photo) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	// Don't overwrite a change the client hasn't seen
+	if !photoUnmodified(w, r, photo) {
+		return
+	}
+
+	if err := app.photoMgr.DeletePhoto(photo); err != nil {
+		http.Error(w, "Failed to delete photo", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "Photo deleted successfully",
+	})
+}
+
+// HandleSharePhoto toggles photo sharing
+// Body (optional): {"shared": true} sets the state instead, so retries and
+// concurrent clicks can't flip it back
+func (app *App) HandleSharePhoto(w http.ResponseWriter, r *http.Request) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := app.sessionMgr.ValidateCSRF(r, session); err != nil {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	photoIDStr := r.PathValue("photoID")
+	photoID, err := strconv.ParseInt(photoIDStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid photo ID", http.StatusBadRequest)
+		return
+	}
+
+	photo, err := app.db.GetPhotoByID(photoID)
+	if err != nil || photo == nil {
+		http.NotFound(w, r)
 		return
 	}
 
-	photos, err := app.db.GetPhotosByUser(session.UserID)
-	if err != nil {
-		http.Error(w, "Failed to list photos", http.StatusInternalServerError)
+	// Only owner can share/unshare (admin can't share others' photos)
+	if photo.UserID != session.UserID {
+		if !canViewPhoto(session, photo) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "Forbidden", http.StatusForbidden)
 		return
 	}
 
-	for _, photo := range photos {
-		app.photoMgr.BuildPhotoURLs(photo)
+	// Don't overwrite a change the client hasn't seen
+	if !photoUnmodified(w, r, photo) {
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(photos)
-}
+	// Limit request body size
+	r.Body = http.MaxBytesReader(w, r.Body, SmallJSONBodyBytes)
 
-// HandleListSharedPhotos lists photos in the family area
-func (app *App) HandleListSharedPhotos(w http.ResponseWriter, r *http.Request) {
-	_, err := app.sessionMgr.ValidateSession(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	var body struct {
+		Shared *bool `json:"shared"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+		writeBodyError(w, err)
 		return
 	}
 
-	photos, err := app.db.GetSharedPhotos()
+	// Set the requested state, or toggle atomically in the database
+	newShared := false
+	if body.Shared != nil {
+		newShared = *body.Shared
+		err = app.db.SetPhotoShared(photoID, newShared)
+	} else {
+		newShared, err = app.db.TogglePhotoShared(photoID)
+	}
 	if err != nil {
-		http.Error(w, "Failed to list photos", http.StatusInternalServerError)
+		log.Printf("Failed to update shared status of photo %d: %v", photoID, err)
+		http.Error(w, "Failed to update photo", http.StatusInternalServerError)
 		return
 	}
 
-	for _, photo := range photos {
-		app.photoMgr.BuildPhotoURLs(photo)
+	status := "unshared from"
+	if newShared {
+		status = "shared to"
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(photos)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    "success",
+		"message":   fmt.Sprintf("Photo %s family area", status),
+		"is_shared": newShared,
+	})
 }
 
-// HandleListAllPhotos lists all photos (admin only)
-func (app *App) HandleListAllPhotos(w http.ResponseWriter, r *http.Request) {
+// HandleSetPhotoDate sets or clears a manual capture date for a photo
+// Body: {"taken_at": "2001-07-14"} (also RFC 3339); {"taken_at": null} clears it
+func (app *App) HandleSetPhotoDate(w http.ResponseWriter, r *http.Request) {
 	session, err := app.sessionMgr.ValidateSession(r)
 	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	if !session.IsAdmin() {
-		http.Error(w, "Forbidden", http.StatusForbidden)
+	if err := app.sessionMgr.ValidateCSRF(r, session); err != nil {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
 		return
 	}
 
-	photos, err := app.db.GetAllPhotos()
+	photoIDStr := r.PathValue("photoID")
+	photoID, err := strconv.ParseInt(photoIDStr, 10, 64)
 	if err != nil {
-		http.Error(w, "Failed to list photos", http.StatusInternalServerError)
+		http.Error(w, "Invalid photo ID", http.StatusBadRequest)
 		return
 	}
 
-	for _, photo := range photos {
-		app.photoMgr.BuildPhotoURLs(photo)
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(photos)
-}
+	// Limit request body size
+	r.Body = http.MaxBytesReader(w, r.Body, SmallJSONBodyBytes)
 
-// HandleGetOriginal serves original photos
-func (app *App) HandleGetOriginal(w http.ResponseWriter, r *http.Request) {
-	session, err := app.sessionMgr.ValidateSession(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	var req struct {
+		TakenAt *string `json:"taken_at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBodyError(w, err)
 		return
 	}
 
-	userIDStr := r.PathValue("userID")
-	filename := r.PathValue("filename")
-
-	userID, err := strconv.ParseInt(userIDStr, 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
-		return
+	var takenAt *time.Time
+	if req.TakenAt != nil {
+		t, err := time.Parse(time.RFC3339, *req.TakenAt)
+		if err != nil {
+			t, err = time.Parse("2006-01-02", *req.TakenAt)
+		}
+		if err != nil {
+			http.Error(w, "taken_at must be a date (YYYY-MM-DD) or RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		takenAt = &t
 	}
 
-	// Get photo from database
-	photo, err := app.db.GetPhotoByFilename(filename, userID)
+	photo, err := app.db.GetPhotoByID(photoID)
 	if err != nil || photo == nil {
 		http.NotFound(w, r)
 		return
 	}
 
-	// Check access: owner, shared, or admin
-	if photo.UserID != session.UserID && !photo.IsShared && !session.IsAdmin() {
+	// Only the owner can correct dates
+	if photo.UserID != session.UserID {
+		if !canViewPhoto(session, photo) {
+			http.NotFound(w, r)
+			return
+		}
 		http.Error(w, "Forbidden", http.StatusForbidden)
 		return
 	}
 
-	// For archived photos, only owner can access (not via shared link)
-	if photo.IsArchived && photo.UserID != session.UserID && !session.IsAdmin() {
-		http.NotFound(w, r)
+	// Don't overwrite a change the client hasn't seen
+	if !photoUnmodified(w, r, photo) {
 		return
 	}
 
-	// Get path based on archived status
-	var path string
-	if photo.IsArchived {
-		path, err = app.photoMgr.GetArchivedOriginalPath(photo)
-	} else {
-		path, err = app.photoMgr.GetOriginalPath(photo)
+	if err := app.db.SetPhotoTakenAtOverride(photoID, takenAt); err != nil {
+		http.Error(w, "Failed to update photo", http.StatusInternalServerError)
+		return
 	}
-	if err != nil {
-		http.NotFound(w, r)
+
+	photo, err = app.db.GetPhotoByID(photoID)
+	if err != nil || photo == nil {
+		http.Error(w, "Failed to update photo", http.StatusInternalServerError)
 		return
 	}
+	app.photoMgr.BuildPhotoURLs(photo)
 
-	http.ServeFile(w, r, path)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"photo":  photo,
+	})
 }
 
-// HandleGetThumbnail serves thumbnail images
-func (app *App) HandleGetThumbnail(w http.ResponseWriter, r *http.Request) {
+// contentHashParam reads ?hash= as a lowercase SHA-256 hex digest
+// Writes the error response and returns "" if it is missing or malformed
+func contentHashParam(w http.ResponseWriter, r *http.Request) string {
+	hash := strings.ToLower(r.URL.Query().Get("hash"))
+	if len(hash) != sha256.Size*2 {
+		http.Error(w, "hash must be a SHA-256 hex digest", http.StatusBadRequest)
+		return ""
+	}
+	if _, err := hex.DecodeString(hash); err != nil {
+		http.Error(w, "hash must be a SHA-256 hex digest", http.StatusBadRequest)
+		return ""
+	}
+	return hash
+}
+
+// HandleGetPhotoByHash resolves a file's SHA-256 to a photo the user can view
+// The user's own copy is preferred; otherwise the oldest one visible to them
+// Query params: hash
+func (app *App) HandleGetPhotoByHash(w http.ResponseWriter, r *http.Request) {
 	session, err := app.sessionMgr.ValidateSession(r)
 	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	userIDStr := r.PathValue("userID")
-	filename := r.PathValue("filename")
-
-	userID, err := strconv.ParseInt(userIDStr, 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+	hash := contentHashParam(w, r)
+	if hash == "" {
 		return
 	}
 
-	// Get photo from database
-	photo, err := app.db.GetPhotoByFilename(filename, userID)
-	if err != nil || photo == nil {
-		http.NotFound(w, r)
+	photos, err := app.db.GetPhotosByContentHash(hash)
+	if err != nil {
+		http.Error(w, "Failed to look up photo", http.StatusInternalServerError)
 		return
 	}
 
-	// Check access: owner, shared, or admin
-	if photo.UserID != session.UserID && !photo.IsShared && !session.IsAdmin() {
-		http.Error(w, "Forbidden", http.StatusForbidden)
-		return
+	var found *Photo
+	for _, photo := range photos {
+		if !canViewPhoto(session, photo) {
+			continue
+		}
+		if found == nil || (photo.UserID == session.UserID && found.UserID != session.UserID) {
+			found = photo
+		}
 	}
-
-	// For archived photos, only owner can access (not via shared link)
-	if photo.IsArchived && photo.UserID != session.UserID && !session.IsAdmin() {
+	if found == nil {
 		http.NotFound(w, r)
 		return
 	}
 
-	// Get path based on archived status
-	var path string
-	if photo.IsArchived {
-		path, err = app.photoMgr.GetArchivedThumbnailPath(photo)
-	} else {
-		path, err = app.photoMgr.GetThumbnailPath(photo)
-	}
-	if err != nil {
-		http.NotFound(w, r)
-		return
-	}
+	app.photoMgr.BuildPhotoURLs(found)
+	app.hideLocationsFor(session.UserID, found)
 
-	http.ServeFile(w, r, path)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"photo":  found,
+	})
 }
 
-// HandleDeletePhoto handles photo deletion
-func (app *App) HandleDeletePhoto(w http.ResponseWriter, r *http.Request) {
+// HandlePhotoExists tells a client whether the user already has a file, so it
+// can skip uploading it again. HEAD answers with the status alone: 200 if the
+// user has it, 404 if not. Query params: hash (SHA-256 of the file)
+func (app *App) HandlePhotoExists(w http.ResponseWriter, r *http.Request) {
 	session, err := app.sessionMgr.ValidateSession(r)
 	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	if err := app.sessionMgr.ValidateCSRF(r, session); err != nil {
-		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+	hash := contentHashParam(w, r)
+	if hash == "" {
 		return
 	}
 
-	photoIDStr := r.PathValue("photoID")
-	photoID, err := strconv.ParseInt(photoIDStr, 10, 64)
+	ids, err := app.db.GetPhotoIDsByContentHash(session.UserID, hash)
 	if err != nil {
-		http.Error(w, "Invalid photo ID", http.StatusBadRequest)
+		http.Error(w, "Failed to look up photo", http.StatusInternalServerError)
 		return
 	}
 
-	photo, err := app.db.GetPhotoByID(photoID)
-	if err != nil || photo == nil {
-		http.NotFound(w, r)
+	if r.Method == http.MethodHead {
+		if len(ids) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+		}
 		return
 	}
 
-	// Check access: owner or admin
-	if photo.UserID != session.UserID && !session.IsAdmin() {
-		http.Error(w, "Forbidden", http.StatusForbidden)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    "success",
+		"exists":    len(ids) > 0,
+		"photo_ids": ids,
+	})
+}
+
+// HandleGetPhoto returns one photo's full metadata
+// Anyone who can view the photo gets its record plus capture date and comment
+// count; the owner and admins also see organizer details (embedding, similar
+// photos, exact duplicates), which describe the owner's private library
+func (app *App) HandleGetPhoto(w http.ResponseWriter, r *http.Request) {
+	session, photo := app.viewablePhotoFromPath(w, r)
+	if photo == nil {
 		return
 	}
 
-	if err := app.photoMgr.DeletePhoto(photo); err != nil {
-		http.Error(w, "Failed to delete photo", http.StatusInternalServerError)
+	app.photoMgr.BuildPhotoURLs(photo)
+	app.hideLocationsFor(session.UserID, photo)
+
+	commentCount, err := app.db.CountComments(photo.ID)
+	if err != nil {
+		http.Error(w, "Failed to get photo", http.StatusInternalServerError)
 		return
 	}
 
+	response := map[string]interface{}{
+		"status":        "success",
+		"photo":         photo,
+		"captured_at":   photo.CapturedAt(),
+		"comment_count": commentCount,
+	}
+
+	if photo.UserID == session.UserID || session.IsAdmin() {
+		embedding, err := app.db.GetEmbedding(photo.ID)
+		if err != nil {
+			http.Error(w, "Failed to get photo", http.StatusInternalServerError)
+			return
+		}
+		similarCount, err := app.db.CountSimilarPhotos(photo.ID)
+		if err != nil {
+			http.Error(w, "Failed to get photo", http.StatusInternalServerError)
+			return
+		}
+
+		duplicateOf := make([]int64, 0)
+		if photo.ContentHash != "" {
+			if ids, err := app.db.GetPhotoIDsByContentHash(photo.UserID, photo.ContentHash); err == nil {
+				for _, id := range ids {
+					if id != photo.ID {
+						duplicateOf = append(duplicateOf, id)
+					}
+				}
+			}
+		}
+
+		response["has_embedding"] = embedding != nil
+		response["similar_count"] = similarCount
+		response["duplicate_of"] = duplicateOf
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"status":  "success",
-		"message": "Photo deleted successfully",
-	})
+	json.NewEncoder(w).Encode(response)
 }
 
-// HandleSharePhoto toggles photo sharing
-func (app *App) HandleSharePhoto(w http.ResponseWriter, r *http.Request) {
+// HandleSetPhotoCaption sets or clears a photo's caption
+// Body: {"caption": "Grandma's 80th"}; an empty caption clears it
+func (app *App) HandleSetPhotoCaption(w http.ResponseWriter, r *http.Request) {
 	session, err := app.sessionMgr.ValidateSession(r)
 	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
@@ -635,42 +2429,103 @@ func (app *App) HandleSharePhoto(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Limit request body size
+	r.Body = http.MaxBytesReader(w, r.Body, MaxJSONBodyBytes)
+
+	var req struct {
+		Caption string `json:"caption"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	caption := strings.TrimSpace(req.Caption)
+	if utf8.RuneCountInString(caption) > MaxCaptionLength {
+		http.Error(w, fmt.Sprintf("Caption must be at most %d characters", MaxCaptionLength), http.StatusBadRequest)
+		return
+	}
+
 	photo, err := app.db.GetPhotoByID(photoID)
 	if err != nil || photo == nil {
 		http.NotFound(w, r)
 		return
 	}
 
-	// Only owner can share/unshare (admin can't share others' photos)
+	// Only the owner can caption a photo
 	if photo.UserID != session.UserID {
+		if !canViewPhoto(session, photo) {
+			http.NotFound(w, r)
+			return
+		}
 		http.Error(w, "Forbidden", http.StatusForbidden)
 		return
 	}
 
-	// Toggle shared status
-	newShared := !photo.IsShared
-	if err := app.db.SetPhotoShared(photoID, newShared); err != nil {
-		http.Error(w, "Failed to update photo", http.StatusInternalServerError)
+	// Don't overwrite a change the client hasn't seen
+	if !photoUnmodified(w, r, photo) {
 		return
 	}
 
-	status := "unshared from"
-	if newShared {
-		status = "shared to"
+	if err := app.db.SetPhotoCaption(photoID, caption); err != nil {
+		http.Error(w, "Failed to update photo", http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"status":    "success",
-		"message":   fmt.Sprintf("Photo %s family area", status),
-		"is_shared": newShared,
+		"status":  "success",
+		"caption": caption,
 	})
 }
 
 // BulkRequest represents a request with multiple photo IDs
 type BulkRequest struct {
 	PhotoIDs []int64 `json:"photo_ids"`
-	Share    bool    `json:"share"` // For bulk share: true = share, false = unshare
+	Share    bool    `json:"share"`   // For bulk share: true = share, false = unshare
+	DryRun   bool    `json:"dry_run"` // For bulk delete and archive: report what would happen without doing it
+}
+
+// Per-photo outcomes of bulk operations
+const (
+	BulkStatusOK        = "ok"
+	BulkStatusNotFound  = "not_found" // missing, or not visible to the caller
+	BulkStatusForbidden = "forbidden" // visible, but the caller may not change it
+	BulkStatusFailed    = "failed"
+	BulkStatusArchived  = "already_archived" // bulk archive: nothing to do
+)
+
+// BulkResult reports what a bulk operation did to one of the requested photos
+type BulkResult struct {
+	PhotoID int64  `json:"photo_id"`
+	Status  string `json:"status"`
+}
+
+// bulkTarget loads a photo named in a bulk request and checks the session may change it
+// Owners always may; admins only if allowAdmin. Returns nil and the status to report otherwise
+func (app *App) bulkTarget(session *Session, photoID int64, allowAdmin bool) (*Photo, string) {
+	photo, err := app.db.GetPhotoByID(photoID)
+	if err != nil {
+		return nil, BulkStatusFailed
+	}
+	if photo == nil || !canViewPhoto(session, photo) {
+		return nil, BulkStatusNotFound
+	}
+	if photo.UserID != session.UserID && !(allowAdmin && session.IsAdmin()) {
+		return nil, BulkStatusForbidden
+	}
+	return photo, BulkStatusOK
+}
+
+// countBulkFailures counts results that aren't BulkStatusOK
+func countBulkFailures(results []BulkResult) int {
+	failed := 0
+	for _, result := range results {
+		if result.Status != BulkStatusOK {
+			failed++
+		}
+	}
+	return failed
 }
 
 // HandleBulkShare shares or unshares multiple photos at once
@@ -691,7 +2546,7 @@ func (app *App) HandleBulkShare(w http.ResponseWriter, r *http.Request) {
 
 	var req BulkRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeBodyError(w, err)
 		return
 	}
 
@@ -701,37 +2556,57 @@ func (app *App) HandleBulkShare(w http.ResponseWriter, r *http.Request) {
 	}
 
 	updated := 0
+	results := make([]BulkResult, 0, len(req.PhotoIDs))
 	for _, photoID := range req.PhotoIDs {
-		photo, err := app.db.GetPhotoByID(photoID)
-		if err != nil || photo == nil {
-			continue
-		}
-
 		// Only owner can share their photos
-		if photo.UserID != session.UserID {
-			continue
-		}
-
-		if err := app.db.SetPhotoShared(photoID, req.Share); err != nil {
-			continue
+		photo, status := app.bulkTarget(session, photoID, false)
+		if photo != nil {
+			if err := app.db.SetPhotoShared(photoID, req.Share); err != nil {
+				status = BulkStatusFailed
+			} else {
+				updated++
+			}
 		}
-		updated++
+		results = append(results, BulkResult{PhotoID: photoID, Status: status})
 	}
 
 	action := "unshared"
 	if req.Share {
 		action = "shared"
 	}
+	failed := countBulkFailures(results)
+
+	message := fmt.Sprintf("%d photo(s) %s", updated, action)
+	if failed > 0 {
+		message += fmt.Sprintf(", %d skipped", failed)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"status":  "success",
-		"message": fmt.Sprintf("%d photo(s) %s", updated, action),
+		"message": message,
 		"updated": updated,
+		"failed":  failed,
+		"results": results,
 	})
 }
 
+// contextReader fails reads once ctx is done, so a copy stops when the client goes away
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
 // HandleBulkDownload creates a zip file with multiple photos
+// The zip is streamed to the client entry by entry, so memory stays flat however
+// many photos are selected, and it stops as soon as the client disconnects
 func (app *App) HandleBulkDownload(w http.ResponseWriter, r *http.Request) {
 	session, err := app.sessionMgr.ValidateSession(r)
 	if err != nil {
@@ -744,7 +2619,7 @@ func (app *App) HandleBulkDownload(w http.ResponseWriter, r *http.Request) {
 
 	var req BulkRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeBodyError(w, err)
 		return
 	}
 
@@ -761,8 +2636,8 @@ func (app *App) HandleBulkDownload(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
-		// Check access: owner, shared, or admin
-		if photo.UserID != session.UserID && !photo.IsShared && !session.IsAdmin() {
+		// Check access: owner, shared with the caller's group, or admin
+		if !canViewPhoto(session, photo) {
 			continue
 		}
 
@@ -781,48 +2656,62 @@ func (app *App) HandleBulkDownload(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/zip")
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
 
-	// Create zip writer
-	zipWriter := zip.NewWriter(w)
-	defer zipWriter.Close()
+	// Create zip writer, batching its small header writes
+	buffered := bufio.NewWriterSize(w, ZipBufferBytes)
+	zipWriter := zip.NewWriter(buffered)
 
 	// Add each photo to the zip
-	usedNames := make(map[string]int)
-	for _, photo := range photos {
-		path, err := app.photoMgr.GetOriginalPath(photo)
-		if err != nil {
-			continue
+	ctx := r.Context()
+	usedNames := make(map[string]bool)
+	for i, photo := range photos {
+		// Stop once the client has gone away
+		if ctx.Err() != nil {
+			log.Printf("Bulk download for %s cancelled after %d of %d photos", session.Username, i, len(photos))
+			return
 		}
 
-		// Handle duplicate filenames
-		name := photo.Filename
-		if count, exists := usedNames[name]; exists {
-			ext := filepath.Ext(name)
-			base := name[:len(name)-len(ext)]
-			name = fmt.Sprintf("%s_%d%s", base, count+1, ext)
+		// Archived photos are not part of bulk downloads
+		if photo.IsArchived {
+			continue
 		}
-		usedNames[photo.Filename]++
 
-		// Create zip entry
-		zipEntry, err := zipWriter.Create(name)
+		file, _, err := app.openOriginalFor(photo, session.UserID)
 		if err != nil {
 			continue
 		}
 
-		// Read and write file
-		file, err := os.Open(path)
+		// Use the names photos were uploaded under, numbering repeats
+		name := photo.DisplayName()
+		ext := filepath.Ext(name)
+		for n := 2; usedNames[name]; n++ {
+			name = fmt.Sprintf("%s_%d%s", strings.TrimSuffix(photo.DisplayName(), ext), n, ext)
+		}
+		usedNames[name] = true
+
+		// Create zip entry
+		zipEntry, err := zipWriter.Create(name)
 		if err != nil {
+			file.Close()
 			continue
 		}
 
-		_, err = io.Copy(zipEntry, file)
+		// Write file; a failure here leaves the zip unusable, so stop
+		_, err = io.Copy(zipEntry, contextReader{ctx: ctx, r: file})
 		file.Close()
 		if err != nil {
-			continue
+			log.Printf("Bulk download for %s stopped at photo %d: %v", session.Username, photo.ID, err)
+			return
 		}
+		app.counter.RecordDownload(photo.ID)
+	}
+
+	if err := zipWriter.Close(); err == nil {
+		buffered.Flush()
 	}
 }
 
 // HandleBulkDelete deletes multiple photos at once
+// With "dry_run": true nothing is deleted; the results show what would be
 func (app *App) HandleBulkDelete(w http.ResponseWriter, r *http.Request) {
 	session, err := app.sessionMgr.ValidateSession(r)
 	if err != nil {
@@ -840,7 +2729,7 @@ func (app *App) HandleBulkDelete(w http.ResponseWriter, r *http.Request) {
 
 	var req BulkRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeBodyError(w, err)
 		return
 	}
 
@@ -850,28 +2739,40 @@ func (app *App) HandleBulkDelete(w http.ResponseWriter, r *http.Request) {
 	}
 
 	deleted := 0
+	results := make([]BulkResult, 0, len(req.PhotoIDs))
 	for _, photoID := range req.PhotoIDs {
-		photo, err := app.db.GetPhotoByID(photoID)
-		if err != nil || photo == nil {
-			continue
-		}
-
 		// Check access: owner or admin
-		if photo.UserID != session.UserID && !session.IsAdmin() {
-			continue
+		photo, status := app.bulkTarget(session, photoID, true)
+		if photo != nil && req.DryRun {
+			deleted++
+		} else if photo != nil {
+			if err := app.photoMgr.DeletePhoto(photo); err != nil {
+				log.Printf("Bulk delete: failed to delete photo %d: %v", photoID, err)
+				status = BulkStatusFailed
+			} else {
+				deleted++
+			}
 		}
+		results = append(results, BulkResult{PhotoID: photoID, Status: status})
+	}
+	failed := countBulkFailures(results)
 
-		if err := app.photoMgr.DeletePhoto(photo); err != nil {
-			continue
-		}
-		deleted++
+	message := fmt.Sprintf("%d photo(s) deleted", deleted)
+	if req.DryRun {
+		message = fmt.Sprintf("%d photo(s) would be deleted", deleted)
+	}
+	if failed > 0 {
+		message += fmt.Sprintf(", %d skipped", failed)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"status":  "success",
-		"message": fmt.Sprintf("%d photo(s) deleted", deleted),
+		"message": message,
 		"deleted": deleted,
+		"failed":  failed,
+		"dry_run": req.DryRun,
+		"results": results,
 	})
 }
 
@@ -905,10 +2806,19 @@ func (app *App) HandleArchivePhoto(w http.ResponseWriter, r *http.Request) {
 
 	// Check access: owner or admin
 	if photo.UserID != session.UserID && !session.IsAdmin() {
+		if !canViewPhoto(session, photo) {
+			http.Error(w, "Photo not found", http.StatusNotFound)
+			return
+		}
 		http.Error(w, "Access denied", http.StatusForbidden)
 		return
 	}
 
+	// Don't overwrite a change the client hasn't seen
+	if !photoUnmodified(w, r, photo) {
+		return
+	}
+
 	if err := app.photoMgr.ArchivePhoto(photo); err != nil {
 		http.Error(w, fmt.Sprintf("Failed to archive: %v", err), http.StatusInternalServerError)
 		return
@@ -949,10 +2859,19 @@ func (app *App) HandleUnarchivePhoto(w http.ResponseWriter, r *http.Request) {
 
 	// Check access: owner or admin
 	if photo.UserID != session.UserID && !session.IsAdmin() {
+		if !canViewPhoto(session, photo) {
+			http.Error(w, "Photo not found", http.StatusNotFound)
+			return
+		}
 		http.Error(w, "Access denied", http.StatusForbidden)
 		return
 	}
 
+	// Don't overwrite a change the client hasn't seen
+	if !photoUnmodified(w, r, photo) {
+		return
+	}
+
 	if err := app.photoMgr.UnarchivePhoto(photo); err != nil {
 		http.Error(w, fmt.Sprintf("Failed to unarchive: %v", err), http.StatusInternalServerError)
 		return
@@ -981,8 +2900,7 @@ func (app *App) HandleListArchivedPhotos(w http.ResponseWriter, r *http.Request)
 
 	// Add URLs to photos
 	for _, p := range photos {
-		p.ThumbnailURL = fmt.Sprintf("/api/photos/thumbnail/%d/%s", p.UserID, url.PathEscape(p.Filename))
-		p.OriginalURL = fmt.Sprintf("/api/photos/original/%d/%s", p.UserID, url.PathEscape(p.Filename))
+		app.photoMgr.BuildPhotoURLs(p)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -990,6 +2908,7 @@ func (app *App) HandleListArchivedPhotos(w http.ResponseWriter, r *http.Request)
 }
 
 // HandleBulkArchive archives multiple photos at once
+// With "dry_run": true nothing is archived; the results show what would be
 func (app *App) HandleBulkArchive(w http.ResponseWriter, r *http.Request) {
 	session, err := app.sessionMgr.ValidateSession(r)
 	if err != nil {
@@ -1007,7 +2926,7 @@ func (app *App) HandleBulkArchive(w http.ResponseWriter, r *http.Request) {
 
 	var req BulkRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeBodyError(w, err)
 		return
 	}
 
@@ -1017,33 +2936,75 @@ func (app *App) HandleBulkArchive(w http.ResponseWriter, r *http.Request) {
 	}
 
 	archived := 0
+	results := make([]BulkResult, 0, len(req.PhotoIDs))
 	for _, photoID := range req.PhotoIDs {
-		photo, err := app.db.GetPhotoByID(photoID)
-		if err != nil || photo == nil {
-			continue
-		}
-
 		// Check access: owner or admin
-		if photo.UserID != session.UserID && !session.IsAdmin() {
-			continue
+		photo, status := app.bulkTarget(session, photoID, true)
+		if photo != nil && photo.IsArchived {
+			status = BulkStatusArchived
+		} else if photo != nil && req.DryRun {
+			archived++
+		} else if photo != nil {
+			if err := app.photoMgr.ArchivePhoto(photo); err != nil {
+				log.Printf("Bulk archive: failed to archive photo %d: %v", photoID, err)
+				status = BulkStatusFailed
+			} else {
+				archived++
+			}
 		}
+		results = append(results, BulkResult{PhotoID: photoID, Status: status})
+	}
+	failed := countBulkFailures(results)
 
-		if err := app.photoMgr.ArchivePhoto(photo); err != nil {
-			continue
-		}
-		archived++
+	message := fmt.Sprintf("%d photo(s) archived", archived)
+	if req.DryRun {
+		message = fmt.Sprintf("%d photo(s) would be archived", archived)
+	}
+	if failed > 0 {
+		message += fmt.Sprintf(", %d skipped", failed)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"status":   "success",
-		"message":  fmt.Sprintf("%d photo(s) archived", archived),
+		"message":  message,
 		"archived": archived,
+		"failed":   failed,
+		"dry_run":  req.DryRun,
+		"results":  results,
 	})
 }
 
 // ==================== PHOTO SELECTOR / ORGANIZE HANDLERS ====================
 
+// newEmbeddingService creates an embedding client that shares the app's AI call limit
+func (app *App) newEmbeddingService() *EmbeddingService {
+	es := NewEmbeddingService(app.config.GetEmbeddingConfig())
+	es.limiter = app.aiLimiter
+	return es
+}
+
+// newLLMClient creates an LLM client that shares the app's AI call limit
+func (app *App) newLLMClient() *LLMClient {
+	return app.newLLMClientWith(app.config.GetLLMConfig())
+}
+
+// llmAlternatives lists the provider/models analysis requests may pick, without credentials
+func (app *App) llmAlternatives() []map[string]string {
+	alternatives := make([]map[string]string, 0, len(app.config.LLMAlternatives))
+	for _, alt := range app.config.LLMAlternatives {
+		alternatives = append(alternatives, map[string]string{"provider": string(alt.Provider), "model": alt.Model})
+	}
+	return alternatives
+}
+
+// newLLMClientWith is newLLMClient for a specific configuration (see GetLLMConfigFor)
+func (app *App) newLLMClientWith(config LLMConfig) *LLMClient {
+	client := NewLLMClient(config)
+	client.limiter = app.aiLimiter
+	return client
+}
+
 // HandleOrganizeStatus returns the status of the organize features
 func (app *App) HandleOrganizeStatus(w http.ResponseWriter, r *http.Request) {
 	session, err := app.sessionMgr.ValidateSession(r)
@@ -1053,8 +3014,8 @@ func (app *App) HandleOrganizeStatus(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check embedding service health
-	embeddingService := NewEmbeddingService(app.config.EmbeddingServiceURL)
-	embeddingHealthy, _ := embeddingService.IsHealthy()
+	embeddingService := app.newEmbeddingService()
+	embeddingStatus, health, _ := embeddingService.Status()
 
 	// Get embedding count
 	embeddingCount, _ := app.db.GetEmbeddingCount(session.UserID)
@@ -1062,21 +3023,67 @@ func (app *App) HandleOrganizeStatus(w http.ResponseWriter, r *http.Request) {
 	// Get photo count
 	photoCount, _ := app.db.GetUserPhotoCount(session.UserID)
 
+	// Get photos still waiting for an embedding
+	pendingCount, _ := app.db.GetPhotosWithoutEmbeddingsCount(session.UserID)
+
+	// Get archive and storage totals
+	storageStats, err := app.db.GetPhotoStorageStats(session.UserID)
+	if err != nil {
+		storageStats = &PhotoStorageStats{}
+	}
+
+	// Dimensions of the embeddings already stored; more than one means the model changed
+	storedDimensions := make([]int, 0)
+	if dimensions, err := app.db.GetEmbeddingDimensions(session.UserID); err == nil {
+		for dimension := range dimensions {
+			storedDimensions = append(storedDimensions, dimension)
+		}
+		sort.Ints(storedDimensions)
+	}
+
+	// Model details as reported by the service ("" / 0 when unreachable or not reported)
+	var embeddingDevice, embeddingModel string
+	var embeddingDimension int
+	if health != nil {
+		embeddingDevice = health.Device
+		embeddingModel = health.Model
+		embeddingDimension = health.Dimension
+	}
+
 	// Check if LLM is configured
 	llmConfigured := app.config.IsLLMConfigured()
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"embedding_service_healthy": embeddingHealthy,
+		"embedding_service_healthy": embeddingStatus == EmbeddingHealthy,
+		"embedding_service_status":  embeddingStatus,
+		"embedding_service_message": embeddingStatus.Message(),
 		"embedding_service_url":     app.config.EmbeddingServiceURL,
+		"embedding_provider":        app.config.GetEmbeddingConfig().Provider,
+		"embedding_device":          embeddingDevice,
+		"embedding_model":           embeddingModel,
+		"embedding_dimension":       embeddingDimension,
+		"stored_dimensions":         storedDimensions,
 		"embeddings_generated":      embeddingCount,
+		"embeddings_missing":        pendingCount,
 		"total_photos":              photoCount,
+		"archived_photos":           storageStats.ArchivedPhotos,
+		"storage_bytes":             storageStats.TotalBytes,
+		"archived_storage_bytes":    storageStats.ArchivedBytes,
 		"llm_configured":            llmConfigured,
 		"llm_provider":              app.config.LLMProvider,
+		"llm_alternatives":          app.llmAlternatives(),
 		"similarity_threshold":      app.config.SimilarityThreshold,
 	})
 }
 
+// EmbeddingFailure describes one photo an embedding run skipped
+type EmbeddingFailure struct {
+	PhotoID  int64  `json:"photo_id"`
+	Filename string `json:"filename"`
+	Error    string `json:"error"`
+}
+
 // HandleGenerateEmbeddings generates CLIP embeddings for all user's photos
 // Always clears existing embeddings and regenerates for all photos
 func (app *App) HandleGenerateEmbeddings(w http.ResponseWriter, r *http.Request) {
@@ -1092,7 +3099,10 @@ func (app *App) HandleGenerateEmbeddings(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Delete all existing embeddings for this user (start fresh)
-	app.db.DeleteAllEmbeddings(session.UserID)
+	if _, err := app.db.DeleteAllEmbeddings(session.UserID); err != nil {
+		http.Error(w, "Failed to clear embeddings", http.StatusInternalServerError)
+		return
+	}
 
 	// Get all non-archived photos
 	photos, err := app.db.GetNonArchivedPhotos(session.UserID)
@@ -1112,59 +3122,133 @@ func (app *App) HandleGenerateEmbeddings(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Initialize embedding service
-	embeddingService := NewEmbeddingService(app.config.EmbeddingServiceURL)
+	embeddingService := app.newEmbeddingService()
 
 	// Check if service is healthy
-	healthy, _ := embeddingService.IsHealthy()
-	if !healthy {
-		http.Error(w, "Embedding service not available. Please start the CLIP service.", http.StatusServiceUnavailable)
+	status, health, err := embeddingService.Status()
+	if status != EmbeddingHealthy {
+		if err != nil {
+			log.Printf("Embedding service %s: %v", status, err)
+		}
+		http.Error(w, status.Message(), http.StatusServiceUnavailable)
+		return
+	}
+
+	// Every vector must match the model's advertised size (or the first one, if
+	// it doesn't say) and any embeddings stored since the ones cleared above
+	stored, err := app.db.GetEmbeddingDimensions(session.UserID)
+	if err != nil {
+		http.Error(w, "Failed to check stored embeddings", http.StatusInternalServerError)
+		return
+	}
+	if err := embeddingService.ExpectStoredDimension(health.Dimension, stored); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
 		return
 	}
 
 	generated := 0
 	errors := 0
+	busy := false
+	failures := make([]EmbeddingFailure, 0)
+
+	// fail counts a skipped photo, keeping details for the first MaxReportedFailures
+	fail := func(photo *Photo, reason string) {
+		errors++
+		if len(failures) < MaxReportedFailures {
+			failures = append(failures, EmbeddingFailure{PhotoID: photo.ID, Filename: photo.Filename, Error: reason})
+		}
+	}
 
 	for _, photo := range photos {
 		// Get photo path
 		path, err := app.photoMgr.GetOriginalPath(photo)
 		if err != nil {
-			errors++
+			fail(photo, "original file not available")
 			continue
 		}
 
-		// Generate embedding
+		// Generate embedding; if other users are keeping the AI service busy,
+		// stop here and let the next run pick up the remaining photos
 		embedding, err := embeddingService.GenerateEmbedding(path, fmt.Sprintf("%d", photo.ID))
+		if err == errAIBusy {
+			busy = true
+			break
+		}
 		if err != nil {
-			errors++
+			log.Printf("Failed to generate embedding for photo %d: %v", photo.ID, err)
+			fail(photo, err.Error())
 			continue
 		}
 
 		// Save embedding to database
 		embeddingBytes := EmbeddingToBytes(embedding)
-		if err := app.db.SaveEmbedding(photo.ID, embeddingBytes); err != nil {
-			errors++
+		if err := app.db.SaveEmbedding(photo.ID, embeddingBytes, len(embedding)); err != nil {
+			log.Printf("Failed to save embedding for photo %d: %v", photo.ID, err)
+			fail(photo, "failed to save embedding")
 			continue
 		}
 
 		generated++
 	}
 
+	message := fmt.Sprintf("Generated embeddings for %d photos (%d errors)", generated, errors)
+	if busy {
+		message += "; stopped early because the AI service is busy, run again to continue"
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"status":    "success",
-		"message":   fmt.Sprintf("Generated embeddings for %d photos (%d errors)", generated, errors),
-		"generated": generated,
-		"errors":    errors,
-		"total":     len(photos),
+		"status":           "success",
+		"message":          message,
+		"generated":        generated,
+		"errors":           errors,
+		"failures":         failures,
+		"failures_omitted": errors - len(failures),
+		"total":            len(photos),
+		"incomplete":       busy,
+	})
+}
+
+// HandleDeleteEmbeddings clears the user's embeddings without regenerating them,
+// e.g. to free space or start clean after switching models
+func (app *App) HandleDeleteEmbeddings(w http.ResponseWriter, r *http.Request) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := app.sessionMgr.ValidateCSRF(r, session); err != nil {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	deleted, err := app.db.DeleteAllEmbeddings(session.UserID)
+	if err != nil {
+		log.Printf("Failed to delete embeddings for user %d: %v", session.UserID, err)
+		http.Error(w, "Failed to delete embeddings", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("User %s cleared %d embedding(s)", session.Username, deleted)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "success",
+		"message": fmt.Sprintf("Deleted %d embeddings", deleted),
+		"deleted": deleted,
 	})
 }
 
 // FindGroupsRequest is the request body for finding photo groups
 type FindGroupsRequest struct {
 	SimilarityThreshold float64 `json:"similarity_threshold"`
+	Full                bool    `json:"full"` // Recluster everything instead of only new/removed photos
 }
 
 // HandleFindGroups finds groups of similar photos
+// Assignments are persisted so group IDs stay stable; later calls only re-evaluate photos
+// added or removed since the last run unless the threshold changes or a full run is requested
 func (app *App) HandleFindGroups(w http.ResponseWriter, r *http.Request) {
 	session, err := app.sessionMgr.ValidateSession(r)
 	if err != nil {
@@ -1178,10 +3262,28 @@ func (app *App) HandleFindGroups(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Parse request body for threshold (with size limit)
+	// The body is optional; an empty one uses the configured threshold
 	var req FindGroupsRequest
-	if r.Body != nil {
-		r.Body = http.MaxBytesReader(w, r.Body, SmallJSONBodyBytes)
-		json.NewDecoder(r.Body).Decode(&req)
+	r.Body = http.MaxBytesReader(w, r.Body, SmallJSONBodyBytes)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeBodyError(w, err)
+		return
+	}
+
+	// Refuse to compare embeddings from different models: their similarity is meaningless
+	dimensions, err := app.db.GetEmbeddingDimensions(session.UserID)
+	if err != nil {
+		http.Error(w, "Failed to get embeddings", http.StatusInternalServerError)
+		return
+	}
+	if len(dimensions) > 1 {
+		sizes := make([]int, 0, len(dimensions))
+		for dimension := range dimensions {
+			sizes = append(sizes, dimension)
+		}
+		sort.Ints(sizes)
+		http.Error(w, fmt.Sprintf("Embeddings have mixed dimensions %v, probably because the embedding model changed. Regenerate embeddings to find groups.", sizes), http.StatusConflict)
+		return
 	}
 
 	// Get all embeddings for user
@@ -1192,6 +3294,10 @@ func (app *App) HandleFindGroups(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if len(embeddingsRaw) < 2 {
+		// Forget stale groups so they don't resurface once embeddings are regenerated
+		if err := app.db.SavePhotoGroupAssignments(session.UserID, map[int64]int64{}, 0); err != nil {
+			log.Printf("Failed to clear photo groups for user %d: %v", session.UserID, err)
+		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"status":  "success",
@@ -1220,7 +3326,48 @@ func (app *App) HandleFindGroups(w http.ResponseWriter, r *http.Request) {
 		threshold = 0.75
 	}
 
-	result := ClusterPhotos(embeddings, threshold)
+	previous, previousThreshold, err := app.db.GetPhotoGroupAssignments(session.UserID)
+	if err != nil {
+		http.Error(w, "Failed to get photo groups", http.StatusInternalServerError)
+		return
+	}
+	nextGroupID, err := app.db.GetNextPhotoGroupID(session.UserID)
+	if err != nil {
+		http.Error(w, "Failed to get photo groups", http.StatusInternalServerError)
+		return
+	}
+
+	added, removed := 0, 0
+	for photoID := range embeddings {
+		if _, ok := previous[photoID]; !ok {
+			added++
+		}
+	}
+	for photoID := range previous {
+		if _, ok := embeddings[photoID]; !ok {
+			removed++
+		}
+	}
+
+	dbscan := &DBSCAN{
+		Eps:    1.0 - threshold, // Convert similarity to distance
+		MinPts: 2,
+	}
+
+	incremental := !req.Full && len(previous) > 0 && previousThreshold == threshold
+	var assignments map[int64]int64
+	if incremental {
+		assignments = dbscan.Update(embeddings, previous, nextGroupID)
+	} else {
+		assignments = AssignGroupIDs(dbscan.Cluster(embeddings), previous, nextGroupID)
+	}
+
+	if err := app.db.SavePhotoGroupAssignments(session.UserID, assignments, threshold); err != nil {
+		http.Error(w, "Failed to save photo groups", http.StatusInternalServerError)
+		return
+	}
+
+	result := dbscan.GroupsFromAssignments(embeddings, assignments)
 
 	// Get photo details for each group
 	type PhotoGroupWithDetails struct {
@@ -1239,8 +3386,7 @@ func (app *App) HandleFindGroups(w http.ResponseWriter, r *http.Request) {
 				continue
 			}
 			// Add URLs
-			photo.ThumbnailURL = fmt.Sprintf("/api/photos/thumbnail/%d/%s", photo.UserID, url.PathEscape(photo.Filename))
-			photo.OriginalURL = fmt.Sprintf("/api/photos/original/%d/%s", photo.UserID, url.PathEscape(photo.Filename))
+			app.photoMgr.BuildPhotoURLs(photo)
 			photos = append(photos, photo)
 		}
 
@@ -1260,12 +3406,130 @@ func (app *App) HandleFindGroups(w http.ResponseWriter, r *http.Request) {
 		"total_groups":   len(groupsWithDetails),
 		"ungrouped":      len(result.Ungrouped),
 		"total_analyzed": len(embeddings),
+		"incremental":    incremental,
+		"added_photos":   added,
+		"removed_photos": removed,
+	})
+}
+
+// HandleReembedPhoto regenerates the embedding of one photo, e.g. after it was
+// rotated or replaced, without rebuilding the rest (owner or admin)
+func (app *App) HandleReembedPhoto(w http.ResponseWriter, r *http.Request) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := app.sessionMgr.ValidateCSRF(r, session); err != nil {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	photoID, err := strconv.ParseInt(r.PathValue("photoID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid photo ID", http.StatusBadRequest)
+		return
+	}
+
+	photo, err := app.db.GetPhotoByID(photoID)
+	if err != nil || photo == nil || !canViewPhoto(session, photo) {
+		http.NotFound(w, r)
+		return
+	}
+	if photo.UserID != session.UserID && !session.IsAdmin() {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if photo.IsArchived {
+		http.Error(w, "Archived photos aren't embedded", http.StatusBadRequest)
+		return
+	}
+
+	path, err := app.photoMgr.GetOriginalPath(photo)
+	if err != nil {
+		http.Error(w, "Original file not available", http.StatusNotFound)
+		return
+	}
+
+	embeddingService := app.newEmbeddingService()
+	status, health, err := embeddingService.Status()
+	if status != EmbeddingHealthy {
+		if err != nil {
+			log.Printf("Embedding service %s: %v", status, err)
+		}
+		http.Error(w, status.Message(), http.StatusServiceUnavailable)
+		return
+	}
+
+	// The new vector must stay comparable with the owner's other embeddings
+	stored, err := app.db.GetEmbeddingDimensions(photo.UserID)
+	if err != nil {
+		http.Error(w, "Failed to check stored embeddings", http.StatusInternalServerError)
+		return
+	}
+	if err := embeddingService.ExpectStoredDimension(health.Dimension, stored); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	start := time.Now()
+	embedding, err := embeddingService.GenerateEmbedding(path, fmt.Sprintf("%d", photo.ID))
+	if errors.Is(err, errAIBusy) {
+		http.Error(w, "The AI service is busy; try again shortly", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to regenerate embedding for photo %d: %v", photo.ID, err)
+		http.Error(w, fmt.Sprintf("Failed to generate embedding: %v", err), http.StatusBadGateway)
+		return
+	}
+	elapsed := time.Since(start)
+
+	if err := app.db.SaveEmbedding(photo.ID, EmbeddingToBytes(embedding), len(embedding)); err != nil {
+		log.Printf("Failed to save embedding for photo %d: %v", photo.ID, err)
+		http.Error(w, "Failed to save embedding", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":      "success",
+		"photo_id":    photo.ID,
+		"dimension":   len(embedding),
+		"created_at":  start,
+		"duration_ms": elapsed.Milliseconds(),
 	})
 }
 
+// Actions HandleAnalyzeGroup can take on the photos that weren't picked
+// Only archiving is offered: it can be undone, and a model's verdict is no
+// reason to destroy anything. Deleting is left to the user, from the archive
+const (
+	GroupActionArchive = "archive"
+)
+
 // AnalyzeGroupRequest is the request body for analyzing a photo group
 type AnalyzeGroupRequest struct {
 	PhotoIDs []int64 `json:"photo_ids"`
+	// Action, if set, is applied to every analyzed photo except the best one.
+	// Off unless asked for; only the caller's own photos are touched
+	Action string `json:"action,omitempty"`
+	// Provider and Model pick an entry from llm_alternatives instead of the
+	// configured default, e.g. to compare models on the same group
+	Provider LLMProvider `json:"provider,omitempty"`
+	Model    string      `json:"model,omitempty"`
+}
+
+// AnalyzeGroupResponse is the best photo result plus what the optional action did
+type AnalyzeGroupResponse struct {
+	*BestPhotoResult
+	Provider      LLMProvider  `json:"provider"`                    // provider that was asked (the default or an llm_alternatives entry)
+	Skipped       []int64      `json:"skipped_photo_ids,omitempty"` // left out to stay within llm_max_photos_per_analysis
+	Action        string       `json:"action,omitempty"`
+	ActionSkipped string       `json:"action_skipped,omitempty"` // why the action wasn't applied
+	Results       []BulkResult `json:"results,omitempty"`
+	Archived      int          `json:"archived,omitempty"`
 }
 
 // HandleAnalyzeGroup uses LLM to select the best photo from a group
@@ -1292,7 +3556,7 @@ func (app *App) HandleAnalyzeGroup(w http.ResponseWriter, r *http.Request) {
 
 	var req AnalyzeGroupRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeBodyError(w, err)
 		return
 	}
 
@@ -1301,6 +3565,19 @@ func (app *App) HandleAnalyzeGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	switch req.Action {
+	case "", GroupActionArchive:
+	default:
+		http.Error(w, `action must be "archive"; archived photos can be deleted from the archive`, http.StatusBadRequest)
+		return
+	}
+
+	llmConfig, err := app.config.GetLLMConfigFor(req.Provider, req.Model)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Unknown provider/model %q/%q: only the configured default and llm_alternatives may be used", req.Provider, req.Model), http.StatusBadRequest)
+		return
+	}
+
 	// Get photo paths
 	photoPaths := make([]string, 0)
 	photoIDs := make([]int64, 0)
@@ -1330,16 +3607,178 @@ func (app *App) HandleAnalyzeGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Keep big groups within llm_max_photos_per_analysis
+	var skipped []int64
+	if limit := app.config.LLMMaxPhotosPerAnalysis; limit > 0 && len(photoIDs) > limit {
+		kept, ok := app.mostDistinctPhotos(photoIDs, limit)
+		if !ok {
+			http.Error(w, fmt.Sprintf("Too many photos to analyze at once (%d, max %d). Generate embeddings so the most distinct can be picked automatically, or send a smaller selection.", len(photoIDs), limit), http.StatusBadRequest)
+			return
+		}
+
+		keep := make(map[int64]bool, len(kept))
+		for _, id := range kept {
+			keep[id] = true
+		}
+		keptPaths := make([]string, 0, len(kept))
+		for i, id := range photoIDs {
+			if keep[id] {
+				keptPaths = append(keptPaths, photoPaths[i])
+			} else {
+				skipped = append(skipped, id)
+			}
+		}
+		photoPaths, photoIDs = keptPaths, kept
+	}
+
 	// Create LLM client
-	llmClient := NewLLMClient(app.config.GetLLMConfig())
+	llmClient := app.newLLMClientWith(llmConfig)
 
 	// Analyze photos
 	result, err := llmClient.SelectBestPhoto(photoPaths, photoIDs)
+	if errors.Is(err, errAIBusy) {
+		http.Error(w, "The AI service is busy; try again shortly", http.StatusServiceUnavailable)
+		return
+	}
 	if err != nil {
 		http.Error(w, fmt.Sprintf("LLM analysis failed: %v", err), http.StatusInternalServerError)
 		return
 	}
 
+	response := AnalyzeGroupResponse{BestPhotoResult: result, Provider: llmConfig.Provider, Action: req.Action, Skipped: skipped}
+	if req.Action != "" {
+		if result.defaulted {
+			// A guess is no basis for archiving anything
+			response.ActionSkipped = "the AI did not give a usable answer"
+		} else {
+			app.applyGroupAction(session, req.Action, photoIDs, result.BestPhotoID, &response)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// mostDistinctPhotos narrows photoIDs to the n most distinct by embedding
+// ok is false when a photo has no usable embedding or the sizes don't match,
+// since they can't be compared then
+func (app *App) mostDistinctPhotos(photoIDs []int64, n int) ([]int64, bool) {
+	embeddings := make(map[int64][]float64, len(photoIDs))
+	dimension := -1
+	for _, id := range photoIDs {
+		data, err := app.db.GetEmbedding(id)
+		if err != nil || data == nil {
+			return nil, false
+		}
+		embedding, err := EmbeddingFromBytes(data)
+		if err != nil || (dimension >= 0 && len(embedding) != dimension) {
+			return nil, false
+		}
+		dimension = len(embedding)
+		embeddings[id] = embedding
+	}
+	return MostDistinctPhotos(photoIDs, embeddings, n), true
+}
+
+// applyGroupAction archives every photo in photoIDs except bestID, recording
+// the outcome in response. Admins analyzing other users' photos only get the
+// recommendation: the action is limited to the caller's own photos
+func (app *App) applyGroupAction(session *Session, action string, photoIDs []int64, bestID int64, response *AnalyzeGroupResponse) {
+	response.Results = make([]BulkResult, 0, len(photoIDs)-1)
+	for _, photoID := range photoIDs {
+		if photoID == bestID {
+			continue
+		}
+
+		photo, status := app.bulkTarget(session, photoID, false)
+		if photo != nil {
+			if err := app.photoMgr.ArchivePhoto(photo); err != nil {
+				log.Printf("Analyze group: failed to %s photo %d: %v", action, photoID, err)
+				status = BulkStatusFailed
+			} else {
+				response.Archived++
+			}
+		}
+		response.Results = append(response.Results, BulkResult{PhotoID: photoID, Status: status})
+	}
+
+	log.Printf("User %s kept photo %d and applied %s to %d other(s) (%d skipped)",
+		session.Username, bestID, action, response.Archived, countBulkFailures(response.Results))
+}
+
+// ComparePhotosRequest is the request body for a head-to-head comparison
+type ComparePhotosRequest struct {
+	PhotoIDs []int64 `json:"photo_ids"`
+}
+
+// HandleComparePhotos uses the LLM to pick the better of exactly two photos
+func (app *App) HandleComparePhotos(w http.ResponseWriter, r *http.Request) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := app.sessionMgr.ValidateCSRF(r, session); err != nil {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	// Check if LLM is configured
+	if !app.config.IsLLMConfigured() {
+		http.Error(w, "LLM not configured. Please add LLM settings to config.json", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Limit request body size
+	r.Body = http.MaxBytesReader(w, r.Body, SmallJSONBodyBytes)
+
+	var req ComparePhotosRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	if len(req.PhotoIDs) != 2 || req.PhotoIDs[0] == req.PhotoIDs[1] {
+		http.Error(w, "Exactly two different photos are required", http.StatusBadRequest)
+		return
+	}
+
+	photoPaths := make([]string, 0, 2)
+	for _, photoID := range req.PhotoIDs {
+		photo, err := app.db.GetPhotoByID(photoID)
+		if err != nil || photo == nil {
+			http.Error(w, "Both photos must exist and be accessible", http.StatusBadRequest)
+			return
+		}
+
+		// Check access
+		if photo.UserID != session.UserID && !session.IsAdmin() {
+			http.Error(w, "Both photos must exist and be accessible", http.StatusBadRequest)
+			return
+		}
+
+		path, err := app.photoMgr.GetOriginalPath(photo)
+		if err != nil {
+			http.Error(w, "Both photos must exist and be accessible", http.StatusBadRequest)
+			return
+		}
+
+		photoPaths = append(photoPaths, path)
+	}
+
+	llmClient := app.newLLMClient()
+
+	result, err := llmClient.ComparePhotos(photoPaths, req.PhotoIDs)
+	if errors.Is(err, errAIBusy) {
+		http.Error(w, "The AI service is busy; try again shortly", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("LLM comparison failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(result)
 }
@@ -0,0 +1,493 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestPhotoManager returns a photo manager over a fresh database and local
+// storage in a temporary directory; mutate adjusts the config first
+func newTestPhotoManager(t *testing.T, mutate func(*Config)) *PhotoManager {
+	t.Helper()
+
+	config := DefaultConfig()
+	config.StoragePath = t.TempDir()
+	if mutate != nil {
+		mutate(config)
+	}
+
+	db, err := NewDatabase(filepath.Join(config.StoragePath, "test.db"), config.GetDatabaseOptions())
+	if err != nil {
+		t.Fatalf("NewDatabase: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.SetBcryptCost(4) // bcrypt's minimum; the default makes tests slow
+
+	storage, err := NewStorage(config)
+	if err != nil {
+		t.Fatalf("NewStorage: %v", err)
+	}
+
+	return NewPhotoManager(storage, config.MaxUploadMB, db, config.GetThumbnailOptions(), config.GetNormalizeOptions(), config.FilenameStrategy)
+}
+
+// newTestUser creates a user to own test photos
+func newTestUser(t *testing.T, db *Database, username string) *User {
+	t.Helper()
+
+	user, err := db.CreateUser(username, "test-password")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	return user
+}
+
+// testJPEG encodes a small gradient as a JPEG
+func testJPEG(t *testing.T, width, height int) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 255 / width), uint8(y * 255 / height), 128, 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatalf("jpeg.Encode: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// failStatement makes every statement of kind ("INSERT", "UPDATE" or "DELETE")
+// on photos fail until the returned function is called
+func failStatement(t *testing.T, db *Database, kind string) (restore func()) {
+	t.Helper()
+
+	trigger := "fail_photos_" + kind
+	_, err := db.db.Exec(`CREATE TRIGGER ` + trigger + ` BEFORE ` + kind + ` ON photos BEGIN SELECT RAISE(FAIL, 'injected failure'); END`)
+	if err != nil {
+		t.Fatalf("create trigger: %v", err)
+	}
+	return func() {
+		if _, err := db.db.Exec(`DROP TRIGGER ` + trigger); err != nil {
+			t.Fatalf("drop trigger: %v", err)
+		}
+	}
+}
+
+func TestSavePhotoCleansUpWhenInsertFails(t *testing.T) {
+	pm := newTestPhotoManager(t, nil)
+	user := newTestUser(t, pm.db, "alice")
+
+	failStatement(t, pm.db, "INSERT")
+
+	if _, err := pm.SavePhoto("beach.jpg", bytes.NewReader(testJPEG(t, 64, 48)), user.ID, ""); err == nil {
+		t.Fatal("SavePhoto succeeded although the insert failed")
+	}
+
+	for _, key := range []string{pm.getOriginalKey(user.ID, "beach.jpg"), pm.getThumbnailKey(user.ID, "beach.jpg")} {
+		if pm.exists(key) {
+			t.Errorf("%s was left behind", key)
+		}
+	}
+}
+
+func TestArchivePhotoRollsBackWhenUpdateFails(t *testing.T) {
+	pm := newTestPhotoManager(t, nil)
+	user := newTestUser(t, pm.db, "alice")
+
+	photo, err := pm.SavePhoto("beach.jpg", bytes.NewReader(testJPEG(t, 64, 48)), user.ID, "")
+	if err != nil {
+		t.Fatalf("SavePhoto: %v", err)
+	}
+
+	restore := failStatement(t, pm.db, "UPDATE")
+	if err := pm.ArchivePhoto(photo); err == nil {
+		t.Fatal("ArchivePhoto succeeded although the update failed")
+	}
+	restore()
+
+	// The files are moved back and the record is untouched
+	for _, key := range []string{pm.getOriginalKey(user.ID, photo.Filename), pm.getThumbnailKey(user.ID, photo.Filename)} {
+		if !pm.exists(key) {
+			t.Errorf("%s was not moved back", key)
+		}
+	}
+	for _, key := range []string{pm.getArchivedOriginalKey(user.ID, photo.Filename), pm.getArchivedThumbnailKey(user.ID, photo.Filename)} {
+		if pm.exists(key) {
+			t.Errorf("%s was left in the archive", key)
+		}
+	}
+	if stored, _ := pm.db.GetPhotoByID(photo.ID); stored == nil || stored.IsArchived {
+		t.Fatalf("record changed: %+v", stored)
+	}
+
+	// And a retry goes through
+	if err := pm.ArchivePhoto(photo); err != nil {
+		t.Fatalf("retrying ArchivePhoto: %v", err)
+	}
+}
+
+func TestArchivePhotoFinishesAfterCrash(t *testing.T) {
+	pm := newTestPhotoManager(t, nil)
+	user := newTestUser(t, pm.db, "alice")
+
+	photo, err := pm.SavePhoto("beach.jpg", bytes.NewReader(testJPEG(t, 64, 48)), user.ID, "")
+	if err != nil {
+		t.Fatalf("SavePhoto: %v", err)
+	}
+
+	// A crash after the files moved but before the record was updated
+	if err := pm.storage.Rename(pm.getOriginalKey(user.ID, photo.Filename), pm.getArchivedOriginalKey(user.ID, photo.Filename)); err != nil {
+		t.Fatalf("move original: %v", err)
+	}
+	if err := pm.storage.Rename(pm.getThumbnailKey(user.ID, photo.Filename), pm.getArchivedThumbnailKey(user.ID, photo.Filename)); err != nil {
+		t.Fatalf("move thumbnail: %v", err)
+	}
+
+	if err := pm.ArchivePhoto(photo); err != nil {
+		t.Fatalf("ArchivePhoto after the crash: %v", err)
+	}
+
+	stored, err := pm.db.GetPhotoByID(photo.ID)
+	if err != nil || stored == nil {
+		t.Fatalf("GetPhotoByID: %v", err)
+	}
+	if !stored.IsArchived {
+		t.Error("photo is not archived")
+	}
+	if !pm.exists(pm.getArchivedOriginalKey(user.ID, photo.Filename)) {
+		t.Error("archived original is missing")
+	}
+}
+
+func TestArchivePhotoAfterThumbnailEviction(t *testing.T) {
+	pm := newTestPhotoManager(t, nil)
+	user := newTestUser(t, pm.db, "alice")
+
+	photo, err := pm.SavePhoto("beach.jpg", bytes.NewReader(testJPEG(t, 800, 600)), user.ID, "")
+	if err != nil {
+		t.Fatalf("SavePhoto: %v", err)
+	}
+
+	// Evicted by the thumbnail cache's sweep
+	if err := pm.storage.Delete(pm.getThumbnailKey(user.ID, photo.Filename)); err != nil {
+		t.Fatalf("evict thumbnail: %v", err)
+	}
+
+	if err := pm.ArchivePhoto(photo); err != nil {
+		t.Fatalf("ArchivePhoto: %v", err)
+	}
+
+	stored, err := pm.db.GetPhotoByID(photo.ID)
+	if err != nil || stored == nil {
+		t.Fatalf("GetPhotoByID: %v", err)
+	}
+	if !stored.ThumbnailDropped {
+		t.Error("missing archived thumbnail is not marked dropped")
+	}
+
+	// Photos archived before such thumbnails were marked are served too
+	unmarked := *stored
+	unmarked.ThumbnailDropped = false
+
+	for name, p := range map[string]*Photo{"marked": stored, "unmarked": &unmarked} {
+		file, _, err := pm.OpenThumbnail(p, false)
+		if err != nil {
+			t.Errorf("%s: OpenThumbnail: %v", name, err)
+			continue
+		}
+		cfg, _, err := image.DecodeConfig(file)
+		file.Close()
+		if err != nil {
+			t.Errorf("%s: thumbnail is not an image: %v", name, err)
+			continue
+		}
+		if cfg.Width > ThumbnailSize || cfg.Height > ThumbnailSize {
+			t.Errorf("%s: thumbnail is %dx%d, want at most %d", name, cfg.Width, cfg.Height, ThumbnailSize)
+		}
+	}
+}
+
+func TestDeletePhotoKeepsFilesWhenRecordDeleteFails(t *testing.T) {
+	pm := newTestPhotoManager(t, nil)
+	user := newTestUser(t, pm.db, "alice")
+
+	photo, err := pm.SavePhoto("beach.jpg", bytes.NewReader(testJPEG(t, 64, 48)), user.ID, "")
+	if err != nil {
+		t.Fatalf("SavePhoto: %v", err)
+	}
+	originalKey := pm.getOriginalKey(user.ID, photo.Filename)
+
+	restore := failStatement(t, pm.db, "DELETE")
+	if err := pm.DeletePhoto(photo); err == nil {
+		t.Fatal("DeletePhoto succeeded although the record delete failed")
+	}
+	restore()
+
+	if !pm.exists(originalKey) {
+		t.Fatal("original was deleted along with a record that still exists")
+	}
+
+	if err := pm.DeletePhoto(photo); err != nil {
+		t.Fatalf("retrying DeletePhoto: %v", err)
+	}
+	if pm.exists(originalKey) {
+		t.Error("original is still there after deleting")
+	}
+	if stored, _ := pm.db.GetPhotoByID(photo.ID); stored != nil {
+		t.Error("record is still there after deleting")
+	}
+}
+
+// testAnimatedGIF encodes a two-frame 400x200 GIF. The first frame only covers
+// (100,50)-(200,150) in red; the second is blue and covers the whole screen
+func testAnimatedGIF(t *testing.T) []byte {
+	t.Helper()
+
+	first := image.NewPaletted(image.Rect(100, 50, 200, 150), palette.Plan9)
+	second := image.NewPaletted(image.Rect(0, 0, 400, 200), palette.Plan9)
+	for i := range first.Pix {
+		first.Pix[i] = uint8(first.Palette.Index(color.RGBA{255, 0, 0, 255}))
+	}
+	for i := range second.Pix {
+		second.Pix[i] = uint8(second.Palette.Index(color.RGBA{0, 0, 255, 255}))
+	}
+
+	var buf bytes.Buffer
+	err := gif.EncodeAll(&buf, &gif.GIF{
+		Image:  []*image.Paletted{first, second},
+		Delay:  []int{50, 50},
+		Config: image.Config{ColorModel: color.Palette(palette.Plan9), Width: 400, Height: 200},
+	})
+	if err != nil {
+		t.Fatalf("gif.EncodeAll: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeImageAnimatedGIF(t *testing.T) {
+	img, err := decodeImage(bytes.NewReader(testAnimatedGIF(t)))
+	if err != nil {
+		t.Fatalf("decodeImage: %v", err)
+	}
+
+	// The first frame, placed on the full logical screen
+	if got := img.Bounds(); got != image.Rect(0, 0, 400, 200) {
+		t.Fatalf("bounds %v, want the 400x200 screen", got)
+	}
+	if r, g, b, _ := img.At(150, 100).RGBA(); r>>8 != 255 || g != 0 || b != 0 {
+		t.Errorf("inside the first frame got %v, want red", img.At(150, 100))
+	}
+	if _, _, b, _ := img.At(10, 10).RGBA(); b != 0 {
+		t.Errorf("outside the first frame got %v, want the second frame not to show", img.At(10, 10))
+	}
+}
+
+func TestAnimatedGIFThumbnail(t *testing.T) {
+	for _, animated := range []bool{false, true} {
+		pm := newTestPhotoManager(t, func(c *Config) { c.AnimatedGIFThumbnails = animated })
+		user := newTestUser(t, pm.db, "alice")
+
+		photo, err := pm.SavePhoto("party.gif", bytes.NewReader(testAnimatedGIF(t)), user.ID, "")
+		if err != nil {
+			t.Fatalf("SavePhoto: %v", err)
+		}
+
+		file, _, err := pm.OpenThumbnail(photo, false)
+		if err != nil {
+			t.Fatalf("OpenThumbnail: %v", err)
+		}
+		data, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		thumbnail, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("thumbnail is not a GIF: %v", err)
+		}
+
+		if animated {
+			// The original itself, every frame included
+			if len(thumbnail.Image) != 2 {
+				t.Errorf("animated: got %d frames, want the original's 2", len(thumbnail.Image))
+			}
+			continue
+		}
+
+		// A static poster of the first frame, scaled to fit
+		if len(thumbnail.Image) != 1 {
+			t.Errorf("poster: got %d frames, want 1", len(thumbnail.Image))
+		}
+		if thumbnail.Config.Width != ThumbnailSize || thumbnail.Config.Height != ThumbnailSize/2 {
+			t.Errorf("poster: got %dx%d, want %dx%d", thumbnail.Config.Width, thumbnail.Config.Height, ThumbnailSize, ThumbnailSize/2)
+		}
+		if r, _, b, _ := thumbnail.Image[0].At(ThumbnailSize*150/400, ThumbnailSize/4).RGBA(); r>>8 < 200 || b>>8 > 50 {
+			t.Errorf("poster: got %v where the first frame is, want red", thumbnail.Image[0].At(ThumbnailSize*150/400, ThumbnailSize/4))
+		}
+	}
+}
+
+func TestSavePhotoParallelSameName(t *testing.T) {
+	pm := newTestPhotoManager(t, nil)
+	user := newTestUser(t, pm.db, "alice")
+
+	const uploads = 8
+	data := testJPEG(t, 64, 48)
+
+	var wg sync.WaitGroup
+	photos := make([]*Photo, uploads)
+	errs := make([]error, uploads)
+	start := make(chan struct{})
+	for i := 0; i < uploads; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			photos[i], errs[i] = pm.SavePhoto("IMG.jpg", bytes.NewReader(data), user.ID, FilenameStrategySuffix)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	for i, photo := range photos {
+		if errs[i] != nil {
+			t.Fatalf("upload %d: %v", i, errs[i])
+		}
+		if seen[photo.Filename] {
+			t.Fatalf("two uploads were stored as %s", photo.Filename)
+		}
+		seen[photo.Filename] = true
+
+		if !pm.exists(pm.getOriginalKey(user.ID, photo.Filename)) {
+			t.Errorf("original of %s is missing", photo.Filename)
+		}
+	}
+
+	stored, err := pm.db.GetPhotosByUser(user.ID)
+	if err != nil {
+		t.Fatalf("GetPhotosByUser: %v", err)
+	}
+	if len(stored) != uploads {
+		t.Errorf("%d photos stored, want %d", len(stored), uploads)
+	}
+}
+
+func TestSavePhotoBodyLimitIsTooLarge(t *testing.T) {
+	pm := newTestPhotoManager(t, nil)
+	user := newTestUser(t, pm.db, "alice")
+
+	// The handler caps the request body; tripping that cap is the same as an oversized file
+	data := testJPEG(t, 64, 48)
+	body := http.MaxBytesReader(httptest.NewRecorder(), io.NopCloser(bytes.NewReader(data)), int64(len(data)/2))
+
+	if _, err := pm.SavePhoto("beach.jpg", body, user.ID, ""); !errors.Is(err, errFileTooLarge) {
+		t.Fatalf("got %v, want errFileTooLarge", err)
+	}
+}
+
+func TestThumbnailETagChangesWithRenderSettings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "beach.gif")
+	if err := os.WriteFile(path, testAnimatedGIF(t), 0600); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := ThumbnailOptions{Mode: ThumbnailModeFit, Quality: 85, Filter: "lanczos"}
+	tag := thumbnailETag("beach.gif", info, base, false)
+
+	changed := map[string]ThumbnailOptions{
+		"mode":     {Mode: ThumbnailModeFill, Quality: 85, Filter: "lanczos"},
+		"quality":  {Mode: ThumbnailModeFit, Quality: 60, Filter: "lanczos"},
+		"filter":   {Mode: ThumbnailModeFit, Quality: 85, Filter: "nearest_neighbor"},
+		"animated": {Mode: ThumbnailModeFit, Quality: 85, Filter: "lanczos", AnimatedGIFs: true},
+	}
+	for name, opts := range changed {
+		if thumbnailETag("beach.gif", info, opts, false) == tag {
+			t.Errorf("ETag unchanged after changing the %s", name)
+		}
+	}
+	if thumbnailETag("beach.gif", info, base, true) == tag {
+		t.Error("stripped and unstripped thumbnails share an ETag")
+	}
+	if thumbnailETag("beach.gif", info, base, false) != tag {
+		t.Error("ETag is not stable for the same settings")
+	}
+}
+
+func TestHandleUploadOversizedBodyWithoutLength(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxUploadMB = 1
+	pm := newTestPhotoManager(t, func(c *Config) { c.MaxUploadMB = config.MaxUploadMB })
+	user := newTestUser(t, pm.db, "alice")
+
+	sm := NewSessionManager(pm.db, 1, 1, CookieOptions{})
+	session := &Session{Token: "session", UserID: user.ID, Username: user.Username, ExpiresAt: time.Now().Add(time.Hour), CSRFToken: "csrf"}
+	sm.mu.Lock()
+	sm.sessions[session.Token] = session
+	sm.mu.Unlock()
+	app := &App{config: config, db: pm.db, photoMgr: pm, sessionMgr: sm}
+
+	// The token is a form field after the file, as a plain HTML form sends it
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("photo", "beach.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(bytes.Repeat([]byte("x"), int(config.MaxUploadMB<<20+UploadOverheadBytes)))
+	form.WriteField("csrf_token", session.CSRFToken)
+	form.Close()
+
+	// Hide the length, as a chunked upload would
+	req := httptest.NewRequest(http.MethodPost, "/upload", io.NopCloser(&body))
+	req.ContentLength = -1
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: session.Token})
+
+	rec := httptest.NewRecorder()
+	app.HandleUpload(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status %d, want %d (%s)", rec.Code, http.StatusRequestEntityTooLarge, rec.Body.String())
+	}
+}
+
+func TestSyncCursorRoundTrip(t *testing.T) {
+	want := SyncCursor{UpdatedAt: time.Date(2024, 1, 2, 15, 4, 5, 123e6, time.UTC), PhotoID: 42}
+
+	got, err := parseSyncCursor(formatSyncCursor(want))
+	if err != nil {
+		t.Fatalf("parseSyncCursor: %v", err)
+	}
+	if !got.UpdatedAt.Equal(want.UpdatedAt) || got.PhotoID != want.PhotoID {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	for _, bad := range []string{"", "42", "x-1", "1-x", "1-2-3"} {
+		if _, err := parseSyncCursor(bad); err == nil {
+			t.Errorf("parseSyncCursor(%q) succeeded", bad)
+		}
+	}
+}
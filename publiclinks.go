@@ -0,0 +1,220 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// hashPublicToken returns the form a public link token is stored in
+// Tokens are random, so a plain SHA-256 is enough to make a leaked database useless
+func hashPublicToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// publicLinkPhoto returns the photo in the path if the session may manage its links:
+// the owner, or an admin for listing and revoking
+func (app *App) publicLinkPhoto(w http.ResponseWriter, r *http.Request, session *Session, allowAdmin bool) *Photo {
+	photoID, err := strconv.ParseInt(r.PathValue("photoID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid photo ID", http.StatusBadRequest)
+		return nil
+	}
+
+	photo, err := app.db.GetPhotoByID(photoID)
+	if err != nil || photo == nil || !canViewPhoto(session, photo) {
+		http.NotFound(w, r)
+		return nil
+	}
+
+	if photo.UserID != session.UserID && !(allowAdmin && session.IsAdmin()) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil
+	}
+
+	return photo
+}
+
+// HandleCreatePublicLink creates a link that shows one photo to anyone who has it,
+// without an account. Owner only; the token is returned once and can't be listed again
+// Body (optional): {"expires_hours": 72}
+func (app *App) HandleCreatePublicLink(w http.ResponseWriter, r *http.Request) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := app.sessionMgr.ValidateCSRF(r, session); err != nil {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	if !app.config.AllowPublicLinks {
+		http.Error(w, "Public links are disabled on this server", http.StatusForbidden)
+		return
+	}
+
+	photo := app.publicLinkPhoto(w, r, session, false)
+	if photo == nil {
+		return
+	}
+
+	if photo.IsArchived {
+		http.Error(w, "Archived photos can't be shared by link", http.StatusBadRequest)
+		return
+	}
+
+	// Limit request body size
+	r.Body = http.MaxBytesReader(w, r.Body, SmallJSONBodyBytes)
+
+	var body struct {
+		ExpiresHours int `json:"expires_hours"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+		writeBodyError(w, err)
+		return
+	}
+
+	hours := body.ExpiresHours
+	if hours == 0 {
+		hours = PublicLinkHours
+	}
+	if hours < 1 || hours > MaxPublicLinkHours {
+		http.Error(w, fmt.Sprintf("expires_hours must be between 1 and %d", MaxPublicLinkHours), http.StatusBadRequest)
+		return
+	}
+
+	token, err := generateRandomToken(PublicTokenLength)
+	if err != nil {
+		http.Error(w, "Failed to create link", http.StatusInternalServerError)
+		return
+	}
+
+	link, err := app.db.CreatePublicLink(hashPublicToken(token), photo.ID, session.UserID, time.Now().Add(time.Duration(hours)*time.Hour))
+	if err != nil {
+		log.Printf("Failed to create public link for photo %d: %v", photo.ID, err)
+		http.Error(w, "Failed to create link", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("User %s created public link %d for photo %d (expires %s)", session.Username, link.ID, photo.ID, link.ExpiresAt.Format(time.RFC3339))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"link":   link,
+		"url":    "/api/public/" + token,
+	})
+}
+
+// HandleListPublicLinks lists a photo's unexpired public links (owner or admin)
+func (app *App) HandleListPublicLinks(w http.ResponseWriter, r *http.Request) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	photo := app.publicLinkPhoto(w, r, session, true)
+	if photo == nil {
+		return
+	}
+
+	links, err := app.db.GetPublicLinks(photo.ID)
+	if err != nil {
+		http.Error(w, "Failed to get links", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"links":  links,
+	})
+}
+
+// HandleDeletePublicLink revokes a public link (owner or admin)
+func (app *App) HandleDeletePublicLink(w http.ResponseWriter, r *http.Request) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := app.sessionMgr.ValidateCSRF(r, session); err != nil {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	photo := app.publicLinkPhoto(w, r, session, true)
+	if photo == nil {
+		return
+	}
+
+	linkID, err := strconv.ParseInt(r.PathValue("linkID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid link ID", http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := app.db.DeletePublicLink(photo.ID, linkID)
+	if err != nil {
+		http.Error(w, "Failed to revoke link", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.NotFound(w, r)
+		return
+	}
+
+	log.Printf("User %s revoked public link %d for photo %d", session.Username, linkID, photo.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "Link revoked",
+	})
+}
+
+// HandleGetPublicPhoto serves the photo behind a public link without a session
+// This is the only route that bypasses authentication, and it reaches exactly
+// one photo. ?size=thumbnail serves the thumbnail; otherwise the original is
+// served with the same ?download=1 and ?format= options as HandleGetOriginal.
+// Unknown, revoked and expired tokens all look like a missing page, as do
+// links to photos that have since been archived
+func (app *App) HandleGetPublicPhoto(w http.ResponseWriter, r *http.Request) {
+	if !app.config.AllowPublicLinks {
+		http.NotFound(w, r)
+		return
+	}
+
+	photo, err := app.db.UsePublicLink(hashPublicToken(r.PathValue("token")))
+	if err != nil {
+		log.Printf("Failed to look up public link: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if photo == nil || photo.IsArchived {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Keep shared caches and search engines from holding on to the photo
+	// after the link is revoked
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+
+	if r.URL.Query().Get("size") == "thumbnail" {
+		app.serveThumbnail(w, r, photo, 0)
+		return
+	}
+	app.serveOriginal(w, r, photo, 0)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Instance-wide visibility of new uploads (config default_visibility)
+const (
+	VisibilityUser    = "user"    // follow each user's default_shared setting
+	VisibilityPrivate = "private" // always private, whatever users chose
+	VisibilityShared  = "shared"  // always shared to the family area, whatever users chose
+)
+
+// shareUploadsByDefault decides whether a new upload starts out shared
+// A "private" or "shared" instance default overrides the user's own setting
+func (app *App) shareUploadsByDefault(userID int64) bool {
+	switch app.config.DefaultVisibility {
+	case VisibilityPrivate:
+		return false
+	case VisibilityShared:
+		return true
+	}
+
+	settings, err := app.db.GetUserSettings(userID)
+	return err == nil && settings.DefaultShared
+}
+
+// HandleGetSettings returns the current user's preferences
+func (app *App) HandleGetSettings(w http.ResponseWriter, r *http.Request) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	settings, err := app.db.GetUserSettings(session.UserID)
+	if err != nil {
+		http.Error(w, "Failed to load settings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+// HandleUpdateSettings updates the current user's preferences
+// Fields left out of the request body keep their current value
+func (app *App) HandleUpdateSettings(w http.ResponseWriter, r *http.Request) {
+	session, err := app.sessionMgr.ValidateSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := app.sessionMgr.ValidateCSRF(r, session); err != nil {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	// Limit request body size
+	r.Body = http.MaxBytesReader(w, r.Body, MaxJSONBodyBytes)
+
+	var body struct {
+		DefaultShared *bool           `json:"default_shared"`
+		UIPreferences json.RawMessage `json:"ui_preferences"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	settings, err := app.db.GetUserSettings(session.UserID)
+	if err != nil {
+		http.Error(w, "Failed to load settings", http.StatusInternalServerError)
+		return
+	}
+
+	if body.DefaultShared != nil {
+		settings.DefaultShared = *body.DefaultShared
+	}
+
+	if body.UIPreferences != nil {
+		// Must be a JSON object so the frontend can add keys freely
+		var prefs map[string]interface{}
+		if err := json.Unmarshal(body.UIPreferences, &prefs); err != nil || prefs == nil {
+			http.Error(w, "ui_preferences must be a JSON object", http.StatusBadRequest)
+			return
+		}
+		settings.UIPreferences = body.UIPreferences
+	}
+
+	if err := app.db.UpdateUserSettings(settings); err != nil {
+		http.Error(w, "Failed to update settings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":   "success",
+		"message":  "Settings updated",
+		"settings": settings,
+	})
+}
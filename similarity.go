@@ -1,169 +1,219 @@
 package main
 
 import (
-	"bytes"
-	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"math"
-	"net/http"
+	"net/url"
 	"os"
-	"time"
+	"sort"
+	"sync"
 )
 
-// EmbeddingService handles communication with the CLIP embedding service
-type EmbeddingService struct {
-	baseURL    string
-	httpClient *http.Client
+// EmbeddingProvider represents the supported embedding providers
+type EmbeddingProvider string
+
+const (
+	EmbeddingProviderCLIP   EmbeddingProvider = "clip"   // the bundled CLIP service (embeddings/main.py)
+	EmbeddingProviderOpenAI EmbeddingProvider = "openai" // an OpenAI-compatible /embeddings endpoint that accepts images
+)
+
+// EmbeddingConfig contains configuration for the embedding provider
+type EmbeddingConfig struct {
+	Provider EmbeddingProvider // clip (default) or openai
+	BaseURL  string            // Service URL (openai: the API base, e.g. http://host:7997/v1)
+	APIKey   string            // Sent as a bearer token (openai only, optional)
+	Model    string            // Model name (openai only)
+}
+
+// Embedder is the transport to an embedding provider
+// Implementations only talk to the provider; EmbeddingService validates what they return
+type Embedder interface {
+	// Health reports whether the provider is reachable and which model it runs
+	Health() (*HealthResponse, error)
+	// Embed returns the embedding of one image
+	Embed(imageData []byte, imageID string) (*EmbeddingResponse, error)
 }
 
-// EmbeddingRequest is the request to generate an embedding
-type EmbeddingRequest struct {
-	ImageBase64 string `json:"image_base64"`
-	ImageID     string `json:"image_id,omitempty"`
+// EmbeddingService generates photo embeddings through the configured provider
+type EmbeddingService struct {
+	embedder Embedder
+
+	mu        sync.Mutex
+	dimension int // Expected vector size; 0 = pinned by the first embedding received
+
+	limiter *aiLimiter // shared cap on concurrent AI calls (nil = unlimited)
 }
 
-// EmbeddingResponse is the response from the embedding service
+// EmbeddingResponse is an embedding returned by a provider
 type EmbeddingResponse struct {
 	ImageID   string    `json:"image_id"`
 	Embedding []float64 `json:"embedding"`
 	Dimension int       `json:"dimension"`
 }
 
-// BatchEmbeddingRequest is a batch request for multiple embeddings
-type BatchEmbeddingRequest struct {
-	Images []EmbeddingRequest `json:"images"`
-}
-
-// BatchEmbeddingResponse is the response for batch embeddings
-type BatchEmbeddingResponse struct {
-	Embeddings []EmbeddingResponse `json:"embeddings"`
-}
-
 // HealthResponse is the health check response
 type HealthResponse struct {
 	Status      string `json:"status"`
 	ModelLoaded bool   `json:"model_loaded"`
 	Device      string `json:"device"`
+	Model       string `json:"model,omitempty"`     // Absent from older services
+	Dimension   int    `json:"dimension,omitempty"` // Size of the vectors the model produces
 }
 
-// NewEmbeddingService creates a new embedding service client
-func NewEmbeddingService(baseURL string) *EmbeddingService {
-	if baseURL == "" {
-		baseURL = "http://127.0.0.1:8081"
-	}
-	return &EmbeddingService{
-		baseURL: baseURL,
-		httpClient: &http.Client{
-			Timeout: 60 * time.Second, // Longer timeout for model inference
-		},
+// NewEmbeddingService creates an embedding client for the configured provider
+func NewEmbeddingService(config EmbeddingConfig) *EmbeddingService {
+	var embedder Embedder
+	switch config.Provider {
+	case EmbeddingProviderOpenAI:
+		embedder = newOpenAIEmbedder(config)
+	default:
+		embedder = newCLIPEmbedder(config.BaseURL)
 	}
+	return &EmbeddingService{embedder: embedder}
 }
 
-// IsHealthy checks if the embedding service is running and ready
-func (es *EmbeddingService) IsHealthy() (bool, error) {
-	resp, err := es.httpClient.Get(es.baseURL + "/health")
-	if err != nil {
-		return false, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return false, nil
-	}
-
-	var health HealthResponse
-	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
-		return false, err
-	}
+// Health fetches the embedding provider's health report
+func (es *EmbeddingService) Health() (*HealthResponse, error) {
+	return es.embedder.Health()
+}
 
-	return health.Status == "healthy" && health.ModelLoaded, nil
+// Ready reports whether a health report describes a service that can embed images
+func (h *HealthResponse) Ready() bool {
+	return h != nil && h.Status == "healthy" && h.ModelLoaded
 }
 
-// GenerateEmbedding generates an embedding for a single image file
-func (es *EmbeddingService) GenerateEmbedding(imagePath string, imageID string) ([]float64, error) {
-	// Read image file
-	imageData, err := os.ReadFile(imagePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read image: %w", err)
-	}
+// EmbeddingStatus says whether the embedding service can be used, and if not, why
+type EmbeddingStatus string
 
-	// Encode to base64
-	imageBase64 := base64.StdEncoding.EncodeToString(imageData)
+const (
+	EmbeddingUnreachable EmbeddingStatus = "unreachable" // nothing answered: the service isn't running or the URL is wrong
+	EmbeddingUnhealthy   EmbeddingStatus = "unhealthy"   // the service answered but can't embed yet, e.g. the model is still loading
+	EmbeddingHealthy     EmbeddingStatus = "healthy"
+)
 
-	// Create request
-	req := EmbeddingRequest{
-		ImageBase64: imageBase64,
-		ImageID:     imageID,
-	}
+// Status checks whether the embedding service is running and ready
+// The health report is returned whenever the service sent one
+func (es *EmbeddingService) Status() (EmbeddingStatus, *HealthResponse, error) {
+	health, err := es.Health()
 
-	reqBody, err := json.Marshal(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	// The HTTP client wraps connection failures and timeouts in *url.Error;
+	// anything else means the service answered
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return EmbeddingUnreachable, nil, err
 	}
-
-	// Send request
-	resp, err := es.httpClient.Post(
-		es.baseURL+"/embed",
-		"application/json",
-		bytes.NewReader(reqBody),
-	)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		return EmbeddingUnhealthy, nil, err
+	}
+	if !health.Ready() {
+		return EmbeddingUnhealthy, health, nil
 	}
-	defer resp.Body.Close()
+	return EmbeddingHealthy, health, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("embedding service error: %s", string(body))
+// Message describes a status for users, with what to do about it
+func (s EmbeddingStatus) Message() string {
+	switch s {
+	case EmbeddingUnreachable:
+		return "Embedding service not reachable. Please start the CLIP service or check embedding_service_url."
+	case EmbeddingUnhealthy:
+		return "Embedding service is starting up (the model may still be loading). Try again in a minute."
 	}
+	return "Embedding service is ready"
+}
 
-	var embResp EmbeddingResponse
-	if err := json.NewDecoder(resp.Body).Decode(&embResp); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+// ExpectDimension sets the vector size embeddings must have, such as the size of
+// those already stored; 0 pins it to whatever the next embedding returns
+func (es *EmbeddingService) ExpectDimension(dimension int) {
+	es.mu.Lock()
+	es.dimension = dimension
+	es.mu.Unlock()
+}
+
+// errDimensionMismatch means new embeddings wouldn't match the size of a user's stored ones
+var errDimensionMismatch = errors.New("embedding dimension doesn't match the stored embeddings")
+
+// ExpectStoredDimension sets the vector size embeddings must have from the
+// size the service reported (0 if it doesn't say) and the sizes of the user's
+// stored embeddings (see Database.GetEmbeddingDimensions), so new vectors stay
+// comparable with the old ones. A service that doesn't report its size is held
+// to the stored size. Fails with errDimensionMismatch if the sizes disagree,
+// e.g. after switching models without clearing the old embeddings
+func (es *EmbeddingService) ExpectStoredDimension(reported int, stored map[int]int) error {
+	dimensions := make([]int, 0, len(stored))
+	for dimension := range stored {
+		// Embeddings saved before dimensions were recorded
+		if dimension != 0 {
+			dimensions = append(dimensions, dimension)
+		}
+	}
+	sort.Ints(dimensions)
+
+	expected := reported
+	for _, dimension := range dimensions {
+		if expected == 0 {
+			expected = dimension
+		} else if dimension != expected {
+			if reported != 0 {
+				return fmt.Errorf("%w: the service produces %d values but %d stored embedding(s) have %d; clear the embeddings and generate them again",
+					errDimensionMismatch, reported, stored[dimension], dimension)
+			}
+			return fmt.Errorf("%w: stored embeddings have mixed dimensions %v; clear the embeddings and generate them again", errDimensionMismatch, dimensions)
+		}
 	}
 
-	return embResp.Embedding, nil
+	es.ExpectDimension(expected)
+	return nil
 }
 
-// GenerateEmbeddingFromBytes generates an embedding from image bytes
-func (es *EmbeddingService) GenerateEmbeddingFromBytes(imageData []byte, imageID string) ([]float64, error) {
-	// Encode to base64
-	imageBase64 := base64.StdEncoding.EncodeToString(imageData)
+// checkEmbedding rejects responses whose vector is empty, disagrees with the
+// dimension the service reported, or differs in size from earlier embeddings
+// Mixing sizes would make every similarity involving the odd vector 0
+func (es *EmbeddingService) checkEmbedding(embResp *EmbeddingResponse) error {
+	got := len(embResp.Embedding)
+	if got == 0 {
+		return fmt.Errorf("embedding service returned an empty embedding")
+	}
+	if embResp.Dimension != got {
+		return fmt.Errorf("embedding service reported dimension %d but returned %d values", embResp.Dimension, got)
+	}
 
-	// Create request
-	req := EmbeddingRequest{
-		ImageBase64: imageBase64,
-		ImageID:     imageID,
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if es.dimension == 0 {
+		es.dimension = got
+	} else if got != es.dimension {
+		return fmt.Errorf("embedding has dimension %d but %d was expected; check the embedding service's model", got, es.dimension)
 	}
+	return nil
+}
 
-	reqBody, err := json.Marshal(req)
+// GenerateEmbedding generates an embedding for a single image file
+func (es *EmbeddingService) GenerateEmbedding(imagePath string, imageID string) ([]float64, error) {
+	imageData, err := os.ReadFile(imagePath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
+		return nil, fmt.Errorf("failed to read image: %w", err)
 	}
+	return es.GenerateEmbeddingFromBytes(imageData, imageID)
+}
 
-	// Send request
-	resp, err := es.httpClient.Post(
-		es.baseURL+"/embed",
-		"application/json",
-		bytes.NewReader(reqBody),
-	)
+// GenerateEmbeddingFromBytes generates an embedding from image bytes
+// Waits for a free slot in the limiter first
+func (es *EmbeddingService) GenerateEmbeddingFromBytes(imageData []byte, imageID string) ([]float64, error) {
+	release, err := es.limiter.acquire()
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("embedding service error: %s", string(body))
+	embResp, err := es.embedder.Embed(imageData, imageID)
+	release()
+	if err != nil {
+		return nil, err
 	}
-
-	var embResp EmbeddingResponse
-	if err := json.NewDecoder(resp.Body).Decode(&embResp); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+	if err := es.checkEmbedding(embResp); err != nil {
+		return nil, err
 	}
-
 	return embResp.Embedding, nil
 }
 
@@ -212,14 +262,11 @@ type PhotoSimilarity struct {
 }
 
 // FindSimilarPhotos finds all pairs of photos with similarity above the threshold
+// Pairs are ordered by similarity (highest first), then by photo IDs
 func FindSimilarPhotos(embeddings map[int64][]float64, threshold float64) []PhotoSimilarity {
 	var similarities []PhotoSimilarity
 
-	// Get all photo IDs
-	ids := make([]int64, 0, len(embeddings))
-	for id := range embeddings {
-		ids = append(ids, id)
-	}
+	ids := sortedPhotoIDs(embeddings)
 
 	// Compare all pairs
 	for i := 0; i < len(ids); i++ {
@@ -237,9 +284,57 @@ func FindSimilarPhotos(embeddings map[int64][]float64, threshold float64) []Phot
 		}
 	}
 
+	sort.SliceStable(similarities, func(i, j int) bool {
+		return similarities[i].Similarity > similarities[j].Similarity
+	})
+
 	return similarities
 }
 
+// MostDistinctPhotos picks n of ids whose embeddings are as different from each
+// other as possible: starting from the first, it repeatedly adds the photo
+// farthest from everything picked so far. The result keeps the order of ids
+func MostDistinctPhotos(ids []int64, embeddings map[int64][]float64, n int) []int64 {
+	if n >= len(ids) {
+		return ids
+	}
+	if n <= 0 {
+		return []int64{}
+	}
+
+	picked := make([]bool, len(ids))
+	picked[0] = true
+
+	// nearest[i] is ids[i]'s distance to the closest picked photo
+	nearest := make([]float64, len(ids))
+	for i := range ids {
+		nearest[i] = CosineDistance(embeddings[ids[i]], embeddings[ids[0]])
+	}
+
+	for count := 1; count < n; count++ {
+		best := -1
+		for i := range ids {
+			if !picked[i] && (best < 0 || nearest[i] > nearest[best]) {
+				best = i
+			}
+		}
+		picked[best] = true
+		for i := range ids {
+			if d := CosineDistance(embeddings[ids[i]], embeddings[ids[best]]); d < nearest[i] {
+				nearest[i] = d
+			}
+		}
+	}
+
+	result := make([]int64, 0, n)
+	for i, id := range ids {
+		if picked[i] {
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
 // EmbeddingToBytes converts an embedding to bytes for database storage
 func EmbeddingToBytes(embedding []float64) []byte {
 	data, _ := json.Marshal(embedding)
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// fakeEmbedder answers every request with the same response
+type fakeEmbedder struct {
+	response *EmbeddingResponse
+}
+
+func (f *fakeEmbedder) Health() (*HealthResponse, error) {
+	return &HealthResponse{Status: "healthy", ModelLoaded: true}, nil
+}
+
+func (f *fakeEmbedder) Embed(imageData []byte, imageID string) (*EmbeddingResponse, error) {
+	return f.response, nil
+}
+
+func TestGenerateEmbeddingChecksDimension(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected int // passed to ExpectDimension
+		response EmbeddingResponse
+		wantErr  bool
+	}{
+		{"matches", 3, EmbeddingResponse{Embedding: []float64{1, 2, 3}, Dimension: 3}, false},
+		{"pinned by first", 0, EmbeddingResponse{Embedding: []float64{1, 2, 3}, Dimension: 3}, false},
+		{"empty", 0, EmbeddingResponse{Embedding: nil, Dimension: 0}, true},
+		{"reported differs from values", 0, EmbeddingResponse{Embedding: []float64{1, 2}, Dimension: 3}, true},
+		{"differs from expected", 512, EmbeddingResponse{Embedding: []float64{1, 2, 3}, Dimension: 3}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			es := &EmbeddingService{embedder: &fakeEmbedder{response: &tt.response}}
+			es.ExpectDimension(tt.expected)
+
+			embedding, err := es.GenerateEmbeddingFromBytes([]byte("image"), "1")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got embedding %v, want an error", embedding)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(embedding) != len(tt.response.Embedding) {
+				t.Errorf("got %d values, want %d", len(embedding), len(tt.response.Embedding))
+			}
+		})
+	}
+}
+
+func TestGenerateEmbeddingPinsFirstDimension(t *testing.T) {
+	fake := &fakeEmbedder{response: &EmbeddingResponse{Embedding: []float64{1, 2, 3}, Dimension: 3}}
+	es := &EmbeddingService{embedder: fake}
+
+	if _, err := es.GenerateEmbeddingFromBytes([]byte("image"), "1"); err != nil {
+		t.Fatalf("first embedding: %v", err)
+	}
+
+	fake.response = &EmbeddingResponse{Embedding: []float64{1, 2}, Dimension: 2}
+	if _, err := es.GenerateEmbeddingFromBytes([]byte("image"), "2"); err == nil {
+		t.Fatal("second embedding with a different dimension was accepted")
+	}
+}
+
+func TestExpectStoredDimension(t *testing.T) {
+	tests := []struct {
+		name     string
+		reported int
+		stored   map[int]int
+		want     int // expected dimension afterwards
+		wantErr  bool
+	}{
+		{"nothing stored", 512, nil, 512, false},
+		{"nothing stored or reported", 0, nil, 0, false},
+		{"stored matches", 512, map[int]int{512: 10}, 512, false},
+		{"unreported takes stored", 0, map[int]int{768: 4}, 768, false},
+		{"unknown stored sizes ignored", 512, map[int]int{0: 2, 512: 3}, 512, false},
+		{"model switched", 768, map[int]int{512: 10}, 0, true},
+		{"stored mixed", 0, map[int]int{512: 1, 768: 1}, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			es := &EmbeddingService{}
+			err := es.ExpectStoredDimension(tt.reported, tt.stored)
+			if tt.wantErr {
+				if !errors.Is(err, errDimensionMismatch) {
+					t.Fatalf("got error %v, want errDimensionMismatch", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if es.dimension != tt.want {
+				t.Errorf("expected dimension %d, want %d", es.dimension, tt.want)
+			}
+		})
+	}
+}
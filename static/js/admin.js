@@ -2,10 +2,12 @@
 
 const csrfToken = document.getElementById('csrfToken')?.value || '';
 let confirmCallback = null;
+let groups = [];
 
 document.addEventListener('DOMContentLoaded', () => {
     loadStats();
-    loadUsers();
+    loadGroups().then(loadUsers);
+    loadInvites();
     setupConfirm();
 });
 
@@ -17,6 +19,14 @@ async function loadStats() {
         const stats = await response.json();
         document.getElementById('totalUsers').textContent = stats.total_users;
         document.getElementById('totalPhotos').textContent = stats.total_photos;
+        document.getElementById('totalBytes').textContent = formatSize(stats.total_bytes);
+        document.getElementById('diskBytes').textContent = stats.disk_bytes != null ? formatSize(stats.disk_bytes) : 'n/a';
+        document.getElementById('diskFree').textContent = stats.disk_free_bytes != null ? formatSize(stats.disk_free_bytes) : 'n/a';
+        const diskFreeCard = document.getElementById('diskFreeCard');
+        diskFreeCard.classList.toggle('stat-card-warning', !!stats.disk_low);
+        diskFreeCard.title = stats.storage_warning || '';
+        document.getElementById('sharedPhotos').textContent = stats.shared_photos;
+        document.getElementById('archivedPhotos').textContent = stats.archived_photos;
     } catch (error) {
         console.error('Error loading stats:', error);
     }
@@ -42,6 +52,7 @@ async function loadUsers() {
                     <tr>
                         <th>Username</th>
                         <th>Role</th>
+                        <th>Group</th>
                         <th>Photos</th>
                         <th>Joined</th>
                         <th></th>
@@ -52,6 +63,14 @@ async function loadUsers() {
                         <tr>
                             <td>${esc(user.username)}</td>
                             <td><span class="role-badge ${user.role}">${user.role}</span></td>
+                            <td>
+                                <select onchange="setUserGroup(${user.id}, this.value)">
+                                    <option value="0">None</option>
+                                    ${groups.map(group => `
+                                        <option value="${group.id}" ${group.id === user.group_id ? 'selected' : ''}>${esc(group.name)}</option>
+                                    `).join('')}
+                                </select>
+                            </td>
                             <td>${user.photo_count}</td>
                             <td>${formatDate(user.created_at)}</td>
                             <td>
@@ -59,6 +78,11 @@ async function loadUsers() {
                                     <button class="btn btn-ghost btn-sm" onclick="toggleRole(${user.id}, '${user.role}')">
                                         ${user.role === 'admin' ? 'Make User' : 'Make Admin'}
                                     </button>
+                                    ${user.role !== 'admin' ? `
+                                    <button class="btn btn-ghost btn-sm" onclick="impersonate(${user.id})">
+                                        Act as
+                                    </button>
+                                    ` : ''}
                                     <button class="btn btn-danger btn-sm" onclick="confirmDelete(${user.id}, '${esc(user.username)}')">
                                         Delete
                                     </button>
@@ -94,6 +118,217 @@ async function toggleRole(userId, currentRole) {
     }
 }
 
+async function setUserGroup(userId, groupId) {
+    try {
+        const response = await fetch(`/api/admin/users/${userId}/group`, {
+            method: 'PUT',
+            headers: {
+                'Content-Type': 'application/json',
+                'X-CSRF-Token': csrfToken
+            },
+            body: JSON.stringify({ group_id: parseInt(groupId, 10) || 0 })
+        });
+
+        if (!response.ok) throw new Error(await response.text());
+        loadGroups();
+    } catch (error) {
+        alert('Failed to update group: ' + error.message);
+        loadUsers();
+    }
+}
+
+async function impersonate(userId) {
+    try {
+        const response = await fetch(`/api/admin/users/${userId}/impersonate`, {
+            method: 'POST',
+            headers: { 'X-CSRF-Token': csrfToken }
+        });
+
+        if (!response.ok) throw new Error(await response.text());
+        window.location.href = '/';
+    } catch (error) {
+        alert('Failed to impersonate user: ' + error.message);
+    }
+}
+
+async function loadGroups() {
+    const container = document.getElementById('groupsList');
+
+    try {
+        const response = await fetch('/api/admin/groups');
+        if (!response.ok) throw new Error('Failed');
+
+        groups = await response.json();
+
+        if (!groups?.length) {
+            container.innerHTML = '<p style="color: var(--text-muted);">No groups. Everyone shares one family area.</p>';
+            return;
+        }
+
+        container.innerHTML = `
+            <table class="table">
+                <thead>
+                    <tr>
+                        <th>Name</th>
+                        <th>Members</th>
+                        <th>Created</th>
+                        <th></th>
+                    </tr>
+                </thead>
+                <tbody>
+                    ${groups.map(group => `
+                        <tr>
+                            <td>${esc(group.name)}</td>
+                            <td>${group.members.map(esc).join(', ') || '-'}</td>
+                            <td>${formatDate(group.created_at)}</td>
+                            <td>
+                                <div class="table-actions">
+                                    <button class="btn btn-danger btn-sm" onclick="deleteGroup(${group.id})">
+                                        Delete
+                                    </button>
+                                </div>
+                            </td>
+                        </tr>
+                    `).join('')}
+                </tbody>
+            </table>
+        `;
+    } catch (error) {
+        container.innerHTML = '<p style="color: var(--danger);">Failed to load groups</p>';
+    }
+}
+
+async function createGroup() {
+    const name = prompt('Group name (e.g. a household):');
+    if (!name) return;
+
+    try {
+        const response = await fetch('/api/admin/groups', {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+                'X-CSRF-Token': csrfToken
+            },
+            body: JSON.stringify({ name })
+        });
+
+        if (!response.ok) throw new Error(await response.text());
+        await loadGroups();
+        loadUsers();
+    } catch (error) {
+        alert('Failed to create group: ' + error.message);
+    }
+}
+
+async function deleteGroup(groupId) {
+    if (!confirm('Delete this group? Its members and their shared photos move to the ungrouped family area.')) return;
+
+    try {
+        const response = await fetch(`/api/admin/groups/${groupId}`, {
+            method: 'DELETE',
+            headers: { 'X-CSRF-Token': csrfToken }
+        });
+
+        if (!response.ok) throw new Error(await response.text());
+        await loadGroups();
+        loadUsers();
+    } catch (error) {
+        alert('Failed to delete group');
+    }
+}
+
+async function loadInvites() {
+    const container = document.getElementById('invitesList');
+
+    try {
+        const response = await fetch('/api/admin/invites');
+        if (!response.ok) throw new Error('Failed');
+
+        const invites = await response.json();
+
+        if (!invites?.length) {
+            container.innerHTML = '<p style="color: var(--text-muted);">No invite codes yet</p>';
+            return;
+        }
+
+        container.innerHTML = `
+            <table class="table">
+                <thead>
+                    <tr>
+                        <th>Code</th>
+                        <th>Uses</th>
+                        <th>Expires</th>
+                        <th>Used by</th>
+                        <th></th>
+                    </tr>
+                </thead>
+                <tbody>
+                    ${invites.map(invite => `
+                        <tr style="${invite.usable ? '' : 'opacity: 0.5;'}">
+                            <td><code>${esc(invite.code)}</code></td>
+                            <td>${invite.uses} / ${invite.max_uses || '∞'}</td>
+                            <td>${invite.expires_at ? formatDate(invite.expires_at) : 'Never'}</td>
+                            <td>${invite.used_by.map(esc).join(', ') || '-'}</td>
+                            <td>
+                                <div class="table-actions">
+                                    <button class="btn btn-danger btn-sm" onclick="revokeInvite('${esc(invite.code)}')">
+                                        Revoke
+                                    </button>
+                                </div>
+                            </td>
+                        </tr>
+                    `).join('')}
+                </tbody>
+            </table>
+        `;
+    } catch (error) {
+        container.innerHTML = '<p style="color: var(--danger);">Failed to load invites</p>';
+    }
+}
+
+async function createInvite() {
+    const maxUses = prompt('How many people can use this code? (0 = unlimited)', '1');
+    if (maxUses === null) return;
+    const expiresIn = prompt('Expire after how many hours? (0 = never)', '72');
+    if (expiresIn === null) return;
+
+    try {
+        const response = await fetch('/api/admin/invites', {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+                'X-CSRF-Token': csrfToken
+            },
+            body: JSON.stringify({
+                max_uses: parseInt(maxUses, 10) || 0,
+                expires_in_hours: parseInt(expiresIn, 10) || 0
+            })
+        });
+
+        if (!response.ok) throw new Error(await response.text());
+
+        const invite = await response.json();
+        prompt('Share this registration link:', window.location.origin + invite.url);
+        loadInvites();
+    } catch (error) {
+        alert('Failed to create invite: ' + error.message);
+    }
+}
+
+async function revokeInvite(code) {
+    try {
+        const response = await fetch(`/api/admin/invites/${encodeURIComponent(code)}`, {
+            method: 'DELETE',
+            headers: { 'X-CSRF-Token': csrfToken }
+        });
+
+        if (!response.ok) throw new Error(await response.text());
+        loadInvites();
+    } catch (error) {
+        alert('Failed to revoke invite');
+    }
+}
+
 function confirmDelete(userId, username) {
     document.getElementById('confirmMessage').textContent = 
         `Delete user "${username}" and all their photos?`;
@@ -148,3 +383,10 @@ function esc(str) {
 function formatDate(dateString) {
     return new Date(dateString).toLocaleDateString();
 }
+
+function formatSize(bytes) {
+    if (!bytes) return '0 B';
+    const units = ['B', 'KB', 'MB', 'GB', 'TB'];
+    const i = Math.min(Math.floor(Math.log(bytes) / Math.log(1024)), units.length - 1);
+    return `${(bytes / Math.pow(1024, i)).toFixed(1)} ${units[i]}`;
+}
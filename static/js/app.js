@@ -28,9 +28,27 @@ document.addEventListener('DOMContentLoaded', () => {
     setupViewer();
     setupSelection();
     setupOrganize();
+    setupImpersonation();
     loadPhotos();
 });
 
+// ==================== IMPERSONATION ====================
+
+function setupImpersonation() {
+    document.getElementById('stopImpersonatingBtn')?.addEventListener('click', async () => {
+        try {
+            const response = await fetch('/api/impersonation/stop', {
+                method: 'POST',
+                headers: { 'X-CSRF-Token': csrfToken }
+            });
+
+            window.location.href = response.ok ? '/admin' : '/login';
+        } catch (error) {
+            alert('Failed to stop impersonating');
+        }
+    });
+}
+
 // ==================== TABS ====================
 
 function setupTabs() {
@@ -129,6 +147,7 @@ function renderGallery() {
     gallery.innerHTML = currentPhotos.map((photo, i) => `
         <div class="photo-card ${selectedPhotos.has(photo.id) ? 'selected' : ''}" 
              data-photo-id="${photo.id}"
+             ${placeholderStyle(photo)}
              onclick="${selectMode ? `togglePhotoSelection(${photo.id})` : `openViewer(${i})`}">
             ${selectMode ? `
                 <div class="photo-checkbox ${selectedPhotos.has(photo.id) ? 'checked' : ''}" onclick="event.stopPropagation(); togglePhotoSelection(${photo.id})">
@@ -137,9 +156,9 @@ function renderGallery() {
                     </svg>
                 </div>
             ` : ''}
-            <img src="${esc(photo.thumbnail_url)}" alt="${esc(photo.filename)}" loading="lazy">
+            <img src="${esc(photo.thumbnail_url)}" alt="${esc(photoName(photo))}" loading="lazy">
             <div class="photo-card-overlay">
-                <div class="photo-card-name">${esc(photo.filename)}</div>
+                <div class="photo-card-name">${esc(photoName(photo))}</div>
                 <div class="photo-card-meta">
                     ${formatSize(photo.size)}
                     ${photo.username && currentTab !== 'my-photos' ? `<span class="badge">${esc(photo.username)}</span>` : ''}
@@ -215,6 +234,11 @@ async function handleUpload(files) {
                 body: formData
             });
 
+            // Out of disk space: the rest would fail too
+            if (response.status === 507) {
+                alert('The server is out of disk space. Remaining uploads were cancelled.');
+                break;
+            }
             if (!response.ok) throw new Error('Upload failed');
             
             completed++;
@@ -247,6 +271,7 @@ function setupViewer() {
     document.getElementById('viewerZoomOut')?.addEventListener('click', () => zoom(-0.25));
     document.getElementById('viewerZoomReset')?.addEventListener('click', resetZoom);
     document.getElementById('viewerShare')?.addEventListener('click', toggleShare);
+    document.getElementById('viewerLink')?.addEventListener('click', createPublicLink);
     document.getElementById('viewerDelete')?.addEventListener('click', deletePhoto);
     document.getElementById('viewerSave')?.addEventListener('click', saveToPhotos);
 
@@ -322,17 +347,25 @@ function openViewer(index) {
     viewerImage.style.opacity = '0';
     viewerImage.src = photo.original_url;
 
-    document.getElementById('viewerFilename').textContent = photo.filename;
+    document.getElementById('viewerFilename').textContent = photoName(photo);
     
     const meta = [];
     if (photo.username && photo.user_id !== currentUserID) meta.push(`by ${photo.username}`);
     if (photo.is_shared) meta.push('Shared');
+    if (photo.view_count) meta.push(`${photo.view_count} view${photo.view_count === 1 ? '' : 's'}`);
+    if (photo.download_count) meta.push(`${photo.download_count} download${photo.download_count === 1 ? '' : 's'}`);
     document.getElementById('viewerMeta').textContent = meta.join(' • ');
 
+    // Count the view; failures don't matter to the viewer
+    fetch(`/api/photos/${photo.id}/view`, {
+        method: 'POST',
+        headers: { 'X-CSRF-Token': csrfToken }
+    }).catch(() => {});
+
     document.getElementById('viewerCounter').textContent = `${index + 1} / ${currentPhotos.length}`;
 
     const downloadBtn = document.getElementById('viewerDownload');
-    downloadBtn.href = photo.original_url;
+    downloadBtn.href = photo.original_url + '?download=1';
     downloadBtn.download = photo.filename;
 
     // On iOS, show save button instead of download (goes to Photos app via share sheet)
@@ -355,6 +388,9 @@ function openViewer(index) {
         shareBtn.style.display = 'none';
     }
 
+    const linkBtn = document.getElementById('viewerLink');
+    if (linkBtn) linkBtn.style.display = photo.user_id === currentUserID ? 'flex' : 'none';
+
     const deleteBtn = document.getElementById('viewerDelete');
     deleteBtn.style.display = (photo.user_id === currentUserID || isAdmin) ? 'flex' : 'none';
 
@@ -421,11 +457,50 @@ async function toggleShare() {
     }
 }
 
+// Create an expiring link for someone without an account and copy it
+async function createPublicLink() {
+    if (currentPhotoIndex < 0) return;
+    const photo = currentPhotos[currentPhotoIndex];
+
+    const days = prompt('Create a link anyone can open without an account.\nExpires after how many days? (1-30)', '3');
+    if (days === null) return;
+    const hours = Math.round(parseFloat(days) * 24);
+    if (!(hours >= 1 && hours <= 720)) {
+        alert('Enter a number of days between 1 and 30');
+        return;
+    }
+
+    try {
+        const response = await fetch(`/api/photos/${photo.id}/public-link`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json', 'X-CSRF-Token': csrfToken },
+            body: JSON.stringify({ expires_hours: hours })
+        });
+
+        if (!response.ok) {
+            alert((await response.text()).trim() || 'Failed to create link');
+            return;
+        }
+
+        const result = await response.json();
+        const url = window.location.origin + result.url;
+        try {
+            await navigator.clipboard.writeText(url);
+            alert(`Link copied. It works until ${new Date(result.link.expires_at).toLocaleString()}.`);
+        } catch (e) {
+            // Clipboard access needs HTTPS; let the user copy it by hand
+            prompt('Copy this link:', url);
+        }
+    } catch (error) {
+        alert('Failed to create link');
+    }
+}
+
 async function deletePhoto() {
     if (currentPhotoIndex < 0) return;
     const photo = currentPhotos[currentPhotoIndex];
 
-    if (!confirm(`Delete "${photo.filename}"?`)) return;
+    if (!confirm(`Delete "${photoName(photo)}"?`)) return;
 
     try {
         const response = await fetch(`/api/photos/${photo.id}`, {
@@ -491,6 +566,11 @@ function esc(str) {
     return div.innerHTML;
 }
 
+// The name a photo was uploaded under (stored names can be renamed to avoid clashes)
+function photoName(photo) {
+    return photo.original_name || photo.filename;
+}
+
 function formatSize(bytes) {
     if (!bytes) return '0 B';
     const units = ['B', 'KB', 'MB', 'GB'];
@@ -498,6 +578,75 @@ function formatSize(bytes) {
     return `${(bytes / Math.pow(1024, i)).toFixed(1)} ${units[i]}`;
 }
 
+// Blurhash placeholders (https://blurha.sh), decoded once per hash
+const blurhashCache = new Map();
+const BASE83 = '0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~';
+
+function decode83(str) {
+    let value = 0;
+    for (const c of str) value = value * 83 + BASE83.indexOf(c);
+    return value;
+}
+
+function blurhashToDataURL(hash, size = 32) {
+    if (!hash || hash.length < 6) return '';
+    if (blurhashCache.has(hash)) return blurhashCache.get(hash);
+
+    const sRGBToLinear = v => { v /= 255; return v <= 0.04045 ? v / 12.92 : Math.pow((v + 0.055) / 1.055, 2.4); };
+    const linearToSRGB = v => { v = Math.max(0, Math.min(1, v)); return Math.round((v <= 0.0031308 ? v * 12.92 : 1.055 * Math.pow(v, 1 / 2.4) - 0.055) * 255); };
+    const signPow = (v, e) => Math.sign(v) * Math.pow(Math.abs(v), e);
+
+    const sizeFlag = decode83(hash[0]);
+    const numX = (sizeFlag % 9) + 1;
+    const numY = Math.floor(sizeFlag / 9) + 1;
+    if (hash.length !== 4 + 2 * numX * numY) return '';
+
+    const maxValue = (decode83(hash[1]) + 1) / 166;
+    const colors = [];
+    const dc = decode83(hash.substring(2, 6));
+    colors.push([sRGBToLinear(dc >> 16), sRGBToLinear((dc >> 8) & 255), sRGBToLinear(dc & 255)]);
+    for (let i = 1; i < numX * numY; i++) {
+        const ac = decode83(hash.substring(4 + i * 2, 6 + i * 2));
+        colors.push([
+            signPow((Math.floor(ac / 361) - 9) / 9, 2) * maxValue,
+            signPow((Math.floor(ac / 19) % 19 - 9) / 9, 2) * maxValue,
+            signPow((ac % 19 - 9) / 9, 2) * maxValue
+        ]);
+    }
+
+    const canvas = document.createElement('canvas');
+    canvas.width = canvas.height = size;
+    const ctx = canvas.getContext('2d');
+    const img = ctx.createImageData(size, size);
+    for (let y = 0; y < size; y++) {
+        for (let x = 0; x < size; x++) {
+            let r = 0, g = 0, b = 0;
+            for (let j = 0; j < numY; j++) {
+                for (let i = 0; i < numX; i++) {
+                    const basis = Math.cos(Math.PI * x * i / size) * Math.cos(Math.PI * y * j / size);
+                    const c = colors[i + j * numX];
+                    r += c[0] * basis; g += c[1] * basis; b += c[2] * basis;
+                }
+            }
+            const p = 4 * (x + y * size);
+            img.data[p] = linearToSRGB(r);
+            img.data[p + 1] = linearToSRGB(g);
+            img.data[p + 2] = linearToSRGB(b);
+            img.data[p + 3] = 255;
+        }
+    }
+    ctx.putImageData(img, 0, 0);
+
+    const url = canvas.toDataURL();
+    blurhashCache.set(hash, url);
+    return url;
+}
+
+function placeholderStyle(photo) {
+    const url = blurhashToDataURL(photo.blurhash);
+    return url ? `style="background-image: url('${url}')"` : '';
+}
+
 // ==================== SELECTION ====================
 
 function setupSelection() {
@@ -857,15 +1006,24 @@ async function loadOrganizeStatus() {
         if (status.embedding_service_healthy) {
             embeddingStatus.textContent = 'Running';
             embeddingStatus.className = 'status-badge status-success';
+        } else if (status.embedding_service_status === 'unhealthy') {
+            embeddingStatus.textContent = 'Starting';
+            embeddingStatus.className = 'status-badge status-warning';
         } else {
             embeddingStatus.textContent = 'Not Running';
             embeddingStatus.className = 'status-badge status-error';
         }
+        embeddingStatus.title = status.embedding_service_message || '';
         
         // Update embedding count
         document.getElementById('embeddingCount').textContent = 
             `${status.embeddings_generated} / ${status.total_photos}`;
         
+        // Update archive and storage totals
+        document.getElementById('archivedCount').textContent = status.archived_photos;
+        document.getElementById('storageUsed').textContent =
+            `${formatSize(status.storage_bytes)} (${formatSize(status.archived_storage_bytes)} archived)`;
+        
         // Update LLM status
         const llmStatus = document.getElementById('llmStatus');
         if (status.llm_configured) {
@@ -911,7 +1069,16 @@ async function generateEmbeddings() {
         }
         
         const result = await response.json();
-        alert(result.message);
+        let message = result.message;
+        if (result.failures && result.failures.length > 0) {
+            message += '\n\nFailed photos:\n' + result.failures
+                .map(f => `${f.filename}: ${f.error}`)
+                .join('\n');
+            if (result.failures_omitted > 0) {
+                message += `\n...and ${result.failures_omitted} more`;
+            }
+        }
+        alert(message);
         loadOrganizeStatus();
         
         // Clear any existing groups since embeddings changed
@@ -944,7 +1111,7 @@ async function findGroups() {
             })
         });
         
-        if (!response.ok) throw new Error('Failed to find groups');
+        if (!response.ok) throw new Error((await response.text()).trim() || response.statusText);
         
         const result = await response.json();
         
@@ -980,7 +1147,7 @@ function renderPhotoGroups(groups) {
     list.innerHTML = groups.map((group, i) => `
         <div class="photo-group" data-group-id="${group.group_id}" data-group-index="${i}">
             <div class="group-header">
-                <h4>Group ${i + 1} (${group.photos.length} photos, ${Math.round(group.avg_similarity * 100)}% similar)</h4>
+                <h4>Group ${group.group_id} (${group.photos.length} photos, ${Math.round(group.avg_similarity * 100)}% similar)</h4>
                 <div class="group-actions">
                     <button class="btn btn-ghost btn-sm" onclick="toggleGroupSelect(${i})" title="Select photos to keep">
                         <svg width="14" height="14" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2">
@@ -1010,14 +1177,14 @@ function renderPhotoGroups(groups) {
             <div class="group-photos">
                 ${group.photos.map((photo, photoIndex) => `
                     <div class="group-photo" data-photo-id="${photo.id}" data-group-index="${i}" data-photo-index="${photoIndex}" onclick="openGroupPhoto(${i}, ${photoIndex})">
-                        <img src="${esc(photo.thumbnail_url)}" alt="${esc(photo.filename)}" loading="lazy">
+                        <img src="${esc(photo.thumbnail_url)}" alt="${esc(photoName(photo))}" loading="lazy">
                         <div class="group-photo-checkbox" style="display: none;" onclick="event.stopPropagation(); toggleGroupPhotoSelect(this, ${photo.id}, ${i})">
                             <svg width="20" height="20" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="3">
                                 <polyline points="20 6 9 17 4 12"/>
                             </svg>
                         </div>
                         <div class="group-photo-overlay">
-                            <span class="photo-name">${esc(photo.filename)}</span>
+                            <span class="photo-name">${esc(photoName(photo))}</span>
                         </div>
                     </div>
                 `).join('')}
@@ -1148,15 +1315,16 @@ async function keepSelectedArchiveRest(groupIndex) {
         if (!response.ok) throw new Error('Archive failed');
         
         const result = await response.json();
+        const archivedIds = succeededIds(result, toArchive);
         
-        // Remove archived photos from UI
-        toArchive.forEach(id => {
+        // Remove archived photos from UI (failed ones stay in the group)
+        archivedIds.forEach(id => {
             const photoEl = groupEl.querySelector(`.group-photo[data-photo-id="${id}"]`);
             if (photoEl) photoEl.remove();
         });
         
         // Update group in memory
-        group.photos = group.photos.filter(p => selectedIds.has(p.id));
+        group.photos = group.photos.filter(p => selectedIds.has(p.id) || !archivedIds.has(p.id));
         
         // Mark gallery as needing refresh
         window.galleryNeedsRefresh = true;
@@ -1174,10 +1342,10 @@ async function keepSelectedArchiveRest(groupIndex) {
             cancelGroupSelect(groupIndex);
             // Update header count
             const header = groupEl.querySelector('h4');
-            header.textContent = `Group ${groupIndex + 1} (${group.photos.length} photos, ${Math.round(group.avg_similarity * 100)}% similar)`;
+            header.textContent = `Group ${group.group_id} (${group.photos.length} photos, ${Math.round(group.avg_similarity * 100)}% similar)`;
         }
         
-        alert(`Archived ${result.archived} photo${result.archived > 1 ? 's' : ''}`);
+        alert(result.message);
         
     } catch (error) {
         console.error('Error archiving photos:', error);
@@ -1243,6 +1411,12 @@ function showAnalysisResult(result, photoIds) {
     }
 }
 
+// IDs a bulk operation reported as done (all requested IDs for older servers)
+function succeededIds(result, requestedIds) {
+    if (!Array.isArray(result.results)) return new Set(requestedIds);
+    return new Set(result.results.filter(r => r.status === 'ok').map(r => r.photo_id));
+}
+
 async function archiveMultiplePhotos(photoIds, groupEl) {
     try {
         const response = await fetch('/api/photos/bulk/archive', {
@@ -1258,8 +1432,8 @@ async function archiveMultiplePhotos(photoIds, groupEl) {
         
         const result = await response.json();
         
-        // Remove archived photos from UI
-        photoIds.forEach(id => {
+        // Remove archived photos from UI (failed ones stay in the group)
+        succeededIds(result, photoIds).forEach(id => {
             const photoEl = document.querySelector(`.group-photo[data-photo-id="${id}"]`);
             if (photoEl) photoEl.remove();
             
@@ -1284,7 +1458,7 @@ async function archiveMultiplePhotos(photoIds, groupEl) {
         // Mark gallery as needing refresh
         window.galleryNeedsRefresh = true;
         
-        alert(`Archived ${result.archived} photo${result.archived > 1 ? 's' : ''}`);
+        alert(result.message);
         
     } catch (error) {
         console.error('Error archiving photos:', error);
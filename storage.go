@@ -0,0 +1,236 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// Storage abstracts where photo files are kept
+// Keys are slash-separated paths relative to the storage root (e.g. users/1/originals/a.jpg)
+// Implementations must return errors satisfying errors.Is(err, fs.ErrNotExist) for missing keys
+type Storage interface {
+	Save(key string, r io.Reader) error
+	Open(key string) (io.ReadSeekCloser, error)
+	Delete(key string) error
+	DeleteAll(prefix string) error
+	Rename(oldKey, newKey string) error
+	Stat(key string) (fs.FileInfo, error)
+}
+
+// LocalPather is implemented by backends whose files are also plain local files
+// Features that hand files to external tools (CLIP embeddings, LLM analysis) require it
+type LocalPather interface {
+	LocalPath(key string) (string, error)
+}
+
+// NewStorage creates the storage backend selected in the config
+func NewStorage(config *Config) (Storage, error) {
+	switch config.StorageBackend {
+	case "", "local":
+		local := NewLocalStorage(config.StoragePath, config.GetTempDir())
+		// Anything still staged was left by a write interrupted by a crash
+		if removed, err := local.CleanTemp(); err == nil && removed > 0 {
+			log.Printf("Removed %d incomplete upload(s) from %s", removed, config.GetTempDir())
+		}
+		return local, nil
+	default:
+		return nil, fmt.Errorf("unsupported storage backend: %s", config.StorageBackend)
+	}
+}
+
+// LocalStorage stores files on the local filesystem under a root directory
+type LocalStorage struct {
+	root    string
+	tempDir string // Staging directory for atomic writes ("" = next to the destination)
+}
+
+// NewLocalStorage creates a local filesystem backend rooted at root
+// Files are written to tempDir first and renamed into place once complete
+func NewLocalStorage(root, tempDir string) *LocalStorage {
+	return &LocalStorage{root: root, tempDir: tempDir}
+}
+
+// LocalPath returns the filesystem path for a key
+// Keys that would resolve outside the storage root are rejected
+func (s *LocalStorage) LocalPath(key string) (string, error) {
+	root := filepath.Clean(s.root)
+	path := filepath.Join(root, filepath.FromSlash(key))
+
+	if path != root && !strings.HasPrefix(path, root+string(filepath.Separator)) {
+		return "", fmt.Errorf("storage key escapes storage root: %s", key)
+	}
+
+	return path, nil
+}
+
+// Save writes the contents of r to key, creating parent directories as needed
+// The data is staged in a temp file and renamed into place, so a crash mid-write
+// never leaves a partial file under key. A temp dir on another filesystem
+// can't be renamed from, so the staged file is copied next to key first
+func (s *LocalStorage) Save(key string, r io.Reader) error {
+	path, err := s.LocalPath(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+
+	tempDir := s.tempDir
+	if tempDir == "" {
+		tempDir = filepath.Dir(path)
+	} else if err := os.MkdirAll(tempDir, 0755); err != nil {
+		return fmt.Errorf("failed to create temp directory: %v", err)
+	}
+
+	file, err := os.CreateTemp(tempDir, "upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %v", err)
+	}
+	tempPath := file.Name()
+
+	_, err = io.Copy(file, r)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tempPath, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tempPath, path)
+		if errors.Is(err, syscall.EXDEV) {
+			err = moveAcrossDevices(tempPath, path)
+		}
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	return nil
+}
+
+// moveAcrossDevices moves a staged file to path on another filesystem: it is
+// copied to a temp file in path's directory, synced and renamed into place, so
+// path still never holds a partial file. The staged file is removed
+func moveAcrossDevices(stagedPath, path string) error {
+	src, err := os.Open(stagedPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	file, err := os.CreateTemp(filepath.Dir(path), "upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %v", err)
+	}
+	tempPath := file.Name()
+
+	_, err = io.Copy(file, src)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tempPath, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tempPath, path)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	os.Remove(stagedPath)
+	return nil
+}
+
+// CleanTemp removes staged files left behind by writes that never completed
+func (s *LocalStorage) CleanTemp() (int, error) {
+	if s.tempDir == "" {
+		return 0, nil
+	}
+
+	leftovers, err := filepath.Glob(filepath.Join(s.tempDir, "upload-*"))
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, path := range leftovers {
+		if err := os.Remove(path); err == nil {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// Open opens key for reading
+func (s *LocalStorage) Open(key string) (io.ReadSeekCloser, error) {
+	path, err := s.LocalPath(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// Delete removes key
+func (s *LocalStorage) Delete(key string) error {
+	path, err := s.LocalPath(key)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// DeleteAll removes prefix and everything under it; a missing prefix is not an error
+func (s *LocalStorage) DeleteAll(prefix string) error {
+	path, err := s.LocalPath(prefix)
+	if err != nil {
+		return err
+	}
+	if path == filepath.Clean(s.root) {
+		return fmt.Errorf("refusing to delete storage root")
+	}
+	return os.RemoveAll(path)
+}
+
+// Rename moves oldKey to newKey, creating parent directories as needed
+func (s *LocalStorage) Rename(oldKey, newKey string) error {
+	oldPath, err := s.LocalPath(oldKey)
+	if err != nil {
+		return err
+	}
+	newPath, err := s.LocalPath(newKey)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(newPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+	return os.Rename(oldPath, newPath)
+}
+
+// Stat returns file info for key
+func (s *LocalStorage) Stat(key string) (fs.FileInfo, error) {
+	path, err := s.LocalPath(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(path)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// failingReader returns data and then fails, like an upload cut off mid-body
+type failingReader struct {
+	data []byte
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if len(f.data) == 0 {
+		return 0, errors.New("connection reset")
+	}
+	n := copy(p, f.data)
+	f.data = f.data[n:]
+	return n, nil
+}
+
+// assertNoUploads fails if staged upload files are left in dir
+func assertNoUploads(t *testing.T, dir string) {
+	t.Helper()
+
+	leftovers, err := filepath.Glob(filepath.Join(dir, "upload-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leftovers) > 0 {
+		t.Errorf("staged files left behind: %v", leftovers)
+	}
+}
+
+func TestSaveNeverExposesPartialFile(t *testing.T) {
+	root := t.TempDir()
+	tempDir := filepath.Join(root, "tmp")
+	storage := NewLocalStorage(root, tempDir)
+	path := filepath.Join(root, "users", "1", "originals", "beach.jpg")
+
+	data := bytes.Repeat([]byte("0123456789"), 100000)
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- storage.Save("users/1/originals/beach.jpg", pr) }()
+
+	// Feed the upload in pieces, checking between them that nothing is visible yet
+	for offset := 0; offset < len(data); offset += 100000 {
+		if _, err := pw.Write(data[offset : offset+100000]); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("file visible after %d of %d bytes (stat: %v)", offset+100000, len(data), err)
+		}
+	}
+	pw.Close()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Save did not finish")
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read saved file: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("saved %d bytes, want %d", len(got), len(data))
+	}
+	assertNoUploads(t, tempDir)
+}
+
+func TestSaveFailedWriteLeavesNothing(t *testing.T) {
+	root := t.TempDir()
+	tempDir := filepath.Join(root, "tmp")
+	storage := NewLocalStorage(root, tempDir)
+
+	err := storage.Save("users/1/originals/beach.jpg", &failingReader{data: bytes.Repeat([]byte("x"), 50000)})
+	if err == nil {
+		t.Fatal("Save succeeded although the upload failed")
+	}
+
+	if _, err := storage.Stat("users/1/originals/beach.jpg"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("partial file is visible (stat: %v)", err)
+	}
+	assertNoUploads(t, tempDir)
+}
+
+func TestSaveWithTempDirOnAnotherFilesystem(t *testing.T) {
+	// /dev/shm is usually a separate tmpfs, which makes the rename fail with EXDEV
+	shm, err := os.MkdirTemp("/dev/shm", "mnemosyne-test-")
+	if err != nil {
+		t.Skipf("no /dev/shm: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(shm) })
+
+	root := t.TempDir()
+	storage := NewLocalStorage(root, shm)
+
+	data := []byte("not really a photo")
+	if err := storage.Save("users/1/originals/beach.jpg", bytes.NewReader(data)); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(root, "users", "1", "originals", "beach.jpg"))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("saved file: %q, %v", got, err)
+	}
+	assertNoUploads(t, shm)
+	assertNoUploads(t, filepath.Join(root, "users", "1", "originals"))
+}
+
+func TestMoveAcrossDevices(t *testing.T) {
+	stagingDir, destDir := t.TempDir(), t.TempDir()
+	staged := filepath.Join(stagingDir, "upload-1")
+	dest := filepath.Join(destDir, "beach.jpg")
+
+	data := []byte("not really a photo")
+	if err := os.WriteFile(staged, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := moveAcrossDevices(staged, dest); err != nil {
+		t.Fatalf("moveAcrossDevices: %v", err)
+	}
+
+	got, err := os.ReadFile(dest)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("moved file: %q, %v", got, err)
+	}
+	if _, err := os.Stat(staged); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("staged file was not removed (stat: %v)", err)
+	}
+	assertNoUploads(t, destDir)
+}
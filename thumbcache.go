@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// thumbnailCache keeps derived images (thumbnails of active photos and cached
+// variants) within a disk budget by deleting the least recently served ones
+// Both are regenerated on demand, so eviction only costs a re-encode. Archived
+// thumbnails aren't regenerated, so they are never evicted; a photo archived
+// after its live thumbnail was evicted is marked dropped and resized on request
+type thumbnailCache struct {
+	pm       *PhotoManager
+	maxBytes int64
+
+	mu       sync.Mutex
+	lastUsed map[string]time.Time // storage key -> last served; files not served since startup fall back to their mtime
+
+	sweeping atomic.Bool
+}
+
+// cachedImage is one evictable file found by a sweep
+type cachedImage struct {
+	key      string
+	size     int64
+	lastUsed time.Time
+}
+
+// newThumbnailCache creates a cache limited to maxBytes (which must be positive)
+func newThumbnailCache(pm *PhotoManager, maxBytes int64) *thumbnailCache {
+	return &thumbnailCache{
+		pm:       pm,
+		maxBytes: maxBytes,
+		lastUsed: make(map[string]time.Time),
+	}
+}
+
+// touch records that a derived image was just served
+func (c *thumbnailCache) touch(key string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.lastUsed[key] = time.Now()
+	c.mu.Unlock()
+}
+
+// added records a newly generated image and starts a sweep in the background
+// Only one sweep runs at a time; images added meanwhile are caught by the next one
+func (c *thumbnailCache) added(key string) {
+	if c == nil {
+		return
+	}
+	c.touch(key)
+	if c.sweeping.CompareAndSwap(false, true) {
+		go func() {
+			defer c.sweeping.Store(false)
+			c.Sweep()
+		}()
+	}
+}
+
+// isCachedKey reports whether a key (relative to the users directory) is a
+// regenerable derived image: users/{id}/thumbnails/* or users/{id}/variants/...
+func isCachedKey(rel string) bool {
+	parts := strings.Split(rel, "/")
+	if len(parts) < 3 {
+		return false
+	}
+	switch parts[1] {
+	case "thumbnails":
+		return len(parts) == 3
+	case "variants":
+		return true
+	}
+	return false
+}
+
+// Sweep evicts least recently served images until the cache fits its budget
+// Returns the number of files and bytes removed
+// Only available when the storage backend keeps plain local files
+func (c *thumbnailCache) Sweep() (evicted int, freed int64) {
+	root, err := c.pm.localPath("users")
+	if err != nil {
+		return 0, 0
+	}
+
+	c.mu.Lock()
+	lastUsed := make(map[string]time.Time, len(c.lastUsed))
+	for key, t := range c.lastUsed {
+		lastUsed[key] = t
+	}
+	c.mu.Unlock()
+
+	images := make([]cachedImage, 0)
+	var total int64
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil || !isCachedKey(filepath.ToSlash(rel)) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil // removed while walking
+		}
+
+		key := path.Join("users", filepath.ToSlash(rel))
+		used, ok := lastUsed[key]
+		if !ok || info.ModTime().After(used) {
+			used = info.ModTime()
+		}
+		images = append(images, cachedImage{key: key, size: info.Size(), lastUsed: used})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		log.Printf("Thumbnail cache: failed to scan: %v", err)
+		return 0, 0
+	}
+
+	// Forget files that no longer exist (deleted photos, earlier evictions)
+	present := make(map[string]bool, len(images))
+	for _, img := range images {
+		present[img.key] = true
+	}
+	c.mu.Lock()
+	for key := range c.lastUsed {
+		if !present[key] {
+			delete(c.lastUsed, key)
+		}
+	}
+	c.mu.Unlock()
+
+	if total <= c.maxBytes {
+		return 0, 0
+	}
+
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].lastUsed.Before(images[j].lastUsed)
+	})
+	for _, img := range images {
+		if total <= c.maxBytes {
+			break
+		}
+		if err := c.pm.storage.Delete(img.key); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Thumbnail cache: failed to evict %s: %v", img.key, err)
+			continue
+		}
+		c.mu.Lock()
+		delete(c.lastUsed, img.key)
+		c.mu.Unlock()
+		total -= img.size
+		freed += img.size
+		evicted++
+	}
+
+	log.Printf("Thumbnail cache: evicted %d file(s), %d bytes", evicted, freed)
+	return evicted, freed
+}
+
+// SweepThumbnailCache trims the thumbnail cache to its budget, if one is configured
+func (pm *PhotoManager) SweepThumbnailCache() {
+	if pm.cache != nil {
+		pm.cache.Sweep()
+	}
+}
@@ -2,8 +2,12 @@ package main
 
 import (
 	"crypto/rand"
+	"encoding/base32"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"mime"
+	"net/http"
 	"net/url"
 	"path/filepath"
 	"strings"
@@ -30,6 +34,27 @@ func generateRandomToken(length int) (string, error) {
 	return base64.URLEncoding.EncodeToString(bytes), nil
 }
 
+// generateInviteCode creates a random invite code that is easy to read out or type
+// Uses uppercase base32 without padding (no 0/1/8/9 to confuse with O/I/B/g)
+func generateInviteCode(length int) (string, error) {
+	bytes := make([]byte, length)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(bytes), nil
+}
+
+// generateUUID creates a random (version 4) UUID
+func generateUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40 // version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
 // Reserved Windows filenames that cannot be used
 var reservedWindowsNames = map[string]bool{
 	"CON": true, "PRN": true, "AUX": true, "NUL": true,
@@ -94,17 +119,70 @@ func sanitizeFilename(filename string) string {
 	return name + ext
 }
 
-// isImageFile checks if the file extension is an allowed image type
-func isImageFile(filename string) bool {
-	ext := strings.ToLower(filepath.Ext(filename))
-	allowed := map[string]bool{
-		".jpg":  true,
-		".jpeg": true,
-		".png":  true,
-		".gif":  true,
-		".webp": true,
-	}
-	return allowed[ext]
+// writeBodyError reports a request body that failed to decode
+// Bodies cut off by http.MaxBytesReader get 413 so clients can tell them from malformed ones
+func writeBodyError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, "Invalid request body", http.StatusBadRequest)
+}
+
+// isSafeFilename reports whether a filename from a URL is a single path element
+// Names with separators, NUL bytes or dot segments could escape the user's directory
+func isSafeFilename(name string) bool {
+	if name == "" || name == "." || name == ".." {
+		return false
+	}
+	if strings.ContainsAny(name, "/\\\x00") {
+		return false
+	}
+	return filepath.Clean(name) == name
+}
+
+// attachmentDisposition builds a Content-Disposition header that forces a download
+// The filename is sanitized and non-ASCII names are RFC 2231 encoded
+func attachmentDisposition(filename string) string {
+	filename = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7F {
+			return -1 // drop control characters (header injection)
+		}
+		return r
+	}, sanitizeFilename(filename))
+
+	if header := mime.FormatMediaType("attachment", map[string]string{"filename": filename}); header != "" {
+		return header
+	}
+	return "attachment"
+}
+
+// imageExtensions lists the extensions accepted for each type validateImageMagicBytes
+// detects; the first is used when a file's name has to be corrected
+var imageExtensions = map[string][]string{
+	"image/jpeg": {".jpg", ".jpeg"},
+	"image/png":  {".png"},
+	"image/gif":  {".gif"},
+	"image/webp": {".webp"},
+}
+
+// withImageExtension returns filename with an extension matching its content
+// The name is returned unchanged if it already has one (in any case); a wrong or
+// missing extension is replaced, so "scan.txt" holding a JPEG becomes "scan.jpg"
+func withImageExtension(filename, mimeType string) string {
+	exts := imageExtensions[mimeType]
+	if len(exts) == 0 {
+		return filename
+	}
+
+	ext := filepath.Ext(filename)
+	for _, allowed := range exts {
+		if strings.EqualFold(ext, allowed) {
+			return filename
+		}
+	}
+	return strings.TrimSuffix(filename, ext) + exts[0]
 }
 
 // validateImageMagicBytes checks if the file content matches image type
@@ -0,0 +1,60 @@
+package main
+
+import "testing"
+
+func TestWithImageExtension(t *testing.T) {
+	tests := []struct {
+		filename string
+		mimeType string
+		want     string
+	}{
+		// Extension already matches the content
+		{"beach.jpg", "image/jpeg", "beach.jpg"},
+		{"beach.jpeg", "image/jpeg", "beach.jpeg"},
+		{"BEACH.JPG", "image/jpeg", "BEACH.JPG"},
+		{"logo.png", "image/png", "logo.png"},
+		// Name doesn't match the content
+		{"logo.jpg", "image/png", "logo.png"},
+		{"photo.png", "image/jpeg", "photo.jpg"},
+		{"scan.txt", "image/jpeg", "scan.jpg"},
+		{"animation.webp", "image/gif", "animation.gif"},
+		{"sticker.gif", "image/webp", "sticker.webp"},
+		// No extension, or only the last one counts
+		{"IMG_0001", "image/jpeg", "IMG_0001.jpg"},
+		{"holiday.png.exe", "image/png", "holiday.png.png"},
+		// Unknown types are left alone
+		{"notes.txt", "text/plain", "notes.txt"},
+	}
+
+	for _, tt := range tests {
+		if got := withImageExtension(tt.filename, tt.mimeType); got != tt.want {
+			t.Errorf("withImageExtension(%q, %q) = %q, want %q", tt.filename, tt.mimeType, got, tt.want)
+		}
+	}
+}
+
+func TestWithImageExtensionFromContent(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		header   []byte
+		want     string
+	}{
+		{"PNG named jpg", "photo.jpg", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR"), "photo.png"},
+		{"JPEG named png", "photo.png", []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00\x01\x01"), "photo.jpg"},
+		{"GIF named webp", "clip.webp", []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00"), "clip.gif"},
+		{"WebP named jpeg", "shot.jpeg", []byte("RIFF\x24\x00\x00\x00WEBPVP8 "), "shot.webp"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mimeType, err := validateImageMagicBytes(tt.header)
+			if err != nil {
+				t.Fatalf("validateImageMagicBytes: %v", err)
+			}
+			if got := withImageExtension(tt.filename, mimeType); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
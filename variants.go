@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// errVariantUnsupported means no transcoded variant can be produced for a
+// photo; callers serve the untouched original instead
+var errVariantUnsupported = errors.New("transcoding not supported")
+
+// variantFormat is an output format originals can be transcoded to
+type variantFormat struct {
+	ext      string
+	mimeType string
+	encode   func(w io.Writer, img image.Image, quality int) error
+}
+
+// variantFormats lists the formats ?format= can produce
+// WebP and AVIF need encoders that aren't bundled; requests for them fall
+// back to the original until one is registered here
+var variantFormats = map[string]variantFormat{
+	"jpeg": {
+		ext:      "jpg",
+		mimeType: "image/jpeg",
+		encode: func(w io.Writer, img image.Image, quality int) error {
+			return imaging.Encode(w, img, imaging.JPEG, imaging.JPEGQuality(quality))
+		},
+	},
+}
+
+// variantQualities are the encoder qualities variants are cached at, lowest first
+// Requested qualities round up to one of them, so a photo has at most a few
+// cached copies per format however many qualities clients ask for
+var variantQualities = []int{40, 60, VariantQuality, 95}
+
+// quantizeVariantQuality rounds quality up to the nearest of variantQualities
+// (down to the highest if it is above them all)
+func quantizeVariantQuality(quality int) int {
+	for _, q := range variantQualities {
+		if quality <= q {
+			return q
+		}
+	}
+	return variantQualities[len(variantQualities)-1]
+}
+
+// normalizeVariantFormat maps format aliases to their variantFormats key
+func normalizeVariantFormat(format string) string {
+	format = strings.ToLower(format)
+	if format == "jpg" {
+		return "jpeg"
+	}
+	return format
+}
+
+// getVariantsDir returns the storage prefix for cached variants of one photo
+func (pm *PhotoManager) getVariantsDir(userID int64, filename string) string {
+	return path.Join(pm.getUserKey(userID), "variants", filename)
+}
+
+// OpenVariant opens a full-resolution copy of a photo re-encoded to format at
+// quality, rounded to one of variantQualities
+// Variants are cached next to the originals and rebuilt when the original is newer.
+// Returns errVariantUnsupported for unknown formats, archived photos and sources
+// that can't be decoded
+func (pm *PhotoManager) OpenVariant(photo *Photo, format string, quality int) (io.ReadSeekCloser, fs.FileInfo, string, error) {
+	vf, ok := variantFormats[normalizeVariantFormat(format)]
+	if !ok || photo.IsArchived {
+		return nil, nil, "", errVariantUnsupported
+	}
+
+	originalsDir := pm.getOriginalsDir(photo.UserID)
+	originalKey := path.Join(originalsDir, photo.Filename)
+	if !keyWithin(originalsDir, originalKey) {
+		return nil, nil, "", fmt.Errorf("invalid filename")
+	}
+
+	original, err := pm.storage.Stat(originalKey)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("file not found")
+	}
+
+	quality = quantizeVariantQuality(quality)
+	key := path.Join(pm.getVariantsDir(photo.UserID, photo.Filename), fmt.Sprintf("q%d.%s", quality, vf.ext))
+	if info, err := pm.storage.Stat(key); err == nil && !info.ModTime().Before(original.ModTime()) {
+		pm.cache.touch(key)
+		file, info, err := pm.open(key)
+		return file, info, vf.mimeType, err
+	}
+
+	file, err := pm.storage.Open(originalKey)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("failed to open file: %v", err)
+	}
+	// Re-encoding drops EXIF, so bake the orientation into the pixels
+	img, err := decodeImage(file, imaging.AutoOrientation(true))
+	file.Close()
+	if err != nil {
+		return nil, nil, "", errVariantUnsupported
+	}
+
+	var buf bytes.Buffer
+	if err := vf.encode(&buf, img, quality); err != nil {
+		return nil, nil, "", fmt.Errorf("failed to encode variant: %v", err)
+	}
+	if err := pm.storage.Save(key, &buf); err != nil {
+		return nil, nil, "", fmt.Errorf("failed to save variant: %v", err)
+	}
+	pm.cache.added(key)
+
+	variant, info, err := pm.open(key)
+	return variant, info, vf.mimeType, err
+}
+
+// deleteVariants removes every cached variant of a photo (best effort)
+func (pm *PhotoManager) deleteVariants(photo *Photo) error {
+	variantsDir := path.Join(pm.getUserKey(photo.UserID), "variants")
+	dir := pm.getVariantsDir(photo.UserID, photo.Filename)
+	if !keyWithin(variantsDir, dir) {
+		return fmt.Errorf("invalid filename")
+	}
+	return pm.storage.DeleteAll(dir)
+}